@@ -0,0 +1,12 @@
+// Package admin defines migrate's gRPC admin service, see admin.proto. The messages and
+// the service's client and server interfaces are generated from it, the server is in
+// admin/server and the client in admin/client.
+package admin
+
+// The kinds of Progress
+const (
+	KindFile    = "file"
+	KindMessage = "message"
+	KindError   = "error"
+	KindDone    = "done"
+)
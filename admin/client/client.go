@@ -0,0 +1,97 @@
+// Package client calls migrate's gRPC admin service, see admin.proto.
+//
+//	cc, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+//	c := client.New(cc, token)
+//	err = c.Apply(ctx, "", func(p *admin.Progress) { log.Println(p.Kind, p.File) })
+//
+// Canceling ctx stops a running Apply, Dump or Restore after the current file or table.
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/acls/migrate/admin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Client calls the admin service over cc
+type Client struct {
+	ac    admin.AdminClient
+	token string
+}
+
+// New returns a client that sends the token with each call
+func New(cc grpc.ClientConnInterface, token string) *Client {
+	return &Client{ac: admin.NewAdminClient(cc), token: token}
+}
+
+// ProgressFunc is called for each progress of Apply, Dump and Restore
+type ProgressFunc func(p *admin.Progress)
+
+// Status returns the migration state of the schema
+func (c *Client) Status(ctx context.Context) (*admin.StatusResponse, error) {
+	return c.ac.Status(c.context(ctx), &admin.StatusRequest{})
+}
+
+// Plan returns the versions Apply would migrate to the latest version
+func (c *Client) Plan(ctx context.Context) ([]string, error) {
+	resp, err := c.ac.Plan(c.context(ctx), &admin.PlanRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Versions, nil
+}
+
+// Apply migrates to the version or migration name to, or up if it's empty
+func (c *Client) Apply(ctx context.Context, to string, progress ProgressFunc) error {
+	stream, err := c.ac.Apply(c.context(ctx), &admin.ApplyRequest{To: to})
+	if err != nil {
+		return err
+	}
+	return receive(stream, progress)
+}
+
+// Dump dumps the schema with the server's dump writer
+func (c *Client) Dump(ctx context.Context, progress ProgressFunc) error {
+	stream, err := c.ac.Dump(c.context(ctx), &admin.DumpRequest{})
+	if err != nil {
+		return err
+	}
+	return receive(stream, progress)
+}
+
+// Restore restores the schema with the server's dump reader
+func (c *Client) Restore(ctx context.Context, progress ProgressFunc) error {
+	stream, err := c.ac.Restore(c.context(ctx), &admin.RestoreRequest{})
+	if err != nil {
+		return err
+	}
+	return receive(stream, progress)
+}
+
+// progressStream is the client stream of Apply, Dump and Restore
+type progressStream interface {
+	Recv() (*admin.Progress, error)
+}
+
+// receive passes each progress of the stream to the func until the server is done.
+// The error is the call's status, e.g. codes.Aborted when the schema is locked.
+func receive(stream progressStream, progress ProgressFunc) error {
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+func (c *Client) context(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+}
@@ -0,0 +1,307 @@
+// Package server serves migrate's gRPC admin service, see admin.proto.
+//
+//	gs := grpc.NewServer()
+//	(&server.Server{Migrator: m, Token: token}).Register(gs)
+//	gs.Serve(lis)
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/acls/migrate/admin"
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+	"github.com/acls/migrate/secret"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the admin service with the Migrator's APIs. Each call opens its own
+// connection with Migrator.Connect, so concurrent migrations wait on the schema's lock.
+type Server struct {
+	admin.UnimplementedAdminServer
+
+	Migrator *migrate.Migrator
+	// Token must be sent as the 'authorization: Bearer <token>' metadata of each call.
+	// All calls are rejected if it's empty.
+	Token string
+	// NewDumpWriter and NewDumpReader open the dump for Dump and Restore, which are
+	// unimplemented if they're nil
+	NewDumpWriter func() (file.DumpWriter, error)
+	NewDumpReader func() (file.DumpReader, error)
+	// Redact removes the secrets from the errors and messages sent to the clients, e.g. a
+	// secret.Resolver's Redact. It defaults to secret.Redact.
+	Redact func(string) string
+}
+
+// Register registers the admin service with gs
+func (s *Server) Register(gs *grpc.Server) {
+	admin.RegisterAdminServer(gs, s)
+}
+
+// authorize compares the call's bearer token in constant time
+func (s *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if s.Token != "" && subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+s.Token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "Unauthorized")
+}
+
+// Status returns the migration state of the schema
+func (s *Server) Status(ctx context.Context, req *admin.StatusRequest) (*admin.StatusResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	st, err := s.Migrator.StatusCtx(ctx, conn)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	return &admin.StatusResponse{
+		Version: st.Version.String(),
+		Latest:  st.Latest.String(),
+		Pending: versionStrings(st.Pending),
+		Drifted: versionStrings(st.Drifted),
+		Locked:  st.Locked,
+		LastRun: s.run(st.LastRun),
+	}, nil
+}
+
+// Plan returns the versions Apply would migrate to the latest version
+func (s *Server) Plan(ctx context.Context, req *admin.PlanRequest) (*admin.PlanResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	migrations, err := s.Migrator.PlanCtx(ctx, conn)
+	if err != nil {
+		return nil, s.statusError(err)
+	}
+	resp := &admin.PlanResponse{Versions: make([]string, len(migrations))}
+	for i, mg := range migrations {
+		resp.Versions[i] = mg.Version.String()
+	}
+	return resp, nil
+}
+
+// Apply migrates up, or to a version, and streams the progress
+func (s *Server) Apply(req *admin.ApplyRequest, stream admin.Admin_ApplyServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	conn, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	m := *s.Migrator
+	pipe := pipep.New()
+	if req.To == "" {
+		m.Command = "up"
+		go m.UpCtx(stream.Context(), pipe, conn)
+	} else {
+		version, err := m.ResolveVersion(req.To)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, s.redact(err.Error()))
+		}
+		m.Command = "goto"
+		go m.MigrateToCtx(stream.Context(), pipe, conn, version)
+	}
+	return s.stream(&m, conn, stream, pipe)
+}
+
+// Dump dumps the schema with the dump writer and streams the progress
+func (s *Server) Dump(req *admin.DumpRequest, stream admin.Admin_DumpServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	if s.NewDumpWriter == nil {
+		return status.Error(codes.Unimplemented, "Dump isn't configured")
+	}
+	conn, err := s.copyConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dw, err := s.NewDumpWriter()
+	if err != nil {
+		return status.Error(codes.Internal, s.redact(err.Error()))
+	}
+	pipe := pipep.New()
+	go s.Migrator.DumpCtx(stream.Context(), pipe, conn, dw)
+	err = s.stream(s.Migrator, conn, stream, pipe)
+	if cerr := dw.Close(); err == nil && cerr != nil {
+		err = status.Error(codes.Internal, s.redact(cerr.Error()))
+	}
+	return err
+}
+
+// Restore restores the schema with the dump reader and streams the progress
+func (s *Server) Restore(req *admin.RestoreRequest, stream admin.Admin_RestoreServer) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	if s.NewDumpReader == nil {
+		return status.Error(codes.Unimplemented, "Restore isn't configured")
+	}
+	conn, err := s.copyConn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	dr, err := s.NewDumpReader()
+	if err != nil {
+		return status.Error(codes.Internal, s.redact(err.Error()))
+	}
+	pipe := pipep.New()
+	go s.Migrator.RestoreCtx(stream.Context(), pipe, conn, dr)
+	return s.stream(s.Migrator, conn, stream, pipe)
+}
+
+// progressStream is the server stream of Apply, Dump and Restore
+type progressStream interface {
+	Send(*admin.Progress) error
+}
+
+// stream sends the pipe's items as progress until it's closed, then the schema's
+// version. The pipe is drained after the client is gone, so the migration can stop.
+func (s *Server) stream(m *migrate.Migrator, conn driver.Conn, stream progressStream, pipe chan interface{}) error {
+	var firstErr, sendErr error
+	for item := range pipe {
+		p := s.progress(item)
+		if p == nil {
+			continue
+		}
+		if err, ok := item.(error); ok && firstErr == nil {
+			firstErr = err
+		}
+		if sendErr == nil {
+			sendErr = stream.Send(p)
+		}
+	}
+	if firstErr != nil {
+		return s.statusError(firstErr)
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		return s.statusError(err)
+	}
+	return stream.Send(&admin.Progress{Kind: admin.KindDone, Version: version.String()})
+}
+
+func (s *Server) progress(item interface{}) *admin.Progress {
+	switch item := item.(type) {
+	case *file.File:
+		return &admin.Progress{Kind: admin.KindFile, File: item.FileName, Version: item.Version.String()}
+	case *file.Migration:
+		return s.progress(item.File())
+	case error:
+		return &admin.Progress{Kind: admin.KindError, Message: s.redact(item.Error())}
+	case string:
+		return &admin.Progress{Kind: admin.KindMessage, Message: s.redact(item)}
+	case migrate.NotificationFailed:
+		return &admin.Progress{Kind: admin.KindMessage, Message: s.redact(item.String())}
+	case migrate.Retrying:
+		return &admin.Progress{Kind: admin.KindMessage, Message: s.redact(item.String())}
+	}
+	return nil
+}
+
+// run converts the latest run of the audit log
+func (s *Server) run(run *driver.Run) *admin.Run {
+	if run == nil {
+		return nil
+	}
+	return &admin.Run{
+		Id:          run.ID,
+		Command:     run.Command,
+		FromVersion: run.FromVersion,
+		ToVersion:   run.ToVersion,
+		User:        run.User,
+		Host:        run.Host,
+		StartedAt:   timestamp(run.StartedAt),
+		FinishedAt:  timestamp(run.FinishedAt),
+		Outcome:     run.Outcome,
+		Error:       s.redact(run.Error),
+		Snapshot:    run.Snapshot,
+	}
+}
+
+func (s *Server) redact(msg string) string {
+	if s.Redact == nil {
+		return secret.Redact(msg)
+	}
+	return s.Redact(msg)
+}
+
+func (s *Server) connect() (driver.Conn, error) {
+	conn, err := s.Migrator.Connect()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, s.redact(err.Error()))
+	}
+	return conn, nil
+}
+
+func (s *Server) copyConn() (driver.CopyConn, error) {
+	conn, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	cconn, ok := conn.(driver.CopyConn)
+	if !ok {
+		conn.Close()
+		return nil, status.Error(codes.Unimplemented, "Connection doesn't support dump and restore")
+	}
+	return cconn, nil
+}
+
+// statusError maps the migration errors to grpc codes
+func (s *Server) statusError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, migrate.ErrLocked):
+		code = codes.Aborted
+	}
+	return status.Error(code, s.redact(err.Error()))
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func versionStrings(versions []file.Version) []string {
+	strs := make([]string, len(versions))
+	for i, v := range versions {
+		strs[i] = v.String()
+	}
+	return strs
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/acls/migrate/admin"
+	"github.com/acls/migrate/admin/client"
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorize(t *testing.T) {
+	auth := func(values ...string) context.Context {
+		md := metadata.MD{}
+		for _, v := range values {
+			md.Append("authorization", v)
+		}
+		return metadata.NewIncomingContext(context.Background(), md)
+	}
+	s := &Server{Token: "token"}
+	cases := []struct {
+		name string
+		s    *Server
+		ctx  context.Context
+		ok   bool
+	}{
+		{"missing", s, context.Background(), false},
+		{"wrong", s, auth("Bearer wrong"), false},
+		{"not bearer", s, auth("token"), false},
+		{"bearer", s, auth("Bearer token"), true},
+		{"one of several", s, auth("Bearer wrong", "Bearer token"), true},
+		{"empty token", &Server{}, auth("Bearer "), false},
+	}
+	for _, c := range cases {
+		err := c.s.authorize(c.ctx)
+		if c.ok && err != nil {
+			t.Errorf("%s: Expected to be authorized, got %v", c.name, err)
+		}
+		if !c.ok && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("%s: Expected %v, got %v", c.name, codes.Unauthenticated, err)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := errors.New("connect postgres://app:s3cret@db/app: connection refused")
+	m := &migrate.Migrator{NewConn: func() (driver.Conn, error) { return nil, refused }}
+	gs := grpc.NewServer()
+	(&Server{Migrator: m, Token: "token"}).Register(gs)
+	go gs.Serve(lis)
+	defer gs.Stop()
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx := context.Background()
+
+	if _, err := client.New(cc, "wrong").Status(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected %v, got %v", codes.Unauthenticated, err)
+	}
+
+	c := client.New(cc, "token")
+	_, err = c.Status(ctx)
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("Expected the connection error as %v, got %v", codes.Unavailable, err)
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Fatalf("Expected the password to be redacted, got %v", err)
+	}
+
+	var progress []*admin.Progress
+	err = c.Dump(ctx, func(p *admin.Progress) { progress = append(progress, p) })
+	if status.Code(err) != codes.Unimplemented || len(progress) != 0 {
+		t.Fatalf("Expected %v without progress, got %v and %v", codes.Unimplemented, err, progress)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	s := &Server{Redact: func(msg string) string { return strings.Replace(msg, "t0ken", "xxxxx", -1) }}
+	if p := s.progress(errors.New("Vault token t0ken expired")); p.Kind != admin.KindError || p.Message != "Vault token xxxxx expired" {
+		t.Fatalf("Expected the redacted error, got %v", p)
+	}
+	err := s.statusError(fmt.Errorf("%w: vault token t0ken", migrate.ErrLocked))
+	if st := status.Convert(err); st.Code() != codes.Aborted || strings.Contains(st.Message(), "t0ken") {
+		t.Fatalf("Expected the redacted error as %v, got %v", codes.Aborted, err)
+	}
+
+	// urls are redacted by default
+	s = &Server{}
+	if p := s.progress("connect postgres://app:s3cret@db/app"); strings.Contains(p.Message, "s3cret") {
+		t.Fatalf("Expected the password to be redacted, got %v", p)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+)
+
+// runBackfill runs the backfill sub command
+func runBackfill(m *migrate.Migrator, conn driver.Conn, command string) error {
+	switch command {
+	case "status":
+		backfills, err := m.Backfills(conn)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "BACKFILL\tROWS\tTOTAL\tPERCENT\tLAST KEY")
+		for _, b := range backfills {
+			percent := "-"
+			if p := b.Percent(); p >= 0 {
+				percent = fmt.Sprintf("%.1f%%", p)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", b.Name, b.Rows, b.Total, percent, b.LastKey)
+		}
+		return w.Flush()
+	}
+	return newMessage(msgUnknownBackfill, command)
+}
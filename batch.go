@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// runBatch reads commands from stdin, one per line, and runs them over the same
+// connection. It stops at the first command that fails. E.g.
+//
+//	up
+//	dump ./snap1
+//	migrate -2
+func runBatch(m *migrate.Migrator, conn driver.Conn, dumpFormat string) error {
+	for n := 1; ; n++ {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if verbosity > quietLevel {
+			printMessage(msgBatchCommand, line)
+		}
+		if err := runBatchCommand(m, conn, dumpFormat, fields[0], strings.Join(fields[1:], " ")); err != nil {
+			return newMessage(msgBatchFailed, n, line, err)
+		}
+	}
+}
+
+// errPipe is returned when errors were written to the pipe
+var errPipe = newMessage(msgCommandFailed)
+
+func runBatchCommand(m *migrate.Migrator, conn driver.Conn, dumpFormat, command, arg string) error {
+	switch {
+	case command == "dump" || command == "restore":
+		cconn, ok := conn.(driver.CopyConn)
+		if !ok {
+			return newMessage(msgBatchNoCopy)
+		}
+		if arg == "" {
+			arg = defaultDumpDir
+		}
+		ok, err := dumpRestore(m, cconn, arg, dumpFormat, command)
+		if err == nil && !ok {
+			err = errPipe
+		}
+		return err
+	case command == "version":
+		printComplete(m, conn, m.Now())
+		return nil
+	case isMigrationCommand(command):
+		timerStart := m.Now()
+		pipe := pipep.New()
+		if err := startMigration(m, conn, pipe, command, arg); err != nil {
+			return err
+		}
+		ok := writePipe(pipe)
+		printComplete(m, conn, timerStart)
+		if !ok {
+			return errPipe
+		}
+		return nil
+	}
+	return newMessage(msgUnknownBatch, command)
+}
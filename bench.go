@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+)
+
+// runBench measures the migrate, dump and restore throughput against the database
+func runBench(m *migrate.Migrator, conn driver.Conn, migrations, rows string) error {
+	cconn, ok := conn.(driver.CopyConn)
+	if !ok {
+		return newMessage(msgBatchNoCopy)
+	}
+	opts := migrate.BenchOptions{Migrations: 100, Rows: 100000}
+	var err error
+	if migrations != "" {
+		if opts.Migrations, err = strconv.Atoi(migrations); err != nil {
+			return newMessage(msgBadBenchParam, migrations)
+		}
+	}
+	if rows != "" {
+		if opts.Rows, err = strconv.Atoi(rows); err != nil {
+			return newMessage(msgBadBenchParam, rows)
+		}
+	}
+	result, err := m.Bench(cconn, opts)
+	if err != nil {
+		return err
+	}
+	printMessage(msgBenchResult, result)
+	return nil
+}
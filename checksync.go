@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// runCheckSync prints the version of each database compared to the target version.
+// The target defaults to the last version in -path.
+// Returns false if any database isn't at the target version.
+func runCheckSync(m *migrate.Migrator, urls []string, to string) (bool, error) {
+	if len(urls) == 0 {
+		return false, newMessage(msgNoCheckURLs)
+	}
+
+	var (
+		target file.Version
+		err    error
+	)
+	if to != "" {
+		target, err = file.ParseVersion(to)
+	} else {
+		var files file.MigrationFiles
+		files, err = m.MigrationFiles()
+		if err == nil {
+			target = files.LastVersion()
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	inSync := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tVERSION\tTARGET\tSTATUS")
+	for _, url := range urls {
+		version, status := checkVersion(m, url, target)
+		if status != "ok" {
+			inSync = false
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", displayURL(url), version, target, status)
+	}
+	return inSync, w.Flush()
+}
+
+// checkVersion returns the database version and its status compared to target
+func checkVersion(m *migrate.Migrator, url string, target file.Version) (version, status string) {
+	conn, err := m.Driver.NewConn(url, m.Schema)
+	if err != nil {
+		return "-", "error: " + redact(err.Error())
+	}
+	defer conn.Close()
+
+	v, err := m.Version(conn)
+	if err != nil {
+		return "-", "error: " + err.Error()
+	}
+	switch v.Compare(target) {
+	case -1:
+		status = "behind"
+	case 1:
+		status = "ahead"
+	default:
+		status = "ok"
+	}
+	return v.String(), status
+}
+
+// displayURL removes the password from the url
+func displayURL(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil || u.User == nil {
+		return url
+	}
+	u.User = neturl.User(u.User.Username())
+	return u.String()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"net/http"
+)
+
+// serveDashboard serves the dashboard page. The page holds no data: it asks for the api
+// token and calls the admin api with it, so it's served without authentication.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// the apply and rollback buttons mustn't be clickable through another site's frame
+	w.Header().Set("X-Frame-Options", "DENY")
+	io.WriteString(w, dashboardHTML)
+}
+
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>migrate</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; }
+table { border-collapse: collapse; }
+td, th { padding: .2em .8em; text-align: left; border-bottom: 1px solid #ddd; }
+pre { background: #f6f6f6; padding: .5em; overflow: auto; }
+.add { background: #e6ffe6; }
+.del { background: #ffe6e6; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>migrate</h1>
+<form id="login">
+<input id="token" type="password" placeholder="api token">
+<button>Connect</button>
+</form>
+<p id="error" class="error"></p>
+<div id="main" hidden>
+<p>Version <b id="version"></b>, latest <b id="latest"></b> <span id="locked"></span></p>
+<h2>Pending</h2>
+<div id="pending"></div>
+<button id="apply">Apply pending migrations</button>
+<h2>History</h2>
+<table><thead><tr><th>Version</th><th>Name</th><th>Applied at</th><th>Duration</th><th></th></tr></thead>
+<tbody id="history"></tbody></table>
+<h2>Drift</h2>
+<div id="drift"></div>
+</div>
+<script>
+"use strict";
+const $ = id => document.getElementById(id);
+
+function el(tag, text, cls) {
+	const e = document.createElement(tag);
+	if (text !== undefined) e.textContent = text;
+	if (cls) e.className = cls;
+	return e;
+}
+
+async function api(method, path) {
+	const res = await fetch(path, {method, headers: {Authorization: "Bearer " + sessionStorage.token}});
+	const body = await res.json().catch(() => ({error: res.statusText}));
+	if (!res.ok) throw new Error(body.error);
+	return body;
+}
+
+// diff returns the lines of a and b as [op, line], op is " ", "-" or "+"
+function diff(a, b) {
+	a = a.split("\n"); b = b.split("\n");
+	const lcs = a.map(() => new Array(b.length + 1).fill(0));
+	lcs.push(new Array(b.length + 1).fill(0));
+	for (let i = a.length - 1; i >= 0; i--)
+		for (let j = b.length - 1; j >= 0; j--)
+			lcs[i][j] = a[i] === b[j] ? lcs[i+1][j+1] + 1 : Math.max(lcs[i+1][j], lcs[i][j+1]);
+	const out = [];
+	let i = 0, j = 0;
+	while (i < a.length || j < b.length) {
+		if (i < a.length && j < b.length && a[i] === b[j]) out.push([" ", a[i++]]), j++;
+		else if (j < b.length && (i === a.length || lcs[i][j+1] >= lcs[i+1][j])) out.push(["+", b[j++]]);
+		else out.push(["-", a[i++]]);
+	}
+	return out;
+}
+
+async function run(method, path, question) {
+	if (!confirm(question)) return;
+	try {
+		const res = await api(method, path);
+		alert("Migrated to version " + res.version);
+	} catch (e) {
+		alert(e.message);
+	}
+	load();
+}
+
+async function load() {
+	$("error").textContent = "";
+	try {
+		const [status, pending, history, drift] = await Promise.all([
+			api("GET", "/status"), api("GET", "/pending"), api("GET", "/history"), api("GET", "/drift")]);
+		$("main").hidden = false;
+		$("version").textContent = status.version;
+		$("latest").textContent = status.latest;
+		$("locked").textContent = status.locked ? "(locked by another migrator)" : "";
+
+		$("pending").replaceChildren(...pending.map(p => {
+			const d = el("details");
+			d.append(el("summary", p.version + " " + p.name), el("pre", p.sql));
+			return d;
+		}));
+		if (!pending.length) $("pending").append(el("p", "Up to date"));
+		$("apply").hidden = !pending.length;
+
+		$("history").replaceChildren(...history.slice().reverse().map((h, i, all) => {
+			const tr = el("tr");
+			tr.append(el("td", h.version), el("td", h.name),
+				el("td", h.applied_at.startsWith("0001") ? "" : new Date(h.applied_at).toLocaleString()),
+				el("td", h.duration_ms + " ms"));
+			const td = el("td");
+			if (i > 0) {
+				const b = el("button", "Roll back to " + h.version);
+				b.onclick = () => run("POST", "/down-to?to=" + encodeURIComponent(h.version),
+					"Roll back " + i + " version(s) to " + h.version + "?");
+				td.append(b);
+			}
+			tr.append(td);
+			return tr;
+		}));
+
+		const parts = [];
+		if (drift.added.length) parts.push(el("p", "Added: " + drift.added.join(", ")));
+		if (drift.removed.length) parts.push(el("p", "Removed: " + drift.removed.join(", ")));
+		for (const f of drift.files) {
+			const pre = el("pre");
+			for (const [op, line] of diff(f.stored, f.current))
+				pre.append(el("div", op + " " + line, op === "+" ? "add" : op === "-" ? "del" : ""));
+			parts.push(el("h3", f.version + " " + f.direction + " (stored vs current)"), pre);
+		}
+		if (!parts.length) parts.push(el("p", "No drift"));
+		$("drift").replaceChildren(...parts);
+	} catch (e) {
+		$("error").textContent = e.message;
+	}
+}
+
+$("login").onsubmit = e => {
+	e.preventDefault();
+	sessionStorage.token = $("token").value;
+	load();
+};
+$("apply").onclick = () => run("POST", "/up", "Apply " + $("pending").children.length + " pending migration(s)?");
+if (sessionStorage.token) load();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// generateDown returns the down file generated from up, and prints the statements
+// that couldn't be inverted
+func generateDown(name string, up []byte) []byte {
+	down, irreversible := file.GenerateDown(up)
+	for _, stmt := range irreversible {
+		printMessage(msgIrreversible, name, bytes.TrimSpace([]byte(stmt.SQL)))
+	}
+	return down
+}
+
+// fillDownFile writes the down file generated from the up file if the down file is empty
+func fillDownFile(m *migrate.Migrator, mf *file.MigrationFile) (filled bool, err error) {
+	if mf.UpFile == nil || mf.DownFile == nil {
+		return false, nil
+	}
+	downPath := mf.DownFile.Path(m.Path)
+	down, err := ioutil.ReadFile(downPath)
+	if err != nil || len(bytes.TrimSpace(down)) > 0 {
+		return false, err
+	}
+	up, err := ioutil.ReadFile(mf.UpFile.Path(m.Path))
+	if err != nil || len(bytes.TrimSpace(up)) == 0 {
+		return false, err
+	}
+	return true, ioutil.WriteFile(downPath, generateDown(mf.UpFile.FileName, up), 0644)
+}
+
+// runGenDown fills the empty down files in the path with the inverse of their up files
+func runGenDown(m *migrate.Migrator) error {
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		filled, err := fillDownFile(m, &files[i])
+		if err != nil {
+			return err
+		}
+		if filled {
+			printMessage(msgGeneratedDown, files[i].DownFile.FileName)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package dialect has the SQL syntax that differs between databases, so drivers
+// share one correct implementation of quoting, placeholders, limits and booleans.
+package dialect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect is the SQL syntax of a database
+type Dialect interface {
+	// QuoteIdent quotes an identifier, e.g. a table or column name
+	QuoteIdent(name string) string
+	// Placeholder returns the nth bind parameter, starting at 1
+	Placeholder(n int) string
+	// Limit returns the clause, appended after ORDER BY, that limits a query to n rows
+	Limit(n int) string
+	// Bool returns the boolean literal
+	Bool(b bool) string
+}
+
+var (
+	// Postgres quotes with "", uses $1 placeholders and TRUE/FALSE
+	Postgres Dialect = postgres{}
+	// MySQL quotes with ``, uses ? placeholders and TRUE/FALSE
+	MySQL Dialect = mysql{}
+	// MSSQL quotes with [], uses @p1 placeholders, OFFSET/FETCH limits and 1/0
+	MSSQL Dialect = mssql{}
+)
+
+// QuoteQualified quotes each part and joins them with dots, e.g. schema.table
+func QuoteQualified(d Dialect, parts ...string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = d.QuoteIdent(part)
+	}
+	return strings.Join(quoted, ".")
+}
+
+// quote wraps name in open and close, doubling close inside name.
+// Null bytes are removed since no database allows them in identifiers.
+func quote(name, open, close string) string {
+	name = strings.Replace(name, "\x00", "", -1)
+	return open + strings.Replace(name, close, close+close, -1) + close
+}
+
+func boolLiteral(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+type postgres struct{}
+
+func (postgres) QuoteIdent(name string) string { return quote(name, `"`, `"`) }
+func (postgres) Placeholder(n int) string      { return "$" + strconv.Itoa(n) }
+func (postgres) Limit(n int) string            { return "LIMIT " + strconv.Itoa(n) }
+func (postgres) Bool(b bool) string            { return boolLiteral(b) }
+
+type mysql struct{}
+
+func (mysql) QuoteIdent(name string) string { return quote(name, "`", "`") }
+func (mysql) Placeholder(n int) string      { return "?" }
+func (mysql) Limit(n int) string            { return "LIMIT " + strconv.Itoa(n) }
+func (mysql) Bool(b bool) string            { return boolLiteral(b) }
+
+type mssql struct{}
+
+func (mssql) QuoteIdent(name string) string { return quote(name, "[", "]") }
+func (mssql) Placeholder(n int) string      { return "@p" + strconv.Itoa(n) }
+func (mssql) Limit(n int) string {
+	return "OFFSET 0 ROWS FETCH NEXT " + strconv.Itoa(n) + " ROWS ONLY"
+}
+func (mssql) Bool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,45 @@
+package dialect
+
+import "testing"
+
+func TestQuoteIdent(t *testing.T) {
+	for _, tc := range []struct {
+		d      Dialect
+		name   string
+		expect string
+	}{
+		{Postgres, "users", `"users"`},
+		{Postgres, `my"table`, `"my""table"`},
+		{Postgres, "bad\x00name", `"badname"`},
+		{MySQL, "my`table", "`my``table`"},
+		{MSSQL, "my]table", "[my]]table]"},
+	} {
+		if got := tc.d.QuoteIdent(tc.name); got != tc.expect {
+			t.Errorf("Expected %s, got %s", tc.expect, got)
+		}
+	}
+	if got := QuoteQualified(Postgres, "public", "Users"); got != `"public"."Users"` {
+		t.Errorf(`Expected "public"."Users", got %s`, got)
+	}
+}
+
+func TestSyntax(t *testing.T) {
+	for _, tc := range []struct {
+		d                       Dialect
+		placeholder, limit, yes string
+	}{
+		{Postgres, "$2", "LIMIT 1", "TRUE"},
+		{MySQL, "?", "LIMIT 1", "TRUE"},
+		{MSSQL, "@p2", "OFFSET 0 ROWS FETCH NEXT 1 ROWS ONLY", "1"},
+	} {
+		if got := tc.d.Placeholder(2); got != tc.placeholder {
+			t.Errorf("Expected %s, got %s", tc.placeholder, got)
+		}
+		if got := tc.d.Limit(1); got != tc.limit {
+			t.Errorf("Expected %s, got %s", tc.limit, got)
+		}
+		if got := tc.d.Bool(true); got != tc.yes {
+			t.Errorf("Expected %s, got %s", tc.yes, got)
+		}
+	}
+}
@@ -2,8 +2,12 @@
 package driver
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/acls/migrate/file"
 )
@@ -42,6 +46,31 @@ type Conn interface {
 	Close() error
 }
 
+// ContextConn is implemented by connections that can run their queries with a context,
+// so they are canceled when the context is done
+type ContextConn interface {
+	Conn
+	// WithContext returns a copy of the connection that uses ctx
+	WithContext(ctx context.Context) Conn
+}
+
+// ContextDatabaser is implemented by transactions that can run their queries with a context,
+// so a migration can be canceled while keeping the transaction
+type ContextDatabaser interface {
+	Databaser
+	// WithContext returns a copy of the transaction that uses ctx
+	WithContext(ctx context.Context) Databaser
+}
+
+// ScriptDatabaser is implemented by the connections and transactions of a dry run, which
+// write the statements instead of executing them. Since the version table isn't changed,
+// drivers read the version from ScriptVersion instead.
+type ScriptDatabaser interface {
+	Databaser
+	// ScriptVersion returns the version after the statements written so far
+	ScriptVersion() file.Version
+}
+
 // CopyConn interface
 type CopyConn interface {
 	Copy
@@ -110,6 +139,417 @@ type Driver interface {
 	UpdateFiles(db Databaser, file *file.Migration, pipe chan interface{})
 }
 
+// ParallelDriver is implemented by drivers that can apply a migration's content and
+// record its version separately, so independent major versions can be applied concurrently.
+type ParallelDriver interface {
+	Driver
+
+	// MigrateContent applies the file without updating the version table.
+	MigrateContent(db Databaser, file *file.Migration, pipe chan interface{})
+
+	// RecordVersion inserts or deletes the file's version in the version table.
+	RecordVersion(db Databaser, file *file.Migration) error
+}
+
+// StatementDriver is implemented by drivers that can execute one statement of a migration
+// file, so files can be applied statement by statement, see file.SplitStatements
+type StatementDriver interface {
+	ParallelDriver
+
+	// ExecStatement executes the statement of the file
+	ExecStatement(db Databaser, f *file.File, stmt file.Statement) error
+}
+
+// RetryDriver is implemented by drivers that can tell transient errors, after which
+// a migration's transaction can be retried
+type RetryDriver interface {
+	Driver
+
+	// IsTransient returns true for errors like serialization failures and deadlocks
+	IsTransient(err error) bool
+}
+
+// IsolationLevel is the isolation level of a transaction
+type IsolationLevel string
+
+// The isolation levels
+const (
+	ReadCommitted  IsolationLevel = "READ COMMITTED"
+	RepeatableRead IsolationLevel = "REPEATABLE READ"
+	Serializable   IsolationLevel = "SERIALIZABLE"
+)
+
+// TxOptions are the isolation level and access mode of a transaction
+type TxOptions struct {
+	// IsolationLevel, e.g. Serializable. Empty keeps the database's default.
+	IsolationLevel IsolationLevel
+	// ReadWrite asserts that the transaction can write, so it fails on a read-only standby
+	ReadWrite bool
+}
+
+// TxOptionsDriver is implemented by drivers that can set the isolation level and access
+// mode of a transaction
+type TxOptionsDriver interface {
+	Driver
+
+	// SetTxOptions sets the options of the transaction before its first statement
+	SetTxOptions(tx Execer, opts TxOptions) error
+}
+
+// SavepointDriver is implemented by drivers that can roll back part of a transaction to a
+// savepoint
+type SavepointDriver interface {
+	Driver
+
+	// Savepoint sets the savepoint name in the transaction
+	Savepoint(tx Execer, name string) error
+	// RollbackToSavepoint rolls the transaction back to the savepoint name
+	RollbackToSavepoint(tx Execer, name string) error
+}
+
+// ContentOptions are the options of the calls that store the contents of a version's files
+type ContentOptions struct {
+	// SkipContent stores empty up and down files instead of their contents.
+	// The up file's checksum is still stored.
+	SkipContent bool
+}
+
+// ContentDriver is implemented by drivers that can record versions without storing the
+// contents of their files, keeping only the versions and checksums. The options are passed
+// with each call, so migrators with different options can share the driver.
+type ContentDriver interface {
+	ParallelDriver
+
+	// MigrateWith is Migrate with the options of the recorded contents
+	MigrateWith(db Databaser, file *file.Migration, opts ContentOptions, pipe chan interface{})
+	// RecordVersionWith is RecordVersion with the options of the recorded contents
+	RecordVersionWith(db Databaser, file *file.Migration, opts ContentOptions) error
+	// UpdateFilesWith is UpdateFiles with the options of the updated contents
+	UpdateFilesWith(db Databaser, file *file.Migration, opts ContentOptions, pipe chan interface{})
+}
+
+// VersionTableDriver is implemented by drivers that can inspect the version table without
+// changing it, so the commands that only read don't run the DDL of EnsureVersionTable
+type VersionTableDriver interface {
+	Driver
+
+	// VersionTableState returns whether the version table exists, and whether it's current:
+	// it has every column EnsureVersionTable adds and none it doesn't know
+	VersionTableState(db Queryer) (exists, current bool, err error)
+}
+
+// BatchUpdateDriver is implemented by drivers that can update the file contents of many
+// versions at once, instead of one UpdateFiles per version
+type BatchUpdateDriver interface {
+	Driver
+
+	// UpdateFilesBatch updates the up and down file contents of the migrations, calling
+	// progress with how many are updated so far after each batch
+	UpdateFilesBatch(db Databaser, files []*file.Migration, opts ContentOptions, progress func(updated int)) error
+}
+
+// Outcomes of a Run
+const (
+	RunSucceeded = "success"
+	RunFailed    = "failure"
+)
+
+// Run is an attempt to migrate, as recorded in the audit log
+type Run struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	// FromVersion and ToVersion are the database versions before and after the run
+	FromVersion string    `json:"from_version"`
+	ToVersion   string    `json:"to_version"`
+	User        string    `json:"user"`
+	Host        string    `json:"host"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	// Outcome is RunSucceeded or RunFailed
+	Outcome string `json:"outcome"`
+	// Error is the first error of a failed run
+	Error string `json:"error,omitempty"`
+	// Snapshot is the location of the dump taken before the run, if any
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// AuditDriver is implemented by drivers that can record the migration runs in an audit log table
+type AuditDriver interface {
+	Driver
+
+	// RecordRun inserts the run into the audit log table, creating the table if needed
+	RecordRun(db Execer, run Run) error
+
+	// Runs returns the recorded runs, the latest first. At most limit if it's positive.
+	Runs(db Databaser, limit int) ([]Run, error)
+}
+
+// RepeatableDriver is implemented by drivers that can apply repeatable migrations and
+// store their checksums, see file.RepeatablePrefix
+type RepeatableDriver interface {
+	Driver
+
+	// EnsureRepeatableTable creates the table that stores the checksums of repeatable migrations
+	EnsureRepeatableTable(db Execer) error
+
+	// RepeatableChecksums returns the checksums of the applied repeatable migrations by name
+	RepeatableChecksums(db Queryer) (map[string]string, error)
+
+	// ApplyRepeatable executes the repeatable migration and stores its checksum
+	ApplyRepeatable(db Databaser, f *file.File) error
+}
+
+// SquashDriver is implemented by drivers that can replace the recorded versions up to a
+// baseline version with the baseline, see file.BaselineName
+type SquashDriver interface {
+	Driver
+
+	// Squash stores the baseline's files in its recorded version, which becomes the
+	// first version, and deletes the versions before it
+	Squash(db Databaser, baseline *file.Migration, opts ContentOptions) error
+}
+
+// AppliedDriver is implemented by drivers that record when each version was applied
+// and how long it took, and return them in the files of GetMigrationFiles
+type AppliedDriver interface {
+	Driver
+
+	// RecordApplied sets when the recorded version was applied and how long it took
+	RecordApplied(db Execer, version file.Version, appliedAt time.Time, duration time.Duration) error
+}
+
+// SequenceDriver is implemented by drivers that can reset sequences after a restore
+type SequenceDriver interface {
+	Driver
+
+	// FixSequences sets the sequences owned by the columns of the tables in schema to the
+	// max value of their column and returns the names of the sequences that were set.
+	FixSequences(db Databaser, schema string) (sequences []string, err error)
+}
+
+// TableHashDriver is implemented by dump drivers that can hash the rows of a table, so
+// incremental dumps can skip the tables that didn't change
+type TableHashDriver interface {
+	DumpDriver
+
+	// TableHashes returns a hash of the rows of each table of schema, by table name, that
+	// doesn't depend on the order of the rows
+	TableHashes(db Databaser, schema string) (map[string]string, error)
+}
+
+// DumpOptions configures the connections of DumpParallel and RestoreParallel
+type DumpOptions struct {
+	// Jobs is the number of tables that are dumped or restored at once, each over its own
+	// connection
+	Jobs int
+	// NewConn opens the connections after the first one
+	NewConn func() (CopyConn, error)
+	// Snapshot makes the connections of a dump read the snapshot exported by the first one,
+	// so the tables are consistent with each other
+	Snapshot bool
+}
+
+// ParallelDumpDriver is implemented by dump drivers that can dump the tables of a schema over
+// several connections at once
+type ParallelDumpDriver interface {
+	DumpDriver
+
+	// DumpParallel dumps the tables like Dump, but sends each table's name to the pipe once
+	// it's dumped. It stops starting tables after an error or an interrupt.
+	DumpParallel(conn CopyConn, dw file.DumpWriter, schema string, opts DumpOptions, pipe chan interface{}, handleInterrupts func() chan os.Signal)
+}
+
+// ParallelRestoreDriver is implemented by dump drivers that can restore the tables of a schema
+// over several connections at once
+type ParallelRestoreDriver interface {
+	DumpDriver
+
+	// RestoreParallel restores the tables like Restore, in waves of tables whose foreign keys
+	// only reference the tables of earlier waves. It stops starting tables after an error or
+	// an interrupt. The dump's files must be readable at the same time.
+	RestoreParallel(conn CopyConn, dr file.DumpReader, schema string, opts DumpOptions, pipe chan interface{}, handleInterrupts func() chan os.Signal)
+}
+
+// ForeignTableError is returned by EnsureVersionTable when the version table exists with
+// columns it doesn't recognize, e.g. it belongs to another migration tool. The table is left as is.
+type ForeignTableError struct {
+	Table   string
+	Columns []string
+	// Tool is the migration tool the layout belongs to, if it's known
+	Tool string
+}
+
+func (e *ForeignTableError) Error() string {
+	owner := "another tool"
+	if e.Tool != "" {
+		owner = e.Tool
+	}
+	return fmt.Sprintf("Version table %s has an unrecognized layout (%s) and may belong to %s. "+
+		"Use another version table and import its history with 'import-history %s'",
+		e.Table, strings.Join(e.Columns, ", "), owner, e.Table)
+}
+
+// HistoryDriver is implemented by drivers that can read the version tables of other migration tools
+type HistoryDriver interface {
+	Driver
+
+	// LastForeignVersion returns the tool that owns the version table and the last version it applied
+	LastForeignVersion(db Queryer, table string) (tool, version string, err error)
+}
+
+// LockDriver is implemented by drivers that can lock a schema's migrations,
+// so concurrent migrators don't apply the same files
+type LockDriver interface {
+	Driver
+
+	// TryLock takes the migration lock of schema for the connection's session.
+	// ok is false if another session holds the lock.
+	TryLock(conn Conn, schema string) (ok bool, err error)
+
+	// Unlock releases the lock taken by TryLock
+	Unlock(conn Conn, schema string) error
+
+	// ForceUnlock releases the lock held by any session, e.g. a hung migrator
+	ForceUnlock(db Databaser, schema string) error
+}
+
+// Backfill is the progress of a backfill as stored in the database
+type Backfill struct {
+	Name string
+	// LastKey is the key of the last processed row
+	LastKey string
+	// Rows is the number of rows processed
+	Rows int64
+	// Total is the number of rows to process, or 0 if unknown
+	Total int64
+	Done  bool
+}
+
+// Percent returns the percent complete or -1 if the total is unknown
+func (b Backfill) Percent() float64 {
+	if b.Done {
+		return 100
+	}
+	if b.Total <= 0 {
+		return -1
+	}
+	return float64(b.Rows) * 100 / float64(b.Total)
+}
+
+// String returns the name and progress
+func (b Backfill) String() string {
+	switch p := b.Percent(); {
+	case b.Done:
+		return fmt.Sprintf("%s: done, %d rows", b.Name, b.Rows)
+	case p < 0:
+		return fmt.Sprintf("%s: %d rows", b.Name, b.Rows)
+	default:
+		return fmt.Sprintf("%s: %.1f%%, %d/%d rows", b.Name, p, b.Rows, b.Total)
+	}
+}
+
+// BackfillDriver is implemented by drivers that can store the progress of backfills
+type BackfillDriver interface {
+	Driver
+
+	// EnsureBackfillTable creates the table that stores the progress of backfills
+	EnsureBackfillTable(db Execer) error
+
+	// GetBackfill returns the progress of the backfill. ok is false if it hasn't started.
+	GetBackfill(db RowQueryer, name string) (b Backfill, ok bool, err error)
+
+	// GetBackfills returns the progress of all backfills
+	GetBackfills(db Queryer) ([]Backfill, error)
+
+	// SaveBackfill inserts or updates the progress of the backfill
+	SaveBackfill(db Execer, b Backfill) error
+}
+
+// Stats are the planner statistics of a schema's tables. They hold no column values,
+// so a snapshot of production can be shared and imported into a scratch database.
+type Stats struct {
+	Tables []TableStats `json:"tables"`
+}
+
+// TableStats are the size and column statistics of a table
+type TableStats struct {
+	Name    string        `json:"name"`
+	Rows    float64       `json:"rows"`
+	Pages   int64         `json:"pages"`
+	Columns []ColumnStats `json:"columns"`
+}
+
+// ColumnStats are the statistics of a column without its most common values or histogram
+type ColumnStats struct {
+	Name      string  `json:"name"`
+	NullFrac  float64 `json:"null_frac"`
+	AvgWidth  int32   `json:"avg_width"`
+	NDistinct float64 `json:"n_distinct"`
+}
+
+// StatsDriver is implemented by drivers that can export and import planner statistics
+// and explain queries
+type StatsDriver interface {
+	Driver
+
+	// ExportStats returns the statistics of the tables in schema
+	ExportStats(db Queryer, schema string) (Stats, error)
+
+	// ImportStats overwrites the statistics of the tables in schema.
+	// Tables and columns that don't exist are skipped.
+	ImportStats(db Databaser, schema string, stats Stats) error
+
+	// Explain returns the query plan of the query
+	Explain(db Queryer, query string) (plan string, err error)
+}
+
+// SchemaInfo is the structure of a schema's tables without their data
+type SchemaInfo struct {
+	Tables []TableInfo `json:"tables"`
+}
+
+// TableInfo is a table's columns, in order, and its indexes and constraints by name
+type TableInfo struct {
+	Name        string           `json:"name"`
+	Columns     []ColumnInfo     `json:"columns"`
+	Indexes     []IndexInfo      `json:"indexes"`
+	Constraints []ConstraintInfo `json:"constraints"`
+}
+
+// ColumnInfo is a column's type, nullability and default expression
+type ColumnInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	NotNull bool   `json:"not_null"`
+	Default string `json:"default,omitempty"`
+}
+
+// IndexInfo is an index that doesn't back a constraint. Definition is the statement
+// that creates it, without the schema.
+type IndexInfo struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+}
+
+// ConstraintInfo is a primary key, unique, foreign key, check or exclusion constraint.
+// Definition is its clause in ADD CONSTRAINT.
+type ConstraintInfo struct {
+	Name       string `json:"name"`
+	Definition string `json:"definition"`
+	ForeignKey bool   `json:"foreign_key,omitempty"`
+}
+
+// SchemaDriver is implemented by drivers that can inspect and diff the structure of schemas
+type SchemaDriver interface {
+	Driver
+
+	// InspectSchema returns the tables of schema, without the version table
+	InspectSchema(db Queryer, schema string) (SchemaInfo, error)
+
+	// DiffSchema returns the statements that change from's structure to to's
+	DiffSchema(from, to SchemaInfo) []string
+}
+
 // DumpDriver interface
 type DumpDriver interface {
 	Driver
@@ -0,0 +1,75 @@
+package pgx
+
+import (
+	"strconv"
+
+	"github.com/acls/migrate/driver"
+)
+
+var _ driver.AuditDriver = &pgDriver{}
+
+// auditTableName is the audit log table, named after the version table
+func (d *pgDriver) auditTableName() string {
+	return d.tableName + "_log"
+}
+
+// RecordRun inserts the run into the audit log table, outside of any migration transaction
+// so failed runs are recorded too
+func (d *pgDriver) RecordRun(db driver.Execer, run driver.Run) error {
+	tbl := d.auditTableName()
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + tbl + ` (
+		id TEXT PRIMARY KEY,
+		command TEXT NOT NULL,
+		from_version TEXT NOT NULL,
+		to_version TEXT NOT NULL,
+		username TEXT NOT NULL,
+		host TEXT NOT NULL,
+		started_at TIMESTAMPTZ NOT NULL,
+		finished_at TIMESTAMPTZ NOT NULL,
+		outcome TEXT NOT NULL,
+		error TEXT NOT NULL
+	);
+	ALTER TABLE ` + tbl + ` ADD COLUMN IF NOT EXISTS snapshot TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO "+tbl+" (id,command,from_version,to_version,username,host,started_at,finished_at,outcome,error,snapshot) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)",
+		run.ID, run.Command, run.FromVersion, run.ToVersion, run.User, run.Host, run.StartedAt, run.FinishedAt, run.Outcome, run.Error, run.Snapshot)
+}
+
+// Runs returns the recorded runs, the latest first, or none if nothing was recorded yet
+func (d *pgDriver) Runs(db driver.Databaser, limit int) (runs []driver.Run, err error) {
+	tbl := d.auditTableName()
+	var exists bool
+	if err = db.QueryRow("SELECT to_regclass($1) IS NOT NULL", tbl).Scan(&exists); err != nil || !exists {
+		return
+	}
+	// the snapshot column was added later, and is only added by RecordRun
+	var snapshot bool
+	if err = db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_attribute WHERE attrelid = $1::regclass AND attname = 'snapshot' AND NOT attisdropped)", tbl).Scan(&snapshot); err != nil {
+		return
+	}
+	cols := "id,command,from_version,to_version,username,host,started_at,finished_at,outcome,error"
+	if snapshot {
+		cols += ",snapshot"
+	} else {
+		cols += ",''"
+	}
+	qry := "SELECT " + cols + " FROM " + tbl + " ORDER BY started_at DESC"
+	if limit > 0 {
+		qry += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := db.Query(qry)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r driver.Run
+		if err = rows.Scan(&r.ID, &r.Command, &r.FromVersion, &r.ToVersion, &r.User, &r.Host, &r.StartedAt, &r.FinishedAt, &r.Outcome, &r.Error, &r.Snapshot); err != nil {
+			return
+		}
+		runs = append(runs, r)
+	}
+	err = rows.Err()
+	return
+}
@@ -0,0 +1,65 @@
+package pgx
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/jackc/pgx"
+)
+
+var _ driver.BackfillDriver = &pgDriver{}
+
+func (d *pgDriver) backfillTableName() string {
+	return d.tableName + "_backfills"
+}
+
+// EnsureBackfillTable creates the backfill progress table
+func (d *pgDriver) EnsureBackfillTable(db driver.Execer) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS ` + d.backfillTableName() + ` (
+		name TEXT NOT NULL PRIMARY KEY,
+		last_key TEXT NOT NULL DEFAULT '',
+		rows_done BIGINT NOT NULL DEFAULT 0,
+		total BIGINT NOT NULL DEFAULT 0,
+		done BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+}
+
+// GetBackfill returns the progress of the backfill
+func (d *pgDriver) GetBackfill(db driver.RowQueryer, name string) (b driver.Backfill, ok bool, err error) {
+	b.Name = name
+	err = db.QueryRow("SELECT last_key, rows_done, total, done FROM "+d.backfillTableName()+" WHERE name = $1", name).
+		Scan(&b.LastKey, &b.Rows, &b.Total, &b.Done)
+	if err == pgx.ErrNoRows {
+		return b, false, nil
+	}
+	return b, err == nil, err
+}
+
+// GetBackfills returns the progress of all backfills
+func (d *pgDriver) GetBackfills(db driver.Queryer) (backfills []driver.Backfill, err error) {
+	rows, err := db.Query("SELECT name, last_key, rows_done, total, done FROM " + d.backfillTableName() + " ORDER BY name")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b driver.Backfill
+		if err = rows.Scan(&b.Name, &b.LastKey, &b.Rows, &b.Total, &b.Done); err != nil {
+			return
+		}
+		backfills = append(backfills, b)
+	}
+	return
+}
+
+// SaveBackfill inserts or updates the progress of the backfill
+func (d *pgDriver) SaveBackfill(db driver.Execer, b driver.Backfill) error {
+	return db.Exec(`INSERT INTO `+d.backfillTableName()+` (name, last_key, rows_done, total, done)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) DO UPDATE SET
+			last_key = EXCLUDED.last_key,
+			rows_done = EXCLUDED.rows_done,
+			total = EXCLUDED.total,
+			done = EXCLUDED.done,
+			updated_at = NOW()`,
+		b.Name, b.LastKey, b.Rows, b.Total, b.Done)
+}
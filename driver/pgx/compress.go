@@ -0,0 +1,62 @@
+package pgx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/acls/migrate/driver"
+)
+
+// compress gzips the content. Empty content stays empty, so files recorded without
+// their content don't take any space.
+func compress(content []byte) ([]byte, error) {
+	if len(content) == 0 {
+		return []byte{}, nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress gunzips the content written by compress
+func decompress(content []byte) ([]byte, error) {
+	if len(content) == 0 {
+		return content, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compressedContent is stored in the up_file and down_file columns of the versions whose
+// contents are compressed, so the older releases that only read those columns fail to verify
+// or apply them, instead of reading empty files. It isn't valid SQL.
+const compressedContent = "MIGRATE: this file is stored compressed in the up_file_gz and down_file_gz columns, upgrade migrate to read it"
+
+// compressedText returns the text column of the compressed content
+func compressedText(gz []byte) string {
+	if len(gz) == 0 {
+		return ""
+	}
+	return compressedContent
+}
+
+// ensureVersionTableCompressed adds the up_file_gz and down_file_gz columns. The contents of
+// the versions recorded before them stay in the up_file and down_file columns, which are read
+// when the compressed columns are NULL, until they're updated.
+func ensureVersionTableCompressed(db driver.Databaser, tbl string) error {
+	return db.Exec(`ALTER TABLE ` + tbl + `
+		ADD COLUMN IF NOT EXISTS up_file_gz BYTEA,
+		ADD COLUMN IF NOT EXISTS down_file_gz BYTEA
+	`)
+}
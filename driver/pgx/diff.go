@@ -0,0 +1,298 @@
+package pgx
+
+import (
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/driver/dialect"
+)
+
+var _ driver.SchemaDriver = &pgDriver{}
+
+// InspectSchema reads the tables' columns from pg_attribute, their constraints from
+// pg_constraint and the indexes that don't back a constraint from pg_index
+func (d *pgDriver) InspectSchema(db driver.Queryer, schema string) (info driver.SchemaInfo, err error) {
+	if schema == "" {
+		schema = "public"
+	}
+	tables := map[string]int{}
+	table := func(name string) *driver.TableInfo {
+		i, ok := tables[name]
+		if !ok {
+			i = len(info.Tables)
+			tables[name] = i
+			info.Tables = append(info.Tables, driver.TableInfo{Name: name})
+		}
+		return &info.Tables[i]
+	}
+
+	rows, err := db.Query(`SELECT c.relname, a.attname, format_type(a.atttypid, a.atttypmod), a.attnotnull,
+			COALESCE(pg_get_expr(ad.adbin, ad.adrelid), '')
+		FROM pg_attribute a
+		JOIN pg_class c ON c.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND c.relname NOT LIKE $2
+			AND a.attnum > 0 AND NOT a.attisdropped
+		ORDER BY c.relname, a.attnum`, schema, d.tableName+"%")
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var name string
+		var c driver.ColumnInfo
+		if err = rows.Scan(&name, &c.Name, &c.Type, &c.NotNull, &c.Default); err != nil {
+			rows.Close()
+			return
+		}
+		t := table(name)
+		t.Columns = append(t.Columns, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	rows, err = db.Query(`SELECT c.relname, con.conname, pg_get_constraintdef(con.oid), con.contype = 'f'
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname NOT LIKE $2 AND con.contype IN ('p', 'u', 'f', 'c', 'x')
+		ORDER BY c.relname, con.conname`, schema, d.tableName+"%")
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var name string
+		var con driver.ConstraintInfo
+		if err = rows.Scan(&name, &con.Name, &con.Definition, &con.ForeignKey); err != nil {
+			rows.Close()
+			return
+		}
+		if _, ok := tables[name]; ok {
+			t := table(name)
+			t.Constraints = append(t.Constraints, con)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	rows, err = db.Query(`SELECT t.relname, i.relname, pg_get_indexdef(i.oid)
+		FROM pg_index x
+		JOIN pg_class i ON i.oid = x.indexrelid
+		JOIN pg_class t ON t.oid = x.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		WHERE n.nspname = $1 AND t.relname NOT LIKE $2
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint con WHERE con.conindid = x.indexrelid)
+		ORDER BY t.relname, i.relname`, schema, d.tableName+"%")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var idx driver.IndexInfo
+		if err = rows.Scan(&name, &idx.Name, &idx.Definition); err != nil {
+			return
+		}
+		if _, ok := tables[name]; ok {
+			idx.Definition = unqualifyIndex(idx.Definition, schema)
+			t := table(name)
+			t.Indexes = append(t.Indexes, idx)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// unqualifyIndex removes the schema from the table of pg_get_indexdef's statement,
+// so the indexes of different schemas compare equal
+func unqualifyIndex(def, schema string) string {
+	for _, q := range []string{dialect.Postgres.QuoteIdent(schema) + ".", schema + "."} {
+		if i := strings.Index(def, " ON "); i >= 0 {
+			if j := strings.Index(def[i:], " "+q); j >= 0 {
+				i += j
+				return def[:i+1] + def[i+1+len(q):]
+			}
+		}
+	}
+	return def
+}
+
+// DiffSchema drops what's only in from, alters the columns that differ and creates what's
+// only in to. Changed constraints and indexes are dropped and created again. Renames are
+// drops and adds, since they can't be told apart.
+func (d *pgDriver) DiffSchema(from, to driver.SchemaInfo) []string {
+	return diffSchema(from, to)
+}
+
+func diffSchema(from, to driver.SchemaInfo) (stmts []string) {
+	q := dialect.Postgres.QuoteIdent
+	fromTables, toTables := tablesByName(from), tablesByName(to)
+	var addConstraints, addForeignKeys, createIndexes []string
+
+	// foreign keys first, so the tables they reference can be dropped
+	for _, fks := range []bool{true, false} {
+		for _, ft := range from.Tables {
+			tt, ok := toTables[ft.Name]
+			if !ok {
+				continue
+			}
+			for _, fc := range ft.Constraints {
+				if fc.ForeignKey != fks {
+					continue
+				}
+				if tc, ok := findConstraint(tt.Constraints, fc.Name); !ok || tc.Definition != fc.Definition {
+					stmts = append(stmts, "ALTER TABLE "+q(ft.Name)+" DROP CONSTRAINT "+q(fc.Name))
+				}
+			}
+		}
+	}
+	for _, ft := range from.Tables {
+		tt, ok := toTables[ft.Name]
+		if !ok {
+			continue
+		}
+		for _, fi := range ft.Indexes {
+			if ti, ok := findIndex(tt.Indexes, fi.Name); !ok || ti.Definition != fi.Definition {
+				stmts = append(stmts, "DROP INDEX "+q(fi.Name))
+			}
+		}
+	}
+	// in one statement, since the dropped tables may reference each other
+	var dropTables []string
+	for _, ft := range from.Tables {
+		if _, ok := toTables[ft.Name]; !ok {
+			dropTables = append(dropTables, q(ft.Name))
+		}
+	}
+	if len(dropTables) > 0 {
+		stmts = append(stmts, "DROP TABLE "+strings.Join(dropTables, ", "))
+	}
+
+	for _, tt := range to.Tables {
+		ft, ok := fromTables[tt.Name]
+		if !ok {
+			cols := make([]string, len(tt.Columns))
+			for i, c := range tt.Columns {
+				cols[i] = "\t" + columnDefinition(c)
+			}
+			stmts = append(stmts, "CREATE TABLE "+q(tt.Name)+" (\n"+strings.Join(cols, ",\n")+"\n)")
+			ft = &driver.TableInfo{}
+		} else {
+			stmts = append(stmts, alterColumns(ft, &tt)...)
+		}
+		for _, tc := range tt.Constraints {
+			if fc, ok := findConstraint(ft.Constraints, tc.Name); ok && fc.Definition == tc.Definition {
+				continue
+			}
+			stmt := "ALTER TABLE " + q(tt.Name) + " ADD CONSTRAINT " + q(tc.Name) + " " + tc.Definition
+			if tc.ForeignKey {
+				addForeignKeys = append(addForeignKeys, stmt)
+			} else {
+				addConstraints = append(addConstraints, stmt)
+			}
+		}
+		for _, ti := range tt.Indexes {
+			if fi, ok := findIndex(ft.Indexes, ti.Name); !ok || fi.Definition != ti.Definition {
+				createIndexes = append(createIndexes, ti.Definition)
+			}
+		}
+	}
+	// foreign keys last, after the keys they reference
+	stmts = append(stmts, addConstraints...)
+	stmts = append(stmts, addForeignKeys...)
+	return append(stmts, createIndexes...)
+}
+
+// alterColumns drops, adds and alters the columns of a table that's in both schemas
+func alterColumns(ft, tt *driver.TableInfo) (stmts []string) {
+	q := dialect.Postgres.QuoteIdent
+	table := "ALTER TABLE " + q(tt.Name) + " "
+	for _, fc := range ft.Columns {
+		if _, ok := findColumn(tt.Columns, fc.Name); !ok {
+			stmts = append(stmts, table+"DROP COLUMN "+q(fc.Name))
+		}
+	}
+	for _, tc := range tt.Columns {
+		fc, ok := findColumn(ft.Columns, tc.Name)
+		if !ok {
+			stmts = append(stmts, table+"ADD COLUMN "+columnDefinition(tc))
+			continue
+		}
+		column := table + "ALTER COLUMN " + q(tc.Name) + " "
+		if fc.Type != tc.Type {
+			stmts = append(stmts, column+"TYPE "+tc.Type)
+		}
+		if fc.Default != tc.Default {
+			if tc.Default == "" {
+				stmts = append(stmts, column+"DROP DEFAULT")
+			} else {
+				stmts = append(stmts, column+"SET DEFAULT "+tc.Default)
+			}
+		}
+		if fc.NotNull != tc.NotNull {
+			if tc.NotNull {
+				stmts = append(stmts, column+"SET NOT NULL")
+			} else {
+				stmts = append(stmts, column+"DROP NOT NULL")
+			}
+		}
+	}
+	return
+}
+
+// serialTypes are the types of a new column whose default is a sequence's nextval, which
+// creates the sequence too
+var serialTypes = map[string]string{"smallint": "smallserial", "integer": "serial", "bigint": "bigserial"}
+
+func columnDefinition(c driver.ColumnInfo) string {
+	if serial, ok := serialTypes[c.Type]; ok && strings.HasPrefix(c.Default, "nextval(") {
+		c.Type, c.Default = serial, ""
+	}
+	def := dialect.Postgres.QuoteIdent(c.Name) + " " + c.Type
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+	if c.Default != "" {
+		def += " DEFAULT " + c.Default
+	}
+	return def
+}
+
+func tablesByName(info driver.SchemaInfo) map[string]*driver.TableInfo {
+	tables := make(map[string]*driver.TableInfo, len(info.Tables))
+	for i := range info.Tables {
+		tables[info.Tables[i].Name] = &info.Tables[i]
+	}
+	return tables
+}
+
+func findColumn(cols []driver.ColumnInfo, name string) (driver.ColumnInfo, bool) {
+	for _, c := range cols {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return driver.ColumnInfo{}, false
+}
+
+func findConstraint(cons []driver.ConstraintInfo, name string) (driver.ConstraintInfo, bool) {
+	for _, c := range cons {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return driver.ConstraintInfo{}, false
+}
+
+func findIndex(idxs []driver.IndexInfo, name string) (driver.IndexInfo, bool) {
+	for _, i := range idxs {
+		if i.Name == name {
+			return i, true
+		}
+	}
+	return driver.IndexInfo{}, false
+}
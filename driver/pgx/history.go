@@ -0,0 +1,126 @@
+package pgx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/driver/dialect"
+)
+
+var _ driver.HistoryDriver = &pgDriver{}
+
+// versionTableColumns are the columns of the v1 and v2 version tables and their types
+var versionTableColumns = map[string]string{
+	"version":      "integer",
+	"up_file":      "text",
+	"down_file":    "text",
+	"major":        "integer",
+	"minor":        "integer",
+	"prev_major":   "integer",
+	"prev_minor":   "integer",
+	"applied_at":   "timestamp with time zone",
+	"duration_ms":  "bigint",
+	"up_checksum":  "text",
+	"up_file_gz":   "bytea",
+	"name":         "text",
+	"down_file_gz": "bytea",
+}
+
+type column struct {
+	name, typ string
+}
+
+// tableColumns returns the columns of the table, or none if it doesn't exist
+func tableColumns(db driver.Queryer, table string) (columns []column, err error) {
+	rows, err := db.Query(`SELECT attname, format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped
+		ORDER BY attnum`, table)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c column
+		if err = rows.Scan(&c.name, &c.typ); err != nil {
+			return
+		}
+		columns = append(columns, c)
+	}
+	err = rows.Err()
+	return
+}
+
+// checkVersionTable returns a *driver.ForeignTableError if the table exists
+// with columns of another layout than the version table's
+func checkVersionTable(db driver.Queryer, table string) error {
+	columns, err := tableColumns(db, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	foreign := true
+	for _, c := range columns {
+		if c.name == "version" || c.name == "major" {
+			foreign = false
+		}
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.name
+		if typ, ok := versionTableColumns[c.name]; !ok || typ != c.typ {
+			foreign = true
+		}
+	}
+	if !foreign {
+		return nil
+	}
+	return &driver.ForeignTableError{Table: table, Columns: names, Tool: foreignTool(columns)}
+}
+
+// foreignTool returns the migration tool with the columns' layout
+func foreignTool(columns []column) string {
+	has := map[string]bool{}
+	for _, c := range columns {
+		has[c.name] = true
+	}
+	switch {
+	case has["installed_rank"] && has["script"] && has["success"]:
+		return "Flyway"
+	case has["version"] && has["dirty"] && len(columns) == 2:
+		return "golang-migrate"
+	}
+	return ""
+}
+
+// LastForeignVersion reads the last successful version of a Flyway or golang-migrate version table
+func (d *pgDriver) LastForeignVersion(db driver.Queryer, table string) (tool, version string, err error) {
+	columns, err := tableColumns(db, table)
+	if err != nil {
+		return
+	}
+	if len(columns) == 0 {
+		return "", "", fmt.Errorf("Version table %s not found", table)
+	}
+	// the table is user supplied, e.g. flyway_schema_history or public.flyway_schema_history
+	quoted := dialect.QuoteQualified(dialect.Postgres, strings.Split(table, ".")...)
+	var qry string
+	switch tool = foreignTool(columns); tool {
+	case "Flyway":
+		qry = "SELECT version FROM " + quoted + " WHERE success AND version IS NOT NULL ORDER BY installed_rank DESC LIMIT 1"
+	case "golang-migrate":
+		qry = "SELECT version::text FROM " + quoted + " WHERE NOT dirty LIMIT 1"
+	default:
+		return "", "", errors.New("Unknown version table layout of " + table)
+	}
+	rows, err := db.Query(qry)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return tool, "", fmt.Errorf("No applied versions in %s", table)
+	}
+	err = rows.Scan(&version)
+	return
+}
@@ -0,0 +1,39 @@
+package pgx
+
+import (
+	"hash/fnv"
+
+	"github.com/acls/migrate/driver"
+)
+
+var _ driver.LockDriver = &pgDriver{}
+
+// lockClass is the first key of the advisory locks, "migr"
+const lockClass int32 = 0x6d696772
+
+// lockKey is the second key of the schema's advisory lock
+func (d *pgDriver) lockKey(schema string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(schema + "." + d.tableName))
+	return int32(h.Sum32())
+}
+
+// TryLock takes a session level advisory lock for the schema
+func (d *pgDriver) TryLock(conn driver.Conn, schema string) (ok bool, err error) {
+	err = conn.QueryRow("SELECT pg_try_advisory_lock($1, $2)", lockClass, d.lockKey(schema)).Scan(&ok)
+	return
+}
+
+// Unlock releases the schema's advisory lock
+func (d *pgDriver) Unlock(conn driver.Conn, schema string) error {
+	var ok bool
+	return conn.QueryRow("SELECT pg_advisory_unlock($1, $2)", lockClass, d.lockKey(schema)).Scan(&ok)
+}
+
+// ForceUnlock terminates the sessions holding the schema's advisory lock, since
+// only the session that took it can release it
+func (d *pgDriver) ForceUnlock(db driver.Databaser, schema string) error {
+	return db.Exec(`SELECT pg_terminate_backend(pid) FROM pg_locks
+		WHERE locktype = 'advisory' AND classid = $1::int::oid AND objid = $2::int::oid AND objsubid = 2`,
+		lockClass, d.lockKey(schema))
+}
@@ -0,0 +1,294 @@
+package pgx
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// DumpParallel dumps the tables over conn and the connections of opts.NewConn. With
+// opts.Snapshot, conn begins a transaction that exports its snapshot, which the other
+// connections' transactions import, so all the tables are dumped as of the same moment.
+func (d *pgDriver) DumpParallel(conn driver.CopyConn, dw file.DumpWriter, schema string, opts driver.DumpOptions, pipe chan interface{}, handleInterrupts func() chan os.Signal) {
+	defer close(pipe)
+
+	if schema == "" {
+		schema = "public"
+	}
+
+	tbls, err := d.getTables(conn, schema)
+	if err != nil {
+		pipe <- err
+		return
+	}
+
+	conns, closeConns, err := openConns(conn, opts.NewConn, opts.Jobs, len(tbls))
+	defer closeConns()
+	if err != nil {
+		pipe <- err
+		return
+	}
+	if opts.Snapshot {
+		end, err := shareSnapshot(conns)
+		defer end()
+		if err != nil {
+			pipe <- err
+			return
+		}
+	}
+
+	runParallel(conns, tbls, func(pipe chan interface{}, conn driver.CopyConn, tbl string) {
+		// the name is sent once the table is dumped, instead of when it's started
+		pipe1 := pipep.New()
+		go dumpTable(pipe1, conn, dw, schema, tbl)
+		var name interface{}
+		failed := false
+		for item := range pipe1 {
+			switch item.(type) {
+			case string:
+				if name == nil {
+					name = item
+					continue
+				}
+			case error:
+				failed = true
+			}
+			pipe <- item
+		}
+		if name != nil && !failed {
+			pipe <- name
+		}
+	}, pipe, handleInterrupts)
+}
+
+// RestoreParallel restores the tables over conn and the connections of opts.NewConn, in the
+// waves of foreignKeyWaves. Foreign keys are disabled on each connection like in Restore.
+func (d *pgDriver) RestoreParallel(conn driver.CopyConn, dr file.DumpReader, schema string, opts driver.DumpOptions, pipe chan interface{}, handleInterrupts func() chan os.Signal) {
+	defer close(pipe)
+
+	if schema == "" {
+		schema = "public"
+	}
+
+	openers, err := dr.Files(file.TablesDir)
+	if err != nil {
+		pipe <- err
+		return
+	}
+	tables := make(map[string]file.Opener, len(openers))
+	names := make([]string, len(openers))
+	for i, o := range openers {
+		tables[o.Name] = o
+		names[i] = o.Name
+	}
+	refs, err := d.foreignKeys(conn, schema)
+	if err != nil {
+		pipe <- err
+		return
+	}
+
+	conns, closeConns, err := openConns(conn, opts.NewConn, opts.Jobs, len(openers))
+	defer closeConns()
+	if err != nil {
+		pipe <- err
+		return
+	}
+	for _, c := range conns {
+		// Disable foreign keys to prevent foreign key violations during import. https://stackoverflow.com/a/18709987
+		if err := c.Exec("SET session_replication_role = replica;"); err != nil {
+			pipe <- err
+			return
+		}
+		// Re-enable foreign keys for this connection.
+		defer c.Exec("SET session_replication_role = default;")
+	}
+
+	for _, wave := range foreignKeyWaves(names, refs) {
+		ok := runParallel(conns, wave, func(pipe chan interface{}, conn driver.CopyConn, name string) {
+			restoreTable(pipe, conn, schema, tables[name])
+		}, pipe, handleInterrupts)
+		if !ok {
+			return
+		}
+	}
+}
+
+// foreignKeys returns the tables of schema that each table's foreign keys reference
+func (d *pgDriver) foreignKeys(db driver.Queryer, schema string) (refs map[string][]string, err error) {
+	rows, err := db.Query(`SELECT
+			t.relname, r.relname
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace tn ON tn.oid = t.relnamespace
+		JOIN pg_class r ON r.oid = c.confrelid
+		JOIN pg_namespace rn ON rn.oid = r.relnamespace
+		WHERE
+			c.contype = 'f'
+			AND tn.nspname = $1
+			AND rn.nspname = $1`,
+		schema,
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	refs = map[string][]string{}
+	for rows.Next() {
+		var tbl, ref string
+		if err = rows.Scan(&tbl, &ref); err != nil {
+			return
+		}
+		refs[tbl] = append(refs[tbl], ref)
+	}
+	return
+}
+
+// foreignKeyWaves groups the tables into waves whose foreign keys only reference the tables of
+// earlier waves, or themselves. The tables of foreign key cycles, and the ones that reference
+// them, are in the last wave.
+func foreignKeyWaves(tables []string, refs map[string][]string) (waves [][]string) {
+	left := map[string]bool{}
+	for _, tbl := range tables {
+		left[tbl] = true
+	}
+	for len(left) > 0 {
+		var wave []string
+		for tbl := range left {
+			ready := true
+			for _, ref := range refs[tbl] {
+				if ref != tbl && left[ref] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, tbl)
+			}
+		}
+		if len(wave) == 0 {
+			// a cycle
+			for tbl := range left {
+				wave = append(wave, tbl)
+			}
+		}
+		sort.Strings(wave)
+		for _, tbl := range wave {
+			delete(left, tbl)
+		}
+		waves = append(waves, wave)
+	}
+	return
+}
+
+// openConns returns conn and the connections of newConn, up to jobs or the number of tasks.
+// closeConns closes the connections that were opened.
+func openConns(conn driver.CopyConn, newConn func() (driver.CopyConn, error), jobs, tasks int) (conns []driver.CopyConn, closeConns func(), err error) {
+	conns = []driver.CopyConn{conn}
+	closeConns = func() {
+		for _, c := range conns[1:] {
+			c.Close()
+		}
+	}
+	if jobs > tasks {
+		jobs = tasks
+	}
+	for len(conns) < jobs {
+		c, err := newConn()
+		if err != nil {
+			return conns, closeConns, err
+		}
+		conns = append(conns, c)
+	}
+	return conns, closeConns, nil
+}
+
+// shareSnapshot begins a read only transaction on each connection, and sets the snapshot of
+// the first one's on the others. The snapshot is valid until end commits the transactions.
+func shareSnapshot(conns []driver.CopyConn) (end func(), err error) {
+	var begun []driver.CopyConn
+	end = func() {
+		for _, c := range begun {
+			c.Exec("COMMIT")
+		}
+	}
+	const begin = "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY"
+	if err = conns[0].Exec(begin); err != nil {
+		return
+	}
+	begun = append(begun, conns[0])
+	var id string
+	if err = conns[0].QueryRow("SELECT pg_export_snapshot()").Scan(&id); err != nil {
+		return
+	}
+	for _, c := range conns[1:] {
+		if err = c.Exec(begin); err != nil {
+			return
+		}
+		begun = append(begun, c)
+		if err = c.Exec("SET TRANSACTION SNAPSHOT '" + strings.Replace(id, "'", "''", -1) + "'"); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// runParallel runs task for each name, each over the next free connection, and redirects
+// the items of the tasks to the pipe. After an error or an interrupt, the tasks that haven't
+// started are skipped and the running ones are waited for.
+func runParallel(conns []driver.CopyConn, names []string, task func(pipe chan interface{}, conn driver.CopyConn, name string), pipe chan interface{}, handleInterrupts func() chan os.Signal) (ok bool) {
+	items := pipep.New()
+	next := make(chan string)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopTasks := func() { stopOnce.Do(func() { close(stop) }) }
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c driver.CopyConn) {
+			defer wg.Done()
+			for name := range next {
+				task(items, c, name)
+			}
+		}(c)
+	}
+	go func() {
+		defer close(items)
+		defer wg.Wait()
+		defer close(next)
+		for _, name := range names {
+			select {
+			case next <- name:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	ok = true
+	interrupts := handleInterrupts()
+	for {
+		select {
+		case <-interrupts:
+			// the caller reports the interrupt
+			ok = false
+			stopTasks()
+			interrupts = nil
+		case item, more := <-items:
+			if !more {
+				return
+			}
+			if _, isErr := item.(error); isErr {
+				ok = false
+				stopTasks()
+			}
+			pipe <- item
+		}
+	}
+}
@@ -2,6 +2,8 @@
 package pgx
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"time"
 
 	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/driver/dialect"
 	"github.com/acls/migrate/file"
 	"github.com/acls/migrate/migrate/direction"
 	pipep "github.com/acls/migrate/pipe"
@@ -21,6 +24,25 @@ type pgDriver struct {
 
 const defaultTableName = "schema_migrations"
 
+var _ driver.AppliedDriver = &pgDriver{}
+var _ driver.ParallelDriver = &pgDriver{}
+var _ driver.StatementDriver = &pgDriver{}
+var _ driver.RetryDriver = &pgDriver{}
+var _ driver.TxOptionsDriver = &pgDriver{}
+var _ driver.ContentDriver = &pgDriver{}
+var _ driver.VersionTableDriver = &pgDriver{}
+var _ driver.BatchUpdateDriver = &pgDriver{}
+var _ driver.SequenceDriver = &pgDriver{}
+var _ driver.SquashDriver = &pgDriver{}
+
+func init() {
+	for _, scheme := range []string{"postgres", "postgresql"} {
+		driver.Register(scheme, func(tableName string) driver.Driver {
+			return New(tableName)
+		})
+	}
+}
+
 // New creates a new postgresql driver
 func New(tableName string) driver.DumpDriver {
 	d := &pgDriver{
@@ -112,7 +134,12 @@ func (d *pgDriver) EnsureVersionTable(db driver.Beginner, schema string) (err er
 	if file.V2 {
 		versions = append(versions, ensureVersionTableV2)
 	}
+	versions = append(versions, ensureVersionTableApplied, ensureVersionTableChecksum, ensureVersionTableCompressed, ensureVersionTableName)
 	tbl := d.tableName
+	// don't alter another tool's table
+	if err = checkVersionTable(tx, tbl); err != nil {
+		return
+	}
 	for _, ensureVersion := range versions {
 		if err = ensureVersion(tx, tbl); err != nil {
 			return
@@ -120,6 +147,29 @@ func (d *pgDriver) EnsureVersionTable(db driver.Beginner, schema string) (err er
 	}
 	return
 }
+
+// VersionTableState returns whether the version table exists in the search path, and whether
+// it has the columns of the current layout
+func (d *pgDriver) VersionTableState(db driver.Queryer) (exists, current bool, err error) {
+	columns, err := tableColumns(db, d.tableName)
+	if err != nil || len(columns) == 0 {
+		return false, false, err
+	}
+	has := map[string]bool{}
+	for _, c := range columns {
+		if typ, ok := versionTableColumns[c.name]; !ok || typ != c.typ {
+			return true, false, nil
+		}
+		has[c.name] = true
+	}
+	current = has["up_file"] && has["down_file"] && has["applied_at"] && has["duration_ms"] &&
+		has["up_checksum"] && has["up_file_gz"] && has["down_file_gz"] && has["name"]
+	if file.V2 {
+		current = current && has["major"] && !has["version"]
+	}
+	return true, current, nil
+}
+
 func ensureVersionTableV1(db driver.Databaser, tbl string) (err error) {
 	sqlCommands := []string{
 		// initial create
@@ -139,6 +189,54 @@ func ensureVersionTableV1(db driver.Databaser, tbl string) (err error) {
 	}
 	return nil
 }
+func ensureVersionTableApplied(db driver.Databaser, tbl string) error {
+	return db.Exec(`ALTER TABLE ` + tbl + `
+		ADD COLUMN IF NOT EXISTS applied_at TIMESTAMPTZ,
+		ADD COLUMN IF NOT EXISTS duration_ms BIGINT
+	`)
+}
+
+// ensureVersionTableChecksum adds the up_checksum column and, when it's added, fills it for the
+// versions recorded before it, with the checksums computed like file.Checksum
+func ensureVersionTableChecksum(db driver.Databaser, tbl string) error {
+	columns, err := tableColumns(db, tbl)
+	if err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if c.name == "up_checksum" {
+			return nil
+		}
+	}
+	if err := db.Exec("ALTER TABLE " + tbl + " ADD COLUMN up_checksum TEXT"); err != nil {
+		return err
+	}
+
+	rows, err := db.Query("SELECT DISTINCT up_file FROM " + tbl)
+	if err != nil {
+		return err
+	}
+	var ups, checksums []string
+	for rows.Next() {
+		var up string
+		if err := rows.Scan(&up); err != nil {
+			rows.Close()
+			return err
+		}
+		ups = append(ups, up)
+		checksums = append(checksums, file.Checksum([]byte(up)))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(ups) == 0 {
+		return err
+	}
+	return db.Exec("UPDATE "+tbl+" SET up_checksum = c.checksum FROM unnest($1::text[], $2::text[]) AS c(up_file, checksum) WHERE "+tbl+".up_file = c.up_file", ups, checksums)
+}
+
+// ensureVersionTableName adds the name column. It's NULL for versions recorded before it existed.
+func ensureVersionTableName(db driver.Databaser, tbl string) error {
+	return db.Exec("ALTER TABLE " + tbl + " ADD COLUMN IF NOT EXISTS name TEXT")
+}
 func ensureVersionTableV2(db driver.Databaser, tbl string) (err error) {
 	// skip if it has the major column already
 	rows, err := db.Query(`
@@ -214,99 +312,252 @@ func (d *pgDriver) TableName() string {
 }
 
 func (d *pgDriver) Migrate(db driver.Databaser, mf *file.Migration, pipe chan interface{}) {
+	d.MigrateWith(db, mf, driver.ContentOptions{}, pipe)
+}
+
+// MigrateWith is Migrate with the options of the recorded contents
+func (d *pgDriver) MigrateWith(db driver.Databaser, mf *file.Migration, opts driver.ContentOptions, pipe chan interface{}) {
 	defer close(pipe)
 	f := mf.File()
-	pipe <- f
 
 	// read content from db before it can be deleted
 	if err := f.ReadContent(); err != nil {
 		pipe <- err
 		return
 	}
+	pipe <- f
 
-	var ok bool
-	if !file.V2 {
-		ok = d.migrateV1(db, mf, pipe)
-	} else {
-		ok = d.migrateV2(db, mf, pipe)
+	if err := d.RecordVersionWith(db, mf, opts); err != nil {
+		pipe <- err
+		return
 	}
-	if !ok {
+	d.exec(db, f, pipe)
+}
+
+// MigrateContent applies the file without updating the version table
+func (d *pgDriver) MigrateContent(db driver.Databaser, mf *file.Migration, pipe chan interface{}) {
+	defer close(pipe)
+	f := mf.File()
+
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
 		return
 	}
+	pipe <- f
+
+	d.exec(db, f, pipe)
+}
+
+// RecordVersion inserts or deletes the file's version in the version table
+func (d *pgDriver) RecordVersion(db driver.Databaser, mf *file.Migration) error {
+	return d.RecordVersionWith(db, mf, driver.ContentOptions{})
+}
+
+// RecordVersionWith is RecordVersion with the options of the recorded contents
+func (d *pgDriver) RecordVersionWith(db driver.Databaser, mf *file.Migration, opts driver.ContentOptions) error {
+	if !file.V2 {
+		return d.migrateV1(db, mf, opts)
+	}
+	return d.migrateV2(db, mf, opts)
+}
 
+func (d *pgDriver) exec(db driver.Databaser, f *file.File, pipe chan interface{}) {
 	if err := db.Exec(string(f.Content)); err != nil {
-		pqErr, ok := err.(pgx.PgError)
-		if !ok {
-			pipe <- err
-		}
-		offset := int(pqErr.Position)
-		if offset >= 0 {
-			lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
-			errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
-			pipe <- fmt.Errorf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart))
-		} else {
-			pipe <- fmt.Errorf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message)
-		}
+		pipe <- execError(err, f.Content, 0)
 	}
 }
 
-func (d *pgDriver) migrateV1(db driver.Databaser, f *file.Migration, pipe chan interface{}) bool {
-	if f.Up() {
-		up, down, err := f.FileContent()
-		if err != nil {
-			pipe <- err
-			return false
-		}
-		if err := db.Exec("INSERT INTO "+d.tableName+" (version,up_file,down_file) VALUES ($1,$2,$3)", f.Minor(), up, down); err != nil {
-			pipe <- err
-			return false
-		}
-	} else {
-		if err := db.Exec("DELETE FROM "+d.tableName+" WHERE version=$1", f.Minor()); err != nil {
-			pipe <- err
-			return false
-		}
+// ExecStatement executes one statement of the file
+func (d *pgDriver) ExecStatement(db driver.Databaser, f *file.File, stmt file.Statement) error {
+	if err := db.Exec(stmt.SQL); err != nil {
+		return execError(err, f.Content, stmt.Offset)
+	}
+	return nil
+}
+
+// execError adds the line and column, and the lines around them, to a postgres error
+// of the sql executed from offset in the content
+func execError(err error, content []byte, offset int) error {
+	pqErr, ok := err.(pgx.PgError)
+	if !ok {
+		return err
+	}
+	pos := int(pqErr.Position)
+	if pos >= 0 {
+		lineNo, columnNo := file.LineColumnFromOffset(content, offset+pos-1)
+		errorPart := file.LinesBeforeAndAfter(content, lineNo, 5, 5, true)
+		return migrationError{pqErr, fmt.Sprintf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart))}
 	}
-	return true
+	return migrationError{pqErr, fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message)}
 }
 
-func (d *pgDriver) migrateV2(db driver.Databaser, f *file.Migration, pipe chan interface{}) bool {
-	if f.Up() {
-		prevVersion := f.Version
-		if !(f.Major() == 0 && f.Minor() <= 1) {
-			// all versions except first version
-			var err error
-			prevVersion, err = d.Version(db)
-			if err != nil {
-				pipe <- err
-				return false
-			}
-			if prevVersion.Inc(prevVersion.Major() != f.Major()).Compare(f.Version) != 0 {
-				pipe <- fmt.Errorf("Unexpected previous version: %v for version %v", prevVersion, f.Version)
-				return false
-			}
-		}
-		up, down, err := f.FileContent()
+// migrationError is a postgres error with where it happened in the migration file
+type migrationError struct {
+	pgx.PgError
+	msg string
+}
+
+func (e migrationError) Error() string {
+	return e.msg
+}
+
+func (e migrationError) Unwrap() error {
+	return e.PgError
+}
+
+func (d *pgDriver) migrateV1(db driver.Databaser, f *file.Migration, opts driver.ContentOptions) error {
+	if !f.Up() {
+		return db.Exec("DELETE FROM "+d.tableName+" WHERE version=$1", f.Minor())
+	}
+	up, down, checksum, err := d.content(f, opts)
+	if err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO "+d.tableName+" (version,name,up_file,down_file,up_file_gz,down_file_gz,up_checksum) VALUES ($1,$2,$3,$4,$5,$6,$7)",
+		f.Minor(), f.Name(), compressedText(up), compressedText(down), up, down, checksum)
+}
+
+func (d *pgDriver) migrateV2(db driver.Databaser, f *file.Migration, opts driver.ContentOptions) error {
+	if !f.Up() {
+		return db.Exec("DELETE FROM "+d.tableName+" WHERE major=$1 AND minor=$2", f.Major(), f.Minor())
+	}
+	prevVersion := f.Version
+	if !(f.Major() == 0 && f.Minor() <= 1) {
+		// all versions except first version
+		var err error
+		prevVersion, err = d.Version(db)
 		if err != nil {
-			pipe <- err
-			return false
+			return err
 		}
-		// foreign key ensures correct order
-		if err := db.Exec("INSERT INTO "+d.tableName+" (major,minor,prev_major,prev_minor,up_file,down_file) VALUES ($1,$2,$3,$4,$5,$6)",
-			f.Major(), f.Minor(), prevVersion.Major(), prevVersion.Minor(), up, down); err != nil {
-			pipe <- err
-			return false
+		if f.Baseline() && prevVersion.Major() == 0 && prevVersion.Minor() == 0 {
+			// a baseline is the first version of an empty database
+			prevVersion = f.Version
+		} else if f.Version.Compare(prevVersion) < 0 {
+			// an out-of-order version references the version before it
+			if prevVersion, err = d.versionBefore(db, f.Version); err != nil {
+				return err
+			}
+		} else if prevVersion.Inc(prevVersion.Major() != f.Major()).Compare(f.Version) > 0 {
+			// a version may follow a gap, when it's applied before an earlier version that
+			// requires it, which fills the gap out of order
+			return fmt.Errorf("Unexpected previous version: %v for version %v", prevVersion, f.Version)
 		}
-	} else {
-		if err := db.Exec("DELETE FROM "+d.tableName+" WHERE major=$1 AND minor=$2", f.Major(), f.Minor()); err != nil {
-			pipe <- err
-			return false
+	}
+	up, down, checksum, err := d.content(f, opts)
+	if err != nil {
+		return err
+	}
+	// foreign key ensures correct order
+	return db.Exec("INSERT INTO "+d.tableName+" (major,minor,prev_major,prev_minor,name,up_file,down_file,up_file_gz,down_file_gz,up_checksum) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)",
+		f.Major(), f.Minor(), prevVersion.Major(), prevVersion.Minor(), f.Name(), compressedText(up), compressedText(down), up, down, checksum)
+}
+
+// versionBefore returns the last version lower than version, or version if there's none
+func (d *pgDriver) versionBefore(db driver.RowQueryer, version file.Version) (file.Version, error) {
+	var major, minor uint64
+	err := db.QueryRow("SELECT major, minor FROM "+d.tableName+" WHERE (major, minor) < ($1, $2) ORDER BY major DESC, minor DESC LIMIT 1",
+		version.Major(), version.Minor()).Scan(&major, &minor)
+	if err == pgx.ErrNoRows {
+		return version, nil
+	}
+	return file.NewVersion2(major, minor), err
+}
+
+// content returns the compressed contents to store for the migration's files and the up
+// file's checksum. With SkipContent, they're empty.
+func (d *pgDriver) content(f *file.Migration, opts driver.ContentOptions) (up, down []byte, checksum string, err error) {
+	if up, err = f.UpContent(); err != nil {
+		return
+	}
+	checksum = file.Checksum(up)
+	if opts.SkipContent {
+		return []byte{}, []byte{}, checksum, nil
+	}
+	if down, err = f.DownContent(); err != nil {
+		return
+	}
+	if up, err = compress(up); err != nil {
+		return
+	}
+	down, err = compress(down)
+	return
+}
+
+// Squash stores the baseline's files in its version, links it to itself like the first
+// version and deletes the versions before it
+func (d *pgDriver) Squash(db driver.Databaser, baseline *file.Migration, opts driver.ContentOptions) error {
+	up, down, checksum, err := d.content(baseline, opts)
+	if err != nil {
+		return err
+	}
+	if !file.V2 {
+		if err := db.Exec("UPDATE "+d.tableName+" SET up_file_gz=$2, down_file_gz=$3, up_checksum=$4, name=$5, up_file=$6, down_file=$7 WHERE version=$1",
+			baseline.Minor(), up, down, checksum, baseline.Name(), compressedText(up), compressedText(down)); err != nil {
+			return err
 		}
+		return db.Exec("DELETE FROM "+d.tableName+" WHERE version < $1", baseline.Minor())
+	}
+	if err := db.Exec("UPDATE "+d.tableName+" SET prev_major=$1, prev_minor=$2, up_file_gz=$3, down_file_gz=$4, up_checksum=$5, name=$6, up_file=$7, down_file=$8 WHERE major=$1 AND minor=$2",
+		baseline.Major(), baseline.Minor(), up, down, checksum, baseline.Name(), compressedText(up), compressedText(down)); err != nil {
+		return err
+	}
+	return db.Exec("DELETE FROM "+d.tableName+" WHERE (major, minor) < ($1, $2)", baseline.Major(), baseline.Minor())
+}
+
+// IsTransient returns true for serialization failures and deadlocks. Connection exceptions
+// aren't transient, since the transaction is retried on the same connection.
+func (d *pgDriver) IsTransient(err error) bool {
+	var pgErr pgx.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// Savepoint sets the savepoint with SAVEPOINT
+func (d *pgDriver) Savepoint(tx driver.Execer, name string) error {
+	return tx.Exec("SAVEPOINT " + dialect.Postgres.QuoteIdent(name))
+}
+
+// RollbackToSavepoint rolls back with ROLLBACK TO SAVEPOINT
+func (d *pgDriver) RollbackToSavepoint(tx driver.Execer, name string) error {
+	return tx.Exec("ROLLBACK TO SAVEPOINT " + dialect.Postgres.QuoteIdent(name))
+}
+
+// SetTxOptions sets the isolation level and access mode with SET TRANSACTION. READ WRITE
+// fails on a hot standby.
+func (d *pgDriver) SetTxOptions(tx driver.Execer, opts driver.TxOptions) error {
+	var modes []string
+	switch opts.IsolationLevel {
+	case "":
+	case driver.ReadCommitted, driver.RepeatableRead, driver.Serializable:
+		modes = append(modes, "ISOLATION LEVEL "+string(opts.IsolationLevel))
+	default:
+		return fmt.Errorf("Invalid isolation level '%s'", opts.IsolationLevel)
+	}
+	if opts.ReadWrite {
+		modes = append(modes, "READ WRITE")
+	}
+	if len(modes) == 0 {
+		return nil
+	}
+	return tx.Exec("SET TRANSACTION " + strings.Join(modes, ", "))
+}
+
+// RecordApplied sets the applied_at and duration_ms of the version
+func (d *pgDriver) RecordApplied(db driver.Execer, version file.Version, appliedAt time.Time, duration time.Duration) error {
+	where := "0 = $1 AND version = $2"
+	if file.V2 {
+		where = "major = $1 AND minor = $2"
 	}
-	return true
+	return db.Exec("UPDATE "+d.tableName+" SET applied_at = $3, duration_ms = $4 WHERE "+where,
+		version.Major(), version.Minor(), appliedAt, duration.Nanoseconds()/int64(time.Millisecond))
 }
 
 func (d *pgDriver) Version(db driver.RowQueryer) (version file.Version, err error) {
+	if sd, ok := db.(driver.ScriptDatabaser); ok {
+		return sd.ScriptVersion(), nil
+	}
 	defer func() {
 		if err == pgx.ErrNoRows {
 			err = nil
@@ -338,6 +589,8 @@ func (d *pgDriver) GetMigrationFiles(db driver.Databaser) (files file.MigrationF
 		columns = "major, minor"
 		order = columns
 	}
+	// milliseconds since the epoch, 0 if unknown
+	columns += ", COALESCE((EXTRACT(EPOCH FROM applied_at) * 1000)::BIGINT, 0), COALESCE(duration_ms, 0), COALESCE(up_checksum, ''), COALESCE(name, '-')"
 	rows, err := db.Query("SELECT " + columns + " FROM " + d.tableName + " ORDER BY " + order)
 	if err != nil {
 		return
@@ -346,17 +599,21 @@ func (d *pgDriver) GetMigrationFiles(db driver.Databaser) (files file.MigrationF
 
 	for rows.Next() {
 		var major, minor uint64
-		if err = rows.Scan(&major, &minor); err != nil {
+		var appliedAt, durationMs int64
+		var upChecksum, name string
+		if err = rows.Scan(&major, &minor, &appliedAt, &durationMs, &upChecksum, &name); err != nil {
 			return
 		}
 		version := file.NewVersion2(major, minor)
-		files = append(files, file.MigrationFile{
-			Version: version,
+		mf := file.MigrationFile{
+			Version:    version,
+			Duration:   time.Duration(durationMs) * time.Millisecond,
+			UpChecksum: upChecksum,
 			UpFile: &file.File{
 				Version:   version,
 				Direction: direction.Up,
-				Name:      "-",
-				FileName:  version.MinorString() + "_-.up.sql",
+				Name:      name,
+				FileName:  version.MinorString() + "_" + name + ".up.sql",
 				Open: func() (io.ReadCloser, error) {
 					return d.readVersionContent(db, version, true)
 				},
@@ -364,13 +621,17 @@ func (d *pgDriver) GetMigrationFiles(db driver.Databaser) (files file.MigrationF
 			DownFile: &file.File{
 				Version:   version,
 				Direction: direction.Down,
-				Name:      "-",
-				FileName:  version.MinorString() + "_-.down.sql",
+				Name:      name,
+				FileName:  version.MinorString() + "_" + name + ".down.sql",
 				Open: func() (io.ReadCloser, error) {
 					return d.readVersionContent(db, version, false)
 				},
 			},
-		})
+		}
+		if appliedAt != 0 {
+			mf.AppliedAt = time.Unix(0, appliedAt*int64(time.Millisecond))
+		}
+		files = append(files, mf)
 	}
 	return
 }
@@ -380,20 +641,28 @@ func (d *pgDriver) readVersionContent(db driver.Databaser, version file.Version,
 	if up {
 		column = "up_file"
 	}
+	// versions are stored compressed, unless recorded before the compressed columns
+	columns := "COALESCE(" + column + ", ''), " + column + "_gz"
 	// set where depending on version
 	where := "0 = $1 AND version = $2"
 	if file.V2 {
 		where = "major = $1 AND minor = $2"
 	}
-	d.GetMigrationFiles(db)
 	// get content
 	var txt string
-	qry := "SELECT " + column + " FROM " + d.tableName + " WHERE " + where
-	err := db.QueryRow(qry, version.Major(), version.Minor()).Scan(&txt)
+	var gz []byte
+	qry := "SELECT " + columns + " FROM " + d.tableName + " WHERE " + where
+	err := db.QueryRow(qry, version.Major(), version.Minor()).Scan(&txt, &gz)
 	if err != nil {
-		panic(err)
 		return nil, err
 	}
+	if len(gz) > 0 {
+		content, err := decompress(gz)
+		if err != nil {
+			return nil, err
+		}
+		txt = string(content)
+	}
 	// make text a ReadCLoser
 	return newVersionContentReader(txt), nil
 }
@@ -410,9 +679,14 @@ func (versionContentReader) Close() error {
 }
 
 func (d *pgDriver) UpdateFiles(db driver.Databaser, f *file.Migration, pipe chan interface{}) {
+	d.UpdateFilesWith(db, f, driver.ContentOptions{}, pipe)
+}
+
+// UpdateFilesWith is UpdateFiles with the options of the updated contents
+func (d *pgDriver) UpdateFilesWith(db driver.Databaser, f *file.Migration, opts driver.ContentOptions, pipe chan interface{}) {
 	defer close(pipe)
 
-	up, down, err := f.FileContent()
+	up, down, checksum, err := d.content(f, opts)
 	if err != nil {
 		pipe <- err
 		return
@@ -422,12 +696,50 @@ func (d *pgDriver) UpdateFiles(db driver.Databaser, f *file.Migration, pipe chan
 	if file.V2 {
 		where = "major = $1 AND minor = $2"
 	}
-	if err := db.Exec("UPDATE "+d.tableName+" SET up_file=$3, down_file=$4 WHERE "+where, f.Major(), f.Minor(), up, down); err != nil {
+	if err := db.Exec("UPDATE "+d.tableName+" SET up_file_gz=$3, down_file_gz=$4, up_checksum=$5, name=$6, up_file=$7, down_file=$8 WHERE "+where,
+		f.Major(), f.Minor(), up, down, checksum, f.Name(), compressedText(up), compressedText(down)); err != nil {
 		pipe <- err
 	}
 	return
 }
 
+// updateBatchSize is how many versions UpdateFilesBatch updates per statement, keeping
+// the parameters below postgres' limit of 65535
+const updateBatchSize = 1000
+
+// UpdateFilesBatch updates the files with one UPDATE ... FROM (VALUES ...) per batch
+func (d *pgDriver) UpdateFilesBatch(db driver.Databaser, files []*file.Migration, opts driver.ContentOptions, progress func(updated int)) error {
+	where := "t.version = v.minor"
+	if file.V2 {
+		where = "t.major = v.major AND t.minor = v.minor"
+	}
+	for start := 0; start < len(files); start += updateBatchSize {
+		end := start + updateBatchSize
+		if end > len(files) {
+			end = len(files)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 8*(end-start))
+		for _, f := range files[start:end] {
+			up, down, checksum, err := d.content(f, opts)
+			if err != nil {
+				return err
+			}
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d::BIGINT, $%d::BIGINT, $%d::BYTEA, $%d::BYTEA, $%d::TEXT, $%d::TEXT, $%d::TEXT, $%d::TEXT)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+			args = append(args, f.Major(), f.Minor(), up, down, checksum, f.Name(), compressedText(up), compressedText(down))
+		}
+		if err := db.Exec("UPDATE "+d.tableName+" AS t SET up_file_gz = v.up, down_file_gz = v.down, up_checksum = v.checksum, name = v.name, up_file = v.up_text, down_file = v.down_text"+
+			" FROM (VALUES "+strings.Join(values, ", ")+") AS v (major, minor, up, down, checksum, name, up_text, down_text) WHERE "+where, args...); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(end)
+		}
+	}
+	return nil
+}
+
 func (d *pgDriver) Dump(conn driver.CopyConn, dw file.DumpWriter, schema string, pipe chan interface{}, handleInterrupts func() chan os.Signal) {
 	defer close(pipe)
 
@@ -455,9 +767,10 @@ func (d *pgDriver) getTables(conn driver.Queryer, schema string) (tbls []string,
 		FROM information_schema.tables
 		WHERE
 			table_schema = $1
-			AND table_name != $2`,
+			AND table_name NOT IN ($2, $3)`,
 		schema,
 		d.tableName,
+		d.auditTableName(),
 	)
 	defer rows.Close()
 
@@ -473,24 +786,54 @@ func (d *pgDriver) getTables(conn driver.Queryer, schema string) (tbls []string,
 func dumpTable(pipe chan interface{}, conn driver.CopyConn, dw file.DumpWriter, schema, tbl string) {
 	defer close(pipe)
 
-	tableName := pgx.Identifier{schema, tbl}.Sanitize()
+	tableName := dialect.QuoteQualified(dialect.Postgres, schema, tbl)
 	pipe <- tableName
 
 	// open a writer
 	w, err := dw.Writer(file.TablesDir, tbl)
+	if err == file.ErrSkipFile {
+		return
+	}
 	if err != nil {
+		pipe <- err
 		return
 	}
-	defer w.Close()
 	// dump table
 	time.Sleep(1 * time.Nanosecond)
 	err = conn.CopyToWriter(w, "COPY "+tableName+" TO STDOUT")
+	// the writer may still write the table when it's closed, e.g. a spooled one
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
 		pipe <- err
 		return
 	}
 }
 
+// TableHashes sums the first 60 bits of the md5 of each row's text, with the row count, so
+// the hashes don't depend on the order of the rows
+func (d *pgDriver) TableHashes(db driver.Databaser, schema string) (hashes map[string]string, err error) {
+	if schema == "" {
+		schema = "public"
+	}
+	tbls, err := d.getTables(db, schema)
+	if err != nil {
+		return
+	}
+	hashes = make(map[string]string, len(tbls))
+	for _, tbl := range tbls {
+		var hash string
+		err = db.QueryRow(`SELECT count(*) || ':' || coalesce(sum(('x' || substr(md5(t::text), 1, 15))::bit(60)::bigint), 0)
+			FROM ` + dialect.QuoteQualified(dialect.Postgres, schema, tbl) + ` AS t`).Scan(&hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes[tbl] = hash
+	}
+	return
+}
+
 // DeleteSchema drop the schema, if it exists
 func (d *pgDriver) DeleteSchema(db driver.Execer, schema string) error {
 	return db.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
@@ -519,7 +862,7 @@ func (d *pgDriver) TruncateTables(db driver.Conn, schema string) (err error) {
 	const cmdFmt = "TRUNCATE TABLE %s CASCADE;"
 	// const cmdFmt = "TRUNCATE TABLE %s;"
 	for _, tbl := range tbls {
-		cmds = append(cmds, fmt.Sprintf(cmdFmt, pgx.Identifier{schema, tbl}.Sanitize()))
+		cmds = append(cmds, fmt.Sprintf(cmdFmt, dialect.QuoteQualified(dialect.Postgres, schema, tbl)))
 	}
 	cmd := strings.Join(cmds, "")
 	// tx, err := db.Begin()
@@ -537,14 +880,79 @@ func (d *pgDriver) TruncateTables(db driver.Conn, schema string) (err error) {
 	return db.Exec(cmd)
 }
 
-func (d *pgDriver) Restore(conn driver.CopyConn, dr file.DumpReader, schema string, pipe chan interface{}, handleInterrupts func() chan os.Signal) {
-	defer close(pipe)
+// FixSequences sets the serial and identity sequences of the tables in schema to the max
+// value of their column. COPY doesn't advance them, so inserts after a restore would fail
+// with duplicate keys. Sequences of empty tables are left as is.
+func (d *pgDriver) FixSequences(db driver.Databaser, schema string) (sequences []string, err error) {
+	if schema == "" {
+		schema = "public"
+	}
 
-	tableFiles, err := dr.Files(file.TablesDir)
+	// 'a' is the dependency type of serial columns and 'i' of identity columns
+	rows, err := db.Query(`SELECT
+			s.relname, t.relname, a.attname
+		FROM pg_class s
+		JOIN pg_namespace n ON n.oid = s.relnamespace
+		JOIN pg_depend dep ON dep.objid = s.oid
+			AND dep.classid = 'pg_class'::regclass
+			AND dep.refclassid = 'pg_class'::regclass
+			AND dep.deptype IN ('a', 'i')
+		JOIN pg_class t ON t.oid = dep.refobjid
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = dep.refobjsubid
+		WHERE
+			s.relkind = 'S'
+			AND n.nspname = $1
+		ORDER BY s.relname`,
+		schema,
+	)
 	if err != nil {
-		pipe <- err
 		return
 	}
+	type owned struct{ seq, tbl, col string }
+	var seqs []owned
+	for rows.Next() {
+		var o owned
+		if err = rows.Scan(&o.seq, &o.tbl, &o.col); err != nil {
+			rows.Close()
+			return
+		}
+		seqs = append(seqs, o)
+	}
+	rows.Close()
+
+	for _, o := range seqs {
+		seq := dialect.QuoteQualified(dialect.Postgres, schema, o.seq)
+		qry := "SELECT setval($1::regclass, MAX(" + dialect.Postgres.QuoteIdent(o.col) + ")) FROM " +
+			dialect.QuoteQualified(dialect.Postgres, schema, o.tbl) + " HAVING MAX(" + dialect.Postgres.QuoteIdent(o.col) + ") IS NOT NULL"
+		if err = db.Exec(qry, seq); err != nil {
+			return
+		}
+		sequences = append(sequences, seq)
+	}
+	return
+}
+
+func (d *pgDriver) Restore(conn driver.CopyConn, dr file.DumpReader, schema string, pipe chan interface{}, handleInterrupts func() chan os.Signal) {
+	defer close(pipe)
+
+	// the tables of a stream are read as they come, since they can't be listed first
+	var next func() (file.Opener, error)
+	if sr, ok := dr.(file.StreamReader); ok {
+		next = func() (file.Opener, error) { return sr.NextFile(file.TablesDir) }
+	} else {
+		tableFiles, err := dr.Files(file.TablesDir)
+		if err != nil {
+			pipe <- err
+			return
+		}
+		next = func() (o file.Opener, err error) {
+			if len(tableFiles) == 0 {
+				return o, io.EOF
+			}
+			o, tableFiles = tableFiles[0], tableFiles[1:]
+			return
+		}
+	}
 
 	// Disable foreign keys to prevent foreign key violations during import. https://stackoverflow.com/a/18709987
 	if err := conn.Exec("SET session_replication_role = replica;"); err != nil {
@@ -555,7 +963,15 @@ func (d *pgDriver) Restore(conn driver.CopyConn, dr file.DumpReader, schema stri
 	defer conn.Exec("SET session_replication_role = default;")
 
 	// restore tables
-	for _, o := range tableFiles {
+	for {
+		o, err := next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			pipe <- err
+			return
+		}
 		interrupts := handleInterrupts()
 		if interrupts == nil {
 			restoreTable(pipe, conn, schema, o)
@@ -572,7 +988,7 @@ func (d *pgDriver) Restore(conn driver.CopyConn, dr file.DumpReader, schema stri
 	}
 }
 func restoreTable(pipe chan interface{}, conn driver.CopyConn, schema string, o file.Opener) {
-	tableName := pgx.Identifier{schema, o.Name}.Sanitize()
+	tableName := dialect.QuoteQualified(dialect.Postgres, schema, o.Name)
 	pipe <- tableName
 
 	r, err := o.Open()
@@ -594,33 +1010,41 @@ func restoreTable(pipe chan interface{}, conn driver.CopyConn, schema string, o
 
 // Conn wraps a postgresql connection and returns a driver.Conn
 func Conn(c *pgx.Conn) driver.CopyConn {
-	return &conn{c}
+	return &conn{conn: c, ctx: context.Background()}
 }
 
+var _ driver.ContextConn = &conn{}
+
 type conn struct {
 	conn *pgx.Conn
+	ctx  context.Context
+}
+
+// WithContext returns a copy of the connection that runs queries with ctx
+func (c *conn) WithContext(ctx context.Context) driver.Conn {
+	return &conn{conn: c.conn, ctx: ctx}
 }
 
 func (c *conn) Begin() (driver.Tx, error) {
-	tx, err := c.conn.Begin()
+	tx, err := c.conn.BeginEx(c.ctx, nil)
 	if err != nil {
 		return nil, err
 	}
-	return &trans{tx}, nil
+	return &trans{tx: tx, ctx: c.ctx}, nil
 }
 func (c *conn) Close() error {
 	return c.conn.Close()
 }
 func (c *conn) Exec(query string, args ...interface{}) error {
-	_, err := c.conn.Exec(query, args...)
+	_, err := c.conn.ExecEx(c.ctx, query, nil, args...)
 	return err
 }
 func (c *conn) Query(query string, args ...interface{}) (driver.RowsScanner, error) {
-	rows, err := c.conn.Query(query, args...)
+	rows, err := c.conn.QueryEx(c.ctx, query, nil, args...)
 	return Rows{rows}, err
 }
 func (c *conn) QueryRow(query string, args ...interface{}) driver.Scanner {
-	row := c.conn.QueryRow(query, args...)
+	row := c.conn.QueryRowEx(c.ctx, query, nil, args...)
 	return Row{row}
 }
 
@@ -631,27 +1055,44 @@ func (c *conn) CopyFromReader(r io.Reader, sql string, args ...interface{}) erro
 	return c.conn.CopyFromReader(r, sql)
 }
 
+var _ driver.ContextDatabaser = &trans{}
+
 type trans struct {
-	tx *pgx.Tx
+	tx  *pgx.Tx
+	ctx context.Context
+}
+
+// WithContext returns a copy of the transaction that runs queries with ctx
+func (tx *trans) WithContext(ctx context.Context) driver.Databaser {
+	return &trans{tx: tx.tx, ctx: ctx}
 }
 
 func (tx *trans) Exec(query string, args ...interface{}) error {
-	_, err := tx.tx.Exec(query, args...)
+	_, err := tx.tx.ExecEx(tx.context(), query, nil, args...)
 	return err
 }
 func (tx *trans) Query(query string, args ...interface{}) (driver.RowsScanner, error) {
-	rows, err := tx.tx.Query(query, args...)
+	rows, err := tx.tx.QueryEx(tx.context(), query, nil, args...)
 	return Rows{rows}, err
 }
 func (tx *trans) QueryRow(query string, args ...interface{}) driver.Scanner {
-	row := tx.tx.QueryRow(query, args...)
+	row := tx.tx.QueryRowEx(tx.context(), query, nil, args...)
 	return Row{row}
 }
 func (tx *trans) Rollback() error {
+	// rollback even if the context is done
 	return tx.tx.Rollback()
 }
 func (tx *trans) Commit() error {
-	return tx.tx.Commit()
+	return tx.tx.CommitEx(tx.context())
+}
+
+// context returns the transaction's context. Transactions begun on a pool don't have one.
+func (tx *trans) context() context.Context {
+	if tx.ctx == nil {
+		return context.Background()
+	}
+	return tx.ctx
 }
 
 // Row wraps *pgx.Row which is a convenience wrapper over *pgx.Rows
@@ -1,8 +1,12 @@
 package pgx
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/acls/migrate/driver"
 	"github.com/acls/migrate/file"
 	"github.com/acls/migrate/migrate/direction"
 	pipep "github.com/acls/migrate/pipe"
@@ -119,3 +123,266 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCompress(t *testing.T) {
+	for _, content := range []string{"", "CREATE TABLE users (id INT);"} {
+		gz, err := compress([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content == "" && len(gz) != 0 {
+			t.Fatalf("Expected empty content to stay empty, got %v", gz)
+		}
+		got, err := decompress(gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != content {
+			t.Fatalf("Expected %q, got %q", content, got)
+		}
+	}
+}
+
+func TestCompressedText(t *testing.T) {
+	if text := compressedText([]byte{}); text != "" {
+		t.Fatalf("Expected no text for empty content, got %q", text)
+	}
+	gz, err := compress([]byte("CREATE TABLE users (id INT);"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := compressedText(gz); text != compressedContent {
+		t.Fatalf("Expected the marker for compressed content, got %q", text)
+	}
+}
+
+func TestContentOptions(t *testing.T) {
+	up := []byte("CREATE TABLE users (id INT);")
+	mf := file.MigrationFile{
+		Version:  file.NewVersion2(0, 1),
+		UpFile:   &file.File{Version: file.NewVersion2(0, 1), Direction: direction.Up, Content: up},
+		DownFile: &file.File{Version: file.NewVersion2(0, 1), Direction: direction.Down, Content: []byte("DROP TABLE users;")},
+	}
+	mg := mf.Migration(direction.Up)
+	d := New("").(*pgDriver)
+
+	// the options are per call, so a shared driver stores the contents of the other calls
+	for _, skip := range []bool{true, false, true} {
+		gzUp, gzDown, checksum, err := d.content(&mg, driver.ContentOptions{SkipContent: skip})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if checksum != file.Checksum(up) {
+			t.Fatalf("Expected the checksum of the up file, got %q", checksum)
+		}
+		if empty := len(gzUp) == 0 && len(gzDown) == 0; empty != skip {
+			t.Fatalf("Expected empty contents %v with SkipContent %v, got %d and %d bytes", skip, skip, len(gzUp), len(gzDown))
+		}
+	}
+}
+
+func TestEnsureVersionTableChecksum(t *testing.T) {
+	up := "CREATE TABLE users (id INT);"
+	db := &queryDB{rows: map[string][][]interface{}{
+		"pg_attribute":     {{"version", "integer"}, {"up_file", "text"}},
+		"DISTINCT up_file": {{up}, {""}},
+	}}
+	if err := ensureVersionTableChecksum(db, "schema_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.execs) != 2 || !strings.HasPrefix(db.execs[1], "UPDATE") {
+		t.Fatalf("Expected the column to be added and filled, got %v", db.execs)
+	}
+	expected := []interface{}{[]string{up, ""}, []string{file.Checksum([]byte(up)), file.Checksum(nil)}}
+	if !reflect.DeepEqual(db.args[1], expected) {
+		t.Fatalf("Expected the checksums %v, got %v", expected, db.args[1])
+	}
+
+	// it's only filled when the column is added
+	db = &queryDB{rows: map[string][][]interface{}{
+		"pg_attribute": {{"version", "integer"}, {"up_file", "text"}, {"up_checksum", "text"}},
+	}}
+	if err := ensureVersionTableChecksum(db, "schema_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.execs) != 0 {
+		t.Fatalf("Expected no statements, got %v", db.execs)
+	}
+}
+
+func TestLastForeignVersion(t *testing.T) {
+	db := &queryDB{rows: map[string][][]interface{}{
+		"pg_attribute":   {{"installed_rank", "integer"}, {"version", "character varying(50)"}, {"script", "character varying(1000)"}, {"success", "boolean"}},
+		"installed_rank": {{"1.2"}},
+	}}
+	tool, version, err := New("").(*pgDriver).LastForeignVersion(db, `public.flyway"; DROP TABLE users; --`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool != "Flyway" || version != "1.2" {
+		t.Fatalf("Expected Flyway 1.2, got %s %s", tool, version)
+	}
+	if q := db.queries[1]; !strings.Contains(q, `FROM "public"."flyway""; DROP TABLE users; --" WHERE`) {
+		t.Fatalf("Expected the table to be quoted, got %s", q)
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	var sd driver.SavepointDriver = New("").(*pgDriver)
+	db := &queryDB{}
+	if err := sd.Savepoint(db, "migrate_file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sd.RollbackToSavepoint(db, "migrate_file"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`SAVEPOINT "migrate_file"`, `ROLLBACK TO SAVEPOINT "migrate_file"`}
+	if !reflect.DeepEqual(db.execs, expected) {
+		t.Fatalf("Expected %v, got %v", expected, db.execs)
+	}
+}
+
+// queryDB returns the rows of the query that contains a key of rows, and records the executed
+// statements and their args
+type queryDB struct {
+	rows    map[string][][]interface{}
+	queries []string
+	execs   []string
+	args    [][]interface{}
+}
+
+func (db *queryDB) Exec(query string, args ...interface{}) error {
+	db.execs = append(db.execs, query)
+	db.args = append(db.args, args)
+	return nil
+}
+
+func (db *queryDB) QueryRow(query string, args ...interface{}) driver.Scanner {
+	rows, _ := db.Query(query, args...)
+	rows.Next()
+	return rows
+}
+
+func (db *queryDB) Query(query string, args ...interface{}) (driver.RowsScanner, error) {
+	db.queries = append(db.queries, query)
+	for key, rows := range db.rows {
+		if strings.Contains(query, key) {
+			return &queryRows{rows: rows}, nil
+		}
+	}
+	return &queryRows{}, nil
+}
+
+type queryRows struct {
+	rows [][]interface{}
+	i    int
+}
+
+func (r *queryRows) Next() bool {
+	r.i++
+	return r.i <= len(r.rows)
+}
+
+func (r *queryRows) Scan(dest ...interface{}) error {
+	if r.i == 0 || r.i > len(r.rows) {
+		return errors.New("No rows")
+	}
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r.rows[r.i-1][i]))
+	}
+	return nil
+}
+
+func (r *queryRows) Err() error { return nil }
+func (r *queryRows) Close()     {}
+
+func TestForeignKeyWaves(t *testing.T) {
+	tables := []string{"comments", "posts", "tags", "users", "a", "b", "c"}
+	refs := map[string][]string{
+		"users":    {"users"}, // a self-reference
+		"posts":    {"users"},
+		"tags":     {"users"},
+		"comments": {"posts", "users"},
+		"a":        {"b"}, // a cycle
+		"b":        {"a"},
+		"c":        {"a"},
+	}
+	expected := [][]string{{"users"}, {"posts", "tags"}, {"comments"}, {"a", "b", "c"}}
+	if waves := foreignKeyWaves(tables, refs); !reflect.DeepEqual(waves, expected) {
+		t.Fatalf("Expected %v, got %v", expected, waves)
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	from := driver.SchemaInfo{Tables: []driver.TableInfo{
+		{Name: "users", Columns: []driver.ColumnInfo{
+			{Name: "id", Type: "integer", NotNull: true, Default: "nextval('users_id_seq'::regclass)"},
+			{Name: "name", Type: "text"},
+			{Name: "legacy", Type: "text"},
+		}, Constraints: []driver.ConstraintInfo{
+			{Name: "users_pkey", Definition: "PRIMARY KEY (id)"},
+		}},
+		{Name: "old", Columns: []driver.ColumnInfo{{Name: "id", Type: "integer"}}},
+	}}
+	to := driver.SchemaInfo{Tables: []driver.TableInfo{
+		{Name: "users", Columns: []driver.ColumnInfo{
+			{Name: "id", Type: "integer", NotNull: true, Default: "nextval('users_id_seq'::regclass)"},
+			{Name: "name", Type: "character varying(100)", NotNull: true},
+			{Name: "email", Type: "text", Default: "''::text"},
+		}, Constraints: []driver.ConstraintInfo{
+			{Name: "users_pkey", Definition: "PRIMARY KEY (id)"},
+		}, Indexes: []driver.IndexInfo{
+			{Name: "users_email_idx", Definition: "CREATE INDEX users_email_idx ON users USING btree (email)"},
+		}},
+		{Name: "posts", Columns: []driver.ColumnInfo{
+			{Name: "id", Type: "bigint", NotNull: true, Default: "nextval('posts_id_seq'::regclass)"},
+			{Name: "user_id", Type: "integer"},
+		}, Constraints: []driver.ConstraintInfo{
+			{Name: "posts_user_id_fkey", Definition: "FOREIGN KEY (user_id) REFERENCES users(id)", ForeignKey: true},
+			{Name: "posts_pkey", Definition: "PRIMARY KEY (id)"},
+		}},
+	}}
+
+	up := diffSchema(from, to)
+	expected := []string{
+		`DROP TABLE "old"`,
+		`ALTER TABLE "users" DROP COLUMN "legacy"`,
+		`ALTER TABLE "users" ALTER COLUMN "name" TYPE character varying(100)`,
+		`ALTER TABLE "users" ALTER COLUMN "name" SET NOT NULL`,
+		`ALTER TABLE "users" ADD COLUMN "email" text DEFAULT ''::text`,
+		"CREATE TABLE \"posts\" (\n\t\"id\" bigserial NOT NULL,\n\t\"user_id\" integer\n)",
+		`ALTER TABLE "posts" ADD CONSTRAINT "posts_pkey" PRIMARY KEY (id)`,
+		`ALTER TABLE "posts" ADD CONSTRAINT "posts_user_id_fkey" FOREIGN KEY (user_id) REFERENCES users(id)`,
+		`CREATE INDEX users_email_idx ON users USING btree (email)`,
+	}
+	if len(up) != len(expected) {
+		t.Fatalf("Expected %d statements, got %d: %q", len(expected), len(up), up)
+	}
+	for i := range expected {
+		if up[i] != expected[i] {
+			t.Errorf("Statement %d: expected %q, got %q", i, expected[i], up[i])
+		}
+	}
+
+	// the down migration drops the new table with its foreign key
+	down := diffSchema(to, from)
+	if len(down) != 7 || down[0] != `DROP INDEX "users_email_idx"` || down[1] != `DROP TABLE "posts"` {
+		t.Errorf("Unexpected down statements %q", down)
+	}
+
+	// a changed foreign key is dropped before the other constraints
+	changed := driver.SchemaInfo{Tables: append([]driver.TableInfo{}, to.Tables...)}
+	changed.Tables[1].Constraints = []driver.ConstraintInfo{
+		{Name: "posts_user_id_fkey", Definition: "FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE", ForeignKey: true},
+	}
+	if stmts := diffSchema(to, changed); len(stmts) != 3 || stmts[0] != `ALTER TABLE "posts" DROP CONSTRAINT "posts_user_id_fkey"` {
+		t.Errorf("Expected the foreign key to be dropped first, got %q", stmts)
+	}
+	if len(diffSchema(to, to)) != 0 {
+		t.Error("Expected no statements for the same schema")
+	}
+
+	if def := unqualifyIndex("CREATE INDEX i ON ONLY app.t USING btree (a)", "app"); def != "CREATE INDEX i ON ONLY t USING btree (a)" {
+		t.Errorf("Unexpected index definition %q", def)
+	}
+}
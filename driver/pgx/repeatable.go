@@ -0,0 +1,53 @@
+package pgx
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+var _ driver.RepeatableDriver = &pgDriver{}
+
+func (d *pgDriver) repeatableTableName() string {
+	return d.tableName + "_repeatables"
+}
+
+// EnsureRepeatableTable creates the table of the repeatable migrations' checksums
+func (d *pgDriver) EnsureRepeatableTable(db driver.Execer) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS ` + d.repeatableTableName() + ` (
+		name TEXT NOT NULL PRIMARY KEY,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+}
+
+// RepeatableChecksums returns the checksums of the applied repeatable migrations by name
+func (d *pgDriver) RepeatableChecksums(db driver.Queryer) (checksums map[string]string, err error) {
+	rows, err := db.Query("SELECT name, checksum FROM " + d.repeatableTableName())
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	checksums = make(map[string]string)
+	for rows.Next() {
+		var name, checksum string
+		if err = rows.Scan(&name, &checksum); err != nil {
+			return
+		}
+		checksums[name] = checksum
+	}
+	err = rows.Err()
+	return
+}
+
+// ApplyRepeatable executes the repeatable migration and stores its checksum
+func (d *pgDriver) ApplyRepeatable(db driver.Databaser, f *file.File) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	if err := db.Exec(string(f.Content)); err != nil {
+		return execError(err, f.Content, 0)
+	}
+	return db.Exec(`INSERT INTO `+d.repeatableTableName()+` (name, checksum) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET checksum = EXCLUDED.checksum, applied_at = NOW()`,
+		f.Name, file.Checksum(f.Content))
+}
@@ -44,6 +44,22 @@ type SchemaMigrator struct {
 	BaseMigrator migrate.Migrator
 }
 
+// NewSchemaMigrator creates a SchemaMigrator with a connection pool to the url
+func NewSchemaMigrator(url string, base migrate.Migrator) (*SchemaMigrator, error) {
+	connConfig, err := pgx.ParseConnectionString(url)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: connConfig})
+	if err != nil {
+		return nil, err
+	}
+	return &SchemaMigrator{
+		ConnPool:     pool,
+		BaseMigrator: base,
+	}, nil
+}
+
 // InitCopy makes a copy and initializes it
 func (m *SchemaMigrator) InitCopy(schemaSuffix string, d driver.DumpDriver, newPool func(string) *pgx.ConnPool, ensureSchema bool) SchemaMigrator {
 	migrator := *m
@@ -235,7 +251,7 @@ func (m *SchemaMigrator) rotateSchemas(schemas []string) (err error) {
 	if err != nil {
 		return
 	}
-	tx := &trans{t}
+	tx := &trans{tx: t}
 	return WithTransaction(tx, func() (err error) {
 		// delete the first schema
 		prevSchema := schemas[0]
@@ -0,0 +1,165 @@
+package pgx
+
+import (
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/driver/dialect"
+	"github.com/jackc/pgx"
+)
+
+var _ driver.StatsDriver = &pgDriver{}
+
+// ExportStats reads the table sizes from pg_class and the column statistics from pg_stats.
+// The most common values and histograms are left out, so no data is exported.
+func (d *pgDriver) ExportStats(db driver.Queryer, schema string) (stats driver.Stats, err error) {
+	rows, err := db.Query(`SELECT c.relname, c.reltuples, c.relpages
+		FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p') AND c.relname NOT LIKE $2
+		ORDER BY c.relname`, schema, d.tableName+"%")
+	if err != nil {
+		return
+	}
+	tables := map[string]int{}
+	for rows.Next() {
+		var t driver.TableStats
+		var pages int32
+		if err = rows.Scan(&t.Name, &t.Rows, &pages); err != nil {
+			rows.Close()
+			return
+		}
+		t.Pages = int64(pages)
+		tables[t.Name] = len(stats.Tables)
+		stats.Tables = append(stats.Tables, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	rows, err = db.Query(`SELECT tablename, attname, null_frac, avg_width, n_distinct
+		FROM pg_stats WHERE schemaname = $1 AND NOT inherited
+		ORDER BY tablename, attname`, schema)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var c driver.ColumnStats
+		var nullFrac, nDistinct float32
+		if err = rows.Scan(&table, &c.Name, &nullFrac, &c.AvgWidth, &nDistinct); err != nil {
+			return
+		}
+		c.NullFrac, c.NDistinct = float64(nullFrac), float64(nDistinct)
+		if i, ok := tables[table]; ok {
+			stats.Tables[i].Columns = append(stats.Tables[i].Columns, c)
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// ImportStats writes the table sizes to pg_class and the column statistics to pg_statistic,
+// which requires a superuser. The planner scales the imported rows per page by the
+// table's actual pages, so the tables need some data, e.g. a restored sample.
+// Autovacuum's analyze overwrites the imported statistics.
+func (d *pgDriver) ImportStats(db driver.Databaser, schema string, stats driver.Stats) error {
+	insert, err := insertStatisticSQL(db)
+	if err != nil {
+		return err
+	}
+	for _, t := range stats.Tables {
+		table := dialect.QuoteQualified(dialect.Postgres, schema, t.Name)
+		var exists bool
+		if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err := db.Exec("UPDATE pg_class SET reltuples = $1, relpages = $2 WHERE oid = $3::regclass",
+			t.Rows, int32(t.Pages), table); err != nil {
+			return err
+		}
+		for _, c := range t.Columns {
+			var attnum int16
+			err := db.QueryRow("SELECT attnum FROM pg_attribute WHERE attrelid = $1::regclass AND attname = $2 AND NOT attisdropped",
+				table, c.Name).Scan(&attnum)
+			if err == pgx.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := db.Exec("DELETE FROM pg_statistic WHERE starelid = $1::regclass AND staattnum = $2 AND NOT stainherit",
+				table, attnum); err != nil {
+				return err
+			}
+			if err := db.Exec(insert, table, attnum, float32(c.NullFrac), c.AvgWidth, float32(c.NDistinct)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertStatisticSQL builds the pg_statistic insert from its columns, which differ by version.
+// Only the null fraction, width and distinct count are set; there are no slots.
+func insertStatisticSQL(db driver.Queryer) (string, error) {
+	rows, err := db.Query(`SELECT attname FROM pg_attribute
+		WHERE attrelid = 'pg_statistic'::regclass AND attnum > 0 AND NOT attisdropped
+		ORDER BY attnum`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var columns, values []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return "", err
+		}
+		value := "NULL"
+		switch {
+		case column == "starelid":
+			value = "$1::regclass"
+		case column == "staattnum":
+			value = "$2"
+		case column == "stainherit":
+			value = "false"
+		case column == "stanullfrac":
+			value = "$3"
+		case column == "stawidth":
+			value = "$4"
+		case column == "stadistinct":
+			value = "$5"
+		case strings.HasPrefix(column, "stakind"), strings.HasPrefix(column, "staop"), strings.HasPrefix(column, "stacoll"):
+			value = "0"
+		}
+		columns = append(columns, column)
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return "INSERT INTO pg_statistic (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(values, ", ") + ")", nil
+}
+
+// Explain returns the output of EXPLAIN for the query
+func (d *pgDriver) Explain(db driver.Queryer, query string) (string, error) {
+	rows, err := db.Query("EXPLAIN " + query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
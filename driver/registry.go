@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates a driver that uses tableName as its version table, or its default if empty
+type Factory func(tableName string) Driver
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+// Register makes a driver available by url scheme, e.g. "postgres". Drivers register
+// themselves in init, so importing the driver package is enough.
+// It panics if the scheme is registered twice.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[scheme]; ok {
+		panic("driver: Register called twice for scheme " + scheme)
+	}
+	registry[scheme] = factory
+}
+
+// Open creates a driver for the scheme of the url, e.g. postgres://
+func Open(url, tableName string) (Driver, error) {
+	i := strings.Index(url, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("No scheme in url, expected one of: %s", strings.Join(Schemes(), ", "))
+	}
+	registryMu.RLock()
+	factory, ok := registry[url[:i]]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown driver '%s', expected one of: %s", url[:i], strings.Join(Schemes(), ", "))
+	}
+	return factory(tableName), nil
+}
+
+// Schemes returns the registered url schemes
+func Schemes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
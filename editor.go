@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// openEditor opens the files in $VISUAL or $EDITOR, defaulting to vi, and waits for it to exit
+func openEditor(files ...string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command(editor, files...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// envFileOrDefault returns the env file to load
+func envFileOrDefault(name string) string {
+	if name == "" {
+		return ".env"
+	}
+	return name
+}
+
+// loadEnvFile sets the environment variables in a .env file.
+// Variables that are already set are not overwritten.
+// A missing file is only an error when required is true.
+func loadEnvFile(name string, required bool) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) && !required {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	lineNo := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		// skip blank lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return newMessage(msgEnvSyntax, name, lineNo)
+		}
+		key := strings.TrimSpace(line[:i])
+		value := unquote(strings.TrimSpace(line[i+1:]))
+
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// unquote removes matching single or double quotes around a value
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/acls/migrate/migrate"
+)
+
+// runExpandContract creates the expand, backfill and contract migrations of the
+// key=value args, see migrate.ExpandContract
+func runExpandContract(m *migrate.Migrator, incMajor bool, name string, args []string) error {
+	if name == "" {
+		return newMessage(msgNoName)
+	}
+	vars, err := parseVars(args)
+	if err != nil {
+		return err
+	}
+	ec := migrate.ExpandContract{
+		Table:     vars["table"],
+		Column:    vars["column"],
+		NewColumn: vars["new_column"],
+		Type:      vars["type"],
+		OldType:   vars["old_type"],
+		Using:     vars["using"],
+		Reverse:   vars["reverse"],
+	}
+	if s, ok := vars["not_null"]; ok {
+		if ec.NotNull, err = strconv.ParseBool(s); err != nil {
+			return newMessage(msgBadVar, "not_null="+s)
+		}
+	}
+	files, err := m.CreateExpandContract(incMajor, name, ec)
+	if err != nil {
+		return err
+	}
+	for _, mf := range files {
+		printCreated(m.Path, mf)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package file
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// azureVersion is the version of the Blob Storage api
+const azureVersion = "2021-08-06"
+
+// DefaultAzureBlockSize is the size of the blocks of AzureWriter when BlockSize is 0
+const DefaultAzureBlockSize = 8 << 20
+
+// AzureConfig is the location of a dump below a prefix of an Azure Blob Storage container.
+// The requests are authorized with a SAS token, which needs the read, write and list
+// permissions of the container. The files are stored in the same layout as DirWriter's
+// below the prefix.
+type AzureConfig struct {
+	// ContainerURL is e.g. https://account.blob.core.windows.net/container
+	ContainerURL string
+	Prefix       string
+	// SASToken is the query of the SAS, with or without the leading ?
+	SASToken string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// AzureWriter writes a dump to Azure. Each file is uploaded as a block blob, block by block,
+// so files are only buffered in memory up to a block.
+type AzureWriter struct {
+	AzureConfig
+	// BlockSize defaults to DefaultAzureBlockSize. A blob has up to 50000 blocks.
+	BlockSize int
+}
+
+// Writer uploads the file below the prefix
+func (a *AzureWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	size := a.BlockSize
+	if size <= 0 {
+		size = DefaultAzureBlockSize
+	}
+	return &azureBlockWriter{a: a, blob: a.blob(dir, name), size: size}, nil
+}
+
+// Close does nothing, the files are uploaded when their writers are closed
+func (a *AzureWriter) Close() error {
+	return nil
+}
+
+// azureBlockWriter uploads a block blob
+type azureBlockWriter struct {
+	a      *AzureWriter
+	blob   string
+	size   int
+	buf    []byte
+	blocks []string
+}
+
+func (w *azureBlockWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		room := w.size - len(w.buf)
+		if room > len(b) {
+			room = len(b)
+		}
+		w.buf = append(w.buf, b[:room]...)
+		b = b[room:]
+		n += room
+		if len(w.buf) >= w.size {
+			if err := w.putBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// putBlock uploads the buffer as the next block
+func (w *azureBlockWriter) putBlock() error {
+	// the ids of a blob's blocks have the same length
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", len(w.blocks))))
+	query := url.Values{"comp": {"block"}, "blockid": {id}}
+	res, err := w.a.do("PUT", w.blob, query, bytes.NewReader(w.buf))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	w.buf = w.buf[:0]
+	w.blocks = append(w.blocks, id)
+	return nil
+}
+
+// Close uploads the rest of the file and commits its blocks
+func (w *azureBlockWriter) Close() error {
+	if len(w.buf) > 0 {
+		if err := w.putBlock(); err != nil {
+			return err
+		}
+	}
+	var list bytes.Buffer
+	list.WriteString(xml.Header + "<BlockList>")
+	for _, id := range w.blocks {
+		list.WriteString("<Latest>" + id + "</Latest>")
+	}
+	list.WriteString("</BlockList>")
+	res, err := w.a.do("PUT", w.blob, url.Values{"comp": {"blocklist"}}, &list)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// AzureReader reads a dump from Azure. The files are streamed from their blobs.
+type AzureReader struct {
+	AzureConfig
+}
+
+// azureList is a page of List Blobs
+type azureList struct {
+	Blobs      []string `xml:"Blobs>Blob>Name"`
+	NextMarker string   `xml:"NextMarker"`
+}
+
+// Files lists the files below the prefix's dir
+func (a *AzureReader) Files(dir string) (Openers, error) {
+	prefix := cloudPrefix(a.Prefix) + dir
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+	openers := Openers{}
+	for {
+		res, err := a.do("GET", "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var list azureList
+		err = xml.NewDecoder(res.Body).Decode(&list)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range list.Blobs {
+			name := strings.TrimPrefix(strings.TrimPrefix(blob, prefix), "/")
+			if name == "" || strings.HasSuffix(name, "/") {
+				continue
+			}
+			blob := blob
+			openers = append(openers, Opener{
+				Name: name,
+				Open: func() (io.ReadCloser, error) {
+					res, err := a.do("GET", blob, nil, nil)
+					if err != nil {
+						return nil, err
+					}
+					return res.Body, nil
+				},
+			})
+		}
+		if list.NextMarker == "" {
+			return openers, nil
+		}
+		query.Set("marker", list.NextMarker)
+	}
+}
+
+func (c AzureConfig) blob(dir, name string) string {
+	return cloudPrefix(c.Prefix) + path.Join(dir, name)
+}
+
+// do sends the request of the blob, or of the container if blob is empty. The SAS token
+// isn't part of its errors.
+func (c AzureConfig) do(method, blob string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := strings.TrimRight(c.ContainerURL, "/")
+	if blob != "" {
+		u += "/" + (&url.URL{Path: blob}).EscapedPath()
+	}
+	rawQuery := query.Encode()
+	if sas := strings.TrimPrefix(c.SASToken, "?"); sas != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += sas
+	}
+	req, err := http.NewRequest(method, u+"?"+rawQuery, body)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Azure url %s", u)
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		// the url.Error's url has the token
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("%s %s failed: %w", method, u, err)
+	}
+	if res.StatusCode/100 != 2 {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s returned %s", method, u, res.Status)
+	}
+	return res, nil
+}
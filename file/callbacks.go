@@ -0,0 +1,50 @@
+package file
+
+import (
+	"strings"
+
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// Callback files in the root of the migrations dir, e.g. _after_each.sql, run at points of a
+// migration that applies any files: the before all file before the first migration file, the
+// after each file in the transaction of each migration file after it's applied, and the after
+// all file after the last migration file and the repeatable migrations.
+const (
+	BeforeAllCallback = "_before_all"
+	AfterEachCallback = "_after_each"
+	AfterAllCallback  = "_after_all"
+)
+
+// Callbacks are the callback files of a migrations dir. Missing files are nil.
+type Callbacks struct {
+	BeforeAll, AfterEach, AfterAll *File
+}
+
+// GetCallbacks returns the callback files in the root of the files
+func GetCallbacks(openers Openers, filenameExtension string) (c Callbacks, err error) {
+	for _, o := range openers {
+		if !strings.HasSuffix(o.Name, "."+filenameExtension) {
+			continue
+		}
+		name := strings.TrimSuffix(o.Name, "."+filenameExtension)
+		var f **File
+		switch name {
+		case BeforeAllCallback:
+			f = &c.BeforeAll
+		case AfterEachCallback:
+			f = &c.AfterEach
+		case AfterAllCallback:
+			f = &c.AfterAll
+		default:
+			continue
+		}
+		*f = &File{
+			Open:      o.Open,
+			FileName:  o.Name,
+			Name:      strings.TrimPrefix(name, "_"),
+			Direction: direction.Up,
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commandWriter streams to the stdin of a command, which is waited for on Close
+type commandWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// startWriter starts the command with a pipe to its stdin
+func startWriter(env []string, command string, args ...string) (io.WriteCloser, error) {
+	cmd := newCommand(env, command, args...)
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandWriter{WriteCloser: w, cmd: cmd}, nil
+}
+
+// Close closes stdin and returns the command's error
+func (w *commandWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if werr := w.cmd.Wait(); werr != nil {
+		return commandError(w.cmd, werr)
+	}
+	return err
+}
+
+// commandReader reads the stdout of a command
+type commandReader struct {
+	io.ReadCloser
+	cmd  *exec.Cmd
+	done bool
+	err  error
+}
+
+// startReader starts the command with a pipe from its stdout
+func startReader(env []string, command string, args ...string) (io.ReadCloser, error) {
+	cmd := newCommand(env, command, args...)
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandReader{ReadCloser: r, cmd: cmd}, nil
+}
+
+// Read returns the command's error instead of io.EOF if it failed
+func (r *commandReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if err == io.EOF {
+		if werr := r.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// Close stops the command if its output wasn't read to the end
+func (r *commandReader) Close() error {
+	if !r.done {
+		_ = r.cmd.Process.Kill()
+		_ = r.ReadCloser.Close()
+		_ = r.wait()
+	}
+	return nil
+}
+
+func (r *commandReader) wait() error {
+	if !r.done {
+		r.done = true
+		if err := r.cmd.Wait(); err != nil {
+			r.err = commandError(r.cmd, err)
+		}
+	}
+	return r.err
+}
+
+// runCommand returns the output of the command
+func runCommand(env []string, command string, args ...string) ([]byte, error) {
+	cmd := newCommand(env, command, args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, commandError(cmd, err)
+	}
+	return out, nil
+}
+
+// newCommand returns the command with the env added to the process' env and its stderr
+// connected to the process'. Secrets are passed in env, since the args are in its errors.
+func newCommand(env []string, command string, args ...string) *exec.Cmd {
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+func commandError(cmd *exec.Cmd, err error) error {
+	return fmt.Errorf("%s failed: %v", strings.Join(cmd.Args, " "), err)
+}
@@ -0,0 +1,116 @@
+package file
+
+import (
+	"bufio"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DependsFile is the name of the file in a major version dir that lists the major
+// versions it depends on, one per line. Lines starting with # are ignored.
+// Major versions without the file depend on every previous major version.
+const DependsFile = "depends"
+
+// MajorDependencies maps a major version to the major versions it depends on
+type MajorDependencies map[uint64][]uint64
+
+// ReadMajorDependencies reads the depends files in the major version dirs of basePath
+func ReadMajorDependencies(basePath string) (MajorDependencies, error) {
+	if !V2 {
+		return make(MajorDependencies), nil
+	}
+	openers, err := (&DirReader{BaseDir: basePath}).Files("")
+	if err != nil {
+		return nil, err
+	}
+	return GetMajorDependencies(openers)
+}
+
+// GetMajorDependencies reads the depends files in the major version dirs of the files
+func GetMajorDependencies(openers Openers) (MajorDependencies, error) {
+	deps := make(MajorDependencies)
+	if !V2 {
+		return deps, nil
+	}
+	for _, o := range openers {
+		dir, name := path.Split(o.Name)
+		if name != DependsFile {
+			continue
+		}
+		major, err := strconv.ParseUint(path.Clean(dir), 10, 64)
+		if err != nil {
+			// not in a major version dir
+			continue
+		}
+		if deps[major], err = readDepends(o); err != nil {
+			return nil, fmt.Errorf("%s: %v", o.Name, err)
+		}
+	}
+	return deps, nil
+}
+func readDepends(o Opener) ([]uint64, error) {
+	r, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	majors := make([]uint64, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		major, err := strconv.ParseUint(line, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid major version %q", line)
+		}
+		majors = append(majors, major)
+	}
+	return majors, scanner.Err()
+}
+
+// DependsOn returns true if major depends on other
+func (d MajorDependencies) DependsOn(major, other uint64) bool {
+	majors, ok := d[major]
+	if !ok {
+		return other < major
+	}
+	for _, m := range majors {
+		if m == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Waves groups the migrations by major version, then groups consecutive major
+// versions that don't depend on each other into waves that can be applied concurrently.
+func (d MajorDependencies) Waves(migrations Migrations) (waves [][]Migrations) {
+	var wave []Migrations
+	for i := 0; i < len(migrations); {
+		// collect the migrations of the same major version
+		j := i
+		for j < len(migrations) && migrations[j].Major() == migrations[i].Major() {
+			j++
+		}
+		major := migrations[i:j]
+		// start a new wave if it depends on a major version in the current wave
+		for _, other := range wave {
+			if d.DependsOn(major[0].Major(), other[0].Major()) {
+				waves = append(waves, wave)
+				wave = nil
+				break
+			}
+		}
+		wave = append(wave, major)
+		i = j
+	}
+	if len(wave) > 0 {
+		waves = append(waves, wave)
+	}
+	return
+}
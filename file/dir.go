@@ -1,11 +1,14 @@
 package file
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 )
 
 // DumpWriter interface
@@ -31,6 +34,130 @@ type Opener struct {
 // TablesDir prefix for DumpWriter/DumpReader
 const TablesDir = "tables/"
 
+// SchemaDir prefix for DumpWriter/DumpReader
+const SchemaDir = "schema/"
+
+// DumpVersionFile is the name of the file in the root of a dump that holds the layout version
+const DumpVersionFile = "DUMP_VERSION"
+
+// Dump layout versions
+const (
+	// DumpLayoutV0 is the layout of dumps written before the version file existed
+	DumpLayoutV0 = iota
+	// DumpLayoutV1 is the same as V0 plus the version file
+	DumpLayoutV1
+	// DumpLayoutV2 is the same as V1, except that tar dumps write the files over
+	// TarChunkSize as numbered chunks
+	DumpLayoutV2
+)
+
+// DumpLayoutVersion is the layout version used when writing dumps
+const DumpLayoutVersion = DumpLayoutV2
+
+// DumpLayout describes where the contents of a dump are stored
+type DumpLayout struct {
+	Version   int
+	SchemaDir string
+	TablesDir string
+}
+
+// dumpLayouts holds every layout that can be read. Never remove a layout
+// since old dumps need to stay restorable.
+var dumpLayouts = map[int]DumpLayout{
+	DumpLayoutV0: {Version: DumpLayoutV0, SchemaDir: SchemaDir, TablesDir: TablesDir},
+	DumpLayoutV1: {Version: DumpLayoutV1, SchemaDir: SchemaDir, TablesDir: TablesDir},
+	DumpLayoutV2: {Version: DumpLayoutV2, SchemaDir: SchemaDir, TablesDir: TablesDir},
+}
+
+// WriteDumpVersion writes the current layout version to the root of the dump
+func WriteDumpVersion(dw DumpWriter) error {
+	w, err := dw.Writer("", DumpVersionFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = fmt.Fprintf(w, "%d\n", DumpLayoutVersion)
+	return err
+}
+
+// DetectDumpLayout returns the layout used by the dump.
+// Dumps without a version file are assumed to be DumpLayoutV0.
+func DetectDumpLayout(dr DumpReader) (layout DumpLayout, err error) {
+	openers, err := dr.Files("")
+	if err != nil {
+		return
+	}
+	version := DumpLayoutV0
+	for _, o := range openers {
+		if o.Name != DumpVersionFile {
+			continue
+		}
+		if version, err = readDumpVersion(o); err != nil {
+			return
+		}
+		break
+	}
+	layout, ok := dumpLayouts[version]
+	if !ok {
+		err = fmt.Errorf("Unsupported dump layout version %d", version)
+	}
+	return
+}
+func readDumpVersion(o Opener) (int, error) {
+	r, err := o.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid dump version: %v", err)
+	}
+	return version, nil
+}
+
+// Reader wraps the DumpReader so SchemaDir and TablesDir are read from this layout's dirs
+func (l DumpLayout) Reader(dr DumpReader) DumpReader {
+	r := &layoutReader{dr: dr, layout: l}
+	if sr, ok := dr.(StreamReader); ok {
+		return &layoutStreamReader{layoutReader: r, sr: sr}
+	}
+	return r
+}
+
+type layoutReader struct {
+	dr     DumpReader
+	layout DumpLayout
+}
+
+func (r *layoutReader) Files(dir string) (Openers, error) {
+	return r.dr.Files(r.dir(dir))
+}
+
+func (r *layoutReader) dir(dir string) string {
+	switch dir {
+	case SchemaDir:
+		return r.layout.SchemaDir
+	case TablesDir:
+		return r.layout.TablesDir
+	}
+	return dir
+}
+
+// layoutStreamReader is the layoutReader of a StreamReader
+type layoutStreamReader struct {
+	*layoutReader
+	sr StreamReader
+}
+
+func (r *layoutStreamReader) NextFile(dir string) (Opener, error) {
+	return r.sr.NextFile(r.dir(dir))
+}
+
 // DirWriter struct
 type DirWriter struct {
 	BaseDir string
@@ -58,6 +185,11 @@ func (d *DirReader) Files(dir string) (Openers, error) {
 	openers := make(Openers, 0)
 	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
 		if err != nil {
+			// a missing dir of the dump has no files, e.g. the tables of an incremental dump
+			// when none changed
+			if fpath == dir && dir != path.Clean(d.BaseDir) && os.IsNotExist(err) {
+				return nil
+			}
 			return fmt.Errorf("walking to %s: %v", fpath, err)
 		}
 		if info.IsDir() {
@@ -0,0 +1,261 @@
+package file
+
+import (
+	"bytes"
+	"strings"
+)
+
+// GenerateDown returns the down file that undoes the up file's statements, in reverse order.
+// It inverts CREATE TABLE, INDEX, VIEW, MATERIALIZED VIEW, SEQUENCE, TYPE, SCHEMA and
+// EXTENSION, and ALTER TABLE ADD COLUMN and ADD CONSTRAINT. The other statements, e.g.
+// DROP TABLE, ALTER COLUMN TYPE or UPDATE, are returned as irreversible and listed as
+// TODO comments at the top of the down file. Transaction and SET statements are skipped.
+func GenerateDown(up []byte) (down []byte, irreversible []Statement) {
+	var stmts []string
+	for _, stmt := range SplitStatements(up) {
+		inverse, ok := invertStatement(stmt.SQL)
+		if !ok {
+			irreversible = append(irreversible, stmt)
+			continue
+		}
+		if inverse != "" {
+			stmts = append(stmts, inverse)
+		}
+	}
+	var buf bytes.Buffer
+	for _, stmt := range irreversible {
+		buf.WriteString("-- TODO: irreversible: " + firstLine(stmt.SQL) + "\n")
+	}
+	if len(irreversible) > 0 && len(stmts) > 0 {
+		buf.WriteString("\n")
+	}
+	for i := len(stmts) - 1; i >= 0; i-- {
+		buf.WriteString(stmts[i] + ";\n")
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes(), irreversible
+}
+
+// createObjects are the objects whose CREATE is undone by a DROP of the name
+var createObjects = []string{"TABLE", "VIEW", "MATERIALIZED VIEW", "SEQUENCE", "TYPE", "SCHEMA", "EXTENSION"}
+
+// invertStatement returns the statement that undoes stmt, empty if nothing needs undoing.
+// ok is false if it can't be inverted.
+func invertStatement(stmt string) (inverse string, ok bool) {
+	t := &tokens{tokens: tokenize(stmt)}
+	switch {
+	case t.keyword("BEGIN"), t.keyword("START"), t.keyword("COMMIT"), t.keyword("END"),
+		t.keyword("SET"), t.keyword("RESET"):
+		return "", true
+	case t.keyword("CREATE"):
+		t.keyword("UNIQUE")
+		if t.keyword("INDEX") {
+			// the down file runs in a transaction, so it can't drop it CONCURRENTLY
+			t.keyword("CONCURRENTLY")
+			t.keywords("IF", "NOT", "EXISTS")
+			if t.keyword("ON") {
+				// unnamed indexes are named by the database
+				return "", false
+			}
+			name, ok := t.name()
+			return "DROP INDEX IF EXISTS " + name, ok
+		}
+		if t.keyword("TEMP") || t.keyword("TEMPORARY") || t.keyword("UNLOGGED") {
+			if !t.keyword("TABLE") {
+				return "", false
+			}
+			return t.dropNamed("TABLE")
+		}
+		// CREATE OR REPLACE isn't inverted, the previous definition is lost
+		for _, object := range createObjects {
+			if t.keywords(strings.Fields(object)...) {
+				return t.dropNamed(object)
+			}
+		}
+	case t.keywords("ALTER", "TABLE"):
+		t.keywords("IF", "EXISTS")
+		t.keyword("ONLY")
+		table, ok := t.name()
+		if !ok {
+			return "", false
+		}
+		var actions []string
+		for {
+			if !t.keyword("ADD") {
+				return "", false
+			}
+			if t.keyword("CONSTRAINT") {
+				name, ok := t.name()
+				if !ok {
+					return "", false
+				}
+				actions = append(actions, "DROP CONSTRAINT IF EXISTS "+name)
+			} else {
+				t.keyword("COLUMN")
+				t.keywords("IF", "NOT", "EXISTS")
+				if t.peekKeyword("PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "EXCLUDE") {
+					// constraints without a name are named by the database
+					return "", false
+				}
+				name, ok := t.name()
+				if !ok {
+					return "", false
+				}
+				actions = append(actions, "DROP COLUMN IF EXISTS "+name)
+			}
+			if !t.skipToComma() {
+				break
+			}
+		}
+		// the actions are undone in reverse order too
+		for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+			actions[i], actions[j] = actions[j], actions[i]
+		}
+		return "ALTER TABLE " + table + " " + strings.Join(actions, ", "), true
+	}
+	return "", false
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+	return s
+}
+
+// tokens are the words, quoted identifiers, strings and punctuation of a statement
+type tokens struct {
+	tokens []string
+	pos    int
+}
+
+func (t *tokens) peek() string {
+	if t.pos < len(t.tokens) {
+		return t.tokens[t.pos]
+	}
+	return ""
+}
+
+// keyword consumes the next token if it's the keyword
+func (t *tokens) keyword(kw string) bool {
+	if strings.EqualFold(t.peek(), kw) {
+		t.pos++
+		return true
+	}
+	return false
+}
+
+// keywords consumes the next tokens if they're the keywords
+func (t *tokens) keywords(kws ...string) bool {
+	for i, kw := range kws {
+		if t.pos+i >= len(t.tokens) || !strings.EqualFold(t.tokens[t.pos+i], kw) {
+			return false
+		}
+	}
+	t.pos += len(kws)
+	return true
+}
+
+func (t *tokens) peekKeyword(kws ...string) bool {
+	for _, kw := range kws {
+		if strings.EqualFold(t.peek(), kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// name consumes an identifier, qualified by dots
+func (t *tokens) name() (string, bool) {
+	name := t.peek()
+	if !isName(name) {
+		return "", false
+	}
+	t.pos++
+	for t.peek() == "." {
+		t.pos++
+		part := t.peek()
+		if !isName(part) {
+			return "", false
+		}
+		t.pos++
+		name += "." + part
+	}
+	return name, true
+}
+
+// dropNamed returns the DROP of the object named next, after an optional IF NOT EXISTS
+func (t *tokens) dropNamed(object string) (string, bool) {
+	t.keywords("IF", "NOT", "EXISTS")
+	name, ok := t.name()
+	return "DROP " + object + " IF EXISTS " + name, ok
+}
+
+// skipToComma consumes the tokens up to and including the next comma outside of
+// parentheses. It returns false at the end of the statement.
+func (t *tokens) skipToComma() bool {
+	depth := 0
+	for ; t.pos < len(t.tokens); t.pos++ {
+		switch t.tokens[t.pos] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		case ",":
+			if depth == 0 {
+				t.pos++
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isName(tok string) bool {
+	if tok == "" {
+		return false
+	}
+	if tok[0] == '"' {
+		return true
+	}
+	return isIdentByte(tok[0]) && (tok[0] < '0' || tok[0] > '9')
+}
+
+// tokenize splits the statement into words, quoted identifiers, strings and single
+// punctuation characters. Comments are left out.
+func tokenize(stmt string) (toks []string) {
+	b := []byte(stmt)
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(b) && b[i+1] == '-':
+			for i < len(b) && b[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(b) && b[i+1] == '*':
+			i = skipBlockComment(b, i) + 1
+		case c == '"' || c == '\'':
+			end := skipQuoted(b, i, false) + 1
+			if end > len(b) {
+				end = len(b)
+			}
+			toks = append(toks, stmt[i:end])
+			i = end
+		case isIdentByte(c):
+			start := i
+			for i < len(b) && isIdentByte(b[i]) {
+				i++
+			}
+			toks = append(toks, stmt[start:i])
+		default:
+			toks = append(toks, stmt[i:i+1])
+			i++
+		}
+	}
+	return
+}
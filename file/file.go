@@ -3,6 +3,8 @@ package file
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"go/token"
@@ -14,6 +16,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/acls/migrate/migrate/direction"
 )
@@ -23,6 +26,23 @@ import (
 // So don't set this to true and then set it to false.
 var V2 bool
 
+var (
+	// ErrVersionGap is returned when a version is missing between the first and last migration files
+	ErrVersionGap = errors.New("Missing version")
+	// ErrBaseFilesDiffer is returned when an applied upfile's content differs from the file on disk
+	ErrBaseFilesDiffer = errors.New("Base upfile contents differ")
+	// ErrInvalidFiles is returned when the migration files can't be read, e.g. duplicate versions
+	ErrInvalidFiles = errors.New("Invalid migration files")
+	// ErrNamesDiffer is reported for the up files whose down file has a different name
+	ErrNamesDiffer = errors.New("Up and down file names differ")
+	// ErrVersionConflict is returned when an applied version was applied from another file
+	// than the file with its version, e.g. after parallel branches used the same version
+	ErrVersionConflict = errors.New("Version conflict")
+	// ErrOutOfOrder is returned when a file's version is lower than the last applied version,
+	// but it isn't applied, e.g. when it was merged late from another branch
+	ErrOutOfOrder = errors.New("Out-of-order migration files")
+)
+
 // File represents one file on disk.
 // Example: 001_initial_plan_to_do_sth.up.sql
 type File struct {
@@ -177,6 +197,19 @@ func (m *Migration) Up() bool {
 	return m.d != direction.Down
 }
 
+// Baseline returns true if the migration is a baseline, see BaselineName
+func (m *Migration) Baseline() bool {
+	return m.migrationFile.Baseline()
+}
+
+// Name returns the name of the migration's up file
+func (m *Migration) Name() string {
+	if m.migrationFile.UpFile == nil {
+		return ""
+	}
+	return m.migrationFile.UpFile.Name
+}
+
 func (m *Migration) File() *File {
 	if m.Up() {
 		return m.migrationFile.UpFile
@@ -213,6 +246,15 @@ type MigrationFile struct {
 
 	// reference to the *down* migration file
 	DownFile *File
+
+	// AppliedAt and Duration are when and how long the up migration ran, for files
+	// read from the version table. Zero if unknown, e.g. applied by an older version.
+	AppliedAt time.Time
+	Duration  time.Duration
+
+	// UpChecksum is the Checksum of the up file's content, for files read from the
+	// version table. Empty if unknown, e.g. recorded by an older version.
+	UpChecksum string
 }
 
 // Migration returns the migration for the passed in direction
@@ -248,6 +290,33 @@ func (mf MigrationFiles) LastVersion() Version {
 	return NewVersion2(0, 0)
 }
 
+// Find returns the migration file with the passed in version
+func (mf MigrationFiles) Find(version Version) (MigrationFile, bool) {
+	for _, f := range mf {
+		if f.Compare(version) == 0 {
+			return f, true
+		}
+	}
+	return MigrationFile{}, false
+}
+
+// FindByName returns the migration files whose up file has the passed in name
+func (mf MigrationFiles) FindByName(name string) MigrationFiles {
+	var found MigrationFiles
+	for _, f := range mf {
+		if f.UpFile != nil && f.UpFile.Name == name {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// Checksum returns the hex encoded sha256 checksum of the content
+func Checksum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
 // ReadContent reads the file's content if the content is nil
 func (f *File) ReadContent() error {
 	if f.Content == nil {
@@ -283,6 +352,11 @@ func (f *File) prevPath(prevDir string) string {
 	return path.Join(prevDir, majorStr)
 }
 
+// Path returns the path of the file in baseDir
+func (f *File) Path(baseDir string) string {
+	return path.Join(f.prevPath(baseDir), f.FileName)
+}
+
 // Write reads the file's content and writes to the passed in path
 func (f *File) Write(baseDir string, mkDir bool) (err error) {
 	if f == nil {
@@ -372,39 +446,143 @@ func (mf MigrationFiles) Between(prevFiles MigrationFiles, force bool) (curVersi
 	return
 }
 
-// ValidateBaseFiles validates that the base files have the same versions and upfile content
+// ValidateBaseFiles validates that the base files have the same versions and upfile content.
+// The previous files' UpChecksum is compared instead of their content when it's set.
+// Returns ErrOutOfOrder if there are OutOfOrder files.
 func (mf MigrationFiles) ValidateBaseFiles(prevFiles MigrationFiles) error {
+	return mf.validateBaseFiles(prevFiles, false)
+}
+
+// ValidateBaseFilesOutOfOrder is ValidateBaseFiles, but skips the OutOfOrder files instead of
+// returning ErrOutOfOrder
+func (mf MigrationFiles) ValidateBaseFilesOutOfOrder(prevFiles MigrationFiles) error {
+	return mf.validateBaseFiles(prevFiles, true)
+}
+
+func (mf MigrationFiles) validateBaseFiles(prevFiles MigrationFiles, allowOutOfOrder bool) error {
 	if len(mf) < len(prevFiles) {
 		return fmt.Errorf("Less migration files than previous migration files")
 	}
 	// check if current files are contiguous
 	if missing := mf.MissingVersion(); missing != nil {
-		return fmt.Errorf("Missing version: %d", missing)
+		return fmt.Errorf("%w: %d", ErrVersionGap, missing)
+	}
+	inOrder := mf
+	if outOfOrder := mf.OutOfOrder(prevFiles); len(outOfOrder) > 0 {
+		if !allowOutOfOrder {
+			return outOfOrderError(outOfOrder, prevFiles.LastVersion())
+		}
+		inOrder = mf.without(outOfOrder)
+		if len(inOrder) < len(prevFiles) {
+			return fmt.Errorf("Less migration files than previous migration files")
+		}
 	}
 	// compare upfiles up to end of previous files
 	for i, prev := range prevFiles {
-		file := mf[i]
+		file := inOrder[i]
 		// compare versions
 		if prev.Compare(file.Version) != 0 {
 			return fmt.Errorf("Expected version %v, but got %v", prev.Version, file.Version)
 		}
-		// compare upfile content
-		if err := prev.UpFile.ReadContent(); err != nil {
-			return fmt.Errorf("Failed to read previous upfile content: %v", err)
+		same, err := file.SameUpFile(prev)
+		if err != nil {
+			return err
 		}
-		if err := file.UpFile.ReadContent(); err != nil {
-			return fmt.Errorf("Failed to read upfile content: %v", err)
+		if !same && storedName(prev) && prev.UpFile.Name != file.UpFile.Name {
+			return fmt.Errorf("%w: version %v was applied as %s, but the migration file is %s",
+				ErrVersionConflict, prev.Version, prev.UpFile.FileName, file.UpFile.FileName)
 		}
-		if bytes.Compare(prev.UpFile.Content, file.UpFile.Content) != 0 {
-			return fmt.Errorf("Base upfile contents differ for version %v. "+
+		if !same {
+			return fmt.Errorf("%w for version %v. "+
 				"The '-force' flag can be added to bypass this validation. "+
 				"Only do so if the text is different, but the schema change is the same. "+
-				"E.g.: adding/removing comments", prev.Version)
+				"E.g.: adding/removing comments", ErrBaseFilesDiffer, prev.Version)
 		}
 	}
 	return nil
 }
 
+// OutOfOrder returns the files with a version lower than the last previous file's version
+// that aren't previous files. These aren't applied by ToLastFrom.
+func (mf MigrationFiles) OutOfOrder(prevFiles MigrationFiles) MigrationFiles {
+	var outOfOrder MigrationFiles
+	last := prevFiles.LastVersion()
+	for _, f := range mf {
+		if f.Compare(last) >= 0 {
+			continue
+		}
+		if _, ok := prevFiles.Find(f.Version); !ok {
+			outOfOrder = append(outOfOrder, f)
+		}
+	}
+	return outOfOrder
+}
+
+// without returns the files that aren't in other
+func (mf MigrationFiles) without(other MigrationFiles) MigrationFiles {
+	var files MigrationFiles
+	for _, f := range mf {
+		if _, ok := other.Find(f.Version); !ok {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// storedName returns true if the stored file's name is known. It's "-" for versions
+// recorded before names were stored.
+func storedName(mf MigrationFile) bool {
+	return mf.UpFile != nil && mf.UpFile.Name != "" && mf.UpFile.Name != "-"
+}
+
+func outOfOrderError(outOfOrder MigrationFiles, last Version) error {
+	names := make([]string, len(outOfOrder))
+	for i, f := range outOfOrder {
+		names[i] = f.UpFile.FileName
+	}
+	return fmt.Errorf("%w: %s lower than the last applied version %v", ErrOutOfOrder, strings.Join(names, ", "), last)
+}
+
+// BaselineName is the name of the migration that replaces the versions up to its own, see
+// migrate.Squash. A baseline can be the first migration file, and is the first version applied
+// to an empty database.
+const BaselineName = "baseline"
+
+// Baseline returns true if the migration is a baseline, see BaselineName
+func (mf MigrationFile) Baseline() bool {
+	return mf.UpFile != nil && mf.UpFile.Name == BaselineName
+}
+
+// SameUpFile returns true if the upfile content matches the stored file's upfile.
+// The stored file's UpChecksum is compared, without reading its content, when it's set.
+func (mf MigrationFile) SameUpFile(stored MigrationFile) (bool, error) {
+	if err := mf.UpFile.ReadContent(); err != nil {
+		return false, fmt.Errorf("Failed to read upfile content: %v", err)
+	}
+	if stored.UpChecksum != "" {
+		return stored.UpChecksum == Checksum(mf.UpFile.Content), nil
+	}
+	if err := stored.UpFile.ReadContent(); err != nil {
+		return false, fmt.Errorf("Failed to read previous upfile content: %v", err)
+	}
+	return bytes.Equal(stored.UpFile.Content, mf.UpFile.Content), nil
+}
+
+// SameDownFile returns true if the downfile content matches the stored file's downfile,
+// or neither has a downfile
+func (mf MigrationFile) SameDownFile(stored MigrationFile) (bool, error) {
+	if mf.DownFile == nil || stored.DownFile == nil {
+		return mf.DownFile == nil && stored.DownFile == nil, nil
+	}
+	if err := mf.DownFile.ReadContent(); err != nil {
+		return false, fmt.Errorf("Failed to read downfile content: %v", err)
+	}
+	if err := stored.DownFile.ReadContent(); err != nil {
+		return false, fmt.Errorf("Failed to read previous downfile content: %v", err)
+	}
+	return bytes.Equal(stored.DownFile.Content, mf.DownFile.Content), nil
+}
+
 // DownTo fetches all (down) migration files including the migration file
 // of the current version to the very first migration file.
 func (mf MigrationFiles) DownTo(dstVersion Version) Migrations {
@@ -531,6 +709,9 @@ func (mf MigrationFiles) MissingVersion() Version {
 	}
 
 	expected := NewVersion2(0, 1)
+	if mf[0].Baseline() {
+		expected = mf[0].Version
+	}
 	for i := range mf {
 		if mf[i].Compare(expected) != 0 {
 			if V2 && i != 0 {
@@ -575,13 +756,75 @@ func ReadMigrationFiles(basePath string, filenameExtension string) (files Migrat
 	if err != nil {
 		return
 	}
-	return GetMigrationFiles(openers, filenameExtension)
+	return GetMigrationFiles(openers, filenameExtension, ParseOptions{})
+}
+
+// ParseOptions are the options of GetMigrationFiles
+type ParseOptions struct {
+	// Strict returns an error listing every file that can't be parsed and every version
+	// with different up and down file names, instead of calling Warn
+	Strict bool
+	// Warn is called for each file that's skipped because it can't be parsed, and with an
+	// ErrNamesDiffer error for each up file whose down file has a different name.
+	// They're ignored if it's nil.
+	Warn func(name string, err error)
+	// Ignore are path.Match patterns of more base names of files to skip without a warning,
+	// besides README*, LICENSE* and *.md files
+	Ignore []string
+}
+
+// warn reports a problem that's an error with Strict
+func (o ParseOptions) warn(problems *[]string, name string, err error) {
+	if o.Strict {
+		*problems = append(*problems, fmt.Sprintf("%s: %v", name, err))
+	} else if o.Warn != nil {
+		o.Warn(name, err)
+	}
 }
-func GetMigrationFiles(openers Openers, filenameExtension string) (files MigrationFiles, err error) {
+
+// ignorePatterns are path.Match patterns of the base names of files in a migrations dir that
+// aren't migration files, e.g. documentation. They're skipped without a warning, even with Strict.
+var ignorePatterns = []string{"README*", "LICENSE*", "*.md"}
+
+// ignored returns true for files in a migrations dir that aren't migration files, e.g. the
+// depends file, templates, hidden files and files matching ignorePatterns or o.Ignore
+func (o ParseOptions) ignored(name string) bool {
+	base := path.Base(name)
+	if base == DependsFile || base == DumpVersionFile {
+		return true
+	}
+	for _, patterns := range [][]string{ignorePatterns, o.Ignore} {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, base); ok {
+				return true
+			}
+		}
+	}
+	if !strings.Contains(name, "/") && (strings.HasPrefix(name, RepeatablePrefix) || strings.HasPrefix(name, "_")) {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMigrationFiles parses the files' names and pairs the up and down files of each version
+func GetMigrationFiles(openers Openers, filenameExtension string, opts ParseOptions) (files MigrationFiles, err error) {
 	tmpFileMap := make(map[string]*MigrationFile)
+	// paths of the files for error messages
+	paths := make(map[*File]string)
+	// problems that are returned as one error
+	var problems []string
 	for _, ioFile := range openers {
 		majorVersion, minorVersion, name, d, err := parseFilenameSchema(V2, ioFile.Name, filenameExtension)
 		if err != nil {
+			if opts.ignored(ioFile.Name) {
+				continue
+			}
+			opts.warn(&problems, ioFile.Name, err)
 			continue
 		}
 		version := NewVersion2(majorVersion, minorVersion)
@@ -602,31 +845,52 @@ func GetMigrationFiles(openers Openers, filenameExtension string) (files Migrati
 			Content:   nil,
 			Direction: d,
 		}
+		paths[file] = ioFile.Name
+		var existing **File
 		switch d {
 		case direction.Up:
-			if migrationFile.UpFile != nil {
-				return nil, fmt.Errorf("duplicate migrate up file version %d", version)
-			}
-			migrationFile.UpFile = file
+			existing = &migrationFile.UpFile
 		case direction.Down:
-			if migrationFile.DownFile != nil {
-				return nil, fmt.Errorf("duplicate migrate down file version %d", version)
-			}
-			migrationFile.DownFile = file
+			existing = &migrationFile.DownFile
 		default:
 			return nil, errors.New("Unsupported direction.Direction Type")
 		}
+		if *existing != nil {
+			problems = append(problems, fmt.Sprintf("%s and %s: duplicate %s file for version %v",
+				paths[*existing], ioFile.Name, directionName(d), version))
+			continue
+		}
+		*existing = file
 	}
 
 	files = make(MigrationFiles, 0, len(tmpFileMap))
 	for _, file := range tmpFileMap {
 		files = append(files, *file)
 	}
-
 	sort.Sort(files)
+
+	// up and down files of a version should have the same name
+	for _, mf := range files {
+		if mf.UpFile == nil || mf.DownFile == nil || mf.UpFile.Name == mf.DownFile.Name {
+			continue
+		}
+		err := fmt.Errorf("%w for version %v, down file is %s", ErrNamesDiffer, mf.Version, paths[mf.DownFile])
+		opts.warn(&problems, paths[mf.UpFile], err)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w:\n  %s", ErrInvalidFiles, strings.Join(problems, "\n  "))
+	}
 	return files, nil
 }
 
+func directionName(d direction.Direction) string {
+	if d == direction.Down {
+		return "down"
+	}
+	return "up"
+}
+
 const filenameRegexSuffix = `(?P<minor>[0-9]+)_(?P<name>.*)\.(?P<direction>up|down)\.(?P<ext>.*)$`
 
 var filenameRegex = regexp.MustCompile("^" + filenameRegexSuffix)
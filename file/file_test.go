@@ -1,9 +1,22 @@
 package file
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/acls/migrate/migrate/direction"
@@ -211,8 +224,60 @@ func TestDuplicateFiles(t *testing.T) {
 	}
 
 	_, err = ReadMigrationFiles(root, "sql")
-	if err == nil {
-		t.Fatal("Expected duplicate migration file error")
+	if !errors.Is(err, ErrInvalidFiles) {
+		t.Fatalf("Expected ErrInvalidFiles, got %v", err)
+	}
+	// both files are named
+	for _, name := range dups {
+		if !strings.Contains(err.Error(), name) {
+			t.Fatalf("Expected error to contain %s, got %v", name, err)
+		}
+	}
+}
+
+func TestConflictingFiles(t *testing.T) {
+	V2 = true
+
+	root, cleanFn, err := makeFiles("TestConflictingFiles",
+		"001_migration.up.sql",
+		"001_duplicate.up.sql",
+		"002_migration.down.sql",
+		"002_duplicate.down.sql",
+		"003_create_users.up.sql",
+		"003_drop_posts.down.sql",
+	)
+	defer cleanFn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	openers, err := (&DirReader{BaseDir: root}).Files("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warned []string
+	var namesDiffer error
+	opts := ParseOptions{Warn: func(name string, err error) {
+		warned = append(warned, name)
+		if errors.Is(err, ErrNamesDiffer) {
+			namesDiffer = err
+		}
+	}}
+
+	// all duplicates are reported at once
+	_, err = GetMigrationFiles(openers, "sql", opts)
+	if err == nil || strings.Count(err.Error(), ": duplicate ") != 2 {
+		t.Fatalf("Expected 2 duplicates, got %v", err)
+	}
+	// different up and down names are a warning
+	if len(warned) != 2 || warned[1] != "000/003_create_users.up.sql" || namesDiffer == nil {
+		t.Fatalf("Expected name mismatch warning, got %v", warned)
+	}
+
+	opts.Strict = true
+	_, err = GetMigrationFiles(openers, "sql", opts)
+	if err == nil || !strings.Contains(err.Error(), "003_drop_posts.down.sql") {
+		t.Fatalf("Expected name mismatch error, got %v", err)
 	}
 }
 
@@ -240,3 +305,1235 @@ func makeFiles(testname string, names ...string) (root string, cleanup func(), e
 	}
 	return
 }
+
+func TestDetectDumpLayout(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("/tmp", "TestDetectDumpLayout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	// legacy dumps don't have a version file
+	dw := &DirWriter{BaseDir: dumpDir}
+	w, err := dw.Writer(TablesDir, "tbl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	layout, err := DetectDumpLayout(&DirReader{BaseDir: dumpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Version != DumpLayoutV0 {
+		t.Fatalf("Expected layout version %d, got %d", DumpLayoutV0, layout.Version)
+	}
+
+	// current dumps
+	if err := WriteDumpVersion(dw); err != nil {
+		t.Fatal(err)
+	}
+	layout, err = DetectDumpLayout(&DirReader{BaseDir: dumpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Version != DumpLayoutVersion {
+		t.Fatalf("Expected layout version %d, got %d", DumpLayoutVersion, layout.Version)
+	}
+	openers, err := layout.Reader(&DirReader{BaseDir: dumpDir}).Files(TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openers) != 1 || openers[0].Name != "tbl" {
+		t.Fatalf("Expected table file 'tbl', got %v", openers)
+	}
+
+	// unknown versions
+	if err := ioutil.WriteFile(path.Join(dumpDir, DumpVersionFile), []byte("999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DetectDumpLayout(&DirReader{BaseDir: dumpDir}); err == nil {
+		t.Fatal("Expected unsupported layout error")
+	}
+}
+
+func TestFind(t *testing.T) {
+	files := MigrationFiles{
+		{Version: NewVersion2(0, 1)},
+		{Version: NewVersion2(0, 2)},
+	}
+	if f, ok := files.Find(NewVersion2(0, 2)); !ok || f.Compare(NewVersion2(0, 2)) != 0 {
+		t.Fatal("Expected to find version 2")
+	}
+	if _, ok := files.Find(NewVersion2(0, 3)); ok {
+		t.Fatal("Didn't expect to find version 3")
+	}
+}
+
+func TestTarGzDump(t *testing.T) {
+	testTarDump(t, "dump.tar.gz", NewTarGzWriter, NewTarGzReader)
+}
+
+func TestTarDump(t *testing.T) {
+	testTarDump(t, "dump.tar", func(tarFile, _ string) (DumpWriter, error) {
+		return NewTarWriter(tarFile)
+	}, NewTarReader)
+}
+
+func TestSpoolWriter(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestSpoolWriter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	tarFile := path.Join(tmpdir, "dump.tar")
+	tw, err := NewTarWriter(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if WritesConcurrently(tw) || !WritesConcurrently(&DirWriter{}) {
+		t.Fatal("Expected only the dir writer to write concurrently")
+	}
+	dw := NewSpoolWriter(tw)
+	dw.Dir = tmpdir
+
+	// write the tables at the same time, a line each in turns
+	tables := []string{"tbl1", "tbl2", "tbl3"}
+	writers := make([]io.WriteCloser, len(tables))
+	for i, name := range tables {
+		if writers[i], err = dw.Writer(TablesDir, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for line := 0; line < 2; line++ {
+		for i, w := range writers {
+			fmt.Fprintf(w, "%d-%d\n", i, line)
+		}
+	}
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(path.Join(tmpdir, "migrate-spool*")); len(files) != 0 {
+		t.Fatalf("Expected the temp files to be removed, got %v", files)
+	}
+
+	dr, err := NewTarReader(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ReadsConcurrently(dr) || !ReadsConcurrently(&DirReader{}) {
+		t.Fatal("Expected only the dir reader to read concurrently")
+	}
+	sr := NewSpoolReader(dr)
+	sr.Dir = tmpdir
+	defer sr.Close()
+	openers, err := sr.Files(TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openers) != len(tables) {
+		t.Fatalf("Expected %d tables, got %d", len(tables), len(openers))
+	}
+
+	// read the tables at the same time
+	readers := make([]io.ReadCloser, len(openers))
+	for i, o := range openers {
+		if readers[i], err = o.Open(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, r := range readers {
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("%d-0\n%d-1\n", i, i); openers[i].Name != tables[i] || string(b) != expected {
+			t.Fatalf("Expected %s with %q, got %s with %q", tables[i], expected, openers[i].Name, b)
+		}
+	}
+	if files, _ := filepath.Glob(path.Join(tmpdir, "migrate-spool*")); len(files) != 0 {
+		t.Fatalf("Expected the temp files to be removed, got %v", files)
+	}
+}
+
+func testTarDump(t *testing.T, base string, newWriter func(tarFile, tmpFile string) (DumpWriter, error), newReader func(tarFile string) (DumpReader, error)) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestTarDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// write tbl1 in chunks
+	defer func(size int) { TarChunkSize = size }(TarChunkSize)
+	TarChunkSize = 3
+
+	tarFile := path.Join(tmpdir, base)
+	dw, err := newWriter(tarFile, tarFile+".tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{
+		path.Join(SchemaDir, "000", "0001_a.up.sql"): "up",
+		path.Join(TablesDir, "tbl1"):                 "1\n2\n3\n4\n",
+		path.Join(TablesDir, "tbl2"):                 "",
+	}
+	for _, name := range []string{path.Join(SchemaDir, "000", "0001_a.up.sql"), path.Join(TablesDir, "tbl1"), path.Join(TablesDir, "tbl2")} {
+		dir, base := path.Split(name)
+		w, err := dw.Writer(dir, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(contents[name])); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(tarFile + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("Expected no tmp file")
+	}
+
+	// tar reads tbl1 as numbered chunks, and the other files from a single entry
+	f, err := os.Open(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(base, ".gz") {
+		if r, err = gzip.NewReader(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr := tar.NewReader(r)
+	tbl1 := path.Join(TablesDir, "tbl1")
+	expected := map[string]string{
+		path.Join(SchemaDir, "000", "0001_a.up.sql"): "up",
+		tbl1 + ".0000":               "1\n2",
+		tbl1 + ".0001":               "\n3\n",
+		tbl1 + ".0002":               "4\n",
+		path.Join(TablesDir, "tbl2"): "",
+	}
+	entries := 0
+	for ; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect, ok := expected[hdr.Name]; !ok || string(b) != expect {
+			t.Fatalf("Expected %s content %q, got %q", hdr.Name, expect, b)
+		}
+		if name, chunk := tarEntryName(hdr); chunk != (name == tbl1) {
+			t.Fatalf("Expected only the entries of tbl1 to be chunks, got %s of %s", hdr.Name, name)
+		}
+	}
+	if entries != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), entries)
+	}
+
+	dr, err := newReader(tarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.(io.Closer).Close()
+	openers, err := dr.Files(TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openers) != 2 {
+		t.Fatalf("Expected 2 table files, got %d", len(openers))
+	}
+	// read out of archive order to force a reopen
+	for _, i := range []int{1, 0} {
+		o := openers[i]
+		r, err := o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := contents[path.Join(TablesDir, o.Name)]; string(b) != expect {
+			t.Fatalf("Expected %s content %q, got %q", o.Name, expect, b)
+		}
+	}
+}
+
+// fakeS3 stores the objects of the fake aws cli below its dir
+const fakeS3 = `#!/bin/sh
+root=$(dirname "$0")/objects
+case "$1 $2" in
+"s3 cp")
+	if [ "$3" = - ]; then
+		key=${4#s3://}
+		mkdir -p "$root/$(dirname "$key")" && cat >"$root/$key"
+	else
+		cat "$root/${3#s3://}"
+	fi ;;
+"s3api list-objects-v2")
+	cd "$root/$4" 2>/dev/null || { echo null; exit; }
+	find . -type f | sed 's|^\./||' | sort | grep "^$6" |
+		awk 'BEGIN { printf "[" } { printf "%s\"%s\"", (NR > 1 ? "," : ""), $0 } END { print "]" }' ;;
+*)
+	exit 1 ;;
+esac
+`
+
+func TestS3Dump(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestS3Dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	command := path.Join(tmpdir, "aws")
+	if err := ioutil.WriteFile(command, []byte(fakeS3), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := S3Config{Bucket: "bucket", Prefix: "/backups/app", Command: command}
+	testRemoteDump(t, &S3Writer{S3Config: cfg}, &S3Reader{S3Config: cfg})
+	if _, err := os.Stat(path.Join(tmpdir, "objects", "bucket", "backups", "app", TablesDir, "tbl1")); err != nil {
+		t.Fatal("Expected the tables below the prefix:", err)
+	}
+}
+
+// fakeGCS stores the objects of the fake gcloud cli below its dir
+const fakeGCS = `#!/bin/sh
+root=$(dirname "$0")/objects
+[ "$1" = storage ] || exit 1
+shift
+case "$1" in
+cp)
+	key=${3#gs://}
+	mkdir -p "$root/$(dirname "$key")" && cat >"$root/$key" ;;
+cat)
+	cat "$root/${2#gs://}" ;;
+ls)
+	pattern=${2#gs://}
+	found=$(cd "$root" 2>/dev/null && find . -type f | sed 's|^\./||' | sort | grep "^${pattern%\*\*}")
+	[ -n "$found" ] || { echo "ERROR: One or more URLs matched no objects." >&2; exit 1; }
+	echo "$found" | sed 's|^|gs://|' ;;
+objects)
+	shift 2
+	: >"$root.compose"
+	while [ $# -gt 1 ]; do cat "$root/${1#gs://}" >>"$root.compose"; shift; done
+	mkdir -p "$root/$(dirname "${1#gs://}")" && mv "$root.compose" "$root/${1#gs://}" ;;
+rm)
+	shift
+	for url; do rm "$root/${url#gs://}"; done ;;
+*)
+	exit 1 ;;
+esac
+`
+
+func TestGCSDump(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestGCSDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	command := path.Join(tmpdir, "gcloud")
+	if err := ioutil.WriteFile(command, []byte(fakeGCS), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// compose the tables from parts
+	cfg := GCSConfig{Bucket: "bucket", Prefix: "backups/app", Command: command}
+	testRemoteDump(t, &GCSWriter{GCSConfig: cfg, PartSize: 1}, &GCSReader{GCSConfig: cfg})
+	files, err := ioutil.ReadDir(path.Join(tmpdir, "objects", "bucket", "backups", "app", TablesDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 table files, got %d files", len(files))
+	}
+	parts := path.Join(tmpdir, "objects", "bucket", "backups", "app", gcsPartsDir)
+	if files, err := ioutil.ReadDir(path.Join(parts, TablesDir)); err != nil || len(files) != 0 {
+		t.Fatalf("Expected the parts to be removed, got %d files, err %v", len(files), err)
+	}
+
+	// the parts of an interrupted upload aren't files of the dump
+	if err := ioutil.WriteFile(path.Join(parts, TablesDir, "tbl3.part-0000"), []byte("4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	openers, err := (&GCSReader{GCSConfig: cfg}).Files("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range openers {
+		if strings.Contains(o.Name, ".part-") {
+			t.Fatalf("Expected the parts to be skipped, got %s", o.Name)
+		}
+	}
+}
+
+// fakeAzure serves the blobs of a container at /container with the SAS token sig=test.
+// Lists return up to 2 blobs, so they're paged.
+type fakeAzure struct {
+	mu     sync.Mutex
+	blobs  map[string][]byte
+	blocks map[string][]byte
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	if q.Get("sig") != "test" || r.Header.Get("x-ms-version") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	blob := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/container"), "/")
+	switch {
+	case r.Method == "PUT" && q.Get("comp") == "block":
+		b, _ := ioutil.ReadAll(r.Body)
+		f.blocks[blob+q.Get("blockid")] = b
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var b []byte
+		for _, id := range list.Latest {
+			b = append(b, f.blocks[blob+id]...)
+		}
+		f.blobs[blob] = b
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && q.Get("comp") == "list":
+		var names []string
+		for name := range f.blobs {
+			if strings.HasPrefix(name, q.Get("prefix")) && name >= q.Get("marker") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var list struct {
+			XMLName    xml.Name `xml:"EnumerationResults"`
+			Names      []string `xml:"Blobs>Blob>Name"`
+			NextMarker string
+		}
+		list.Names = names
+		if len(names) > 2 {
+			list.Names, list.NextMarker = names[:2], names[2]
+		}
+		xml.NewEncoder(w).Encode(list)
+	case r.Method == "GET":
+		b, ok := f.blobs[blob]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestAzureDump(t *testing.T) {
+	fake := &fakeAzure{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cfg := AzureConfig{ContainerURL: server.URL + "/container", Prefix: "backups/app", SASToken: "?sv=2021-08-06&sig=test"}
+	testRemoteDump(t, &AzureWriter{AzureConfig: cfg, BlockSize: 1}, &AzureReader{AzureConfig: cfg})
+	if _, ok := fake.blobs["backups/app/"+TablesDir+"tbl1"]; !ok {
+		t.Fatal("Expected the tables below the prefix")
+	}
+
+	// the token isn't in the errors
+	cfg.SASToken = "sig=secret"
+	if _, err := (&AzureReader{AzureConfig: cfg}).Files(""); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatalf("Expected an error without the token, got %v", err)
+	}
+}
+
+// testRemoteDump writes a dump and reads it back
+func testRemoteDump(t *testing.T, dw DumpWriter, dr DumpReader) {
+	if openers, err := dr.Files(""); err != nil || len(openers) != 0 {
+		t.Fatalf("Expected an empty dump, got %d files, err %v", len(openers), err)
+	}
+	contents := map[string]string{
+		path.Join(SchemaDir, "000", "0001_a.up.sql"): "up",
+		path.Join(TablesDir, "tbl1"):                 "1\n2\n",
+		path.Join(TablesDir, "tbl2"):                 "3\n",
+	}
+	for name, content := range contents {
+		dir, base := path.Split(name)
+		w, err := dw.Writer(dir, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	openers, err := dr.Files(TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(openers) != 2 {
+		t.Fatalf("Expected 2 table files, got %d", len(openers))
+	}
+	for _, o := range openers {
+		r, err := o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := contents[path.Join(TablesDir, o.Name)]; string(b) != expect {
+			t.Fatalf("Expected %s content %q, got %q", o.Name, expect, b)
+		}
+	}
+	if openers, err = dr.Files(SchemaDir); err != nil || len(openers) != 1 || openers[0].Name != path.Join("000", "0001_a.up.sql") {
+		t.Fatalf("Expected the schema file, got %v, err %v", openers, err)
+	}
+}
+
+func TestTarStreamDump(t *testing.T) {
+	// write tbl1 in chunks
+	defer func(size int) { TarChunkSize = size }(TarChunkSize)
+	TarChunkSize = 3
+
+	for _, gz := range []bool{false, true} {
+		var stream bytes.Buffer
+		dw := NewTarStreamWriter(&stream, gz)
+		if err := WriteDumpVersion(dw); err != nil {
+			t.Fatal(err)
+		}
+		contents := [][2]string{
+			{path.Join(SchemaDir, "000", "0001_a.up.sql"), "up"},
+			{path.Join(TablesDir, "tbl1"), "1\n2\n3\n4\n"},
+			{path.Join(TablesDir, "tbl2"), "5\n"},
+		}
+		for _, c := range contents {
+			dir, base := path.Split(c[0])
+			w, err := dw.Writer(dir, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = w.Write([]byte(c[1])); err != nil {
+				t.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// tbl1 is joined from its chunks
+		sr, err := NewTarStreamReader(bytes.NewReader(stream.Bytes()), gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := sr.NextFile(TablesDir)
+		if err != nil || o.Name != "tbl1" {
+			t.Fatalf("Expected tbl1, got %q, err %v", o.Name, err)
+		}
+		r, err := o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != contents[1][1] {
+			t.Fatalf("Expected tbl1 content %q, got %q, err %v", contents[1][1], b, err)
+		}
+
+		// a reader that only reads the stream once
+		sr, err = NewTarStreamReader(struct{ io.Reader }{&stream}, gz)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layout, err := DetectDumpLayout(sr)
+		if err != nil || layout.Version != DumpLayoutVersion {
+			t.Fatalf("Expected layout %d, got %d, err %v", DumpLayoutVersion, layout.Version, err)
+		}
+		dr, ok := layout.Reader(sr).(StreamReader)
+		if !ok {
+			t.Fatal("Expected the layout's reader to be a StreamReader")
+		}
+		openers, err := dr.Files(SchemaDir)
+		if err != nil || len(openers) != 1 || openers[0].Name != path.Join("000", "0001_a.up.sql") {
+			t.Fatalf("Expected the schema file, got %v, err %v", openers, err)
+		}
+
+		// tbl1 is skipped after reading a byte
+		o, err = dr.NextFile(TablesDir)
+		if err != nil || o.Name != "tbl1" {
+			t.Fatalf("Expected tbl1, got %q, err %v", o.Name, err)
+		}
+		r, err = o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Read(make([]byte, 1)); err != nil {
+			t.Fatal(err)
+		}
+		if o, err = dr.NextFile(TablesDir); err != nil || o.Name != "tbl2" {
+			t.Fatalf("Expected tbl2, got %q, err %v", o.Name, err)
+		}
+		r, err = o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil || string(b) != "5\n" {
+			t.Fatalf("Expected tbl2 content %q, got %q, err %v", "5\n", b, err)
+		}
+		if _, err = dr.NextFile(TablesDir); err != io.EOF {
+			t.Fatalf("Expected io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestManifest(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestManifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	mw := NewManifestWriter(&DirWriter{BaseDir: tmpdir})
+	contents := map[string]string{
+		path.Join(SchemaDir, "000", "0001_a.up.sql"): "up",
+		path.Join(TablesDir, "tbl1"):                 "1\n2\n",
+		path.Join(TablesDir, "tbl2"):                 "3\n",
+	}
+	for name, content := range contents {
+		dir, base := path.Split(name)
+		w, err := mw.Writer(dir, base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mw.WriteManifest(Manifest{SchemaVersion: "0.1", ToolVersion: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	dr := &DirReader{BaseDir: tmpdir}
+	m, err := ReadManifest(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || len(m.Files) != 3 || strings.Join(m.Tables, ",") != "tbl1,tbl2" {
+		t.Fatalf("Expected the files and tables in the manifest, got %+v", m)
+	}
+	if m.Files[1].Path != path.Join(TablesDir, "tbl1") || m.Files[1].Rows != 2 || m.Files[1].Size != 4 {
+		t.Fatalf("Expected tbl1 with 2 rows and 4 bytes, got %+v", m.Files[1])
+	}
+
+	readTable := func(name string) error {
+		vr, err := m.Reader(dr)
+		if err != nil {
+			return err
+		}
+		openers, err := vr.Files(TablesDir)
+		if err != nil {
+			return err
+		}
+		for _, o := range openers {
+			if o.Name != name {
+				continue
+			}
+			r, err := o.Open()
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+			_, err = ioutil.ReadAll(r)
+			return err
+		}
+		return errors.New("Missing " + name)
+	}
+	if err := readTable("tbl1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a truncated table
+	if err := ioutil.WriteFile(path.Join(tmpdir, TablesDir, "tbl1"), []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := readTable("tbl1"); !errors.Is(err, ErrCorruptDump) {
+		t.Fatalf("Expected ErrCorruptDump, got %v", err)
+	}
+	// a missing table
+	if err := os.Remove(path.Join(tmpdir, TablesDir, "tbl2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Reader(dr); !errors.Is(err, ErrCorruptDump) || !strings.Contains(err.Error(), "tbl2") {
+		t.Fatalf("Expected ErrCorruptDump of tbl2, got %v", err)
+	}
+}
+
+func TestIncrementalDump(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "TestIncrementalDump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// dump writes the tables to a new dump in dir, and reuses the base's entries of the others
+	dump := func(dir string, tables map[string]string, reuse []string, base string) *Manifest {
+		mw := NewManifestWriter(&DirWriter{BaseDir: path.Join(tmpdir, dir)})
+		for name, content := range tables {
+			w, err := mw.Writer(TablesDir, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write([]byte(content))
+			if err = w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			mw.SetContentHash(path.Join(TablesDir, name), "hash-"+content)
+		}
+		if base != "" {
+			bm, err := ReadManifest(&DirReader{BaseDir: path.Join(tmpdir, base)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range bm.Files {
+				for _, name := range reuse {
+					if e.Path == path.Join(TablesDir, name) {
+						mw.Reuse(e, base)
+					}
+				}
+			}
+		}
+		if err := mw.WriteManifest(Manifest{SchemaVersion: "0.1"}); err != nil {
+			t.Fatal(err)
+		}
+		m, err := ReadManifest(&DirReader{BaseDir: path.Join(tmpdir, dir)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	dump("full", map[string]string{"tbl1": "1\n", "tbl2": "2\n"}, nil, "")
+	dump("inc1", map[string]string{"tbl1": "1\n3\n"}, []string{"tbl2"}, "full")
+	// the second incremental reuses tbl2 from the full dump, where it is
+	m := dump("inc2", nil, []string{"tbl1", "tbl2"}, "inc1")
+	if len(m.Files) != 2 || m.Files[0].Base != "inc1" || m.Files[1].Base != "full" || m.Files[1].ContentHash != "hash-2\n" {
+		t.Fatalf("Expected tbl1 from inc1 and tbl2 from full, got %+v", m.Files)
+	}
+
+	open := func(location string) (DumpReader, error) {
+		return &DirReader{BaseDir: path.Join(tmpdir, location)}, nil
+	}
+	dr, err := NewIncrementalReader(&DirReader{BaseDir: path.Join(tmpdir, "inc2")}, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr, err = m.Reader(dr); err != nil {
+		t.Fatal(err)
+	}
+	openers, err := dr.Files(TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, o := range openers {
+		r, err := o.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, o.Name+"="+string(b))
+	}
+	if strings.Join(contents, ",") != "tbl1=1\n3\n,tbl2=2\n" {
+		t.Fatalf("Expected the tables from the bases, got %q", contents)
+	}
+
+	// without its bases, the dump is missing the tables
+	if _, err := m.Reader(&DirReader{BaseDir: path.Join(tmpdir, "inc2")}); !errors.Is(err, ErrCorruptDump) {
+		t.Fatalf("Expected ErrCorruptDump, got %v", err)
+	}
+}
+
+func TestTouchedTables(t *testing.T) {
+	content := `CREATE TABLE IF NOT EXISTS public.orders (id serial);
+ALTER TABLE ONLY "Users" ADD COLUMN name text;
+INSERT INTO countries (code) VALUES ('de');
+WITH old AS (SELECT id FROM sessions) DELETE FROM tokens USING old WHERE tokens.id = old.id;
+UPDATE orders SET total = 0;
+TRUNCATE TABLE logs, ONLY audit;
+SELECT * FROM ignored;
+CREATE INDEX users_name_idx ON users (name);`
+
+	tables := TouchedTables([]byte(content))
+	if strings.Join(tables, ",") != "orders,Users,countries,tokens,logs,audit" {
+		t.Fatalf("Unexpected tables %v", tables)
+	}
+}
+
+func TestMajorDependencies(t *testing.T) {
+	V2 = true
+
+	root, cleanFn, err := makeFiles("TestMajorDependencies")
+	defer cleanFn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, major := range []string{"001", "002", "003"} {
+		if err := os.Mkdir(path.Join(root, major), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 001 and 002 only depend on 000. 003 has no depends file.
+	ioutil.WriteFile(path.Join(root, "001", DependsFile), []byte("# shared\n0\n"), 0644)
+	ioutil.WriteFile(path.Join(root, "002", DependsFile), []byte("0\n"), 0644)
+
+	deps, err := ReadMajorDependencies(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var migrations Migrations
+	for _, v := range []Version{
+		NewVersion2(0, 1), NewVersion2(1, 1), NewVersion2(1, 2),
+		NewVersion2(2, 1), NewVersion2(3, 1),
+	} {
+		migrations = append(migrations, MigrationFile{Version: v}.Migration(direction.Up))
+	}
+
+	waves := deps.Waves(migrations)
+	expect := [][]uint64{{0}, {1, 2}, {3}}
+	if len(waves) != len(expect) {
+		t.Fatalf("Expected %d waves, got %d", len(expect), len(waves))
+	}
+	for i, wave := range waves {
+		if len(wave) != len(expect[i]) {
+			t.Fatalf("Expected wave %d to have %d major versions, got %d", i, len(expect[i]), len(wave))
+		}
+		for j, major := range expect[i] {
+			if wave[j][0].Major() != major {
+				t.Errorf("Expected wave %d to have major version %d, got %d", i, major, wave[j][0].Major())
+			}
+		}
+	}
+	if len(waves[1][0]) != 2 {
+		t.Errorf("Expected major version 1 to have 2 migrations, got %d", len(waves[1][0]))
+	}
+
+	// invalid depends file
+	ioutil.WriteFile(path.Join(root, "003", DependsFile), []byte("abc\n"), 0644)
+	if _, err := ReadMajorDependencies(root); err == nil {
+		t.Fatal("Expected invalid major version error")
+	}
+}
+
+func TestRequires(t *testing.T) {
+	V2 = true
+
+	content := []byte("-- add posts\n-- Requires: 000/0005, 001/0002 000/0001\nCREATE TABLE posts (id INT);\n")
+	versions, err := ParseRequires(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"000/0005", "001/0002", "000/0001"}
+	if len(versions) != len(expect) {
+		t.Fatalf("Expected %d versions, got %v", len(expect), versions)
+	}
+	for i, v := range versions {
+		if v.String() != expect[i] {
+			t.Errorf("Expected %s, got %v", expect[i], v)
+		}
+	}
+	if _, err := ParseRequires([]byte("-- requires: 5\n")); err == nil {
+		t.Fatal("Expected invalid version error")
+	}
+
+	// 002 depends on 000, and its requirement adds 001
+	deps := MajorDependencies{2: {0}}
+	up := &File{FileName: "0001_posts.up.sql", Content: []byte("-- requires: 001/0002\n")}
+	migrations := Migrations{MigrationFile{Version: NewVersion2(2, 1), UpFile: up}.Migration(direction.Up)}
+	if err := deps.AddRequires(migrations); err != nil {
+		t.Fatal(err)
+	}
+	if !deps.DependsOn(2, 1) || !deps.DependsOn(2, 0) {
+		t.Fatalf("Expected 002 to depend on 000 and 001, got %v", deps[2])
+	}
+	up.Content = []byte("-- requires: 003/0001\n")
+	if err := deps.AddRequires(migrations); err == nil {
+		t.Fatal("Expected error for a requirement in a later major version")
+	}
+}
+
+func TestMissingVersionBaseline(t *testing.T) {
+	V2 = true
+
+	files := MigrationFiles{
+		{Version: NewVersion2(0, 600), UpFile: &File{Name: BaselineName}},
+		{Version: NewVersion2(0, 601), UpFile: &File{Name: "add_posts"}},
+	}
+	if missing := files.MissingVersion(); missing != nil {
+		t.Fatalf("Expected a baseline to start the versions, got missing %v", missing)
+	}
+	files[0].UpFile.Name = "users"
+	if missing := files.MissingVersion(); missing == nil || missing.String() != "000/0001" {
+		t.Fatalf("Expected missing version 000/0001, got %v", missing)
+	}
+}
+
+func TestRepeatableFiles(t *testing.T) {
+	V2 = true
+
+	var openers Openers
+	for _, name := range []string{"R_views.sql", "R__functions.sql", "R_.sql", "000/R_nested.sql", "000/0001_users.up.sql", "R_notes.txt"} {
+		openers = append(openers, Opener{Name: name})
+	}
+	files, err := GetRepeatableFiles(openers, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Name != "functions" || files[1].Name != "views" {
+		t.Fatalf("Expected the functions and views repeatable files, got %v", files)
+	}
+	if !(ParseOptions{}).ignored("R_views.sql") || (ParseOptions{}).ignored("000/R_nested.sql") {
+		t.Fatal("Expected only repeatable files in the root to be ignored as versioned files")
+	}
+
+	openers = append(openers, Opener{Name: "R__views.sql"})
+	if _, err := GetRepeatableFiles(openers, "sql"); !errors.Is(err, ErrInvalidFiles) || !strings.Contains(err.Error(), "R_views.sql and R__views.sql") {
+		t.Fatalf("Expected an error for the duplicate views file, got %v", err)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	var openers Openers
+	for _, name := range []string{"_before_all.sql", "_after_each.sql", "_after_all.txt", "000/_after_all.sql", "000/0001_users.up.sql"} {
+		openers = append(openers, Opener{Name: name})
+	}
+	c, err := GetCallbacks(openers, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.BeforeAll == nil || c.BeforeAll.FileName != "_before_all.sql" || c.AfterEach == nil || c.AfterEach.Name != "after_each" {
+		t.Fatalf("Expected the before all and after each callbacks, got %+v", c)
+	}
+	if c.AfterAll != nil {
+		t.Fatalf("Expected no after all callback, got %v", c.AfterAll)
+	}
+	if !(ParseOptions{}).ignored("_after_each.sql") {
+		t.Fatal("Expected callback files to be ignored as versioned files")
+	}
+}
+
+func TestFindByName(t *testing.T) {
+	files := MigrationFiles{
+		{Version: NewVersion(1), UpFile: &File{Name: "users"}},
+		{Version: NewVersion(2), UpFile: &File{Name: "users_index"}},
+		{Version: NewVersion(3), UpFile: &File{Name: "users"}},
+	}
+	if found := files.FindByName("users_index"); len(found) != 1 || found[0].Compare(NewVersion(2)) != 0 {
+		t.Fatalf("Expected version 2, got %v", found)
+	}
+	if found := files.FindByName("users"); len(found) != 2 {
+		t.Fatalf("Expected 2 matches, got %d", len(found))
+	}
+	if found := files.FindByName("posts"); len(found) != 0 {
+		t.Fatalf("Expected no matches, got %d", len(found))
+	}
+}
+
+func TestSkippedFiles(t *testing.T) {
+	V2 = true
+
+	tmpdir, err := ioutil.TempDir("/tmp", "TestSkippedFiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	majorDir := NewVersion2(0, 0).MajorString()
+	os.MkdirAll(path.Join(tmpdir, majorDir), 0700)
+	os.MkdirAll(path.Join(tmpdir, ".templates"), 0700)
+	ioutil.WriteFile(path.Join(tmpdir, majorDir, "0001_users.up.sql"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, majorDir, "0001_users.down.sql"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, majorDir, "0002_add_users.upsql"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, DependsFile), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, ".templates", "create-table.up.sql"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, majorDir, "README.md"), nil, 0644)
+	ioutil.WriteFile(path.Join(tmpdir, majorDir, "NOTES.txt"), nil, 0644)
+
+	openers, err := (&DirReader{BaseDir: tmpdir}).Files("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warned []string
+	opts := ParseOptions{Warn: func(name string, err error) {
+		if errors.Is(err, ErrNamesDiffer) {
+			t.Errorf("Expected a parse error for %s, got %v", name, err)
+		}
+		warned = append(warned, name)
+	}}
+
+	files, err := GetMigrationFiles(openers, "sql", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected 1 migration file, got %d", len(files))
+	}
+	if len(warned) != 2 || warned[0] != majorDir+"/0002_add_users.upsql" || warned[1] != majorDir+"/NOTES.txt" {
+		t.Fatalf("Expected warnings for the misspelled and notes files, got %v", warned)
+	}
+
+	warned = nil
+	opts.Ignore = []string{"NOTES*"}
+	if _, err := GetMigrationFiles(openers, "sql", opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(warned) != 1 || warned[0] != majorDir+"/0002_add_users.upsql" {
+		t.Fatalf("Expected the notes file to be ignored, got %v", warned)
+	}
+
+	opts.Strict = true
+	_, err = GetMigrationFiles(openers, "sql", opts)
+	if err == nil || !strings.Contains(err.Error(), "0002_add_users.upsql") {
+		t.Fatalf("Expected an error listing the skipped file, got %v", err)
+	}
+}
+
+func TestValidateBaseFilesChecksum(t *testing.T) {
+	content := []byte("CREATE TABLE users ();")
+	opened := false
+	prev := MigrationFiles{{
+		Version:    NewVersion(1),
+		UpChecksum: Checksum(content),
+		UpFile: &File{Open: func() (io.ReadCloser, error) {
+			opened = true
+			return nil, errors.New("Stored content shouldn't be read")
+		}},
+	}}
+	files := MigrationFiles{{Version: NewVersion(1), UpFile: &File{Content: content}}}
+	if err := files.ValidateBaseFiles(prev); err != nil {
+		t.Fatal(err)
+	}
+	if opened {
+		t.Fatal("Expected the checksum to be compared instead of the stored content")
+	}
+
+	files[0].UpFile.Content = []byte("CREATE TABLE users (id INT);")
+	if err := files.ValidateBaseFiles(prev); !errors.Is(err, ErrBaseFilesDiffer) {
+		t.Fatalf("Expected ErrBaseFilesDiffer, got %v", err)
+	}
+}
+
+func TestValidateBaseFilesConflict(t *testing.T) {
+	prev := MigrationFiles{{
+		Version:    NewVersion(1),
+		UpChecksum: Checksum([]byte("CREATE TABLE users ();")),
+		UpFile:     &File{Name: "users", FileName: "0001_users.up.sql"},
+	}}
+	files := MigrationFiles{{Version: NewVersion(1), UpFile: &File{Name: "posts", FileName: "0001_posts.up.sql", Content: []byte("CREATE TABLE posts ();")}}}
+	err := files.ValidateBaseFiles(prev)
+	if !errors.Is(err, ErrVersionConflict) || !strings.Contains(err.Error(), "0001_users.up.sql") || !strings.Contains(err.Error(), "0001_posts.up.sql") {
+		t.Fatalf("Expected ErrVersionConflict naming both files, got %v", err)
+	}
+
+	// versions recorded before names were stored only differ
+	prev[0].UpFile.Name = "-"
+	if err := files.ValidateBaseFiles(prev); !errors.Is(err, ErrBaseFilesDiffer) {
+		t.Fatalf("Expected ErrBaseFilesDiffer, got %v", err)
+	}
+}
+
+func TestValidateBaseFilesOutOfOrder(t *testing.T) {
+	V2 = true
+
+	content := []byte("CREATE TABLE users ();")
+	mf := func(major, minor uint64, name string) MigrationFile {
+		return MigrationFile{Version: NewVersion2(major, minor), UpFile: &File{FileName: name, Content: content}}
+	}
+	prev := MigrationFiles{mf(0, 1, "0001_users.up.sql"), mf(1, 1, "0001_posts.up.sql")}
+	files := MigrationFiles{mf(0, 1, "0001_users.up.sql"), mf(0, 2, "0002_merged_late.up.sql"), mf(1, 1, "0001_posts.up.sql")}
+
+	if outOfOrder := files.OutOfOrder(prev); len(outOfOrder) != 1 || outOfOrder[0].Compare(NewVersion2(0, 2)) != 0 {
+		t.Fatalf("Expected 000/0002 to be out of order, got %v", outOfOrder)
+	}
+	err := files.ValidateBaseFiles(prev)
+	if !errors.Is(err, ErrOutOfOrder) || !strings.Contains(err.Error(), "0002_merged_late.up.sql") {
+		t.Fatalf("Expected ErrOutOfOrder naming the file, got %v", err)
+	}
+	if err := files.ValidateBaseFilesOutOfOrder(prev); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateDown(t *testing.T) {
+	up := `BEGIN;
+CREATE TABLE IF NOT EXISTS users (id serial PRIMARY KEY, name text);
+-- a comment
+CREATE UNIQUE INDEX CONCURRENTLY users_name_idx ON users (name);
+ALTER TABLE "app".users ADD COLUMN email text DEFAULT 'a,b', ADD CONSTRAINT users_email_key UNIQUE (email);
+CREATE INDEX ON users (email);
+UPDATE users SET name = 'x';
+CREATE OR REPLACE VIEW v AS SELECT 1;
+CREATE MATERIALIZED VIEW mv AS SELECT 1;
+COMMIT;`
+	expected := `-- TODO: irreversible: CREATE INDEX ON users (email)
+-- TODO: irreversible: UPDATE users SET name = 'x'
+-- TODO: irreversible: CREATE OR REPLACE VIEW v AS SELECT 1
+
+DROP MATERIALIZED VIEW IF EXISTS mv;
+
+ALTER TABLE "app".users DROP CONSTRAINT IF EXISTS users_email_key, DROP COLUMN IF EXISTS email;
+
+DROP INDEX IF EXISTS users_name_idx;
+
+DROP TABLE IF EXISTS users;
+`
+	down, irreversible := GenerateDown([]byte(up))
+	if string(down) != expected {
+		t.Errorf("Expected down file:\n%s\ngot:\n%s", expected, down)
+	}
+	if len(irreversible) != 3 {
+		t.Errorf("Expected 3 irreversible statements, got %d", len(irreversible))
+	}
+
+	for _, stmt := range []string{
+		"ALTER TABLE t ADD PRIMARY KEY (id)",
+		"ALTER TABLE t ADD COLUMN a int, DROP COLUMN b",
+		"DROP TABLE t",
+	} {
+		if inverse, ok := invertStatement(stmt); ok {
+			t.Errorf("Expected %q to be irreversible, got %q", stmt, inverse)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	content := `CREATE TABLE orders (id serial PRIMARY KEY, total int);
+CREATE INDEX orders_total_idx ON orders (total);
+ALTER TABLE orders ALTER COLUMN total TYPE bigint;
+
+ALTER TABLE users ALTER COLUMN name SET DATA TYPE text;
+CREATE INDEX users_name_idx ON users (name);
+CREATE INDEX CONCURRENTLY users_email_idx ON users (email);
+DROP TABLE legacy;
+DROP TABLE IF EXISTS legacy2;
+ALTER TABLE users ADD COLUMN token uuid DEFAULT gen_random_uuid(), ALTER COLUMN x SET DEFAULT random();
+ALTER TABLE users ADD COLUMN created_at timestamptz DEFAULT now();`
+
+	findings := Lint("1_a.up.sql", []byte(content), LintOptions{})
+	expected := []struct {
+		line int
+		rule string
+	}{
+		{5, "alter-column-type"},
+		{5, "missing-lock-timeout"},
+		{6, "create-index-not-concurrently"},
+		{8, "drop-table-without-if-exists"},
+		{10, "volatile-default"},
+	}
+	if len(findings) != len(expected) {
+		t.Fatalf("Expected %d findings, got %v", len(expected), findings)
+	}
+	for i, e := range expected {
+		if findings[i].Line != e.line || findings[i].Rule != e.rule {
+			t.Errorf("Expected %s on line %d, got %v", e.rule, e.line, findings[i])
+		}
+	}
+
+	// severities are configurable and a lock timeout satisfies the rule
+	severities, err := ParseSeverities("alter-column-type=off, create-index-not-concurrently=error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings = Lint("2_b.up.sql", []byte("SET lock_timeout = '5s';\nALTER TABLE users ALTER COLUMN a TYPE text;\nCREATE INDEX i ON users (a);"),
+		LintOptions{Severities: severities})
+	if len(findings) != 1 || findings[0].Rule != "create-index-not-concurrently" || findings[0].Severity != SeverityError {
+		t.Errorf("Unexpected findings %v", findings)
+	}
+
+	// volatile defaults of small tables are fine
+	findings = Lint("3_c.up.sql", []byte("SET lock_timeout = '5s'; ALTER TABLE users ADD COLUMN a uuid DEFAULT gen_random_uuid();"), LintOptions{
+		TableRows:    func(table string) (float64, bool) { return 10, table == "users" },
+		BigTableRows: 1000,
+	})
+	if len(findings) != 0 {
+		t.Errorf("Unexpected findings %v", findings)
+	}
+
+	if _, err := ParseSeverities("no-such-rule=off"); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+	if _, err := ParseSeverities("alter-column-type=fatal"); err == nil {
+		t.Error("Expected an error for an unknown severity")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	content := `-- create the table; not a statement
+CREATE TABLE t (s TEXT DEFAULT 'a;b', "c;d" INT);
+/* a /* nested; */ comment */
+CREATE FUNCTION f() RETURNS INT AS $body$ SELECT 1; $body$ LANGUAGE sql;
+INSERT INTO t (s) VALUES (E'it\'s;'), ($$;$$);
+SELECT $1;
+
+-- trailing comment`
+	statements := SplitStatements([]byte(content))
+	expected := []string{
+		`-- create the table; not a statement
+CREATE TABLE t (s TEXT DEFAULT 'a;b', "c;d" INT)`,
+		`/* a /* nested; */ comment */
+CREATE FUNCTION f() RETURNS INT AS $body$ SELECT 1; $body$ LANGUAGE sql`,
+		`INSERT INTO t (s) VALUES (E'it\'s;'), ($$;$$)`,
+		`SELECT $1`,
+	}
+	if len(statements) != len(expected) {
+		t.Fatalf("Expected %d statements, got %d: %v", len(expected), len(statements), statements)
+	}
+	for i, s := range statements {
+		if s.SQL != expected[i] {
+			t.Errorf("Expected statement %d to be %q, got %q", i, expected[i], s.SQL)
+		}
+		if content[s.Offset:s.Offset+len(s.SQL)] != s.SQL {
+			t.Errorf("Expected statement %d at offset %d", i, s.Offset)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// gcsMaxCompose is the most sources of a compose
+const gcsMaxCompose = 32
+
+// gcsPartsDir is the dir below the prefix the parts of the files are uploaded to, so
+// the parts an interrupted upload leaves behind aren't read as files of the dump
+const gcsPartsDir = ".parts"
+
+// GCSConfig is the location of a dump below a prefix of a Google Cloud Storage bucket. The
+// dump is read and written with the gcloud cli, so its credentials are used, e.g. the account
+// of 'gcloud auth login' or CLOUDSDK_AUTH_ACCESS_TOKEN_FILE. The files are stored in the same
+// layout as DirWriter's below the prefix.
+type GCSConfig struct {
+	Bucket string
+	Prefix string
+	// ImpersonateServiceAccount is passed to the cli's --impersonate-service-account if it's set
+	ImpersonateServiceAccount string
+	// Command defaults to gcloud
+	Command string
+}
+
+// GCSWriter writes a dump to GCS. Each file is streamed to the cli, so files aren't
+// buffered on disk.
+type GCSWriter struct {
+	GCSConfig
+	// PartSize, if set, uploads the files in parts of PartSize bytes below .parts/, which
+	// are composed into the file when it's closed. A failed upload of a big table then
+	// only loses the part, instead of the whole stream.
+	PartSize int64
+}
+
+// Writer uploads the file below the prefix
+func (g *GCSWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	url := g.url(dir, name)
+	if g.PartSize <= 0 {
+		return startWriter(nil, g.command(), g.args("storage", "cp", "-", url)...)
+	}
+	return &gcsPartWriter{g: g, url: url, partURL: g.url(path.Join(gcsPartsDir, dir), name)}, nil
+}
+
+// Close does nothing, the files are uploaded when their writers are closed
+func (g *GCSWriter) Close() error {
+	return nil
+}
+
+// gcsPartWriter uploads a file in parts
+type gcsPartWriter struct {
+	g       *GCSWriter
+	url     string
+	partURL string // the url of the parts, without their number
+	cur     io.WriteCloser
+	n       int64 // bytes written to cur
+	parts   []string
+}
+
+func (w *gcsPartWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		if w.cur == nil {
+			if err := w.startPart(); err != nil {
+				return written, err
+			}
+		}
+		size := w.g.PartSize - w.n
+		if size > int64(len(b)) {
+			size = int64(len(b))
+		}
+		n, err := w.cur.Write(b[:size])
+		written += n
+		w.n += int64(n)
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+		if w.n >= w.g.PartSize {
+			cur := w.cur
+			w.cur = nil
+			if err := cur.Close(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// startPart starts the upload of the next part
+func (w *gcsPartWriter) startPart() (err error) {
+	part := fmt.Sprintf("%s.part-%04d", w.partURL, len(w.parts))
+	if w.cur, err = startWriter(nil, w.g.command(), w.g.args("storage", "cp", "-", part)...); err != nil {
+		return
+	}
+	w.n = 0
+	w.parts = append(w.parts, part)
+	return
+}
+
+// Close composes the parts into the file and removes them
+func (w *gcsPartWriter) Close() error {
+	if w.cur != nil {
+		err := w.cur.Close()
+		w.cur = nil
+		if err != nil {
+			return err
+		}
+	}
+	if len(w.parts) == 0 {
+		// an empty file
+		if err := w.startPart(); err != nil {
+			return err
+		}
+		if err := w.cur.Close(); err != nil {
+			return err
+		}
+		w.cur = nil
+	}
+	// the file is composed from up to 32 sources at a time, starting with itself after the first
+	for i := 0; i < len(w.parts); {
+		var sources []string
+		if i > 0 {
+			sources = append(sources, w.url)
+		}
+		for ; i < len(w.parts) && len(sources) < gcsMaxCompose; i++ {
+			sources = append(sources, w.parts[i])
+		}
+		args := append([]string{"storage", "objects", "compose"}, sources...)
+		if _, err := runCommand(nil, w.g.command(), w.g.args(append(args, w.url)...)...); err != nil {
+			return err
+		}
+	}
+	_, err := runCommand(nil, w.g.command(), w.g.args(append([]string{"storage", "rm"}, w.parts...)...)...)
+	return err
+}
+
+// GCSReader reads a dump from GCS. The files are streamed from the cli.
+type GCSReader struct {
+	GCSConfig
+}
+
+// Files lists the files below the prefix's dir
+func (g *GCSReader) Files(dir string) (Openers, error) {
+	base := "gs://" + g.Bucket + "/"
+	prefix := cloudPrefix(g.Prefix) + dir
+	cmd := newCommand(nil, g.command(), g.args("storage", "ls", base+prefix+"**")...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// an empty prefix isn't an error of the dump
+		if strings.Contains(stderr.String(), "matched no objects") {
+			return Openers{}, nil
+		}
+		return nil, commandError(cmd, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+
+	var openers Openers
+	for _, line := range strings.Split(string(out), "\n") {
+		url := strings.TrimSpace(line)
+		name := strings.TrimPrefix(strings.TrimPrefix(url, base+prefix), "/")
+		if name == "" || strings.HasSuffix(name, "/") || strings.HasPrefix(name, gcsPartsDir+"/") {
+			continue
+		}
+		openers = append(openers, Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) {
+				return startReader(nil, g.command(), g.args("storage", "cat", url)...)
+			},
+		})
+	}
+	return openers, nil
+}
+
+func (c GCSConfig) url(dir, name string) string {
+	return "gs://" + c.Bucket + "/" + cloudPrefix(c.Prefix) + path.Join(dir, name)
+}
+
+func (c GCSConfig) command() string {
+	if c.Command == "" {
+		return "gcloud"
+	}
+	return c.Command
+}
+
+// args appends the global options to the cli's args
+func (c GCSConfig) args(args ...string) []string {
+	if c.ImpersonateServiceAccount != "" {
+		args = append(args, "--impersonate-service-account", c.ImpersonateServiceAccount)
+	}
+	return args
+}
@@ -0,0 +1,117 @@
+package file
+
+import (
+	"errors"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrSkipFile is returned by a DumpWriter's Writer when the file doesn't need to be written,
+// e.g. a table that didn't change since the base of an incremental dump
+var ErrSkipFile = errors.New("File is skipped")
+
+// incrementalReader reads the files of an incremental dump, and the files it reuses from
+// the dumps of their manifest entries' Base
+type incrementalReader struct {
+	dr    DumpReader
+	files []ManifestEntry // the files from bases
+	bases map[string]DumpReader
+	// openers are the openers of the bases by location and path
+	openers map[string]map[string]Opener
+}
+
+// NewIncrementalReader returns a reader of the incremental dump that also has the files it
+// reuses from its bases, which are opened by their location with open. Dumps that aren't
+// incremental are returned as is. Close closes the bases and the dump if they're io.Closers.
+func NewIncrementalReader(dr DumpReader, open func(location string) (DumpReader, error)) (DumpReader, error) {
+	m, err := ReadManifest(dr)
+	if err != nil || m == nil {
+		return dr, err
+	}
+	r := &incrementalReader{dr: dr, bases: map[string]DumpReader{}, openers: map[string]map[string]Opener{}}
+	for _, e := range m.Files {
+		if e.Base == "" {
+			continue
+		}
+		if _, ok := r.bases[e.Base]; !ok {
+			base, err := open(e.Base)
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			r.bases[e.Base] = base
+		}
+		r.files = append(r.files, e)
+	}
+	if len(r.files) == 0 {
+		return dr, nil
+	}
+	return r, nil
+}
+
+// Files returns the dump's files below dir and the ones from its bases, sorted by name
+func (r *incrementalReader) Files(dir string) (Openers, error) {
+	openers, err := r.dr.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range r.files {
+		if dir != "" && !strings.HasPrefix(e.Path, dir) {
+			continue
+		}
+		o, err := r.open(e)
+		if err != nil {
+			return nil, err
+		}
+		name, err := filepath.Rel(dir, e.Path)
+		if err != nil {
+			return nil, err
+		}
+		openers = append(openers, Opener{Name: name, Open: o.Open})
+	}
+	sort.Slice(openers, func(i, j int) bool { return openers[i].Name < openers[j].Name })
+	return openers, nil
+}
+
+// open returns the opener of the file in its base
+func (r *incrementalReader) open(e ManifestEntry) (Opener, error) {
+	openers, ok := r.openers[e.Base]
+	if !ok {
+		// the root lists all the files of the base
+		list, err := r.bases[e.Base].Files("")
+		if err != nil {
+			return Opener{}, err
+		}
+		openers = make(map[string]Opener, len(list))
+		for _, o := range list {
+			openers[filepath.ToSlash(o.Name)] = o
+		}
+		r.openers[e.Base] = openers
+	}
+	o, ok := openers[path.Clean(e.Path)]
+	if !ok {
+		return Opener{}, errors.New("Missing " + e.Path + " in the base dump " + e.Base)
+	}
+	return o, nil
+}
+
+// Close closes the bases and the dump
+func (r *incrementalReader) Close() error {
+	var err error
+	for _, base := range r.bases {
+		if c, ok := base.(io.Closer); ok {
+			if cerr := c.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	if c, ok := r.dr.(io.Closer); ok {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,322 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Severity of a lint rule's findings
+type Severity string
+
+// Severities
+const (
+	SeverityOff     Severity = "off"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// LintRule is a check of a migration file's statements
+type LintRule struct {
+	Name        string
+	Description string
+	// Severity is the default severity, overridden by LintOptions.Severities
+	Severity Severity
+	check    func(l *linter, t *tokens) string
+}
+
+// LintRules are the rules of Lint
+var LintRules = []LintRule{
+	{"alter-column-type", "ALTER COLUMN TYPE rewrites the table and its indexes under an exclusive lock",
+		SeverityError, (*linter).alterColumnType},
+	{"create-index-not-concurrently", "CREATE INDEX without CONCURRENTLY blocks writes to the table until it's built",
+		SeverityWarning, (*linter).createIndex},
+	{"drop-table-without-if-exists", "DROP TABLE without IF EXISTS fails if the table was already dropped",
+		SeverityWarning, (*linter).dropTable},
+	{"missing-lock-timeout", "Locking an existing table without SET lock_timeout first queues every query behind it",
+		SeverityWarning, (*linter).lockTimeout},
+	{"volatile-default", "ADD COLUMN with a volatile DEFAULT rewrites the table under an exclusive lock",
+		SeverityError, (*linter).volatileDefault},
+}
+
+// LintFinding is a statement that breaks a rule
+type LintFinding struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s (%s)", f.File, f.Line, f.Severity, f.Message, f.Rule)
+}
+
+// LintOptions configures Lint
+type LintOptions struct {
+	// Severities overrides the rules' severities by rule name
+	Severities map[string]Severity
+	// TableRows returns a table's estimated rows, false if it's unknown. When it's set,
+	// volatile defaults are only flagged on tables with at least BigTableRows rows.
+	TableRows    func(table string) (rows float64, ok bool)
+	BigTableRows float64
+}
+
+func (o LintOptions) severity(r LintRule) Severity {
+	if s, ok := o.Severities[r.Name]; ok {
+		return s
+	}
+	return r.Severity
+}
+
+// ParseSeverities parses rule=severity pairs separated by commas, e.g.
+// "missing-lock-timeout=off,drop-table-without-if-exists=error"
+func ParseSeverities(s string) (map[string]Severity, error) {
+	severities := map[string]Severity{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if !isLintRule(name) {
+			return nil, fmt.Errorf("Unknown lint rule '%s'", name)
+		}
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Missing severity of lint rule '%s'", name)
+		}
+		switch sev := Severity(strings.TrimSpace(kv[1])); sev {
+		case SeverityOff, SeverityWarning, SeverityError:
+			severities[name] = sev
+		default:
+			return nil, fmt.Errorf("Unknown severity '%s' of lint rule '%s', expected off, warning or error", sev, name)
+		}
+	}
+	return severities, nil
+}
+
+func isLintRule(name string) bool {
+	for _, r := range LintRules {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Lint checks the statements of the named file against LintRules. Tables created in the
+// same file are new, so statements on them aren't flagged.
+func Lint(name string, content []byte, opts LintOptions) (findings []LintFinding) {
+	l := &linter{opts: opts, created: map[string]bool{}}
+	for _, stmt := range SplitStatements(content) {
+		for _, r := range LintRules {
+			sev := opts.severity(r)
+			if sev == SeverityOff {
+				continue
+			}
+			if msg := r.check(l, &tokens{tokens: tokenize(stmt.SQL)}); msg != "" {
+				findings = append(findings, LintFinding{
+					File:     name,
+					Line:     statementLine(content, stmt),
+					Rule:     r.Name,
+					Severity: sev,
+					Message:  msg,
+				})
+			}
+		}
+		l.record(&tokens{tokens: tokenize(stmt.SQL)})
+	}
+	return
+}
+
+// statementLine is the line of the statement's first token
+func statementLine(content []byte, stmt Statement) int {
+	offset := stmt.Offset + len(stmt.SQL) - len(strings.TrimLeft(stmt.SQL, " \t\r\n"))
+	if offset > len(content) {
+		offset = len(content)
+	}
+	return bytes.Count(content[:offset], []byte("\n")) + 1
+}
+
+// linter is the state of a file's statements before the current one
+type linter struct {
+	opts LintOptions
+	// created are the tables created in the file
+	created map[string]bool
+	// lockTimeoutSet is set after SET lock_timeout
+	lockTimeoutSet bool
+	// lockFlagged is set after the first missing lock timeout, which is only flagged once
+	lockFlagged bool
+}
+
+// record remembers the created tables and the lock timeout
+func (l *linter) record(t *tokens) {
+	switch {
+	case t.keyword("CREATE"):
+		t.keyword("TEMP")
+		t.keyword("TEMPORARY")
+		t.keyword("UNLOGGED")
+		if t.keyword("TABLE") {
+			t.keywords("IF", "NOT", "EXISTS")
+			if name, ok := t.name(); ok {
+				l.created[tableKey(name)] = true
+			}
+		}
+	case t.keyword("SET"):
+		t.keyword("LOCAL")
+		if t.keyword("lock_timeout") {
+			l.lockTimeoutSet = true
+		}
+	}
+}
+
+// tableKey is the table's name without the schema, lowercase unless it's quoted
+func tableKey(name string) string {
+	b := []byte(name)
+	start := 0
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '"':
+			i = skipQuoted(b, i, false)
+		case '.':
+			start = i + 1
+		}
+	}
+	part := name[start:]
+	if strings.HasPrefix(part, `"`) {
+		return strings.Replace(strings.Trim(part, `"`), `""`, `"`, -1)
+	}
+	return strings.ToLower(part)
+}
+
+// alteredTable returns the table of an ALTER TABLE statement, if it wasn't created in the file
+func (l *linter) alteredTable(t *tokens) (string, bool) {
+	if !t.keywords("ALTER", "TABLE") {
+		return "", false
+	}
+	t.keywords("IF", "EXISTS")
+	t.keyword("ONLY")
+	name, ok := t.name()
+	if !ok || l.created[tableKey(name)] {
+		return "", false
+	}
+	return name, true
+}
+
+func (l *linter) alterColumnType(t *tokens) string {
+	table, ok := l.alteredTable(t)
+	if !ok {
+		return ""
+	}
+	for ; t.pos < len(t.tokens); t.pos++ {
+		if !t.keyword("ALTER") {
+			continue
+		}
+		t.keyword("COLUMN")
+		column, ok := t.name()
+		if !ok {
+			continue
+		}
+		t.keywords("SET", "DATA")
+		if t.keyword("TYPE") {
+			return fmt.Sprintf("Changing the type of %s.%s rewrites the table; add a new column and backfill it instead", table, column)
+		}
+	}
+	return ""
+}
+
+// indexedTable returns the table of a CREATE INDEX statement without CONCURRENTLY, if it
+// wasn't created in the file
+func (l *linter) indexedTable(t *tokens) (string, bool) {
+	if !t.keyword("CREATE") {
+		return "", false
+	}
+	t.keyword("UNIQUE")
+	if !t.keyword("INDEX") || t.keyword("CONCURRENTLY") {
+		return "", false
+	}
+	for t.pos < len(t.tokens) && !t.keyword("ON") {
+		t.pos++
+	}
+	t.keyword("ONLY")
+	table, ok := t.name()
+	if !ok || l.created[tableKey(table)] {
+		return "", false
+	}
+	return table, true
+}
+
+func (l *linter) createIndex(t *tokens) string {
+	table, ok := l.indexedTable(t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("Creating an index on %s blocks its writes until it's built; create it while the table is small or during a maintenance window", table)
+}
+
+func (l *linter) dropTable(t *tokens) string {
+	if !t.keywords("DROP", "TABLE") || t.keywords("IF", "EXISTS") {
+		return ""
+	}
+	name, _ := t.name()
+	return fmt.Sprintf("DROP TABLE %s fails if it doesn't exist; use DROP TABLE IF EXISTS", name)
+}
+
+func (l *linter) lockTimeout(t *tokens) string {
+	if l.lockTimeoutSet || l.lockFlagged {
+		return ""
+	}
+	table, ok := l.alteredTable(t)
+	if !ok {
+		t.pos = 0
+		if table, ok = l.indexedTable(t); !ok {
+			return ""
+		}
+	}
+	l.lockFlagged = true
+	return fmt.Sprintf("Locking %s waits behind long queries and blocks the queries after it; SET lock_timeout first", table)
+}
+
+// volatileFuncs are the common volatile functions of defaults
+var volatileFuncs = map[string]bool{
+	"random": true, "gen_random_uuid": true, "uuid_generate_v1": true, "uuid_generate_v4": true,
+	"clock_timestamp": true, "timeofday": true, "nextval": true,
+}
+
+func (l *linter) volatileDefault(t *tokens) string {
+	table, ok := l.alteredTable(t)
+	if !ok {
+		return ""
+	}
+	if l.opts.TableRows != nil {
+		if rows, ok := l.opts.TableRows(tableKey(table)); ok && rows < l.opts.BigTableRows {
+			return ""
+		}
+	}
+	// the DEFAULTs of ADD actions, up to the next action
+	adding, depth := false, 0
+	for t.pos < len(t.tokens) {
+		switch tok := t.peek(); {
+		case tok == "(":
+			depth++
+		case tok == ")":
+			depth--
+		case tok == "," && depth == 0:
+			adding = false
+		case depth == 0 && strings.EqualFold(tok, "ADD"):
+			adding = true
+		}
+		if !adding || !t.keyword("DEFAULT") {
+			t.pos++
+			continue
+		}
+		name, ok := t.name()
+		if i := strings.LastIndexByte(name, '.'); i >= 0 {
+			name = name[i+1:]
+		}
+		if ok && t.peek() == "(" && volatileFuncs[strings.ToLower(name)] {
+			return fmt.Sprintf("The volatile default %s() of a new column rewrites %s; add the column without it, then set the default and backfill", name, table)
+		}
+	}
+	return ""
+}
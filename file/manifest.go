@@ -0,0 +1,296 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DumpManifestFile is the name of the file in the root of a dump that holds its Manifest
+const DumpManifestFile = "MANIFEST.json"
+
+// ErrCorruptDump is returned when a dump doesn't match its manifest
+var ErrCorruptDump = errors.New("Dump doesn't match its manifest")
+
+// Manifest describes the files of a dump, so a restore can verify them
+type Manifest struct {
+	SchemaVersion string    `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	ToolVersion   string    `json:"tool_version,omitempty"`
+	// Tables are the names of the dumped tables
+	Tables []string        `json:"tables"`
+	Files  []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is a file of the dump
+type ManifestEntry struct {
+	// Path is the file's dir and name, e.g. tables/users
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Rows is the number of lines of a table's data, which are its rows in COPY's text format
+	Rows int64 `json:"rows,omitempty"`
+	// ContentHash is the driver's hash of a table's rows, which incremental dumps compare to
+	// tell if the table changed
+	ContentHash string `json:"content_hash,omitempty"`
+	// Base is the location of the dump that has the file, if it's a table that didn't change
+	// since the base of an incremental dump, see NewIncrementalReader
+	Base string `json:"base,omitempty"`
+}
+
+// sameContents returns true if the files have the same size, rows and checksum
+func (e ManifestEntry) sameContents(o ManifestEntry) bool {
+	return e.Size == o.Size && e.Rows == o.Rows && e.SHA256 == o.SHA256
+}
+
+// ManifestWriter records the files written to the DumpWriter for the manifest
+type ManifestWriter struct {
+	DumpWriter
+	mu      sync.Mutex
+	entries []ManifestEntry
+	hashes  map[string]string
+}
+
+// NewManifestWriter returns a new ManifestWriter
+func NewManifestWriter(dw DumpWriter) *ManifestWriter {
+	return &ManifestWriter{DumpWriter: dw}
+}
+
+// Writer opens a writer of the file that records its checksum when it's closed
+func (w *ManifestWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	wc, err := w.DumpWriter.Writer(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	p := path.Join(dir, name)
+	return &checksumWriter{
+		WriteCloser: wc,
+		c:           newChecksum(strings.HasPrefix(p, TablesDir)),
+		onClose: func(c *checksum) {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.entries = append(w.entries, c.entry(p))
+		},
+	}, nil
+}
+
+// SetContentHash records the content hash of the file at path p, e.g. tables/users
+func (w *ManifestWriter) SetContentHash(p, hash string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.hashes == nil {
+		w.hashes = map[string]string{}
+	}
+	w.hashes[p] = hash
+}
+
+// Reuse records the entry of a base dump's file that isn't written, since it didn't change.
+// Its Base is the base's location, unless the base got it from its own base.
+func (w *ManifestWriter) Reuse(e ManifestEntry, base string) {
+	if e.Base == "" {
+		e.Base = base
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.entries = append(w.entries, e)
+}
+
+// WriteManifest writes the manifest of the files written so far to the root of the dump
+func (w *ManifestWriter) WriteManifest(m Manifest) error {
+	w.mu.Lock()
+	m.Files = append([]ManifestEntry(nil), w.entries...)
+	for i, e := range m.Files {
+		if hash, ok := w.hashes[e.Path]; ok {
+			m.Files[i].ContentHash = hash
+		}
+	}
+	w.mu.Unlock()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	m.Tables = nil
+	for _, e := range m.Files {
+		if strings.HasPrefix(e.Path, TablesDir) {
+			m.Tables = append(m.Tables, strings.TrimPrefix(e.Path, TablesDir))
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := w.DumpWriter.Writer("", DumpManifestFile)
+	if err != nil {
+		return err
+	}
+	if _, err = mw.Write(append(b, '\n')); err != nil {
+		mw.Close()
+		return err
+	}
+	return mw.Close()
+}
+
+// ReadManifest returns the manifest of the dump, or nil if it doesn't have one, e.g. it was
+// written before manifests existed, or it's a stream whose manifest is last
+func ReadManifest(dr DumpReader) (*Manifest, error) {
+	openers, err := dr.Files("")
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range openers {
+		if o.Name != DumpManifestFile {
+			continue
+		}
+		r, err := o.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		var m Manifest
+		if err := json.NewDecoder(r).Decode(&m); err != nil {
+			return nil, fmt.Errorf("%w: invalid manifest: %v", ErrCorruptDump, err)
+		}
+		return &m, nil
+	}
+	return nil, nil
+}
+
+// Reader checks that the dump has the manifest's files and no others, and wraps it so
+// each file returns an ErrCorruptDump at its end if it doesn't match its checksum
+func (m *Manifest) Reader(dr DumpReader) (DumpReader, error) {
+	files := make(map[string]ManifestEntry, len(m.Files))
+	for _, e := range m.Files {
+		files[e.Path] = e
+	}
+	openers, err := dr.Files("")
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, o := range openers {
+		p := filepath.ToSlash(o.Name)
+		if p == DumpManifestFile {
+			continue
+		}
+		if _, ok := files[p]; !ok {
+			return nil, fmt.Errorf("%w: %s isn't in the manifest", ErrCorruptDump, p)
+		}
+		found[p] = true
+	}
+	for _, e := range m.Files {
+		if !found[e.Path] && e.Base != "" {
+			return nil, fmt.Errorf("%w: %s is in the base dump %s, which isn't read", ErrCorruptDump, e.Path, e.Base)
+		}
+		if !found[e.Path] {
+			return nil, fmt.Errorf("%w: %s is missing", ErrCorruptDump, e.Path)
+		}
+	}
+	return &manifestReader{dr: dr, files: files}, nil
+}
+
+type manifestReader struct {
+	dr    DumpReader
+	files map[string]ManifestEntry
+}
+
+func (r *manifestReader) Files(dir string) (Openers, error) {
+	openers, err := r.dr.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	verified := make(Openers, 0, len(openers))
+	for _, o := range openers {
+		p := path.Join(dir, filepath.ToSlash(o.Name))
+		e, ok := r.files[p]
+		if !ok {
+			if p == DumpManifestFile {
+				verified = append(verified, o)
+				continue
+			}
+			return nil, fmt.Errorf("%w: %s isn't in the manifest", ErrCorruptDump, p)
+		}
+		open := o.Open
+		verified = append(verified, Opener{Name: o.Name, Open: func() (io.ReadCloser, error) {
+			rc, err := open()
+			if err != nil {
+				return nil, err
+			}
+			return &verifyingReader{ReadCloser: rc, c: newChecksum(strings.HasPrefix(p, TablesDir)), e: e}, nil
+		}})
+	}
+	return verified, nil
+}
+
+// checksum is the checksum, size and lines of a file
+type checksum struct {
+	h     hash.Hash
+	size  int64
+	rows  int64
+	table bool // rows are counted
+}
+
+func newChecksum(table bool) *checksum {
+	return &checksum{h: sha256.New(), table: table}
+}
+
+func (c *checksum) write(b []byte) {
+	c.h.Write(b)
+	c.size += int64(len(b))
+	if c.table {
+		c.rows += int64(bytes.Count(b, []byte("\n")))
+	}
+}
+
+func (c *checksum) entry(p string) ManifestEntry {
+	return ManifestEntry{Path: p, Size: c.size, SHA256: hex.EncodeToString(c.h.Sum(nil)), Rows: c.rows}
+}
+
+// checksumWriter computes the checksum of the written file
+type checksumWriter struct {
+	io.WriteCloser
+	c       *checksum
+	onClose func(*checksum)
+}
+
+func (w *checksumWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	w.c.write(b[:n])
+	return n, err
+}
+
+func (w *checksumWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		return err
+	}
+	w.onClose(w.c)
+	return nil
+}
+
+// verifyingReader returns an ErrCorruptDump instead of io.EOF if the file doesn't match
+// its manifest entry
+type verifyingReader struct {
+	io.ReadCloser
+	c *checksum
+	e ManifestEntry
+}
+
+func (r *verifyingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.c.write(b[:n])
+	if err == io.EOF {
+		if got := r.c.entry(r.e.Path); !got.sameContents(r.e) {
+			return n, fmt.Errorf("%w: %s has %d bytes and %d rows with sha256 %s, expected %d bytes and %d rows with sha256 %s",
+				ErrCorruptDump, r.e.Path, got.Size, got.Rows, got.SHA256, r.e.Size, r.e.Rows, r.e.SHA256)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package file
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// RepeatablePrefix starts the names of repeatable migration files in the root of the
+// migrations dir, e.g. R_views.sql. They're applied again whenever their checksum changes,
+// after the versioned migrations and in the order of their names. They have no version
+// and no down file, so they suit definitions that are replaced, like views and functions.
+const RepeatablePrefix = "R_"
+
+// RepeatableName returns the name of a repeatable migration file, e.g. views for R_views.sql
+// or R__views.sql. ok is false if it isn't a repeatable migration file.
+func RepeatableName(filename, filenameExtension string) (name string, ok bool) {
+	if strings.Contains(filename, "/") || !strings.HasPrefix(filename, RepeatablePrefix) ||
+		!strings.HasSuffix(filename, "."+filenameExtension) {
+		return "", false
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(filename, RepeatablePrefix), "."+filenameExtension)
+	name = strings.TrimLeft(name, "_")
+	return name, name != ""
+}
+
+// GetRepeatableFiles returns the repeatable migration files sorted by name.
+// Their Version is nil. Files with the same name, e.g. R_views.sql and R__views.sql,
+// are an ErrInvalidFiles error.
+func GetRepeatableFiles(openers Openers, filenameExtension string) (files Files, err error) {
+	fileNames := make(map[string]string)
+	var problems []string
+	for _, o := range openers {
+		name, ok := RepeatableName(o.Name, filenameExtension)
+		if !ok {
+			continue
+		}
+		if existing, ok := fileNames[name]; ok {
+			problems = append(problems, fmt.Sprintf("%s and %s: duplicate repeatable file %s", existing, o.Name, name))
+			continue
+		}
+		fileNames[name] = o.Name
+		files = append(files, &File{
+			Open:      o.Open,
+			FileName:  o.Name,
+			Name:      name,
+			Direction: direction.Up,
+		})
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w:\n  %s", ErrInvalidFiles, strings.Join(problems, "\n  "))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
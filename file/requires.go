@@ -0,0 +1,86 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// RequiresDirective starts a comment line of an upfile that lists the versions the
+// migration requires, separated by commas or spaces, e.g.
+//
+//	-- requires: 000/0005, 001/0002
+//
+// A required version must be applied before the migration, and can't be rolled back while
+// the migration is applied. A requirement in another major version adds that major
+// version to the depends file of the migration's major version, if it has one.
+const RequiresDirective = "-- requires:"
+
+// Requires returns the versions the file requires, see RequiresDirective
+func (f *File) Requires() ([]Version, error) {
+	if err := f.ReadContent(); err != nil {
+		return nil, err
+	}
+	versions, err := ParseRequires(f.Content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", f.FileName, err)
+	}
+	return versions, nil
+}
+
+// ParseRequires returns the versions listed in the RequiresDirective lines of the content
+func ParseRequires(content []byte) (versions []Version, err error) {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(strings.ToLower(line), RequiresDirective) {
+			continue
+		}
+		list := strings.FieldsFunc(line[len(RequiresDirective):], func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+		for _, s := range list {
+			v, err := ParseVersion(s)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid required version %q: %v", s, err)
+			}
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// AddRequires adds the major versions required by the up migrations to the depends of
+// their major versions that have a depends file. A requirement in a later major version
+// is an error, since the major versions are applied in order.
+func (d MajorDependencies) AddRequires(migrations Migrations) error {
+	for i := range migrations {
+		mg := &migrations[i]
+		majors, ok := d[mg.Major()]
+		if !mg.Up() || !ok {
+			continue
+		}
+		requires, err := mg.File().Requires()
+		if err != nil {
+			return err
+		}
+		for _, v := range requires {
+			switch {
+			case v.Major() > mg.Major():
+				return fmt.Errorf("Version %v requires %v of a later major version", mg.Version, v)
+			case v.Major() < mg.Major() && !containsMajor(majors, v.Major()):
+				majors = append(majors, v.Major())
+			}
+		}
+		d[mg.Major()] = majors
+	}
+	return nil
+}
+
+func containsMajor(majors []uint64, major uint64) bool {
+	for _, m := range majors {
+		if m == major {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package file
+
+import (
+	"encoding/json"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// S3Config is the location of a dump below a prefix of a S3 bucket. The dump is read and
+// written with the aws cli, so its credentials chain is used, e.g. the AWS_ACCESS_KEY_ID
+// and AWS_SECRET_ACCESS_KEY env vars, AWS_PROFILE or an instance role. The files are stored
+// in the same layout as DirWriter's below the prefix.
+type S3Config struct {
+	Bucket string
+	Prefix string
+	// Profile, Region and Endpoint are passed to the cli's --profile, --region and
+	// --endpoint-url if they're set, e.g. Endpoint for a S3 compatible storage
+	Profile  string
+	Region   string
+	Endpoint string
+	// Command defaults to aws
+	Command string
+}
+
+// S3Writer writes a dump to S3. Each file is streamed to the cli, which uploads it as a
+// multipart upload, so files aren't buffered on disk.
+type S3Writer struct {
+	S3Config
+	// ExpectedSize is passed to the cli's --expected-size if it's set. The cli uploads files
+	// of up to 10000 parts of 8 MB without it, so it's needed for tables over 80 GB.
+	ExpectedSize int64
+}
+
+// Writer uploads the file below the prefix
+func (s *S3Writer) Writer(dir, name string) (io.WriteCloser, error) {
+	args := []string{"s3", "cp", "-", s.url(dir, name)}
+	if s.ExpectedSize > 0 {
+		args = append(args, "--expected-size", strconv.FormatInt(s.ExpectedSize, 10))
+	}
+	return startWriter(nil, s.command(), s.args(args...)...)
+}
+
+// Close does nothing, the files are uploaded when their writers are closed
+func (s *S3Writer) Close() error {
+	return nil
+}
+
+// S3Reader reads a dump from S3. The files are streamed from the cli.
+type S3Reader struct {
+	S3Config
+}
+
+// Files lists the files below the prefix's dir
+func (s *S3Reader) Files(dir string) (Openers, error) {
+	prefix := cloudPrefix(s.Prefix) + dir
+	out, err := runCommand(nil, s.command(), s.args("s3api", "list-objects-v2",
+		"--bucket", s.Bucket, "--prefix", prefix, "--query", "Contents[].Key", "--output", "json")...)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(out, &keys); err != nil {
+		return nil, err
+	}
+	openers := make(Openers, 0, len(keys))
+	for _, key := range keys {
+		name := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		url := "s3://" + s.Bucket + "/" + key
+		openers = append(openers, Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) {
+				return startReader(nil, s.command(), s.args("s3", "cp", url, "-")...)
+			},
+		})
+	}
+	return openers, nil
+}
+
+func (c S3Config) url(dir, name string) string {
+	return "s3://" + c.Bucket + "/" + cloudPrefix(c.Prefix) + path.Join(dir, name)
+}
+
+func (c S3Config) command() string {
+	if c.Command == "" {
+		return "aws"
+	}
+	return c.Command
+}
+
+// args appends the global options to the cli's args
+func (c S3Config) args(args ...string) []string {
+	if c.Profile != "" {
+		args = append(args, "--profile", c.Profile)
+	}
+	if c.Region != "" {
+		args = append(args, "--region", c.Region)
+	}
+	if c.Endpoint != "" {
+		args = append(args, "--endpoint-url", c.Endpoint)
+	}
+	return args
+}
+
+// cloudPrefix returns the prefix without a leading slash and with a trailing one, if not empty
+func cloudPrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
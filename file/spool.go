@@ -0,0 +1,155 @@
+package file
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// WritesConcurrently returns true if the DumpWriter's files can be written at the same time,
+// e.g. by a parallel dump. The files of archives and streams are written one at a time.
+func WritesConcurrently(dw DumpWriter) bool {
+	switch dw.(type) {
+	case *DirWriter, *S3Writer, *GCSWriter, *AzureWriter:
+		return true
+	}
+	return false
+}
+
+// ReadsConcurrently returns true if the DumpReader's files can be read at the same time, e.g.
+// by a parallel restore. The files of tar archives and streams are read one at a time.
+func ReadsConcurrently(dr DumpReader) bool {
+	switch dr.(type) {
+	case *DirReader, *zipReader, *S3Reader, *GCSReader, *AzureReader:
+		return true
+	}
+	return false
+}
+
+// SpoolWriter lets the files of a DumpWriter that writes one file at a time be written at
+// the same time. Each file is written to a temp file in Dir, or the default temp dir, and
+// copied to the DumpWriter when it's closed.
+type SpoolWriter struct {
+	DumpWriter
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewSpoolWriter returns a new SpoolWriter
+func NewSpoolWriter(dw DumpWriter) *SpoolWriter {
+	return &SpoolWriter{DumpWriter: dw}
+}
+
+// Writer returns a writer of the file's temp file
+func (s *SpoolWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	f, err := ioutil.TempFile(s.Dir, "migrate-spool")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolFile{File: f, s: s, dir: dir, name: name}, nil
+}
+
+// spoolFile is the temp file of a file of SpoolWriter
+type spoolFile struct {
+	*os.File
+	s         *SpoolWriter
+	dir, name string
+}
+
+// Close copies the temp file to the DumpWriter and removes it
+func (f *spoolFile) Close() error {
+	defer os.Remove(f.Name())
+	defer f.File.Close()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	w, err := f.s.DumpWriter.Writer(f.dir, f.name)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, f.File); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// SpoolReader lets the files of a DumpReader that reads one file at a time be read at the
+// same time. Each file is copied to a temp file in Dir, or the default temp dir, when it's
+// opened, and removed when it's closed.
+type SpoolReader struct {
+	DumpReader
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewSpoolReader returns a new SpoolReader
+func NewSpoolReader(dr DumpReader) *SpoolReader {
+	return &SpoolReader{DumpReader: dr}
+}
+
+// Files returns the files below dir, whose openers copy them to temp files
+func (s *SpoolReader) Files(dir string) (Openers, error) {
+	openers, err := s.DumpReader.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	spooled := make(Openers, len(openers))
+	for i, o := range openers {
+		open := o.Open
+		spooled[i] = Opener{Name: o.Name, Open: func() (io.ReadCloser, error) { return s.open(open) }}
+	}
+	return spooled, nil
+}
+
+func (s *SpoolReader) open(open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	f, err := ioutil.TempFile(s.Dir, "migrate-spool")
+	if err != nil {
+		return nil, err
+	}
+	sf := &spooledFile{f}
+	if err = s.copy(f, open); err != nil {
+		sf.Close()
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		sf.Close()
+		return nil, err
+	}
+	return sf, nil
+}
+
+// copy copies the file to w, one file at a time
+func (s *SpoolReader) copy(w io.Writer, open func() (io.ReadCloser, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close closes the DumpReader if it's an io.Closer
+func (s *SpoolReader) Close() error {
+	if c, ok := s.DumpReader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// spooledFile removes the temp file of a SpoolReader when it's closed
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	defer os.Remove(f.Name())
+	return f.File.Close()
+}
@@ -0,0 +1,131 @@
+package file
+
+import (
+	"bytes"
+	"unicode"
+)
+
+// Statement is one statement of a migration file
+type Statement struct {
+	// SQL is the statement's text without the terminating semicolon
+	SQL string
+	// Offset is the byte offset of the statement in the file's content
+	Offset int
+}
+
+// SplitStatements splits the content into statements at the semicolons outside of quotes,
+// dollar quotes and comments. Statements that are only whitespace and comments are left out.
+// Semicolons inside BEGIN ATOMIC ... END function bodies aren't recognized, so files with
+// them can't be split.
+func SplitStatements(content []byte) (statements []Statement) {
+	start := 0
+	significant := false
+	add := func(end int) {
+		if significant {
+			sql := bytes.TrimRightFunc(content[start:end], unicode.IsSpace)
+			trimmed := bytes.TrimLeftFunc(sql, unicode.IsSpace)
+			statements = append(statements, Statement{
+				SQL:    string(trimmed),
+				Offset: start + len(sql) - len(trimmed),
+			})
+		}
+		start, significant = end+1, false
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == ';':
+			add(i)
+		case c == '-' && i+1 < len(content) && content[i+1] == '-':
+			if end := bytes.IndexByte(content[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(content)
+			}
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			i = skipBlockComment(content, i)
+		case c == '\'' || c == '"':
+			significant = true
+			i = skipQuoted(content, i, c == '\'' && isEscapeString(content, i))
+		case c == '$':
+			significant = true
+			if tag := dollarQuoteTag(content[i:]); tag != nil {
+				if end := bytes.Index(content[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(content)
+				}
+			}
+		case !unicode.IsSpace(rune(c)):
+			significant = true
+		}
+	}
+	add(len(content))
+	return
+}
+
+// skipBlockComment returns the offset of the end of the, possibly nested, comment starting at i
+func skipBlockComment(content []byte, i int) int {
+	depth := 0
+	for ; i+1 < len(content); i++ {
+		switch {
+		case content[i] == '/' && content[i+1] == '*':
+			depth++
+			i++
+		case content[i] == '*' && content[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(content)
+}
+
+// skipQuoted returns the offset of the closing quote of the string or identifier starting at i.
+// Doubled quotes are escaped, and backslashes too if escapes is set.
+func skipQuoted(content []byte, i int, escapes bool) int {
+	quote := content[i]
+	for i++; i < len(content); i++ {
+		switch content[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(content) && content[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(content)
+}
+
+// isEscapeString returns true if the quote at i starts an E'...' string
+func isEscapeString(content []byte, i int) bool {
+	if i == 0 || (content[i-1] != 'E' && content[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !isIdentByte(content[i-2])
+}
+
+// dollarQuoteTag returns the $tag$ at the start of b, or nil if it isn't a dollar quote, e.g. $1
+func dollarQuoteTag(b []byte) []byte {
+	for i := 1; i < len(b); i++ {
+		switch {
+		case b[i] == '$':
+			return b[:i+1]
+		case !isIdentByte(b[i]) || (i == 1 && b[i] >= '0' && b[i] <= '9'):
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,219 @@
+package file
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// StreamReader is a DumpReader of a stream, e.g. stdin, whose files can only be read once,
+// in the order they were written. Restores read the tables with NextFile, since they're
+// last and can't be listed before they're read.
+type StreamReader interface {
+	DumpReader
+	// NextFile returns the next file below dir, skipping the files that aren't, or io.EOF
+	// at the end of the stream. The file must be read before the next call.
+	NextFile(dir string) (Opener, error)
+}
+
+// tarStreamReader reads the tar stream of NewTarStreamWriter sequentially
+type tarStreamReader struct {
+	tr    *tar.Reader
+	next  *tar.Header // the header of the next entry, if it's been read
+	cur   io.Reader   // the reader of the current entry
+	eof   bool
+	files map[string]Openers // the results of Files
+}
+
+// NewTarStreamReader returns a new StreamReader of a tar stream, compressed with gzip if gz
+// is set. Files buffers its files in memory, so it's only for the files before the tables:
+// the version file in the root and the schema.
+func NewTarStreamReader(r io.Reader, gz bool) (StreamReader, error) {
+	if gz {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	}
+	return &tarStreamReader{tr: tar.NewReader(r), files: map[string]Openers{}}, nil
+}
+
+// peek returns the header of the next regular entry without reading it
+func (s *tarStreamReader) peek() (*tar.Header, error) {
+	for s.next == nil {
+		if s.eof {
+			return nil, io.EOF
+		}
+		// Next skips the rest of the current entry
+		s.cur = nil
+		if err := s.readNext(); err != nil {
+			return nil, err
+		}
+	}
+	return s.next, nil
+}
+
+// readNext reads the header of the next regular entry into next, or sets eof
+func (s *tarStreamReader) readNext() error {
+	for s.next == nil && !s.eof {
+		hdr, err := s.tr.Next()
+		if err == io.EOF {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			s.next = hdr
+		}
+	}
+	return nil
+}
+
+// belowDir returns true if the name is below dir, or in the root if dir is empty
+func belowDir(dir, name string) bool {
+	if dir == "" {
+		return !strings.Contains(name, "/")
+	}
+	return strings.HasPrefix(name, dir)
+}
+
+// Files reads the consecutive files below dir from the stream. Files that are below the
+// root dir "" are the ones in the root only.
+func (s *tarStreamReader) Files(dir string) (Openers, error) {
+	if openers, ok := s.files[dir]; ok {
+		return openers, nil
+	}
+	if err := s.drain(); err != nil {
+		return nil, err
+	}
+	openers := Openers{}
+	for {
+		hdr, err := s.peek()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, _ := tarEntryName(hdr)
+		if !belowDir(dir, name) {
+			break
+		}
+		if name, err = filepath.Rel(dir, name); err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(s.entry())
+		if err != nil {
+			return nil, err
+		}
+		openers = append(openers, Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(b)), nil },
+		})
+	}
+	s.files[dir] = openers
+	return openers, nil
+}
+
+func (s *tarStreamReader) NextFile(dir string) (Opener, error) {
+	if err := s.drain(); err != nil {
+		return Opener{}, err
+	}
+	for {
+		hdr, err := s.peek()
+		if err != nil {
+			return Opener{}, err
+		}
+		name, _ := tarEntryName(hdr)
+		if !belowDir(dir, name) {
+			// skip the entry
+			s.entry()
+			if err := s.drain(); err != nil {
+				return Opener{}, err
+			}
+			continue
+		}
+		if name, err = filepath.Rel(dir, name); err != nil {
+			return Opener{}, err
+		}
+		r := s.entry()
+		opened := false
+		return Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) {
+				if opened {
+					return nil, errors.New("Files of a stream can only be opened once")
+				}
+				opened = true
+				return &streamEntryCloser{r}, nil
+			},
+		}, nil
+	}
+}
+
+// entry returns the reader of the next entry, which joins the chunks of a file
+func (s *tarStreamReader) entry() io.Reader {
+	hdr := s.next
+	s.next = nil
+	s.cur = s.tr
+	if name, chunk := tarEntryName(hdr); chunk {
+		s.cur = &streamChunksReader{s: s, name: name}
+	}
+	return s.cur
+}
+
+// streamChunksReader reads the current chunk of the stream, then the next chunks of the file
+type streamChunksReader struct {
+	s    *tarStreamReader
+	name string
+	done bool
+}
+
+func (c *streamChunksReader) Read(b []byte) (int, error) {
+	for !c.done {
+		n, err := c.s.tr.Read(b)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err := c.s.readNext(); err != nil {
+			return 0, err
+		}
+		if hdr := c.s.next; hdr == nil || hdr.PAXRecords[tarChunkKey] != c.name {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.s.next = nil
+	}
+	return 0, io.EOF
+}
+
+// drain reads the rest of the current entry, so the stream is at the next one
+func (s *tarStreamReader) drain() error {
+	if s.cur == nil {
+		return nil
+	}
+	_, err := io.Copy(ioutil.Discard, s.cur)
+	s.cur = nil
+	return err
+}
+
+// streamEntryCloser drains the entry when it's closed, so the stream is at the next one
+type streamEntryCloser struct {
+	io.Reader
+}
+
+func (c *streamEntryCloser) Close() error {
+	_, err := io.Copy(ioutil.Discard, c.Reader)
+	return err
+}
@@ -0,0 +1,333 @@
+package file
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TarChunkSize is the size of the memory buffer of a tar entry. The size of an entry is
+// written before its content, so a file that's bigger is written as numbered chunks of up to
+// TarChunkSize, e.g. tables/t1.0000 and tables/t1.0001, which the tar readers join in order.
+// Extracted with tar, the chunks can be joined with cat.
+var TarChunkSize = 8 << 20
+
+// tarChunkKey is the pax header of the chunks of a file, with the file's name
+const tarChunkKey = "MIGRATE.chunk"
+
+// tarWriter streams a tar or tar.gz archive to an io.Writer, without a tmp file per entry
+type tarWriter struct {
+	gw  *gzip.Writer // nil if the archive isn't compressed
+	tw  *tar.Writer
+	f   io.Closer // the archive's file, nil if it's owned by the caller
+	cur *chunkWriter
+}
+
+// NewTarStreamWriter returns a new DumpWriter that streams a tar archive, compressed with
+// gzip if gz is set, to w, e.g. a pipe. Closing it doesn't close w.
+func NewTarStreamWriter(w io.Writer, gz bool) DumpWriter {
+	t := &tarWriter{}
+	if gz {
+		t.gw = gzip.NewWriter(w)
+		w = t.gw
+	}
+	t.tw = tar.NewWriter(w)
+	return t
+}
+
+// NewTarWriter returns a new DumpWriter of a tar file
+func NewTarWriter(tarFile string) (DumpWriter, error) {
+	return newTarFileWriter(tarFile, false)
+}
+
+// NewTarGzWriter returns a new DumpWriter of a tar.gz file. The entries are streamed,
+// so tmpFile isn't used anymore.
+func NewTarGzWriter(tarFile, tmpFile string) (DumpWriter, error) {
+	return newTarFileWriter(tarFile, true)
+}
+
+func newTarFileWriter(tarFile string, gz bool) (DumpWriter, error) {
+	f, err := os.Create(tarFile)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTarStreamWriter(f, gz).(*tarWriter)
+	t.f = f
+	return t, nil
+}
+
+// Close closes the open writer, if one exists, then closes the tar.Writer
+func (t *tarWriter) Close() error {
+	var err error
+	if t.cur != nil {
+		err = t.cur.Close()
+	}
+	if cerr := t.tw.Close(); err == nil {
+		err = cerr
+	}
+	if t.gw != nil {
+		if cerr := t.gw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if t.f != nil {
+		if cerr := t.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Writer returns a writer of the entry that buffers up to TarChunkSize in memory
+func (t *tarWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	if t.cur != nil {
+		return nil, errors.New("Only one writer can open at a time")
+	}
+	t.cur = &chunkWriter{t: t, name: path.Join(dir, name)}
+	return t.cur, nil
+}
+
+// chunkWriter writes a file as one entry, or as numbered chunks if it's bigger than TarChunkSize
+type chunkWriter struct {
+	t      *tarWriter
+	name   string
+	buf    []byte
+	chunks int // the chunks written
+}
+
+func (c *chunkWriter) Write(b []byte) (int, error) {
+	if c.t.cur != c {
+		return 0, errors.New("Invalid chunkWriter")
+	}
+	n := 0
+	for len(b) > 0 {
+		// the buffer is written once there's more, so a file of TarChunkSize is one entry
+		if len(c.buf) >= TarChunkSize {
+			if err := c.flush(); err != nil {
+				return n, err
+			}
+		}
+		room := TarChunkSize - len(c.buf)
+		if room > len(b) {
+			room = len(b)
+		}
+		c.buf = append(c.buf, b[:room]...)
+		b = b[room:]
+		n += room
+	}
+	return n, nil
+}
+
+// flush writes the buffer as the next chunk
+func (c *chunkWriter) flush() error {
+	header := &tar.Header{
+		Name:       fmt.Sprintf("%s.%04d", c.name, c.chunks),
+		Mode:       0644,
+		Size:       int64(len(c.buf)),
+		ModTime:    time.Now(),
+		Typeflag:   tar.TypeReg,
+		PAXRecords: map[string]string{tarChunkKey: c.name},
+	}
+	if err := c.t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := c.t.tw.Write(c.buf); err != nil {
+		return err
+	}
+	c.buf = c.buf[:0]
+	c.chunks++
+	return nil
+}
+
+// Close writes the file as one entry, or its last chunk
+func (c *chunkWriter) Close() error {
+	if c.t.cur != c {
+		return errors.New("Invalid chunkWriter")
+	}
+	c.t.cur = nil
+	if c.chunks > 0 {
+		return c.flush()
+	}
+	header := &tar.Header{
+		Name:     c.name,
+		Mode:     0644,
+		Size:     int64(len(c.buf)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := c.t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := c.t.tw.Write(c.buf)
+	return err
+}
+
+// tarEntryName returns the name of the file of an entry, and if the entry is one of its chunks
+func tarEntryName(hdr *tar.Header) (name string, chunk bool) {
+	if name, ok := hdr.PAXRecords[tarChunkKey]; ok {
+		return name, true
+	}
+	return hdr.Name, false
+}
+
+// tarReader reads a tar or tar.gz file.
+// Entries are read sequentially so only one opened file can be read at a time.
+// Opening files in archive order is fastest since the archive doesn't need to be reopened.
+type tarReader struct {
+	name    string
+	gz      bool
+	entries []tarEntry // in archive order
+
+	f   *os.File
+	gr  *gzip.Reader
+	tr  *tar.Reader
+	pos int // index of the next header in tr
+}
+
+// tarEntry is a file of the archive, written as one entry or as consecutive chunks
+type tarEntry struct {
+	name   string // empty if it isn't a regular file
+	start  int    // index of the first header
+	chunks int    // the number of chunks, 0 if it's one entry
+}
+
+// NewTarReader returns a new DumpReader of a tar file
+func NewTarReader(tarFile string) (DumpReader, error) {
+	return newTarFileReader(tarFile, false)
+}
+
+// NewTarGzReader returns a new DumpReader of a tar.gz file
+func NewTarGzReader(tarFile string) (DumpReader, error) {
+	return newTarFileReader(tarFile, true)
+}
+
+func newTarFileReader(tarFile string, gz bool) (DumpReader, error) {
+	t := &tarReader{name: tarFile, gz: gz}
+	if err := t.reset(); err != nil {
+		return nil, err
+	}
+	// index entries, joining the chunks of the files bigger than TarChunkSize
+	for i := 0; ; i++ {
+		hdr, err := t.tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			t.entries = append(t.entries, tarEntry{start: i})
+			continue
+		}
+		name, chunk := tarEntryName(hdr)
+		if n := len(t.entries); chunk && n > 0 && t.entries[n-1].chunks > 0 && t.entries[n-1].name == name {
+			t.entries[n-1].chunks++
+			continue
+		}
+		e := tarEntry{name: name, start: i}
+		if chunk {
+			e.chunks = 1
+		}
+		t.entries = append(t.entries, e)
+	}
+	return t, t.reset()
+}
+
+// reset reopens the archive at the first entry
+func (t *tarReader) reset() (err error) {
+	if t.f != nil {
+		_ = t.Close()
+	}
+	if t.f, err = os.Open(t.name); err != nil {
+		return
+	}
+	var r io.Reader = t.f
+	if t.gz {
+		if t.gr, err = gzip.NewReader(t.f); err != nil {
+			_ = t.f.Close()
+			return
+		}
+		r = t.gr
+	}
+	t.tr = tar.NewReader(r)
+	t.pos = 0
+	return
+}
+
+func (t *tarReader) open(e tarEntry) (io.ReadCloser, error) {
+	if e.start < t.pos {
+		if err := t.reset(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		if _, err := t.tr.Next(); err != nil {
+			return nil, err
+		}
+		t.pos++
+		if t.pos-1 == e.start {
+			if e.chunks == 0 {
+				return ioutil.NopCloser(t.tr), nil
+			}
+			return ioutil.NopCloser(&chunksReader{t: t, chunks: e.chunks - 1}), nil
+		}
+	}
+}
+
+// chunksReader reads the current chunk of the tar.Reader, then the remaining chunks
+type chunksReader struct {
+	t      *tarReader
+	chunks int
+}
+
+func (c *chunksReader) Read(b []byte) (int, error) {
+	for {
+		n, err := c.t.tr.Read(b)
+		if err != io.EOF || c.chunks == 0 {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if _, err := c.t.tr.Next(); err != nil {
+			return 0, err
+		}
+		c.t.pos++
+		c.chunks--
+	}
+}
+
+func (t *tarReader) Files(dir string) (openers Openers, err error) {
+	var name string
+	for _, entry := range t.entries {
+		if entry.name == "" || !strings.HasPrefix(entry.name, dir) {
+			continue
+		}
+		if name, err = filepath.Rel(dir, entry.name); err != nil {
+			return
+		}
+		e := entry
+		o := Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) { return t.open(e) },
+		}
+		openers = append(openers, o)
+	}
+	return
+}
+
+func (t *tarReader) Close() error {
+	if t.gr != nil {
+		_ = t.gr.Close()
+	}
+	return t.f.Close() // close file
+}
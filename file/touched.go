@@ -0,0 +1,62 @@
+package file
+
+// TouchedTables returns the tables whose rows the statements may change: the tables that are
+// created, altered, truncated or copied, and the targets of INSERT, UPDATE and DELETE. The
+// names are without the schema, lowercase unless they're quoted. Statements in functions
+// and DO blocks aren't parsed.
+func TouchedTables(content []byte) []string {
+	var tables []string
+	seen := map[string]bool{}
+	for _, stmt := range SplitStatements(content) {
+		for _, name := range touchedTables(&tokens{tokens: tokenize(stmt.SQL)}) {
+			if key := tableKey(name); !seen[key] {
+				seen[key] = true
+				tables = append(tables, key)
+			}
+		}
+	}
+	return tables
+}
+
+func touchedTables(t *tokens) []string {
+	var names []string
+	name := func() {
+		if name, ok := t.name(); ok {
+			names = append(names, name)
+		}
+	}
+	// WITH queries are skipped up to the statement they're for
+	if t.keyword("WITH") {
+		for t.pos < len(t.tokens) && !t.peekKeyword("INSERT", "UPDATE", "DELETE") {
+			t.pos++
+		}
+	}
+	switch {
+	case t.keyword("CREATE"):
+		t.keyword("TEMP")
+		t.keyword("TEMPORARY")
+		t.keyword("UNLOGGED")
+		if t.keyword("TABLE") {
+			t.keywords("IF", "NOT", "EXISTS")
+			name()
+		}
+	case t.keywords("ALTER", "TABLE"):
+		t.keywords("IF", "EXISTS")
+		t.keyword("ONLY")
+		name()
+	case t.keywords("INSERT", "INTO"), t.keywords("DELETE", "FROM"), t.keyword("UPDATE"), t.keyword("COPY"):
+		t.keyword("ONLY")
+		name()
+	case t.keyword("TRUNCATE"):
+		t.keyword("TABLE")
+		for {
+			t.keyword("ONLY")
+			name()
+			if t.peek() != "," {
+				break
+			}
+			t.pos++
+		}
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	"github.com/acls/migrate/migrate/direction"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// k8s-init exit codes, so the pod's status tells why the init container failed
+const (
+	exitMigrationFailed     = 1
+	exitDatabaseUnreachable = 2
+	exitLockTimeout         = 3
+)
+
+// k8s-init defaults for the timeouts that aren't set. Without a lock timeout, the replicas
+// that wait for the migrator would hang as long as a migrator that hung.
+const (
+	k8sWaitTimeout = 5 * time.Minute
+	k8sLockTimeout = 10 * time.Minute
+)
+
+// k8sInitDefaults sets the timeouts that aren't set
+func k8sInitDefaults(m *migrate.Migrator) {
+	if m.WaitTimeout == 0 {
+		m.WaitTimeout = k8sWaitTimeout
+	}
+	if m.LockTimeout == 0 {
+		m.LockTimeout = k8sLockTimeout
+	}
+}
+
+// runK8sInit migrates like 'between' and logs json lines. The replicas that start at the
+// same time wait for the lock of the first one, then find nothing left to migrate.
+// It returns the exit code, and stops after the running file on SIGTERM.
+func runK8sInit(m *migrate.Migrator, conn driver.Conn) int {
+	timerStart := m.Now()
+	ctx, stop := migrate.SignalContext(context.Background())
+	defer stop()
+
+	m.Command = "between"
+	printMessage(msgK8sMigrating, m.Schema)
+	pipe := pipep.New()
+	go m.MigrateBetweenCtx(ctx, pipe, conn)
+
+	code := 0
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			if errors.Is(item, migrate.ErrNoChange) {
+				continue
+			}
+			printError(item)
+			if errors.Is(item, migrate.ErrLocked) {
+				code = exitLockTimeout
+			} else if code == 0 {
+				code = exitMigrationFailed
+			}
+		case *file.Migration:
+			printK8sFile(item.File())
+		case *file.File:
+			printK8sFile(item)
+		case migrate.NotificationFailed:
+			printWarning(msgNotifyFailed, item.Err)
+		case migrate.Retrying:
+			printMessage(msgRetrying, item.Wait, item.Err)
+		case string:
+			printMessage(msgK8sLog, item)
+		}
+	}
+	if code != 0 {
+		return code
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		printError(err)
+		return exitMigrationFailed
+	}
+	printMessage(msgK8sMigrated, version, m.Since(timerStart).Seconds())
+	return 0
+}
+
+func printK8sFile(f *file.File) {
+	d := "up"
+	if f.Direction == direction.Down {
+		d = "down"
+	}
+	printMessage(msgK8sFile, d, f.FileName)
+}
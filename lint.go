@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	"github.com/fatih/color"
+)
+
+// runLint lints the files, or the up files in the path if there are none, and prints the
+// findings. ok is false if any of them is an error. conn may be nil.
+func runLint(m *migrate.Migrator, conn driver.Conn, paths []string, rules string, jsonOutput bool) (ok bool, err error) {
+	severities, err := file.ParseSeverities(rules)
+	if err != nil {
+		return false, err
+	}
+	opts := file.LintOptions{Severities: severities}
+	var findings []file.LintFinding
+	if len(paths) == 0 {
+		if findings, err = m.Lint(conn, opts); err != nil {
+			return false, err
+		}
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		findings = append(findings, file.Lint(filepath.Base(path), content, opts)...)
+	}
+
+	ok = true
+	for _, f := range findings {
+		if f.Severity == file.SeverityError {
+			ok = false
+		}
+	}
+	if jsonOutput {
+		if findings == nil {
+			findings = []file.LintFinding{}
+		}
+		return ok, json.NewEncoder(os.Stdout).Encode(findings)
+	}
+	for _, f := range findings {
+		c := color.New(color.FgYellow)
+		if f.Severity == file.SeverityError {
+			c = color.New(color.FgRed)
+		}
+		c.Println(f.String())
+	}
+	if len(findings) == 0 {
+		printMessage(msgNoLintFindings)
+	}
+	return ok, nil
+}
@@ -4,11 +4,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	mpgx "github.com/acls/migrate/driver/pgx"
@@ -18,30 +24,130 @@ import (
 	"github.com/acls/migrate/migrate"
 	"github.com/acls/migrate/migrate/direction"
 	pipep "github.com/acls/migrate/pipe"
+	"github.com/acls/migrate/secret"
 	"github.com/fatih/color"
 )
 
 const Version string = "2.2.2"
 
+const defaultDumpDir = "./dump"
+
+// Verbosity levels
+const (
+	quietLevel = iota
+	normalLevel
+	verboseLevel
+)
+
+// verbosity controls how much of the pipe output is printed
+var verbosity = normalLevel
+
 func main() {
 	m := &migrate.Migrator{
-		Interrupts: true,
+		Interrupts:  true,
+		ToolVersion: Version,
 	}
 
-	var url string
+	var url, prevURL string
 	flag.StringVar(&url, "url", os.Getenv("MIGRATE_URL"), "")
+	flag.StringVar(&prevURL, "prev-url", os.Getenv("MIGRATE_PREV_URL"), "")
 	flag.StringVar(&m.Path, "path", os.Getenv("SCHEMA_DIR"), "")
 	flag.BoolVar(&m.TxPerFile, "perfile", false, "")
+	flag.BoolVar(&m.ContinueOnError, "continue-on-error", false, "")
+	flag.BoolVar(&m.SkipContent, "skip-content", false, "")
+	flag.BoolVar(&m.AllowOutOfOrder, "allow-out-of-order", false, "")
+	flag.BoolVar(&m.Audit, "audit", false, "")
+	var snapshotDir string
+	flag.StringVar(&snapshotDir, "snapshot-dir", os.Getenv("MIGRATE_SNAPSHOT_DIR"), "")
+	var notifyURL, notifyCmd string
+	flag.StringVar(&notifyURL, "notify-url", os.Getenv("MIGRATE_NOTIFY_URL"), "")
+	flag.StringVar(&notifyCmd, "notify-cmd", "", "")
+	var listen, apiToken string
+	flag.StringVar(&listen, "listen", ":8085", "")
+	flag.StringVar(&apiToken, "token", os.Getenv("MIGRATE_API_TOKEN"), "")
+	var lintRules string
+	flag.StringVar(&lintRules, "lint-rules", "", "")
+	var dashboard bool
+	flag.BoolVar(&dashboard, "dashboard", false, "")
+	flag.BoolVar(&m.Parallel, "parallel", false, "")
+	flag.BoolVar(&m.Statements, "statements", false, "")
+	flag.BoolVar(&m.Render, "render", false, "")
+	flag.BoolVar(&m.ChecksumRendered, "checksum-rendered", false, "")
+	var renderVars string
+	flag.StringVar(&renderVars, "vars", "", "")
 	flag.BoolVar(&file.V2, "v2", false, "")
 	flag.BoolVar(&m.Force, "force", false, "")
+	flag.BoolVar(&m.ParseOptions.Strict, "strict", false, "")
+	var ignore string
+	flag.StringVar(&ignore, "ignore", "", "")
 	flag.StringVar(&m.Schema, "schema", "public", "")
+	var versionTable string
+	flag.StringVar(&versionTable, "table", os.Getenv("MIGRATE_TABLE"), "")
+	flag.DurationVar(&m.LockTimeout, "lock-timeout", 0, "")
+	flag.DurationVar(&m.WaitTimeout, "wait-timeout", 0, "")
+	flag.BoolVar(&m.NoLock, "no-lock", false, "")
+	flag.DurationVar(&m.MigrationTimeout, "migration-timeout", 0, "")
+	var isolation string
+	flag.StringVar(&isolation, "isolation", "", "")
+	flag.BoolVar(&m.TxOptions.ReadWrite, "read-write", false, "")
+	flag.IntVar(&m.Retry.Retries, "retries", 0, "")
+	flag.DurationVar(&m.Retry.Backoff, "retry-backoff", 100*time.Millisecond, "")
 	var incMajor bool
 	flag.BoolVar(&incMajor, "major", false, "")
+	var upSQL, downSQL string
+	flag.StringVar(&upSQL, "up-sql", "", "")
+	flag.StringVar(&downSQL, "down-sql", "", "")
+	var edit bool
+	flag.BoolVar(&edit, "edit", false, "")
+	var tmpl string
+	flag.StringVar(&tmpl, "template", "", "")
 	var version bool
 	flag.BoolVar(&version, "version", false, "")
 
-	var dumpDir string
-	flag.StringVar(&dumpDir, "dump", "./dump", "")
+	var dumpDir, dumpFormat string
+	flag.StringVar(&dumpDir, "dump", defaultDumpDir, "")
+	flag.StringVar(&dumpFormat, "dump-format", dumpFormatDir, "")
+	var dumpBase string
+	var dumpBaseMigrations bool
+	flag.StringVar(&dumpBase, "dump-base", "", "")
+	flag.BoolVar(&dumpBaseMigrations, "dump-base-migrations", false, "")
+	var dumpSchemaOnly, dumpDataOnly bool
+	var dumpJobs int
+	var dumpSnapshot bool
+	flag.IntVar(&dumpJobs, "dump-jobs", 1, "")
+	flag.BoolVar(&dumpSnapshot, "dump-snapshot", false, "")
+	flag.BoolVar(&dumpSchemaOnly, "dump-schema-only", false, "")
+	flag.BoolVar(&dumpDataOnly, "dump-data-only", false, "")
+
+	var envFile string
+	flag.StringVar(&envFile, "env-file", "", "")
+
+	var watchInterval time.Duration
+	flag.DurationVar(&watchInterval, "watch-interval", time.Second, "")
+
+	var secretTTL time.Duration
+	flag.DurationVar(&secretTTL, "secret-ttl", 5*time.Minute, "")
+
+	var stateOut string
+	flag.StringVar(&stateOut, "state-out", "", "")
+
+	var generate string
+	flag.StringVar(&generate, "generate", os.Getenv("MIGRATE_GENERATE"), "")
+
+	var prompt bool
+	flag.BoolVar(&prompt, "prompt", false, "")
+
+	var jsonOutput bool
+	flag.BoolVar(&jsonOutput, "json", false, "")
+
+	var quiet, verbose bool
+	flag.BoolVar(&quiet, "quiet", false, "")
+	flag.BoolVar(&verbose, "verbose", false, "")
+
+	var fromVersion, toVersion, outFile string
+	flag.StringVar(&fromVersion, "from", "", "")
+	flag.StringVar(&toVersion, "to", "", "")
+	flag.StringVar(&outFile, "out", "", "")
 
 	flag.Usage = func() {
 		printHelp()
@@ -49,140 +155,977 @@ func main() {
 
 	flag.Parse()
 	command := flag.Arg(0)
+	// init containers have no terminal and their logs are collected
+	if command == "k8s-init" {
+		prompt = false
+		jsonOutput = true
+		k8sInitDefaults(m)
+	}
+	jsonMessages = jsonOutput
 	if version {
 		fmt.Println(Version)
-		os.Exit(0)
+		exit(0)
+	}
+
+	switch {
+	case quiet:
+		verbosity = quietLevel
+	case verbose:
+		verbosity = verboseLevel
+	}
+	// https://no-color.org
+	if os.Getenv("NO_COLOR") != "" {
+		color.NoColor = true
+	}
+	// the dump is streamed to stdout, so the output goes to stderr
+	if dumpDir == dumpStdio {
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
 	}
 
+	// e.g. repeatable-read
+	m.TxOptions.IsolationLevel = driver.IsolationLevel(strings.ToUpper(strings.Replace(isolation, "-", " ", -1)))
+
+	m.ParseOptions.Warn = warnFile
+	if ignore != "" {
+		m.ParseOptions.Ignore = strings.Split(ignore, ",")
+	}
+	if renderVars != "" {
+		vars, err := parseVars(strings.Split(renderVars, ","))
+		if err != nil {
+			printError(err)
+			exit(1)
+		}
+		m.Vars = vars
+	}
+
+	// load .env, or -env-file which must exist
+	if err := loadEnvFile(envFileOrDefault(envFile), envFile != ""); err != nil {
+		printError(err)
+		exit(1)
+	}
+	// flag defaults were read before the env file was loaded
 	if url == "" {
-		fmt.Println("No url")
-		os.Exit(0)
+		url = os.Getenv("MIGRATE_URL")
+	}
+	if prevURL == "" {
+		prevURL = os.Getenv("MIGRATE_PREV_URL")
+	}
+	if m.Path == "" {
+		m.Path = os.Getenv("SCHEMA_DIR")
+	}
+	m.Path = os.ExpandEnv(m.Path)
+	if apiToken == "" {
+		apiToken = os.Getenv("MIGRATE_API_TOKEN")
+	}
+	if snapshotDir == "" {
+		snapshotDir = os.Getenv("MIGRATE_SNAPSHOT_DIR")
+	}
+	if snapshotDir != "" {
+		m.Snapshots = snapshotStore{dir: snapshotDir, format: dumpFormat}
+	}
+	switch {
+	case dumpSchemaOnly && dumpDataOnly:
+		printError(newMessage(msgDumpContents))
+		exit(1)
+	case dumpSchemaOnly:
+		m.DumpContents = migrate.DumpSchemaOnly
+	case dumpDataOnly:
+		m.DumpContents = migrate.DumpDataOnly
+	}
+	m.DumpJobs, m.DumpSnapshot = dumpJobs, dumpSnapshot
+	if dumpBase != "" {
+		inc, err := incrementalDump(dumpBase, dumpBaseMigrations)
+		if err != nil {
+			printError(err)
+			exit(1)
+		}
+		m.Incremental = inc
+	}
+	if notifyURL != "" {
+		m.Notifiers = append(m.Notifiers, &migrate.WebhookNotifier{URL: notifyURL})
+	}
+	if notifyCmd != "" {
+		m.Notifiers = append(m.Notifiers, &migrate.CommandNotifier{Command: notifyCmd})
 	}
 
-	m.Driver = mpgx.New("")
+	// read url from stdin and/or prompt for the password
+	// so they aren't exposed in process listings and shell history
+	var err error
+	if url == "-" {
+		if url, err = readURL(); err != nil {
+			printError(err)
+			exit(1)
+		}
+	}
+	// resolve ${VAR} and ${provider:key} secret references so credentials can stay out of the command line
+	secrets := secret.NewResolver(secretTTL)
+	redact = secrets.Redact
+	rawURL := url
+	if url, err = secrets.Resolve(url); err != nil {
+		printError(err)
+		exit(1)
+	}
+	if prevURL, err = secrets.Resolve(prevURL); err != nil {
+		printError(err)
+		exit(1)
+	}
+	if prompt && url != "" {
+		if url, err = promptPassword(url); err != nil {
+			printError(err)
+			exit(1)
+		}
+	}
+
+	m.Driver = mpgx.New(versionTable)
 
 	if m.Path == "" {
 		m.Path, _ = os.Getwd()
 		m.Path = path.Join(m.Path, "schema")
 	}
+	// read the migration files from a bucket or url instead of a local dir
+	if m.Source, err = migrate.ParseSource(m.Path); err != nil {
+		printError(err)
+		exit(1)
+	} else if m.Source != nil {
+		m.Path = ""
+	}
+
+	// lint in code review without a database, which is only used for the table sizes
+	if command == "lint" {
+		var conn driver.Conn
+		if url != "" {
+			if conn, err = m.Driver.NewConn(url, m.Schema); err != nil {
+				printError(err)
+				exit(2)
+			}
+		}
+		ok, err := runLint(m, conn, flag.Args()[1:], lintRules, jsonOutput)
+		if err != nil {
+			printError(err)
+			exit(2)
+		}
+		if !ok {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	if command == "check-sync" {
+		urls := flag.Args()[1:]
+		if url != "" {
+			urls = append([]string{url}, urls...)
+		}
+		inSync, err := runCheckSync(m, urls, toVersion)
+		if err != nil {
+			printError(err)
+			exit(2)
+		}
+		if !inSync {
+			exit(1)
+		}
+		exit(0)
+	}
+
+	if url == "" {
+		printError(newMessage(msgNoURL))
+		switch command {
+		case "k8s-init":
+			exit(exitMigrationFailed)
+		case "check":
+			exit(exitCheckError)
+		}
+		exit(0)
+	}
 
 	switch command {
 	case "dump", "restore":
-		runDumpRestore(m, url, dumpDir, command)
-		os.Exit(0)
+		runDumpRestore(m, url, dumpDir, dumpFormat, command)
+		exit(0)
+	case "restore-rotate", "revert-schema", "drop-rotate":
+		if err := runRotate(m, url, dumpDir, dumpFormat, command); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
 	}
 
-	conn, err := m.Driver.NewConn(url, m.Schema)
+	m.NewConn = func() (driver.Conn, error) {
+		if prompt || rawURL == url {
+			return m.Driver.NewConn(url, m.Schema)
+		}
+		// pick up rotated secrets once they expire
+		u, err := secrets.Resolve(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := m.Driver.NewConn(u, m.Schema)
+		if err == nil {
+			return conn, nil
+		}
+		// the secrets may have been rotated before they expired
+		secrets.Invalidate()
+		if u, err = secrets.Resolve(rawURL); err != nil {
+			return nil, err
+		}
+		return m.Driver.NewConn(u, m.Schema)
+	}
+
+	conn, err := m.Connect()
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		printError(err)
+		exit(connectExitCode(command))
+	}
+
+	if prevURL != "" {
+		// read migration files from the other database instead of -path
+		if m.PrevConn, err = m.Driver.NewConn(prevURL, m.Schema); err != nil {
+			printError(err)
+			exit(connectExitCode(command))
+		}
+		closers = append(closers, m.PrevConn)
 	}
 
 	switch command {
 	default:
-		runMigration(m, conn, command)
+		runMigration(m, conn, command, generate, stateOut)
 	case "create":
 		name := flag.Arg(1)
 		if name == "" {
-			fmt.Println("Please specify name.")
-			os.Exit(1)
+			printError(newMessage(msgNoName))
+			exit(1)
+		}
+		var migrationFile *file.MigrationFile
+		if tmpl != "" {
+			var vars map[string]string
+			if vars, err = parseVars(flag.Args()[2:]); err != nil {
+				printError(err)
+				exit(1)
+			}
+			migrationFile, err = m.CreateFromTemplate(incMajor, name, tmpl, vars)
+		} else {
+			if upSQL != "" && downSQL == "" {
+				downSQL = string(generateDown(name, []byte(upSQL)))
+			}
+			migrationFile, err = m.Create(incMajor, name, upSQL, downSQL)
+		}
+		if err != nil {
+			printError(err)
+			exit(1)
+		}
+		printCreated(m.Path, migrationFile)
+		if edit {
+			if err := openEditor(migrationFile.UpFile.Path(m.Path), migrationFile.DownFile.Path(m.Path)); err != nil {
+				printError(err)
+				exit(1)
+			}
+			if filled, err := fillDownFile(m, migrationFile); err != nil {
+				printError(err)
+				exit(1)
+			} else if filled {
+				printMessage(msgGeneratedDown, migrationFile.DownFile.FileName)
+			}
+		}
+		exit(0)
+	case "expand-contract":
+		if err := runExpandContract(m, incMajor, flag.Arg(1), flag.Args()[2:]); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "gen-down":
+		if err := runGenDown(m); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "verify":
+		if err := m.Verify(conn); err != nil {
+			printError(err)
+			exit(1)
+		}
+		printMessage(msgBaseFilesMatch)
+		exit(0)
+	case "drift":
+		drifted, err := runDrift(m, conn, jsonOutput)
+		if err != nil {
+			printError(err)
+			exit(2)
+		}
+		if drifted {
+			exit(1)
 		}
-		migrationFile, err := m.Create(incMajor, name)
+		exit(0)
+	case "repair":
+		repaired, err := m.Repair(conn)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			printError(err)
+			exit(1)
+		}
+		for _, v := range repaired {
+			printMessage(msgRepaired, v)
+		}
+		if len(repaired) == 0 {
+			printMessage(msgNoDrift)
+		}
+		exit(0)
+	case "check":
+		exit(runCheck(m, conn))
+	case "watch", "dev":
+		if err := runWatch(m, conn, watchInterval, command == "dev"); err != nil {
+			printError(err)
+			exit(1)
+		}
+	case "upgrade-matrix":
+		releases, err := releaseDirs(flag.Args()[1:])
+		if err != nil {
+			printError(err)
+			exit(2)
+		}
+		ok, err := runUpgradeMatrix(m, conn, releases)
+		if err != nil {
+			printError(err)
+			exit(2)
+		}
+		if !ok {
+			exit(1)
+		}
+		exit(0)
+	case "amend":
+		if err := runAmend(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "plan":
+		if err := runPlan(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
 		}
-		fmt.Printf("Create version %s/%v migration files\n", m.Path, migrationFile.Version)
-		fmt.Println(migrationFile.UpFile.FileName)
-		fmt.Println(migrationFile.DownFile.FileName)
-		os.Exit(0)
+		exit(0)
+	case "show":
+		if err := runShow(m, conn, flag.Arg(1), flag.Arg(2)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "export-script":
+		if err := runExportScript(m, conn, fromVersion, toVersion, outFile); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "export-stats":
+		if err := runExportStats(m, conn, outFile); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "export-schema":
+		if err := runExportSchema(m, conn, outFile); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "diff-schema":
+		if err := runDiffSchema(m, conn, flag.Arg(1), flag.Arg(2), outFile, incMajor); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "simulate":
+		if err := runSimulate(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "batch":
+		if err := runBatch(m, conn, dumpFormat); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "backfill":
+		if err := runBackfill(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "import-history":
+		if err := runImportHistory(m, conn, flag.Arg(1), flag.Arg(2)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "mark-applied", "mark-reverted":
+		if err := runMark(m, conn, command == "mark-applied", flag.Args()[1:]); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "squash":
+		if err := runSquash(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "bench":
+		if err := runBench(m, conn, flag.Arg(1), flag.Arg(2)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "force-unlock":
+		if err := m.ForceUnlock(conn); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "rollback-to-snapshot":
+		if err := runRollbackToSnapshot(m, conn, flag.Arg(1)); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "runs":
+		if err := runRuns(m, conn, flag.Arg(1), jsonOutput); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "k8s-init":
+		exit(runK8sInit(m, conn))
+	case "serve":
+		conn.Close()
+		if err := runServe(m, listen, apiToken, dumpDir, dumpFormat, dashboard); err != nil {
+			printError(err)
+			exit(1)
+		}
+		exit(0)
+	case "fix-sequences":
+		sequences, err := m.FixSequences(conn)
+		if err != nil {
+			printError(err)
+			exit(1)
+		}
+		if verbosity > quietLevel {
+			for _, seq := range sequences {
+				printMessage(msgFixedSequence, seq)
+			}
+		}
+		exit(0)
 	case "version":
-		printComplete(m, conn, time.Now())
-		os.Exit(0)
+		if jsonOutput {
+			if err := printVersionJSON(m, conn); err != nil {
+				printError(err)
+				exit(1)
+			}
+			exit(0)
+		}
+		printComplete(m, conn, m.Now())
+		exit(0)
 	case "help":
 		printHelp()
-		os.Exit(0)
+		exit(0)
 	}
 }
 
-func runDumpRestore(m *migrate.Migrator, url, dumpDir, command string) {
-	timerStart := time.Now()
+// Dump formats
+const (
+	dumpFormatDir   = "dir"
+	dumpFormatZip   = "zip"
+	dumpFormatTar   = "tar"
+	dumpFormatTarGz = "tar.gz"
+)
+
+func runDumpRestore(m *migrate.Migrator, url, dumpPath, dumpFormat, command string) {
+	dd := m.Driver.(driver.DumpDriver)
+	// the connections of '-dump-jobs'
+	m.NewConn = func() (driver.Conn, error) {
+		return dd.NewCopyConn(url, m.Schema)
+	}
+	var conn driver.CopyConn
+	err := m.WaitForDatabase(func() (err error) {
+		conn, err = dd.NewCopyConn(url, m.Schema)
+		return
+	})
+	if err != nil {
+		printError(err)
+		exit(1)
+	}
+	if ok, err := dumpRestore(m, conn, dumpPath, dumpFormat, command); err != nil || !ok {
+		if err != nil {
+			printError(err)
+		}
+		exit(1)
+	}
+}
+
+// dumpRestore dumps to or restores from the dump path. ok is false if the pipe had errors.
+func dumpRestore(m *migrate.Migrator, conn driver.CopyConn, dumpPath, dumpFormat, command string) (ok bool, err error) {
+	timerStart := m.Now()
 	pipe := pipep.New()
 
-	if dumpDir == "" {
-		fmt.Println("Please specify an output directory to dump to/from (-dump=)")
-		os.Exit(1)
+	if dumpPath == "" {
+		return false, newMessage(msgNoDumpPath)
 	}
 
-	empty, err := file.IsEmpty(dumpDir)
+	remote, err := parseRemoteDump(dumpPath, dumpFormat)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return false, err
+	}
+	var empty bool
+	if remote != nil {
+		empty, err = remote.empty(command)
+	} else {
+		dumpPath, empty, err = checkDumpPath(dumpPath, dumpFormat)
 	}
-
-	conn, err := m.Driver.(driver.DumpDriver).NewCopyConn(url, m.Schema)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return false, err
 	}
 
+	var closer io.Closer
 	switch command {
 	default: // "dump"
 		// check if dir is empty or not
 		if !m.Force && !empty {
-			fmt.Println("Dump dir must be empty or -force must be set")
-			os.Exit(1)
+			return false, newMessage(msgDumpNotEmpty)
+		}
+		var dw file.DumpWriter
+		switch {
+		case remote != nil:
+			dw = remote.dw
+		case dumpFormat == dumpFormatDir:
+			// empty dir
+			err = file.RemoveContents(dumpPath)
+			dw = &file.DirWriter{BaseDir: dumpPath}
+		case dumpFormat == dumpFormatZip:
+			dw, err = file.NewZipWriter(dumpPath, dumpPath+".tmp")
+		case dumpFormat == dumpFormatTar:
+			dw, err = file.NewTarWriter(dumpPath)
+		case dumpFormat == dumpFormatTarGz:
+			dw, err = file.NewTarGzWriter(dumpPath, dumpPath+".tmp")
 		}
-		// empty dir
-		// if m.Force {
-		if err = file.RemoveContents(dumpDir); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		if err != nil {
+			return false, err
 		}
-		go m.Dump(pipe, conn, &file.DirWriter{BaseDir: dumpDir})
+		closer = dw
+		go m.Dump(pipe, conn, dw)
 	case "restore":
 		if empty {
-			fmt.Println("Can't restore empty dump dir")
-			os.Exit(1)
+			return false, newMessage(msgDumpEmpty)
+		}
+		var dr file.DumpReader
+		if remote != nil {
+			dr = remote.dr
+		} else if dr, err = openDumpReader(dumpPath, dumpFormat); err != nil {
+			return false, err
 		}
-		// fmt.Println("m.Path1", m.Path)
-		// // set migration Path to dumped schema dir
-		// m.Path = path.Join(dumpDir, migrate.SchemaDir)
-		// fmt.Println("m.Path2", m.Path)
-		go m.Restore(pipe, conn, &file.DirReader{BaseDir: dumpDir})
+		// the tables of an incremental dump that didn't change are read from its bases
+		if dr, err = file.NewIncrementalReader(dr, openDumpLocation); err != nil {
+			return false, err
+		}
+		closer, _ = dr.(io.Closer)
+		go m.Restore(pipe, conn, dr)
 	}
 
-	ok := writePipe(pipe)
+	ok = writePipe(pipe)
+	if closer != nil {
+		if err := closer.Close(); err != nil {
+			printError(err)
+			ok = false
+		}
+	}
+	if remote == nil && dumpFormat != dumpFormatDir {
+		_ = os.Remove(dumpPath + ".tmp")
+	}
 	printComplete(m, conn, timerStart)
-	if !ok {
-		os.Exit(1)
+	return ok, nil
+}
+
+// checkDumpPath returns the dump path for the format and whether it's empty or missing
+func checkDumpPath(dumpPath, dumpFormat string) (string, bool, error) {
+	switch dumpFormat {
+	case dumpFormatDir:
+		empty, err := file.IsEmpty(dumpPath)
+		return dumpPath, empty, err
+	case dumpFormatZip, dumpFormatTar, dumpFormatTarGz:
+		// archives default to a file next to the default dump dir
+		if dumpPath == defaultDumpDir {
+			dumpPath += "." + dumpFormat
+		}
+		_, err := os.Stat(dumpPath)
+		if os.IsNotExist(err) {
+			return dumpPath, true, nil
+		}
+		return dumpPath, false, err
 	}
+	return dumpPath, false, newMessage(msgUnknownDumpFormat, dumpFormat)
 }
 
-func runMigration(m *migrate.Migrator, conn driver.Conn, command string) {
-	timerStart := time.Now()
-	pipe := pipep.New()
+// openDumpReader opens the dump for reading
+func openDumpReader(dumpPath, dumpFormat string) (file.DumpReader, error) {
+	switch dumpFormat {
+	case dumpFormatZip:
+		return file.NewZipReader(dumpPath)
+	case dumpFormatTar:
+		return file.NewTarReader(dumpPath)
+	case dumpFormatTarGz:
+		return file.NewTarGzReader(dumpPath)
+	}
+	return &file.DirReader{BaseDir: dumpPath}, nil
+}
+
+// check exit codes, so deploys can tell drift from a check that couldn't run
+const (
+	exitCheckPending = 1
+	exitCheckDrift   = 2
+	exitCheckError   = 3
+)
+
+// closers are closed by exit, e.g. the -prev-url connection
+var closers []io.Closer
+
+// exit closes the closers and exits with code, since deferred calls don't run on os.Exit
+func exit(code int) {
+	for _, c := range closers {
+		c.Close()
+	}
+	os.Exit(code)
+}
 
+// connectExitCode is the exit code of the command when it can't connect to the database
+func connectExitCode(command string) int {
 	switch command {
+	case "k8s-init":
+		return exitDatabaseUnreachable
+	case "check":
+		return exitCheckError
+	}
+	return 1
+}
+
+// runCheck prints a one line summary and returns the exit code: 0 when fully migrated,
+// 1 when migrations are pending, 2 on validation errors and 3 on other errors, e.g.
+// when the database or the files can't be read
+func runCheck(m *migrate.Migrator, conn driver.Conn) int {
+	version, pending, err := m.Pending(conn)
+	if err != nil {
+		if !isDrift(err) {
+			printError(err)
+			return exitCheckError
+		}
+		printMessage(msgCheckDrift, err)
+		return exitCheckDrift
+	}
+	if len(pending) > 0 {
+		printMessage(msgCheckPending, version, len(pending), pending[len(pending)-1].Version)
+		return exitCheckPending
+	}
+	printMessage(msgCheckOK, version)
+	return 0
+}
+
+// isDrift returns true for the errors of files that don't match the applied versions
+func isDrift(err error) bool {
+	for _, target := range []error{migrate.ErrBaseFilesDiffer, migrate.ErrVersionConflict, migrate.ErrOutOfOrder,
+		migrate.ErrVersionGap, migrate.ErrInvalidFiles, migrate.ErrAheadOfFiles, migrate.ErrUnmetRequirement} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// runDrift prints the versions whose stored files differ from '-path'
+func runDrift(m *migrate.Migrator, conn driver.Conn, jsonOutput bool) (drifted bool, err error) {
+	r, err := m.Drift(conn)
+	if err != nil {
+		return false, err
+	}
+	if jsonOutput {
+		return r.Drifted(), json.NewEncoder(os.Stdout).Encode(r)
+	}
+	for _, v := range r.Added {
+		printMessage(msgDriftAdded, v)
+	}
+	for _, v := range r.Removed {
+		printMessage(msgDriftRemoved, v)
+	}
+	for _, mv := range r.Modified {
+		var files []string
+		if mv.Up {
+			files = append(files, "upfile")
+		}
+		if mv.Down {
+			files = append(files, "downfile")
+		}
+		printMessage(msgDriftModified, mv.Version, strings.Join(files, " and "))
+	}
+	if !r.Drifted() {
+		printMessage(msgNoDrift)
+	}
+	return r.Drifted(), nil
+}
+
+// runRuns prints the last n runs of the audit log, 20 if n is empty
+func runRuns(m *migrate.Migrator, conn driver.Conn, n string, jsonOutput bool) error {
+	limit := 20
+	if n != "" {
+		var err error
+		if limit, err = strconv.Atoi(n); err != nil {
+			return newMessage(msgBadRelativeParam)
+		}
+	}
+	runs, err := m.Runs(conn, limit)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return json.NewEncoder(os.Stdout).Encode(runs)
+	}
+	for _, r := range runs {
+		var details string
+		if r.Error != "" {
+			details += text(msgRunError, r.Error)
+		}
+		if r.Snapshot != "" {
+			details += text(msgRunSnapshot, r.Snapshot)
+		}
+		printMessage(msgRun, r.StartedAt.Format(time.RFC3339), r.ID, r.Command,
+			r.FromVersion, r.ToVersion, r.Outcome, r.User, r.Host, details)
+	}
+	return nil
+}
+
+// runAmend opens the files of a version that hasn't been applied in $EDITOR
+func runAmend(m *migrate.Migrator, conn driver.Conn, v string) error {
+	version, err := file.ParseVersion(v)
+	if err != nil {
+		return newMessage(msgBadVersionParam, err)
+	}
+
+	// refuse to edit versions that have been applied
+	conns := map[string]driver.Conn{"database": conn}
+	if m.PrevConn != nil {
+		conns["-prev-url database"] = m.PrevConn
+	}
+	for name, c := range conns {
+		applied, err := m.IsApplied(c, version)
+		if err != nil {
+			return err
+		}
+		if applied {
+			return newMessage(msgAlreadyApplied, version, name)
+		}
+	}
+
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return err
+	}
+	mf, ok := files.Find(version)
+	if !ok {
+		return newMessage(msgVersionNotFound, version, m.Path)
+	}
+	var paths []string
+	for _, f := range []*file.File{mf.UpFile, mf.DownFile} {
+		if f != nil {
+			paths = append(paths, f.Path(m.Path))
+		}
+	}
+	if err = openEditor(paths...); err != nil {
+		return err
+	}
+
+	// make sure the edited files are still valid
+	files, err = m.MigrationFiles()
+	if err != nil {
+		return err
+	}
+	if missing := files.MissingVersion(); missing != nil {
+		return newMessage(msgMissingVersion, missing)
+	}
+	printMessage(msgAmended, version)
+	return nil
+}
+
+// runPlan prints the migrations that 'up', or 'goto <v>' if v is set, would apply
+func runPlan(m *migrate.Migrator, conn driver.Conn, v string) error {
+	var migrations file.Migrations
+	var err error
+	if v == "" {
+		migrations, err = m.Plan(conn)
+	} else {
+		var version file.Version
+		if version, err = m.ResolveVersion(v); err != nil {
+			return newMessage(msgBadVersionParam, err)
+		}
+		migrations, err = m.PlanTo(conn, version)
+	}
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		printMessage(msgNothingPlanned)
+	}
+	for _, mg := range migrations {
+		printFile(mg.File())
+	}
+	return nil
+}
+
+func runShow(m *migrate.Migrator, conn driver.Conn, v, dir string) error {
+	version, err := file.ParseVersion(v)
+	if err != nil {
+		return newMessage(msgBadVersionParam, err)
+	}
+	d := direction.Up
+	switch dir {
+	case "", "up":
+	case "down":
+		d = direction.Down
 	default:
+		return newMessage(msgBadDirectionParam, dir)
+	}
+
+	f, stored, err := m.StoredFile(conn, version, d)
+	if err != nil {
+		return err
+	}
+	source := "database"
+	if !stored {
+		source = m.Path
+	}
+	// the header goes to stderr, so the content can be redirected to a file
+	fprintMessage(os.Stderr, msgShowFile, f.FileName, source)
+	_, err = os.Stdout.Write(f.Content)
+	return err
+}
+
+// parseVars parses key=value args
+func parseVars(args []string) (map[string]string, error) {
+	vars := make(map[string]string, len(args))
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, newMessage(msgBadVar, arg)
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return vars, nil
+}
+
+func runExportScript(m *migrate.Migrator, conn driver.Conn, from, to, outFile string) (err error) {
+	if to == "" {
+		return newMessage(msgNoToVersion)
+	}
+	toVersion, err := file.ParseVersion(to)
+	if err != nil {
+		return newMessage(msgBadTo, err)
+	}
+	// default to the database version
+	var fromVersion file.Version
+	if from == "" {
+		fromVersion, err = m.Version(conn)
+	} else {
+		fromVersion, err = file.ParseVersion(from)
+	}
+	if err != nil {
+		return newMessage(msgBadFrom, err)
+	}
+
+	w := os.Stdout
+	if outFile != "" {
+		if w, err = os.Create(outFile); err != nil {
+			return err
+		}
+		defer func() {
+			if e := w.Close(); err == nil {
+				err = e
+			}
+		}()
+	}
+	return m.ExportScript(w, fromVersion, toVersion)
+}
+
+func runMigration(m *migrate.Migrator, conn driver.Conn, command, generate, stateOut string) {
+	timerStart := m.Now()
+	pipe := pipep.New()
+
+	var prevVersion file.Version
+	if stateOut != "" {
+		var err error
+		if prevVersion, err = m.Version(conn); err != nil {
+			printError(err)
+			exit(1)
+		}
+	}
+
+	if !isMigrationCommand(command) {
 		printHelp()
-		os.Exit(0)
+		exit(0)
+	}
+	if err := startMigration(m, conn, pipe, command, flag.Arg(1)); err != nil {
+		printError(err)
+		exit(1)
+	}
+
+	ok := writePipe(pipe)
+	printComplete(m, conn, timerStart)
+	if stateOut != "" {
+		if err := writeState(m, conn, prevVersion, ok, stateOut); err != nil {
+			printError(err)
+			exit(1)
+		}
+	}
+	if !ok {
+		exit(1)
+	}
 
+	// keep generated code in lockstep with the schema
+	if generate != "" && command == "up" {
+		if err := runGenerate(generate); err != nil {
+			color.New(color.FgRed).Printf("Generate command failed: %v\n", err)
+			exit(1)
+		}
+	}
+}
+
+// isMigrationCommand returns true for the commands handled by startMigration
+func isMigrationCommand(command string) bool {
+	switch command {
+	case "migrate", "up-by-one", "down-by-one", "between", "goto", "up", "down", "redo", "reset":
+		return true
+	}
+	return false
+}
+
+// startMigration starts the migration command, which writes to the pipe
+func startMigration(m *migrate.Migrator, conn driver.Conn, pipe chan interface{}, command, arg string) error {
+	m.Command = command
+	switch command {
 	case "migrate":
-		relativeN := flag.Arg(1)
-		relativeNInt, err := strconv.Atoi(relativeN)
+		relativeNInt, err := strconv.Atoi(arg)
 		if err != nil {
-			fmt.Println("Unable to parse param <n>.")
-			os.Exit(1)
+			return newMessage(msgBadRelativeParam)
+		}
+		go m.Migrate(pipe, conn, relativeNInt)
+	case "up-by-one", "down-by-one":
+		relativeNInt := +1
+		if command == "down-by-one" {
+			relativeNInt = -1
+		}
+		if err := printStep(m, conn, relativeNInt); err != nil {
+			return err
 		}
 		go m.Migrate(pipe, conn, relativeNInt)
 	case "between":
 		go m.MigrateBetween(pipe, conn)
 	case "goto":
-		toVersion, err := file.ParseVersion(flag.Arg(1))
+		toVersion, err := m.ResolveVersion(arg)
 		if err != nil {
-			fmt.Println("Unable to parse param <v>.", err)
-			os.Exit(1)
+			return newMessage(msgBadVersionParam, err)
 		}
 		go m.MigrateTo(pipe, conn, toVersion)
 	case "up":
@@ -193,13 +1136,36 @@ func runMigration(m *migrate.Migrator, conn driver.Conn, command string) {
 		go m.Redo(pipe, conn)
 	case "reset":
 		go m.Reset(pipe, conn)
+	default:
+		return newMessage(msgUnknownCommand, command)
 	}
+	return nil
+}
 
-	ok := writePipe(pipe)
-	printComplete(m, conn, timerStart)
-	if !ok {
-		os.Exit(1)
+// runGenerate runs the code generation command in a shell
+func runGenerate(generate string) error {
+	printMessage(msgRunningGenerate, generate)
+	cmd := exec.Command("sh", "-c", generate)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// printStep prints the current version and the direction of a single step migration
+func printStep(m *migrate.Migrator, conn driver.Conn, relativeN int) error {
+	v, err := m.Version(conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case relativeN > 0:
+		printMessage(msgStepUp, v)
+	case v.Major() == 0 && v.Minor() == 0: // the base version
+		printMessage(msgNoStepDown)
+	default:
+		printMessage(msgStepDown, v)
 	}
+	return nil
 }
 
 func writePipe(pipe chan interface{}) (ok bool) {
@@ -214,11 +1180,13 @@ func writePipe(pipe chan interface{}) (ok bool) {
 					switch item.(type) {
 
 					case string:
-						fmt.Println(item.(string))
+						if verbosity > quietLevel {
+							fmt.Println(redact(item.(string)))
+						}
 
 					case error:
 						c := color.New(color.FgRed)
-						c.Println(item.(error).Error())
+						c.Println(redact(item.(error).Error()))
 						okFlag = false
 
 					case *file.Migration:
@@ -226,10 +1194,23 @@ func writePipe(pipe chan interface{}) (ok bool) {
 						printFile(f.File())
 					case *file.File:
 						printFile(item.(*file.File))
+					case driver.Backfill:
+						if verbosity > quietLevel {
+							fmt.Println(item.(driver.Backfill).String())
+						}
+					case migrate.NotificationFailed:
+						printWarning(msgNotifyFailed, item.(migrate.NotificationFailed).Err)
+					case migrate.Retrying:
+						if verbosity > quietLevel {
+							r := item.(migrate.Retrying)
+							printMessage(msgRetrying, r.Wait, r.Err)
+						}
 
 					default:
-						text := fmt.Sprintf("%T: %v", item, item)
-						fmt.Println(text)
+						if verbosity > quietLevel {
+							text := fmt.Sprintf("%T: %v", item, item)
+							fmt.Println(text)
+						}
 					}
 				}
 			}
@@ -238,6 +1219,9 @@ func writePipe(pipe chan interface{}) (ok bool) {
 	return okFlag
 }
 func printFile(f *file.File) {
+	if verbosity == quietLevel {
+		return
+	}
 	var c *color.Color
 	var d string
 	switch f.Direction {
@@ -251,11 +1235,14 @@ func printFile(f *file.File) {
 		c = color.New(color.FgBlack)
 		d = "-"
 	}
-	if file.V2 {
+	if file.V2 && f.Version != nil {
 		c.Printf("%s %v/%s\n", d, f.MajorString(), f.FileName)
 	} else {
 		c.Printf("%s %s\n", d, f.FileName)
 	}
+	if verbosity >= verboseLevel && len(f.Content) > 0 {
+		fmt.Printf("%s\n", file.LinesBeforeAndAfter(f.Content, 0, 0, bytes.Count(f.Content, []byte("\n"))+1, true))
+	}
 }
 
 func printComplete(m *migrate.Migrator, conn driver.Conn, timerStart time.Time) {
@@ -268,7 +1255,7 @@ func printComplete(m *migrate.Migrator, conn driver.Conn, timerStart time.Time)
 	}
 
 	var duration string
-	diff := time.Now().Sub(timerStart).Seconds()
+	diff := m.Since(timerStart).Seconds()
 	if diff > 60 {
 		duration = fmt.Sprintf("%.4f minutes", diff/60)
 	} else {
@@ -289,22 +1276,303 @@ func printHelp() {
 		`usage: migrate [-path=<path>] -url=<url> <command> [<args>]
 
 Commands:
-   create <name>  Create a new migration
+   create <name> [<key>=<value>...]
+                  Create a new migration. The key/values are used by '-template'. Without '-down-sql',
+                  the down file is generated from '-up-sql', or from the up file after '-edit'.
+   expand-contract <name> table=<t> column=<c> type=<type> [<key>=<value>...]
+                  Create three migrations that rename column c to new_column=<n> or change its type
+                  without locking t: <name>_expand adds the new column and a trigger that keeps both
+                  in sync, <name>_backfill fills the existing rows and <name>_contract drops the old
+                  column once the application uses the new one. Optional keys: old_type, using and
+                  reverse (the expressions that convert a value to the new column and back), not_null.
+   gen-down       Write the down files that are empty with the inverse of their up files'
+                  CREATE and ALTER TABLE ADD statements, and list the statements it can't invert
    up             Apply all -up- migrations
    down           Apply all -down- migrations
    reset          Down followed by Up
    redo           Roll back most recent migration, then apply it again
    version        Show current migration version
    migrate <n>    Apply migrations -n|+n
-   goto <v>       Migrate to version v
+   up-by-one      Apply the next migration. Same as 'migrate +1'
+   down-by-one    Roll back the current migration. Same as 'migrate -1'
+   goto <v>       Migrate to version v or to the version of the migration named v
    between        Migrates between '-path' and prev files stored in db
+   watch          Watch '-path' and run 'between' each time a file changes. For development databases.
+   dev            Like 'watch', but drops the schema and migrates it from scratch when an applied
+                  file is edited. For local databases.
+   check          Exit 0 if fully migrated, 1 if migrations are pending, 2 on validation errors
+                  or 3 if the database or the files can't be read
+   lint [<file>...]
+                  Check the files, or the up files in '-path', for table rewrites, non-concurrent
+                  indexes, DROP TABLE without IF EXISTS, missing lock timeouts and volatile defaults.
+                  Doesn't need '-url'; with it, volatile defaults are only flagged on tables with
+                  100000 rows or more. Exits 1 if a finding is an error. Prints json with '-json'.
+   check-sync [<url>...]
+                  Compare the version of '-url' and each <url> to '-to' or the last version in '-path'.
+                  Exits 1 if any database isn't at the target version.
+   upgrade-matrix <dir>...
+                  For each release dir of migration files, migrate a fresh <schema>_upgrade to it
+                  and then to '-path'. Exits 1 if any upgrade path fails.
+   verify         Validate that '-path' matches the prev files stored in db
+   drift          Report the applied versions whose stored up or down files differ from '-path' or
+                  were removed, and the versions older than the database version that weren't applied.
+                  Exits 1 on drift. Prints json with '-json'.
+   repair         Rewrite the stored files of the applied versions that were modified in '-path', without
+                  applying them. For edits that don't change the schema, e.g. whitespace or comments.
+   amend <v>      Edit the files of version v in $EDITOR if it hasn't been applied
+   plan [<v>]     Print the migrations 'up', or 'goto <v>', would apply without applying them
+   show <v> [up|down]
+                  Print the up (default) or down file stored in db for version v
+   export-script  Write the migrations between '-from' and '-to' to a single sql script
+   dump           Dump the schema migrations and table data to '-dump'
+   restore        Restore the schema migrations and table data from '-dump'
+   batch          Run the commands read from stdin, one per line, over one connection.
+                  Stops at the first command that fails. Supports the migration commands,
+                  'version', 'dump [<path>]' and 'restore [<path>]'.
+   backfill status
+                  Show the percent complete of the backfills run with migrate.Backfill
+   export-stats   Write the planner statistics of the tables, without any values, to '-out' as json
+   export-schema  Write the tables, columns, indexes and constraints of the schema to '-out' as json
+   diff-schema <url|schema-file> [<name>]
+                  Generate a draft up and down migration from the schema to the schema of the database
+                  url, e.g. where a change was prototyped, or of a file written by export-schema.
+                  Creates the migration files in '-path' with a name, prints them otherwise.
+   simulate <stats-file>
+                  Migrate a scratch database up, import the exported statistics (needs a superuser)
+                  and print the plan of each query read from stdin, separated by semicolons
+   import-history <table> [<v>]
+                  Record the migration files up to v, or the last version applied according to another
+                  tool's version table (Flyway or golang-migrate), as applied without running them
+   mark-applied <v>...
+                  Record the next pending versions as applied without running them,
+                  e.g. after applying them by hand
+   mark-reverted <v>...
+                  Remove the last applied versions from the version table without running
+                  their down files
+   squash <v>     Replace the applied files up to v with one baseline file and move them to
+                  '-path'/.squashed. Run it once per database: after the files are squashed, it only
+                  rewrites the version table. Databases before v can't be migrated past it.
+   bench [<migrations>] [<rows>]
+                  Measure migrations/s, dump MB/s and restore rows/s in a <schema>_bench schema,
+                  with generated migrations (default 100) and rows (default 100000)
+   runs [<n>]     Print the last n (default 20) runs recorded in the audit log with '-audit'.
+   rollback-to-snapshot [<snapshot>]
+                  Drop the schema and restore it from the snapshot, or the latest snapshot of the
+                  audit log, e.g. after a run failed midway, after committing the major versions
+                  before the one that failed. Needs '-snapshot-dir'. The audit log is kept.
+                  Prints json with '-json'.
+   k8s-init       Migrate like 'between' in a Kubernetes init container: never prompts, logs json
+                  lines, waits for the database ('-wait-timeout', default 5m) and the lock of another
+                  replica ('-lock-timeout', default 10m). Exits 1 when a migration fails, 2 when the
+                  database is unreachable and 3 when the lock timed out.
+   serve          Serve an admin api on '-listen' (default :8085). Requests need an
+                  'Authorization: Bearer <token>' header with '-token' (default $MIGRATE_API_TOKEN).
+                  GET /status, /plan, /pending, /history, /drift and /ready. POST /up,
+                  /down-to?to=<v>, and /dump and /restore with '-dump'. With '-dashboard', / serves
+                  a web page to review and apply or roll back the migrations.
+   force-unlock   Release the migration lock held by another migrator, e.g. one that hung
+   fix-sequences  Set the serial and identity sequences to the max value of their column. 'restore' does this automatically.
+   restore-rotate Restore '-dump' into <schema>_tmp, migrate it, then rotate it to live and live to <schema>_bak
+   revert-schema  Migrate <schema>_bak, then rotate it back to live and live to <schema>_tmp
+   drop-rotate    Recreate and migrate <schema>_tmp, then rotate it to live and live to <schema>_bak
    help           Show this help
 
 '-version'  Print version then exit.
-'-path'     Defaults to ./schema.
+'-path'     Defaults to ./schema. Can be a s3://bucket/prefix, gs://bucket/prefix or https:// url to read
+            the migration files from. The aws and gcloud clis are used for buckets, and a url must
+            list the files, one per line, in <url>/manifest.txt. 'create' needs a local path.
 '-perfile'  Per file transaction. Defaults to one transaction per major version.
+'-continue-on-error'
+            Roll back only the file that fails and commit the files before it in its major
+            version's transaction. The migration still stops at the failed file.
+'-skip-content'
+            Store only the versions and checksums of the migration files, not their contents.
+            Down migrations read the down files from '-path' instead.
+'-audit'    Record each migration run, including failed ones, in the <table>_log table.
+'-snapshot-dir'
+            Dump the schema to a new snapshot in the dir, in '-dump-format', before each run that
+            applies migrations. With '-audit' the snapshot is recorded with the run, for
+            'rollback-to-snapshot'. Defaults to $MIGRATE_SNAPSHOT_DIR.
+'-notify-url'
+            POST a json summary of each migration run, including failed ones, to the url, e.g. a
+            chat webhook. Defaults to $MIGRATE_NOTIFY_URL.
+'-notify-cmd'
+            Run the command with sh after each migration run, with the json summary on stdin and
+            $MIGRATE_OUTCOME, $MIGRATE_FROM_VERSION and $MIGRATE_TO_VERSION set.
+'-allow-out-of-order'
+            Apply the files with a version lower than the database's version that aren't applied,
+            e.g. merged late from another branch, instead of failing.
+'-parallel' Apply consecutive major versions that don't depend on each other concurrently.
+            A major version dir can list the major versions it depends on in a 'depends' file.
+            Requires '-v2'. Ignored with '-perfile' and '-continue-on-error'.
+'-render'   Render the migration files as Go templates before applying them, with {{ .Schema }},
+            {{ .Env "NAME" }}, {{ .Vars.name }} and {{ .Version }}.
+'-vars'     Comma separated key=value pairs for {{ .Vars }}. Applies to '-render'.
+'-checksum-rendered'
+            Store and validate the rendered files instead of the templates. Applies to '-render'.
+'-statements'
+            Run the migration files one statement at a time and report which statement failed
+            and how many succeeded. For databases that can't run multiple statements at once.
+'-state-out' Write the final version, previous version and the checksums of the applied
+            migrations to a json file after migrating. For infrastructure-as-code tools.
+'-generate' Command to run after a successful 'up', e.g. 'sqlc generate'.
+            The run fails if the command fails. Defaults to $MIGRATE_GENERATE.
 '-major'    Increment major version. Applies to 'create' command.
+'-up-sql'   Content of the up file. Applies to 'create' command.
+'-down-sql' Content of the down file. Applies to 'create' command.
+'-template' Render the new files from a template in '-path'/.templates or a built-in template:
+            create-table (table), add-column (table, column, type), create-index (table, column).
+            Applies to 'create' command.
+'-edit'     Open the new files in $EDITOR. Applies to 'create' command.
 '-force'    Skips validation. Applies to 'between' command.
+'-prev-url' Read migration files from the version table of another database instead of '-path'.
+            Applies to 'between' and 'verify' commands.
+'-from'     Version to export from. Defaults to the database version. Applies to 'export-script' command.
+'-to'       Version to export to. Applies to 'export-script' and 'check-sync' commands.
+'-out'      File to write to. Defaults to stdout. Applies to 'export-script', 'export-stats', 'export-schema' and 'diff-schema' commands.
+'-dump'     Dump dir or archive file. Defaults to ./dump, or ./dump.<format> for archives.
+            Can be a s3://bucket/prefix url, uploaded and downloaded with the aws cli. Its query
+            sets the cli's options: profile, region, endpoint and expected_size.
+            Can be a gs://bucket/prefix url, uploaded and downloaded with the gcloud cli. Its query
+            sets impersonate_service_account, and part_size to upload tables in composed parts.
+            Can be an azblob://account/container/prefix url of Azure Blob Storage, authorized with
+            the SAS token in $AZURE_STORAGE_SAS_TOKEN. Its query sets block_size, and endpoint
+            instead of https://<account>.blob.core.windows.net.
+            Can be - to stream the dump to stdout or restore it from stdin as a tar, or a tar.gz
+            with '-dump-format tar.gz'. The output goes to stderr then.
+'-dump-format'
+            Format of the dump: dir, zip, tar or tar.gz. Defaults to dir. The tar formats
+            are streamed without a tmp file, and the files over 8 MB are written as
+            numbered chunks, e.g. tables/t1.0000 and tables/t1.0001.
+'-dump-base'
+            Dump only the tables whose rows changed since the dump at the path or url, by hash.
+            The manifest records the base for the other tables, and restores read them from
+            it, so it must stay at its location. The base can be incremental too. Bases that
+            weren't dumped with '-dump-base' have no hashes, so all their tables are dumped.
+'-dump-base-migrations'
+            With '-dump-base', dump only the tables touched by the migrations after the base's
+            schema version instead, for databases whose data only changes through migrations.
+'-dump-schema-only'
+            Dump only the schema migrations, or restore only them by migrating up, without
+            the table data.
+'-dump-data-only'
+            Dump only the table data, or restore only it into a schema that's already migrated.
+            The schema must be at the dump's schema version, and '-force' doesn't drop it.
+'-dump-jobs'
+            Dump or restore up to this many tables at once, each over its own connection.
+            Defaults to 1. The tables of archives and streams are written to tmp files first,
+            and the tables of tar archives are read into tmp files first. Restores wait for
+            the tables that a table's foreign keys reference. Restores from stdin restore one
+            table at a time.
+'-dump-snapshot'
+            Dump all the tables as of the same moment, in one snapshot shared by the
+            connections of '-dump-jobs'.
+'-table'    Version table name. Defaults to $MIGRATE_TABLE or schema_migrations.
+'-lock-timeout'
+            How long to wait for another migrator's lock on the schema. Defaults to 0, wait until released.
+'-no-lock'  Don't lock the schema while migrating.
+'-wait-timeout'
+            Retry connecting, with exponential backoff, until the database is reachable or this
+            long has passed. Defaults to 0, fail on the first error.
+'-isolation'
+            Isolation level of the migration transactions: read-committed, repeatable-read or
+            serializable. Defaults to the database's default.
+'-read-write'
+            Assert that the migration transactions can write, so they fail on a read-only standby.
+'-retries'  Retry the transaction of a migration that fails with a serialization failure or deadlock
+            up to this many times. Defaults to 0.
+'-retry-backoff'
+            Wait before the first retry, doubled for each next retry. Defaults to 100ms.
+'-migration-timeout'
+            Cancel a migration file, or each statement with '-statements', that runs longer and
+            roll back its transaction. Defaults to 0, no timeout.
+'-watch-interval'
+            How often 'watch' checks for changes. Defaults to 1s.
+'-lint-rules'
+            Severity of the 'lint' rules, e.g. missing-lock-timeout=off,create-index-not-concurrently=error.
+            The severities are off, warning and error.
+'-json'     Print 'version' as json with the latest file version, the number of pending migrations
+            and whether the stored files match '-path'. Messages and errors are printed as json
+            lines with a stable 'id'. Set MIGRATE_LANG to choose the message language.
+'-quiet'    Only print errors and the final schema version.
+'-verbose'  Also print the content of each migration file.
+            Set the NO_COLOR environment variable to disable colored output.
+'-url'      Connection url. Use '-url=-' to read it from stdin.
+'-prompt'   Prompt for the password instead of including it in '-url'.
+'-env-file' Load environment variables from file. Defaults to .env, if it exists.
+            Existing environment variables are not overwritten.
+            ${VAR} references in '-url', '-prev-url' and '-path' are expanded.
+            ${env:VAR}, ${file:path}, ${aws-sm:secret-id#field} and ${vault:path#field}
+            secret references in '-url' and '-prev-url' are resolved. Other $ characters in
+            '-url' and '-prev-url' are kept, e.g. in a password like pa$$word.
+'-secret-ttl' How long resolved secrets are cached before new connections resolve them again.
+            Defaults to 5m.
+'-strict'   Fail instead of warning when files in '-path' can't be parsed as migration files,
+            e.g. misspelled extensions like '.up.slq'.
+'-ignore'   Comma separated patterns of more file names in '-path' to skip without a warning,
+            e.g. 'NOTES*,*.txt'. README*, LICENSE* and *.md files are always skipped.
 '-v2'       Use version 2 which enables major versions. Warning: once you switch you can't go back.
 `)
 }
+
+// runImportHistory records the migration files as applied up to v or the other tool's last version
+func runImportHistory(m *migrate.Migrator, conn driver.Conn, table, v string) error {
+	if table == "" {
+		return newMessage(msgNoHistoryTable)
+	}
+	var upTo file.Version
+	if v != "" {
+		var err error
+		if upTo, err = m.ResolveVersion(v); err != nil {
+			return newMessage(msgBadVersionParam, err)
+		}
+	}
+	imported, err := m.ImportHistory(conn, table, upTo)
+	if err != nil {
+		return err
+	}
+	for _, mg := range imported {
+		printMessage(msgImported, mg.File().FileName)
+	}
+	return nil
+}
+
+// runMark records the versions as applied or reverted without running them
+func runMark(m *migrate.Migrator, conn driver.Conn, applied bool, args []string) error {
+	if len(args) == 0 {
+		return newMessage(msgNoVersions)
+	}
+	versions := make([]file.Version, len(args))
+	for i, arg := range args {
+		var err error
+		if versions[i], err = m.ResolveVersion(arg); err != nil {
+			return newMessage(msgBadVersionParam, err)
+		}
+	}
+	mark, id := m.MarkApplied, msgImported
+	if !applied {
+		mark, id = m.MarkReverted, msgMarkedReverted
+	}
+	marked, err := mark(conn, versions...)
+	if err != nil {
+		return err
+	}
+	for _, mg := range marked {
+		printMessage(id, mg.File().FileName)
+	}
+	return nil
+}
+
+// runSquash replaces the applied files up to the version with a baseline
+func runSquash(m *migrate.Migrator, conn driver.Conn, v string) error {
+	version, err := m.ResolveVersion(v)
+	if err != nil {
+		return newMessage(msgBadVersionParam, err)
+	}
+	baseline, err := m.Squash(conn, version)
+	if err != nil {
+		return err
+	}
+	printMessage(msgSquashed, version, baseline.UpFile.FileName)
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	mpgx "github.com/acls/migrate/driver/pgx"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// fakeRotator fails each workflow with err
+type fakeRotator struct {
+	err      error
+	restored bool
+}
+
+func (r *fakeRotator) Restore(dr file.DumpReader) error {
+	r.restored = true
+	return r.err
+}
+func (r *fakeRotator) Revert() error { return r.err }
+func (r *fakeRotator) Drop() error   { return r.err }
+
+func TestRotateErrors(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("/tmp", "migrate-TestRotateErrors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	if err := ioutil.WriteFile(path.Join(dumpDir, "schema.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	for _, command := range []string{"restore-rotate", "revert-schema", "drop-rotate"} {
+		sm := &fakeRotator{err: failed}
+		if err := rotate(sm, dumpDir, dumpFormatDir, command); err != failed {
+			t.Fatalf("Expected %s to return the error, got %v", command, err)
+		}
+		if command == "restore-rotate" && !sm.restored {
+			t.Fatal("Expected the dump to be restored")
+		}
+	}
+
+	sm := &fakeRotator{}
+	if err := rotate(sm, dumpDir, dumpFormatDir, "restore-rotate"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeConn is a connection for the requests that fail before using it
+type fakeConn struct {
+	driver.Conn
+}
+
+func (fakeConn) Close() error { return nil }
+
+func TestAdminHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "migrate-TestAdminHandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	refused := errors.New("connection refused")
+	connErr := error(nil)
+	m := &migrate.Migrator{
+		Driver:  mpgx.New("schema_migrations"),
+		Path:    dir,
+		NewConn: func() (driver.Conn, error) { return fakeConn{}, connErr },
+	}
+	h := adminHandler(m, "token", dir, dumpFormatDir, false)
+	request := func(method, target, auth string) int {
+		r := httptest.NewRequest(method, target, nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	cases := []struct {
+		method, target, auth string
+		code                 int
+	}{
+		{"GET", "/status", "", http.StatusUnauthorized},
+		{"GET", "/status", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/status", "token", http.StatusUnauthorized},
+		{"GET", "/status", "Basic dG9rZW4=", http.StatusUnauthorized},
+		{"POST", "/up", "", http.StatusUnauthorized},
+		{"GET", "/up", "Bearer token", http.StatusMethodNotAllowed},
+		{"POST", "/status", "Bearer token", http.StatusMethodNotAllowed},
+		{"POST", "/down-to?to=nope", "Bearer token", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if code := request(c.method, c.target, c.auth); code != c.code {
+			t.Errorf("Expected %d for %s %s with %q, got %d", c.code, c.method, c.target, c.auth, code)
+		}
+	}
+
+	// the database is down
+	connErr = refused
+	if code := request("GET", "/status", "Bearer token"); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected %d without a connection, got %d", http.StatusServiceUnavailable, code)
+	}
+	// /ready doesn't need the token
+	if code := request("GET", "/ready", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /ready to be unauthenticated, got %d", code)
+	}
+}
+
+func TestAPIStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{newMessage(msgBadVersionParam, "nope"), http.StatusBadRequest},
+		{newMessage(msgNotDown, "2", "1"), http.StatusBadRequest},
+		{fmt.Errorf("%w: schema public", migrate.ErrLocked), http.StatusConflict},
+		{newMessage(msgBatchNoCopy), http.StatusInternalServerError},
+		{errors.New("failed"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		if code := apiStatus(c.err); code != c.code {
+			t.Errorf("Expected %d for %v, got %d", c.code, c.err, code)
+		}
+	}
+}
+
+func TestFprintMessage(t *testing.T) {
+	defer func(json bool) { jsonMessages = json }(jsonMessages)
+	var b bytes.Buffer
+	jsonMessages = false
+	fprintMessage(&b, msgShowFile, "001_users.up.sql", "database")
+	jsonMessages = true
+	fprintMessage(&b, msgShowFile, "001_users.up.sql", "database")
+	expected := "-- 001_users.up.sql from database\n" +
+		`{"level":"info","id":"show_file","message":"-- 001_users.up.sql from database"}` + "\n"
+	if b.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestCheckExitCodes(t *testing.T) {
+	m := &migrate.Migrator{
+		Driver:  mpgx.New("schema_migrations"),
+		NewConn: func() (driver.Conn, error) { return nil, errors.New("connection refused") },
+	}
+	if code := runCheck(m, nil); code != exitCheckError {
+		t.Fatalf("Expected %d without a connection, got %d", exitCheckError, code)
+	}
+	if code := connectExitCode("check"); code != exitCheckError {
+		t.Fatalf("Expected %d when check can't connect, got %d", exitCheckError, code)
+	}
+	if !isDrift(fmt.Errorf("%w: 1", migrate.ErrBaseFilesDiffer)) || isDrift(errors.New("connection refused")) {
+		t.Fatal("Expected only validation errors to be drift")
+	}
+}
+
+// searchPathDriver fails to set the search path, e.g. when the connection is lost
+type searchPathDriver struct {
+	driver.DumpDriver
+	err     error
+	deleted bool
+}
+
+func (d *searchPathDriver) SearchPath(conn driver.Conn, searchPath string) (func() error, error) {
+	return nil, d.err
+}
+func (d *searchPathDriver) DeleteSchema(db driver.Execer, schema string) error {
+	d.deleted = true
+	return nil
+}
+
+func TestWatchRecreateError(t *testing.T) {
+	d := &searchPathDriver{err: errors.New("connection refused")}
+	m := &migrate.Migrator{Driver: d, Path: t.TempDir()}
+	if err := ioutil.WriteFile(path.Join(m.Path, "0001_users.up.sql"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runWatch(m, fakeConn{}, time.Millisecond, true); err != d.err {
+		t.Fatalf("Expected the verify error, got %v", err)
+	}
+	if d.deleted {
+		t.Fatal("Expected the schema to be kept")
+	}
+}
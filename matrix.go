@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+)
+
+// upgradeSchemaSuffix is appended to -schema for the schema used to test upgrade paths
+const upgradeSchemaSuffix = "_upgrade"
+
+// runUpgradeMatrix applies each release's migration files to a fresh schema and then
+// upgrades it to the files in -path, printing the result of each upgrade path.
+// Returns false if any upgrade path fails.
+func runUpgradeMatrix(m *migrate.Migrator, conn driver.Conn, releases []string) (bool, error) {
+	if len(releases) == 0 {
+		return false, newMessage(msgNoReleases)
+	}
+	dd, ok := m.Driver.(driver.DumpDriver)
+	if !ok {
+		return false, newMessage(msgCantDeleteSchemas)
+	}
+
+	ok = true
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RELEASE\tFROM\tTO\tSTATUS")
+	for _, release := range releases {
+		from, to, status := upgradePath(m, dd, conn, release)
+		if status != "ok" {
+			ok = false
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", release, from, to, status)
+	}
+	return ok, w.Flush()
+}
+
+// upgradePath migrates a fresh schema to the release, then to -path
+func upgradePath(m *migrate.Migrator, dd driver.DumpDriver, conn driver.Conn, release string) (from, to, status string) {
+	from, to = "-", "-"
+
+	rm := *m
+	rm.Schema = m.Schema + upgradeSchemaSuffix
+	rm.PrevConn = nil
+	rm.Parallel = false
+	rm.Interrupts = false
+	if err := dd.DeleteSchema(conn, rm.Schema); err != nil {
+		return from, to, "error: " + err.Error()
+	}
+	defer dd.DeleteSchema(conn, rm.Schema)
+
+	rm.Path = release
+	_, version, errs := rm.MigrateBetweenSync(conn)
+	if len(errs) > 0 {
+		return from, to, "release failed: " + joinErrors(errs)
+	}
+	from = version.String()
+
+	rm.Path = m.Path
+	_, version, errs = rm.MigrateBetweenSync(conn)
+	if len(errs) > 0 {
+		return from, to, "upgrade failed: " + joinErrors(errs)
+	}
+	to = version.String()
+	return from, to, "ok"
+}
+
+// releaseDirs expands globs, e.g. ./releases/*
+func releaseDirs(args []string) ([]string, error) {
+	var dirs []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, newMessage(msgReleaseNotFound, arg)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+	return dirs, nil
+}
+
+func joinErrors(errs []error) string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = strings.Replace(err.Error(), "\n", " ", -1)
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/secret"
+)
+
+// msgID is the stable id of a user facing message. Ids never change, so wrapper
+// tools can match on them, in -json mode, instead of the text.
+type msgID string
+
+const (
+	msgError             msgID = "error"
+	msgNoURL             msgID = "no_url"
+	msgNoName            msgID = "no_name"
+	msgCreated           msgID = "created"
+	msgBaseFilesMatch    msgID = "base_files_match"
+	msgNoDumpPath        msgID = "no_dump_path"
+	msgDumpNotEmpty      msgID = "dump_not_empty"
+	msgDumpEmpty         msgID = "dump_empty"
+	msgUnknownDumpFormat msgID = "unknown_dump_format"
+	msgCheckDrift        msgID = "check_drift"
+	msgCheckPending      msgID = "check_pending"
+	msgCheckOK           msgID = "check_ok"
+	msgBadVersionParam   msgID = "bad_version_param"
+	msgBadRelativeParam  msgID = "bad_relative_param"
+	msgBadDirectionParam msgID = "bad_direction_param"
+	msgAlreadyApplied    msgID = "already_applied"
+	msgVersionNotFound   msgID = "version_not_found"
+	msgMissingVersion    msgID = "missing_version"
+	msgAmended           msgID = "amended"
+	msgBadVar            msgID = "bad_var"
+	msgNoToVersion       msgID = "no_to_version"
+	msgBadTo             msgID = "bad_to"
+	msgBadFrom           msgID = "bad_from"
+	msgUnknownCommand    msgID = "unknown_command"
+	msgRunningGenerate   msgID = "running_generate"
+	msgUnknownBackfill   msgID = "unknown_backfill"
+	msgUnknownBatch      msgID = "unknown_batch"
+	msgStepUp            msgID = "step_up"
+	msgStepDown          msgID = "step_down"
+	msgNoStepDown        msgID = "no_step_down"
+	msgNoCheckURLs       msgID = "no_check_urls"
+	msgNoReleases        msgID = "no_releases"
+	msgReleaseNotFound   msgID = "release_not_found"
+	msgCantDeleteSchemas msgID = "cant_delete_schemas"
+	msgRotated           msgID = "rotated"
+	msgWatchChanges      msgID = "watch_changes"
+	msgWatching          msgID = "watching"
+	msgBatchCommand      msgID = "batch_command"
+	msgBatchFailed       msgID = "batch_failed"
+	msgBatchNoCopy       msgID = "batch_no_copy"
+	msgCommandFailed     msgID = "command_failed"
+	msgNoAPIToken        msgID = "no_api_token"
+	msgServing           msgID = "serving"
+	msgNotDown           msgID = "not_down"
+	msgK8sMigrating      msgID = "k8s_migrating"
+	msgK8sFile           msgID = "k8s_file"
+	msgK8sLog            msgID = "k8s_log"
+	msgK8sMigrated       msgID = "k8s_migrated"
+	msgNoDiffTarget      msgID = "no_diff_target"
+	msgSchemasMatch      msgID = "schemas_match"
+	msgIrreversible      msgID = "irreversible"
+	msgGeneratedDown     msgID = "generated_down"
+	msgNoLintFindings    msgID = "no_lint_findings"
+	msgNoSnapshotDir     msgID = "no_snapshot_dir"
+	msgReadURL           msgID = "read_url"
+	msgEmptyURL          msgID = "empty_url"
+	msgReadPassword      msgID = "read_password"
+	msgEnvSyntax         msgID = "env_syntax"
+	msgNoStatsFile       msgID = "no_stats_file"
+	msgExplainFailed     msgID = "explain_failed"
+	msgDevRecreate       msgID = "dev_recreate"
+	msgBadBenchParam     msgID = "bad_bench_param"
+	msgNoHistoryTable    msgID = "no_history_table"
+	msgImported          msgID = "imported"
+	msgNothingPlanned    msgID = "nothing_planned"
+	msgNoVersions        msgID = "no_versions"
+	msgMarkedReverted    msgID = "marked_reverted"
+	msgNoDrift           msgID = "no_drift"
+	msgDriftAdded        msgID = "drift_added"
+	msgDriftRemoved      msgID = "drift_removed"
+	msgDriftModified     msgID = "drift_modified"
+	msgRepaired          msgID = "repaired"
+	msgSquashed          msgID = "squashed"
+	msgNoBaseManifest    msgID = "no_base_manifest"
+	msgStreamBase        msgID = "stream_base"
+	msgDumpContents      msgID = "dump_contents"
+	msgFileSkipped       msgID = "file_skipped"
+	msgFileNamesDiffer   msgID = "file_names_differ"
+	msgCreatedFile       msgID = "created_file"
+	msgBenchResult       msgID = "bench_result"
+	msgExplainPlan       msgID = "explain_plan"
+	msgFixedSequence     msgID = "fixed_sequence"
+	msgRun               msgID = "run"
+	msgRunError          msgID = "run_error"
+	msgRunSnapshot       msgID = "run_snapshot"
+	msgShowFile          msgID = "show_file"
+	msgNotifyFailed      msgID = "notify_failed"
+	msgRetrying          msgID = "retrying"
+)
+
+// catalog holds the message formats per language. Messages missing from a
+// language fall back to English.
+var catalog = map[string]map[msgID]string{
+	"en": {
+		msgError:             "%v",
+		msgNoURL:             "No url",
+		msgNoName:            "Please specify name.",
+		msgCreated:           "Create version %s/%v migration files",
+		msgBaseFilesMatch:    "Base files match",
+		msgNoDumpPath:        "Please specify an output directory or file to dump to/from (-dump=)",
+		msgDumpNotEmpty:      "Dump dir or file must be empty or -force must be set",
+		msgDumpEmpty:         "Can't restore empty dump dir or missing file",
+		msgUnknownDumpFormat: "Unknown dump format '%s'",
+		msgCheckDrift:        "drift: %v",
+		msgCheckPending:      "pending: version %v, %d pending migrations to %v",
+		msgCheckOK:           "ok: version %v",
+		msgBadVersionParam:   "Unable to parse param <v>. %v",
+		msgBadRelativeParam:  "Unable to parse param <n>.",
+		msgBadDirectionParam: "Unable to parse param [up|down]: %s",
+		msgAlreadyApplied:    "Version %v has already been applied to the %s. Create a new migration instead.",
+		msgVersionNotFound:   "Version %v not found in %s",
+		msgMissingVersion:    "Missing version: %v",
+		msgAmended:           "Amended version %v",
+		msgBadVar:            "Expected key=value, got '%s'",
+		msgNoToVersion:       "Please specify a version to export to (-to=)",
+		msgBadTo:             "Unable to parse -to: %v",
+		msgBadFrom:           "Unable to parse -from: %v",
+		msgUnknownCommand:    "Unknown command '%s'",
+		msgRunningGenerate:   "\nRunning: %s",
+		msgUnknownBackfill:   "Unknown backfill command '%s'",
+		msgUnknownBatch:      "Unknown batch command '%s'",
+		msgStepUp:            "Applying next migration after version %v",
+		msgStepDown:          "Rolling back version %v",
+		msgNoStepDown:        "No version to roll back",
+		msgNoCheckURLs:       "Please specify the database urls to check",
+		msgNoReleases:        "Please specify the release migration dirs to upgrade from",
+		msgReleaseNotFound:   "Release dir '%s' not found",
+		msgCantDeleteSchemas: "Driver can't delete schemas",
+		msgRotated:           "%s: rotated schema %s in %.4f seconds",
+		msgWatchChanges:      "\n%s: changes detected in %s",
+		msgWatching:          "Watching %s for changes ...",
+		msgBatchCommand:      "> %s",
+		msgBatchFailed:       "Line %d '%s' failed: %v",
+		msgBatchNoCopy:       "Connection doesn't support dump and restore",
+		msgCommandFailed:     "Command failed",
+		msgNoAPIToken:        "Please specify an api token (-token= or $MIGRATE_API_TOKEN)",
+		msgServing:           "Serving the admin api on %s ...",
+		msgNotDown:           "Version %v is higher than the current version %v",
+		msgK8sMigrating:      "Migrating schema %s",
+		msgK8sFile:           "Applied %s file %s",
+		msgK8sLog:            "%s",
+		msgK8sMigrated:       "Migrated to version %v in %.4f seconds",
+		msgNoDiffTarget:      "Please specify the database url or exported schema file to diff with",
+		msgSchemasMatch:      "The schemas' tables, columns, indexes and constraints match",
+		msgIrreversible:      "%s: write the down statement by hand for: %s",
+		msgGeneratedDown:     "Generated %s",
+		msgNoLintFindings:    "No lint findings",
+		msgNoSnapshotDir:     "Please specify the snapshot dir (-snapshot-dir= or $MIGRATE_SNAPSHOT_DIR)",
+		msgReadURL:           "Unable to read url from stdin: %v",
+		msgEmptyURL:          "Empty url read from stdin",
+		msgReadPassword:      "Unable to read password: %v",
+		msgEnvSyntax:         "%s:%d: expected KEY=VALUE",
+		msgNoStatsFile:       "Please specify the stats snapshot file to simulate with",
+		msgExplainFailed:     "Unable to explain '%s': %v",
+		msgDevRecreate:       "Applied files changed, recreating schema %s",
+		msgBadBenchParam:     "Unable to parse param '%s', expected a number",
+		msgNoHistoryTable:    "Please specify the version table of the other tool",
+		msgImported:          "Recorded %s as applied",
+		msgNothingPlanned:    "No migrations to apply",
+		msgNoVersions:        "Please specify the versions.",
+		msgMarkedReverted:    "Recorded %s as reverted",
+		msgNoDrift:           "Stored files match",
+		msgDriftAdded:        "added: %v isn't applied, but is older than the database version",
+		msgDriftRemoved:      "removed: %v is applied, but has no files",
+		msgDriftModified:     "modified: %v %s differs",
+		msgRepaired:          "Rewrote the stored files of %v",
+		msgSquashed:          "Squashed the versions up to %v into %s",
+		msgNoBaseManifest:    "The base dump %s has no manifest, dump it again to use it as a base",
+		msgStreamBase:        "A dump streamed from stdin can't be a base dump",
+		msgDumpContents:      "Only one of -dump-schema-only and -dump-data-only can be set",
+		msgFileSkipped:       "Warning: skipped '%s': %v",
+		msgFileNamesDiffer:   "Warning: '%s': %v",
+		msgCreatedFile:       "%s",
+		msgBenchResult:       "%v",
+		msgExplainPlan:       "%s\n\n%s\n",
+		msgFixedSequence:     "%s",
+		msgRun:               "%s  %s  %-8s %s -> %s  %s  %s@%s%s",
+		msgRunError:          "  %s",
+		msgRunSnapshot:       "  snapshot %s",
+		msgShowFile:          "-- %s from %s",
+		msgNotifyFailed:      "Notification failed: %v",
+		msgRetrying:          "Retrying after %v: %v",
+	},
+}
+
+// jsonMessages prints messages as json lines with their ids
+var jsonMessages bool
+
+// language is the catalog language, from $MIGRATE_LANG or $LANG. E.g. de_DE.UTF-8 is de.
+func language() string {
+	lang := os.Getenv("MIGRATE_LANG")
+	if lang == "" {
+		lang = os.Getenv("LANG")
+	}
+	if i := strings.IndexAny(lang, "_.@"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// text formats the message in the current language
+func text(id msgID, args ...interface{}) string {
+	format, ok := catalog[language()][id]
+	if !ok {
+		format = catalog["en"][id]
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// message is an error with a message id
+type message struct {
+	id   msgID
+	args []interface{}
+}
+
+func newMessage(id msgID, args ...interface{}) error {
+	return &message{id: id, args: args}
+}
+
+func (m *message) Error() string {
+	return text(m.id, m.args...)
+}
+
+// printMessage prints the message, as a json line in -json mode
+func printMessage(id msgID, args ...interface{}) {
+	printLevel("info", id, text(id, args...))
+}
+
+// fprintMessage prints the message to w, as a json line in -json mode
+func fprintMessage(w io.Writer, id msgID, args ...interface{}) {
+	fprintLevel(w, "info", id, text(id, args...))
+}
+
+// printCreated prints the version and the names of the created files
+func printCreated(dir string, mf *file.MigrationFile) {
+	printMessage(msgCreated, dir, mf.Version)
+	printMessage(msgCreatedFile, mf.UpFile.FileName)
+	printMessage(msgCreatedFile, mf.DownFile.FileName)
+}
+
+// printWarning prints the warning, as a json line in -json mode
+func printWarning(id msgID, args ...interface{}) {
+	printLevel("warning", id, text(id, args...))
+}
+
+// warnFile prints the files that are skipped, or whose up and down names differ
+func warnFile(name string, err error) {
+	if errors.Is(err, file.ErrNamesDiffer) {
+		printWarning(msgFileNamesDiffer, name, err)
+		return
+	}
+	printWarning(msgFileSkipped, name, err)
+}
+
+// printError prints the error, as a json line in -json mode
+func printError(err error) {
+	id := msgError
+	if m, ok := err.(*message); ok {
+		id = m.id
+	}
+	printLevel("error", id, err.Error())
+}
+
+// redact removes the passwords from the output. main replaces it with the secret resolver's.
+var redact = secret.Redact
+
+func printLevel(level string, id msgID, text string) {
+	fprintLevel(os.Stdout, level, id, text)
+}
+
+func fprintLevel(w io.Writer, level string, id msgID, text string) {
+	text = redact(text)
+	if !jsonMessages {
+		fmt.Fprintln(w, text)
+		return
+	}
+	b, _ := json.Marshal(struct {
+		Level   string `json:"level"`
+		ID      msgID  `json:"id"`
+		Message string `json:"message"`
+	}{level, id, strings.TrimSpace(text)})
+	fmt.Fprintln(w, string(b))
+}
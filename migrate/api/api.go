@@ -0,0 +1,131 @@
+// Package api is the stable surface of migrate for applications that embed it.
+//
+// The names in this package follow semantic versioning by APIVersion: they're only
+// removed or changed incompatibly in a new major version, after being marked Deprecated
+// for at least one minor version. Everything outside this package, including the
+// Migrator's methods that aren't used by the examples in the README, may change
+// between releases.
+//
+// Drivers register themselves by url scheme when their package is imported:
+//
+//	import _ "github.com/acls/migrate/driver/pgx"
+//
+//	d, err := api.OpenDriver("postgres://localhost/db", "")
+//	m, err := api.New(d, api.WithPath("./migrations"), api.WithSchema("app"))
+package api
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// APIVersion is the semantic version of this package's compatibility guarantee
+const APIVersion = "1.0.0"
+
+// Migrator and its options
+type (
+	Migrator = migrate.Migrator
+	Option   = migrate.Option
+	Clock    = migrate.Clock
+	Source   = migrate.Source
+	// DirSource reads the migrations from a directory
+	DirSource = migrate.DirSource
+	// FSSource reads the migrations from a fs.FS, e.g. an embed.FS
+	FSSource = migrate.FSSource
+)
+
+// New creates a Migrator, see migrate.New
+func New(d Driver, opts ...Option) (*Migrator, error) {
+	return migrate.New(d, opts...)
+}
+
+// WithPath sets the migrations directory
+func WithPath(path string) Option { return migrate.WithPath(path) }
+
+// WithSource reads the migrations from the source instead of a directory
+func WithSource(source Source) Option { return migrate.WithSource(source) }
+
+// WithSchema sets the schema to migrate
+func WithSchema(schema string) Option { return migrate.WithSchema(schema) }
+
+// WithTxPerFile runs each migration file in its own transaction
+func WithTxPerFile() Option { return migrate.WithTxPerFile() }
+
+// WithForce skips validating applied files against the files on disk
+func WithForce() Option { return migrate.WithForce() }
+
+// Versions and migration files
+type (
+	Version        = file.Version
+	File           = file.File
+	MigrationFiles = file.MigrationFiles
+	Migrations     = file.Migrations
+)
+
+// ParseVersion parses a version like "3" or "1.2"
+func ParseVersion(s string) (Version, error) { return file.ParseVersion(s) }
+
+// NewVersion2 creates a major.minor version
+func NewVersion2(major, minor uint64) Version { return file.NewVersion2(major, minor) }
+
+// Plans
+type PlanOptions = migrate.PlanOptions
+
+// PlanBetween returns the migrations from the stored files to target, see migrate.PlanBetween
+func PlanBetween(stored, disk MigrationFiles, target Version, opts PlanOptions) (Migrations, error) {
+	return migrate.PlanBetween(stored, disk, target, opts)
+}
+
+// Events
+type (
+	Event            = migrate.Event
+	EventHandler     = migrate.EventHandler
+	MigrationStarted = migrate.MigrationStarted
+	MigrationApplied = migrate.MigrationApplied
+	MigrationFailed  = migrate.MigrationFailed
+	TableDumped      = migrate.TableDumped
+	FilesUpdated     = migrate.FilesUpdated
+)
+
+// Tracing
+type (
+	Tracer    = migrate.Tracer
+	Span      = migrate.Span
+	Attribute = migrate.Attribute
+)
+
+// Drivers
+type (
+	Driver        = driver.Driver
+	Conn          = driver.Conn
+	DriverFactory = driver.Factory
+	// Run is a migration run recorded in the audit log
+	Run = driver.Run
+)
+
+// RegisterDriver makes a driver available to OpenDriver by url scheme
+func RegisterDriver(scheme string, factory DriverFactory) { driver.Register(scheme, factory) }
+
+// OpenDriver creates the registered driver for the url's scheme, with tableName as its
+// version table, or the driver's default if empty
+func OpenDriver(url, tableName string) (Driver, error) { return driver.Open(url, tableName) }
+
+// DriverSchemes returns the registered url schemes
+func DriverSchemes() []string { return driver.Schemes() }
+
+// Errors, to check with errors.Is
+var (
+	ErrNoChange          = migrate.ErrNoChange
+	ErrLocked            = migrate.ErrLocked
+	ErrInterrupted       = migrate.ErrInterrupted
+	ErrMissingDownFile   = migrate.ErrMissingDownFile
+	ErrVersionNotFound   = migrate.ErrVersionNotFound
+	ErrAheadOfFiles      = migrate.ErrAheadOfFiles
+	ErrUnsupportedDriver = migrate.ErrUnsupportedDriver
+	ErrVersionGap        = migrate.ErrVersionGap
+	ErrBaseFilesDiffer   = migrate.ErrBaseFilesDiffer
+	ErrInvalidFiles      = migrate.ErrInvalidFiles
+	ErrOutOfOrder        = migrate.ErrOutOfOrder
+	ErrVersionConflict   = migrate.ErrVersionConflict
+)
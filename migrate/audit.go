@@ -0,0 +1,214 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/user"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+	"github.com/acls/migrate/secret"
+)
+
+// auditDriver returns the driver as a driver.AuditDriver, or nil if Audit isn't set
+func (m *Migrator) auditDriver() (driver.AuditDriver, error) {
+	if !m.Audit {
+		return nil, nil
+	}
+	ad, ok := m.Driver.(driver.AuditDriver)
+	if !ok {
+		return nil, unsupportedDriver("AuditDriver")
+	}
+	return ad, nil
+}
+
+// runRecord is the run that's recorded in the audit log and passed to the Notifiers
+type runRecord struct {
+	driver.Run
+	// applied are the versions of the applied files
+	applied []string
+}
+
+// recording returns true if the runs are recorded in the audit log or passed to the Notifiers
+func (m *Migrator) recording() bool {
+	return m.Audit || len(m.Notifiers) > 0 || m.Snapshots != nil
+}
+
+// lockedRun is locked for the commands that apply migrations, with fn running as a recorded
+// run, see recorded, that includes taking the lock
+func (m *Migrator) lockedRun(pipe chan interface{}, conn driver.Conn, fn func(m *Migrator, pipe chan interface{}, conn driver.Conn) error) {
+	if !m.recording() {
+		m.locked(pipe, conn, func(conn driver.Conn) error { return fn(m, pipe, conn) })
+		return
+	}
+	err := m.recorded(pipe, conn, func(m *Migrator, pipe chan interface{}) error {
+		conn, unlock, err := m.acquireLock(conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return fn(m, pipe, conn)
+	})
+	go pipep.Close(pipe, err)
+}
+
+// recorded runs fn as a run that's recorded in the audit log with Audit, and passed to the
+// Notifiers after it, whether it succeeded or failed, even before applying any file, e.g. to
+// take the lock or to validate the files. fn runs with a copy of the Migrator that collects the
+// run. The run is written with conn, or a connection opened with NewConn if it's nil.
+func (m *Migrator) recorded(pipe chan interface{}, conn driver.Conn, fn func(m *Migrator, pipe chan interface{}) error) error {
+	ad, err := m.auditDriver()
+	if err != nil {
+		return err
+	}
+	run := &runRecord{Run: driver.Run{
+		Command:   m.Command,
+		User:      currentUser(),
+		StartedAt: m.Now(),
+	}}
+	run.Host, _ = os.Hostname()
+	if run.ID, err = newRunID(); err != nil {
+		return err
+	}
+
+	// collect the applied files and the first error sent to the pipe
+	rm := *m
+	rm.run = run
+	rm.EventHandler = func(e Event) {
+		if e, ok := e.(MigrationApplied); ok {
+			run.applied = append(run.applied, e.File.Version.String())
+		}
+		if m.EventHandler != nil {
+			m.EventHandler(e)
+		}
+	}
+	var failed error
+	pipe1 := pipep.New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for item := range pipe1 {
+			if err, ok := item.(error); ok && failed == nil {
+				failed = err
+			}
+			pipe <- item
+		}
+	}()
+	err = fn(&rm, pipe1)
+	close(pipe1)
+	<-done
+	if failed == nil {
+		failed = err
+	}
+	if recordErr := rm.writeRun(ad, conn, failed); recordErr != nil && err == nil {
+		err = recordErr
+	}
+	m.notify(pipe, RunSummary{Run: run.Run, Schema: m.Schema, Applied: run.applied})
+	return err
+}
+
+// startRun sets the version before the run, the command if it isn't set and takes the
+// snapshot, once the migrations are planned
+func (m *Migrator) startRun(conn driver.Conn, prevFiles file.MigrationFiles, applyMigrations file.Migrations) (err error) {
+	if m.run == nil {
+		return nil
+	}
+	m.run.FromVersion = prevFiles.LastVersion().String()
+	if m.run.Command == "" && len(applyMigrations) > 0 {
+		m.run.Command = directionName(applyMigrations[0].Up())
+	}
+	if m.Snapshots != nil && len(applyMigrations) > 0 {
+		m.run.Snapshot, err = m.snapshot(conn, m.run.ID)
+	}
+	return
+}
+
+// writeRun writes the run to the audit log with conn, or a connection opened with NewConn
+func (m *Migrator) writeRun(ad driver.AuditDriver, conn driver.Conn, failed error) error {
+	conn, release, err := m.acquire(conn)
+	if err != nil {
+		m.setOutcome(failed)
+		return err
+	}
+	defer release()
+	return m.recordRun(ad, conn, failed)
+}
+
+// setOutcome sets when the run finished and its outcome
+func (m *Migrator) setOutcome(failed error) {
+	if errors.Is(failed, ErrNoChange) {
+		failed = nil
+	}
+	run := m.run
+	run.FinishedAt = m.Now()
+	run.Outcome = driver.RunSucceeded
+	run.Error = ""
+	if failed != nil {
+		run.Outcome = driver.RunFailed
+		run.Error = secret.Redact(failed.Error())
+	}
+}
+
+// recordRun sets the outcome and the version after the run and writes the run to the audit
+// log, if ad isn't nil, even if the migration was canceled. The version before the run is
+// the version after it if the run failed before planning any migration.
+func (m *Migrator) recordRun(ad driver.AuditDriver, conn driver.Conn, failed error) error {
+	m.setOutcome(failed)
+	run := m.run
+	conn = withContext(context.Background(), conn)
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return err
+	}
+	defer revert()
+	version, err := m.Driver.Version(conn)
+	if err != nil {
+		return err
+	}
+	run.ToVersion = version.String()
+	if run.FromVersion == "" {
+		run.FromVersion = run.ToVersion
+	}
+	if run.Command == "" {
+		run.Command = "migrate"
+	}
+	if ad == nil {
+		return nil
+	}
+	return ad.RecordRun(conn, run.Run)
+}
+
+// Runs returns the runs recorded in the audit log, the latest first. At most limit if it's positive.
+// Requires a driver.AuditDriver.
+func (m *Migrator) Runs(conn driver.Conn, limit int) ([]driver.Run, error) {
+	ad, ok := m.Driver.(driver.AuditDriver)
+	if !ok {
+		return nil, unsupportedDriver("AuditDriver")
+	}
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return nil, err
+	}
+	defer revert()
+	return ad.Runs(conn, limit)
+}
+
+func newRunID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// currentUser returns the name of the user running the migration
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
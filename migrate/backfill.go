@@ -0,0 +1,178 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// Backfill is a long running data migration that is applied in batches.
+// The progress is stored after each batch so an interrupted backfill resumes
+// after the last processed key.
+type Backfill struct {
+	Name string
+	// Total, if set, returns the number of rows to process for the percent complete
+	Total func(db driver.Databaser) (int64, error)
+	// Batch processes the next batch of rows after lastKey, which is empty for the
+	// first batch, and returns the key of the last processed row and the number of rows.
+	// The backfill is done when a batch processes no rows.
+	// Each batch runs in its own transaction with the progress update.
+	Batch func(db driver.Databaser, lastKey string) (key string, rows int64, err error)
+	// Explain, if set, is the query of the first batch. It's previewed with EXPLAIN by
+	// ExplainBackfill, e.g. in a scratch database with imported production statistics.
+	Explain string
+	// Sleep is the pause after each batch, which throttles the load on the database and
+	// leaves time for replicas to catch up
+	Sleep time.Duration
+	// Throttle, if set, is called before each batch and may block, e.g. until the replication
+	// lag is low enough. An error stops the backfill.
+	Throttle func() error
+}
+
+// RunBackfill runs the backfill's batches until it's done or interrupted.
+// The progress (driver.Backfill) is sent to the pipe after each batch.
+func (m *Migrator) RunBackfill(pipe chan interface{}, conn driver.Conn, b Backfill) {
+	var err error
+	defer func() {
+		go pipep.Close(pipe, err)
+	}()
+
+	bd, ok := m.Driver.(driver.BackfillDriver)
+	if !ok {
+		err = unsupportedDriver("BackfillDriver")
+		return
+	}
+	revert, err := bd.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return
+	}
+	defer revert()
+	if err = bd.EnsureBackfillTable(conn); err != nil {
+		return
+	}
+
+	progress, started, err := bd.GetBackfill(conn, b.Name)
+	if err != nil || progress.Done {
+		return
+	}
+	if !started && b.Total != nil {
+		if progress.Total, err = b.Total(conn); err != nil {
+			return
+		}
+	}
+
+	interrupts, stop := m.handleInterrupts()
+	defer stop()
+	for !progress.Done {
+		if b.Throttle != nil {
+			if err = b.Throttle(); err != nil {
+				return
+			}
+		}
+		if progress, err = m.backfillBatch(bd, conn, b, progress); err != nil {
+			return
+		}
+		pipe <- progress
+		if b.Sleep > 0 && !progress.Done {
+			m.clock().Sleep(b.Sleep)
+		}
+
+		select {
+		case <-interrupts:
+			// progress is saved, so the backfill resumes from here next time
+			return
+		default:
+		}
+	}
+}
+
+// RunBackfillSync is synchronous version of RunBackfill
+func (m *Migrator) RunBackfillSync(conn driver.Conn, b Backfill) []error {
+	pipe := pipep.New()
+	go m.RunBackfill(pipe, conn, b)
+	return pipep.ReadErrors(pipe)
+}
+
+// backfillBatch processes a batch and saves the progress in the same transaction
+func (m *Migrator) backfillBatch(bd driver.BackfillDriver, conn driver.Conn, b Backfill, progress driver.Backfill) (next driver.Backfill, err error) {
+	tx, err := m.begin(conn)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	next = progress
+	key, rows, err := b.Batch(tx, progress.LastKey)
+	if err != nil {
+		return
+	}
+	if rows == 0 {
+		next.Done = true
+	} else {
+		next.LastKey = key
+		next.Rows += rows
+	}
+	err = bd.SaveBackfill(tx, next)
+	return
+}
+
+// Backfills returns the progress of all backfills
+func (m *Migrator) Backfills(conn driver.Conn) ([]driver.Backfill, error) {
+	bd, ok := m.Driver.(driver.BackfillDriver)
+	if !ok {
+		return nil, unsupportedDriver("BackfillDriver")
+	}
+	revert, err := bd.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return nil, err
+	}
+	defer revert()
+	if err := bd.EnsureBackfillTable(conn); err != nil {
+		return nil, err
+	}
+	return bd.GetBackfills(conn)
+}
+
+// KeysetBatch returns a Backfill.Batch that sets the columns of up to size rows of the table
+// in the order of the unique key column, e.g.
+//
+//	KeysetBatch("users", "id", "email <> lower(email)", "email = lower(email)", 1000)
+//
+// The rows that match where, if it's set, are updated with set, which is the SET list of
+// an UPDATE. The last key is a literal in the query, so it's compared as the key's type
+// and the key's index is used.
+func KeysetBatch(table, key, where, set string, size int) func(db driver.Databaser, lastKey string) (string, int64, error) {
+	return func(db driver.Databaser, lastKey string) (k string, rows int64, err error) {
+		err = db.QueryRow(keysetQuery(table, key, where, set, size, lastKey)).Scan(&k, &rows)
+		return
+	}
+}
+
+func keysetQuery(table, key, where, set string, size int, lastKey string) string {
+	var conds []string
+	if where != "" {
+		conds = append(conds, "("+where+")")
+	}
+	if lastKey != "" {
+		conds = append(conds, key+" > '"+strings.Replace(lastKey, "'", "''", -1)+"'")
+	}
+	cond := ""
+	if len(conds) > 0 {
+		cond = " WHERE " + strings.Join(conds, " AND ")
+	}
+	return fmt.Sprintf(`WITH batch AS (
+	SELECT %[1]s FROM %[2]s%[3]s ORDER BY %[1]s LIMIT %[4]d
+), updated AS (
+	UPDATE %[2]s SET %[5]s WHERE %[1]s IN (SELECT %[1]s FROM batch) RETURNING %[1]s
+)
+SELECT COALESCE(max(%[1]s)::text, ''), count(*) FROM updated`, key, table, cond, size, set)
+}
@@ -0,0 +1,175 @@
+package migrate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// BenchOptions sizes the generated migrations and data of Bench
+type BenchOptions struct {
+	// Migrations is the number of generated migration files
+	Migrations int
+	// Rows is the number of rows inserted by the first migration
+	Rows int
+}
+
+// BenchResult is the throughput measured by Bench
+type BenchResult struct {
+	Migrations  int
+	MigrateTime time.Duration
+	DumpBytes   int64
+	DumpTime    time.Duration
+	RestoreRows int64
+	RestoreTime time.Duration
+}
+
+// MigrationsPerSecond is the migrate throughput
+func (r BenchResult) MigrationsPerSecond() float64 {
+	return perSecond(float64(r.Migrations), r.MigrateTime)
+}
+
+// DumpMBPerSecond is the dump throughput
+func (r BenchResult) DumpMBPerSecond() float64 {
+	return perSecond(float64(r.DumpBytes)/(1<<20), r.DumpTime)
+}
+
+// RestoreRowsPerSecond is the restore throughput
+func (r BenchResult) RestoreRowsPerSecond() float64 {
+	return perSecond(float64(r.RestoreRows), r.RestoreTime)
+}
+
+func (r BenchResult) String() string {
+	return fmt.Sprintf("migrate: %d migrations in %v, %.1f/s\n"+
+		"dump:    %d bytes in %v, %.2f MB/s\n"+
+		"restore: %d rows in %v, %.0f rows/s",
+		r.Migrations, r.MigrateTime, r.MigrationsPerSecond(),
+		r.DumpBytes, r.DumpTime, r.DumpMBPerSecond(),
+		r.RestoreRows, r.RestoreTime, r.RestoreRowsPerSecond())
+}
+
+func perSecond(n float64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return n / d.Seconds()
+}
+
+// benchInsertBatch is the number of rows per generated INSERT
+const benchInsertBatch = 1000
+
+// Bench generates migrations in a temp dir and measures migrating them up, dumping and
+// restoring in the <Schema>_bench schema, which is dropped before and after.
+// The driver must be a driver.DumpDriver.
+func (m *Migrator) Bench(conn driver.CopyConn, opts BenchOptions) (r BenchResult, err error) {
+	dd, ok := m.Driver.(driver.DumpDriver)
+	if !ok {
+		return r, unsupportedDriver("DumpDriver")
+	}
+	if opts.Migrations < 1 {
+		opts.Migrations = 1
+	}
+
+	tmpdir, err := ioutil.TempDir("", "migrate-bench")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmpdir)
+	migrationsDir, dumpDir := filepath.Join(tmpdir, "migrations"), filepath.Join(tmpdir, "dump")
+	if err = os.Mkdir(migrationsDir, 0755); err != nil {
+		return
+	}
+
+	bm := *m
+	bm.Path = migrationsDir
+	bm.Source = nil
+	bm.Schema = m.Schema + "_bench"
+	bm.ExtraSchemas = nil
+	bm.Force = true
+	bm.Interrupts = false
+	bm.EventHandler = nil
+	if err = createBenchMigrations(&bm, opts); err != nil {
+		return
+	}
+
+	if err = dd.DeleteSchema(conn, bm.Schema); err != nil {
+		return
+	}
+	defer dd.DeleteSchema(conn, bm.Schema)
+
+	start := m.Now()
+	if err = firstError(bm.UpSync(conn)); err != nil {
+		return
+	}
+	r.Migrations, r.MigrateTime = opts.Migrations, m.Since(start)
+
+	start = m.Now()
+	if err = firstError(bm.DumpSync(conn, &file.DirWriter{BaseDir: dumpDir})); err != nil {
+		return
+	}
+	r.DumpTime = m.Since(start)
+	if r.DumpBytes, err = dirSize(dumpDir); err != nil {
+		return
+	}
+
+	start = m.Now()
+	if err = firstError(bm.RestoreSync(conn, &file.DirReader{BaseDir: dumpDir})); err != nil {
+		return
+	}
+	r.RestoreRows, r.RestoreTime = int64(opts.Rows), m.Since(start)
+	return
+}
+
+// createBenchMigrations creates a table with the rows, then a table per migration
+func createBenchMigrations(m *Migrator, opts BenchOptions) error {
+	var up strings.Builder
+	up.WriteString("CREATE TABLE bench (id BIGINT PRIMARY KEY, name VARCHAR(64) NOT NULL);\n")
+	for i := 0; i < opts.Rows; i += benchInsertBatch {
+		up.WriteString("INSERT INTO bench (id, name) VALUES ")
+		for j := i; j < i+benchInsertBatch && j < opts.Rows; j++ {
+			if j > i {
+				up.WriteString(",")
+			}
+			fmt.Fprintf(&up, "(%d,'row %d')", j+1, j+1)
+		}
+		up.WriteString(";\n")
+	}
+	if _, err := m.Create(false, "bench", up.String(), "DROP TABLE bench;"); err != nil {
+		return err
+	}
+	for i := 1; i < opts.Migrations; i++ {
+		table := fmt.Sprintf("bench_%d", i)
+		if _, err := m.Create(false, table,
+			"CREATE TABLE "+table+" (id BIGINT PRIMARY KEY);",
+			"DROP TABLE "+table+";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func firstError(errs []error) error {
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
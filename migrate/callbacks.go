@@ -0,0 +1,63 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// Callbacks reads the callback files from Source, or Path. See file.Callbacks.
+func (m *Migrator) Callbacks() (file.Callbacks, error) {
+	openers, err := m.sourceFiles()
+	if err != nil {
+		return file.Callbacks{}, err
+	}
+	return file.GetCallbacks(openers, m.Driver.FilenameExtension())
+}
+
+// execCallback executes the callback file, if it's set. It's rendered with Render.
+func (m *Migrator) execCallback(db driver.Databaser, f *file.File) error {
+	if f == nil {
+		return nil
+	}
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	content := f.Content
+	if m.Render {
+		var err error
+		if content, err = m.render(f, content); err != nil {
+			return fmt.Errorf("%s: %w", f.FileName, err)
+		}
+	}
+	var err error
+	if sd, ok := m.Driver.(driver.StatementDriver); ok {
+		rendered := *f
+		rendered.Content = content
+		err = sd.ExecStatement(db, &rendered, file.Statement{SQL: string(content)})
+	} else {
+		err = db.Exec(string(content))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.FileName, err)
+	}
+	return nil
+}
+
+// runCallback executes the callback file, if it's set, in its own transaction
+func (m *Migrator) runCallback(pipe chan interface{}, conn driver.Conn, f *file.File) error {
+	if f == nil {
+		return nil
+	}
+	pipe <- f
+	tx, err := m.begin(conn)
+	if err != nil {
+		return err
+	}
+	if err := m.execCallback(tx, f); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
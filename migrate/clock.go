@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of time used to time migrations, wait between retries and
+// stamp when migrations were applied. Replace it with a FrozenClock for deterministic tests.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the Clock used when Migrator.Clock is nil
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// FrozenClock is a Clock that only moves when Sleep or Advance is called
+type FrozenClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewFrozenClock creates a FrozenClock stopped at t
+func NewFrozenClock(t time.Time) *FrozenClock {
+	return &FrozenClock{t: t}
+}
+
+// Now returns the frozen time
+func (c *FrozenClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Sleep advances the clock by d without waiting
+func (c *FrozenClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the clock forward by d
+func (c *FrozenClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// Now returns the current time of the Migrator's Clock
+func (m *Migrator) Now() time.Time {
+	return m.clock().Now()
+}
+
+// Since returns the time elapsed since t on the Migrator's Clock
+func (m *Migrator) Since(t time.Time) time.Duration {
+	return m.clock().Now().Sub(t)
+}
+
+func (m *Migrator) clock() Clock {
+	if m.Clock == nil {
+		return SystemClock
+	}
+	return m.Clock
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// checkContent returns an error if SkipContent is set but the driver isn't a driver.ContentDriver
+func (m *Migrator) checkContent() error {
+	if _, ok := m.Driver.(driver.ContentDriver); !ok && m.SkipContent {
+		return unsupportedDriver("ContentDriver")
+	}
+	return nil
+}
+
+// contentOptions returns the options of the driver calls that store the file contents
+func (m *Migrator) contentOptions() driver.ContentOptions {
+	return driver.ContentOptions{SkipContent: m.SkipContent}
+}
+
+// driverMigrate is the driver's Migrate, with the content options if it's a driver.ContentDriver
+func (m *Migrator) driverMigrate(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	if cd, ok := m.Driver.(driver.ContentDriver); ok {
+		cd.MigrateWith(db, mg, m.contentOptions(), pipe)
+		return
+	}
+	m.Driver.Migrate(db, mg, pipe)
+}
+
+// recordVersion is the driver's RecordVersion, with the content options if it's a driver.ContentDriver
+func (m *Migrator) recordVersion(pd driver.ParallelDriver, db driver.Databaser, mg *file.Migration) error {
+	if cd, ok := pd.(driver.ContentDriver); ok {
+		return cd.RecordVersionWith(db, mg, m.contentOptions())
+	}
+	return pd.RecordVersion(db, mg)
+}
+
+// updateFiles is the driver's UpdateFiles, with the content options if it's a driver.ContentDriver
+func (m *Migrator) updateFiles(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	if cd, ok := m.Driver.(driver.ContentDriver); ok {
+		cd.UpdateFilesWith(db, mg, m.contentOptions(), pipe)
+		return
+	}
+	m.Driver.UpdateFiles(db, mg, pipe)
+}
+
+// sourceDownFiles replaces the stored down files with the ones in files, since their
+// contents aren't stored with SkipContent. The stored down file of a version that isn't
+// in files is kept, but fails to open if it's empty, instead of removing the version
+// without reverting it.
+func sourceDownFiles(prevFiles, files file.MigrationFiles) {
+	for i, prev := range prevFiles {
+		if mf, ok := files.Find(prev.Version); ok {
+			prevFiles[i].DownFile = mf.DownFile
+			continue
+		}
+		f := *prev.DownFile
+		open := f.Open
+		f.Open = func() (io.ReadCloser, error) {
+			r, err := open()
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			content, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(content) == 0 {
+				return nil, fmt.Errorf("No down file for version %v, its content isn't stored and it's missing from the migration files", prev.Version)
+			}
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		}
+		prevFiles[i].DownFile = &f
+	}
+}
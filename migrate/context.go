@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// The *Ctx functions are like their counterparts, but stop after the current migration,
+// or table for dumps and restores, when ctx is done and then send ctx.Err() to the pipe.
+// Connections that implement driver.ContextConn run their queries with ctx,
+// so the running query is canceled too.
+
+// SignalContext returns a copy of parent that's canceled on the first of the ShutdownSignals,
+// for graceful shutdowns with the *Ctx functions: the running query is canceled, the open
+// transaction is rolled back and the lock is released before they return. Call stop to
+// stop listening for the signals.
+func SignalContext(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, ShutdownSignals...)
+}
+
+// UpCtx is Up with a context
+func (m *Migrator) UpCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Up(pipe, conn)
+	})
+}
+
+// DownCtx is Down with a context
+func (m *Migrator) DownCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Down(pipe, conn)
+	})
+}
+
+// RedoCtx is Redo with a context
+func (m *Migrator) RedoCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Redo(pipe, conn)
+	})
+}
+
+// ResetCtx is Reset with a context
+func (m *Migrator) ResetCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Reset(pipe, conn)
+	})
+}
+
+// MigrateCtx is Migrate with a context
+func (m *Migrator) MigrateCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn, relativeN int) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Migrate(pipe, conn, relativeN)
+	})
+}
+
+// MigrateToCtx is MigrateTo with a context
+func (m *Migrator) MigrateToCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn, dstVersion file.Version) (version file.Version) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		version = m.MigrateTo(pipe, conn, dstVersion)
+	})
+	return
+}
+
+// MigrateBetweenCtx is MigrateBetween with a context
+func (m *Migrator) MigrateBetweenCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn) (curVersion, dstVersion file.Version) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		curVersion, dstVersion = m.MigrateBetween(pipe, conn)
+	})
+	return
+}
+
+// DumpCtx is Dump with a context
+func (m *Migrator) DumpCtx(ctx context.Context, pipe chan interface{}, conn driver.CopyConn, dw file.DumpWriter) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Dump(pipe, conn.(driver.CopyConn), dw)
+	})
+}
+
+// RestoreCtx is Restore with a context
+func (m *Migrator) RestoreCtx(ctx context.Context, pipe chan interface{}, conn driver.CopyConn, dr file.DumpReader) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.Restore(pipe, conn.(driver.CopyConn), dr)
+	})
+}
+
+// RunBackfillCtx is RunBackfill with a context
+func (m *Migrator) RunBackfillCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn, b Backfill) {
+	m.runCtx(ctx, pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) {
+		m.RunBackfill(pipe, conn, b)
+	})
+}
+
+// StatusCtx is Status with a context
+func (m *Migrator) StatusCtx(ctx context.Context, conn driver.Conn) (*Status, error) {
+	cm := *m
+	cm.ctx = ctx
+	return cm.Status(withContext(ctx, conn))
+}
+
+// PlanCtx is Plan with a context
+func (m *Migrator) PlanCtx(ctx context.Context, conn driver.Conn) (file.Migrations, error) {
+	cm := *m
+	cm.ctx = ctx
+	return cm.Plan(withContext(ctx, conn))
+}
+
+// runCtx runs fn with a copy of the migrator and connection that use ctx,
+// redirects fn's pipe to pipe and closes pipe once fn is done
+func (m *Migrator) runCtx(ctx context.Context, pipe chan interface{}, conn driver.Conn, fn func(*Migrator, chan interface{}, driver.Conn)) {
+	cm := *m
+	cm.ctx = ctx
+	conn = withContext(ctx, conn)
+	if m.PrevConn != nil {
+		cm.PrevConn = withContext(ctx, m.PrevConn)
+	}
+	if m.NewConn != nil {
+		cm.NewConn = func() (driver.Conn, error) {
+			c, err := m.NewConn()
+			if err != nil {
+				return nil, err
+			}
+			return withContext(ctx, c), nil
+		}
+	}
+
+	pipe1 := pipep.New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(&cm, pipe1, conn)
+	}()
+	for item := range pipe1 {
+		pipe <- item
+	}
+	<-done
+
+	var err error
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	go pipep.Close(pipe, err)
+}
+
+// context returns the migrator's context, which is set by the *Ctx functions
+func (m *Migrator) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// withContext returns a copy of conn that uses ctx, if it's a driver.ContextConn
+func withContext(ctx context.Context, conn driver.Conn) driver.Conn {
+	if cc, ok := conn.(driver.ContextConn); ok {
+		return cc.WithContext(ctx)
+	}
+	return conn
+}
+
+// contextInterrupts sends an interrupt to c when the migrator's context is done, until stop
+// is called
+func (m *Migrator) contextInterrupts(c chan os.Signal) (_ chan os.Signal, stop func()) {
+	if m.ctx == nil || m.ctx.Done() == nil {
+		return c, func() {}
+	}
+	if c == nil {
+		c = make(chan os.Signal, 1)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-m.ctx.Done():
+			select {
+			case c <- os.Interrupt:
+			default:
+			}
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return c, func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,153 @@
+// Package datamigrate applies large data migrations, e.g. backfills, as Go migrations that
+// are ordered with the migration files by their version. The rows are updated in batches in
+// the order of a key, each batch in a short transaction of its own, so the locks of the
+// updated rows are only held for a batch. The progress is stored in the driver's backfill
+// table after each batch, so an interrupted data migration resumes after the last batch
+// when it's applied again.
+//
+//	datamigrate.Register(file.NewVersion2(1, 3), m, datamigrate.Migration{
+//		Name:      "lowercase_emails",
+//		Table:     "users",
+//		Key:       "id",
+//		Where:     "email <> lower(email)",
+//		Set:       "email = lower(email)",
+//		BatchSize: 5000,
+//		Sleep:     100 * time.Millisecond,
+//	})
+//
+// The batches run on a connection of the Migrator's NewConn, outside the migration's
+// transaction: they don't see its changes and wait for its locks. The schema changes a
+// data migration needs must be committed first, with TxPerFile or in an earlier major version.
+package datamigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// DefaultBatchSize is the number of rows of a batch when Migration.BatchSize is 0
+const DefaultBatchSize = 1000
+
+// Migration is a data migration of the rows of Table that match Where, or of a custom Batch
+type Migration struct {
+	// Name identifies the progress of the data migration in the backfill table
+	Name string
+	// Table, Key, Where and Set are the arguments of migrate.KeysetBatch
+	Table string
+	Key   string
+	Where string
+	Set   string
+	// Batch, if set, processes the batches instead of Set, see migrate.Backfill
+	Batch func(db driver.Databaser, lastKey string) (key string, rows int64, err error)
+	// BatchSize is the number of rows of a batch of Set, DefaultBatchSize if it's 0
+	BatchSize int
+	// Sleep and Throttle slow the batches down, see migrate.Backfill
+	Sleep    time.Duration
+	Throttle func() error
+	// Down, if set, rolls back the data migration in the migration's transaction.
+	// The data migration can't be rolled back otherwise.
+	Down migrate.GoMigrationFunc
+}
+
+// Backfill returns the data migration as a migrate.Backfill. Its total is the number of
+// rows of Table that match Where, if Table is set.
+func (mg Migration) Backfill() migrate.Backfill {
+	b := migrate.Backfill{
+		Name:     mg.Name,
+		Batch:    mg.Batch,
+		Sleep:    mg.Sleep,
+		Throttle: mg.Throttle,
+	}
+	if mg.Table != "" {
+		query := "SELECT count(*) FROM " + mg.Table
+		if mg.Where != "" {
+			query += " WHERE " + mg.Where
+		}
+		b.Total = func(db driver.Databaser) (n int64, err error) {
+			err = db.QueryRow(query).Scan(&n)
+			return
+		}
+	}
+	if b.Batch == nil {
+		size := mg.BatchSize
+		if size <= 0 {
+			size = DefaultBatchSize
+		}
+		b.Batch = migrate.KeysetBatch(mg.Table, mg.Key, mg.Where, mg.Set, size)
+	}
+	return b
+}
+
+// Register registers the data migration as the Go migration of the version, see
+// migrate.RegisterGoMigration. It's applied with Run on a connection of m's NewConn.
+// Rolling it back runs Down and resets its progress, so it's applied again on the next up.
+func Register(version file.Version, m *migrate.Migrator, mg Migration) {
+	var down migrate.GoMigrationFunc
+	if mg.Down != nil {
+		down = func(ctx context.Context, tx driver.Tx) error {
+			if err := mg.Down(ctx, tx); err != nil {
+				return err
+			}
+			return reset(m, tx, mg.Name)
+		}
+	}
+	migrate.RegisterGoMigration(version, func(ctx context.Context, tx driver.Tx) error {
+		return Run(ctx, m, nil, mg)
+	}, down)
+}
+
+// Run applies the data migration with m until it's done. A nil conn is opened with m's NewConn
+// and closed when it's done. It returns an error if it was interrupted.
+func Run(ctx context.Context, m *migrate.Migrator, conn driver.Conn, mg Migration) error {
+	if conn == nil {
+		if m.NewConn == nil {
+			return fmt.Errorf("Data migration %s needs the Migrator's NewConn", mg.Name)
+		}
+		var err error
+		if conn, err = m.NewConn(); err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+
+	pipe := pipep.New()
+	go m.RunBackfillCtx(ctx, pipe, conn, mg.Backfill())
+	var err error
+	for item := range pipe {
+		if e, ok := item.(error); ok && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Data migration %s: %w", mg.Name, err)
+	}
+
+	backfills, err := m.Backfills(conn)
+	if err != nil {
+		return err
+	}
+	for _, b := range backfills {
+		if b.Name == mg.Name && b.Done {
+			return nil
+		}
+	}
+	return fmt.Errorf("Data migration %s was interrupted, it resumes when it's applied again", mg.Name)
+}
+
+// reset clears the progress of the data migration in the migration's transaction
+func reset(m *migrate.Migrator, tx driver.Tx, name string) error {
+	bd, ok := m.Driver.(driver.BackfillDriver)
+	if !ok {
+		return fmt.Errorf("%w, must be a BackfillDriver", migrate.ErrUnsupportedDriver)
+	}
+	if err := bd.EnsureBackfillTable(tx); err != nil {
+		return err
+	}
+	return bd.SaveBackfill(tx, driver.Backfill{Name: name})
+}
@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+)
+
+// ReadSchema decodes a schema's structure written by WriteSchema
+func ReadSchema(r io.Reader) (info driver.SchemaInfo, err error) {
+	err = json.NewDecoder(r).Decode(&info)
+	return
+}
+
+// WriteSchema encodes the schema's structure as json
+func WriteSchema(w io.Writer, info driver.SchemaInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
+
+func (m *Migrator) schemaDriver(conn driver.Conn) (sd driver.SchemaDriver, revert func() error, err error) {
+	sd, ok := m.Driver.(driver.SchemaDriver)
+	if !ok {
+		return nil, nil, unsupportedDriver("SchemaDriver")
+	}
+	revert, err = sd.SearchPath(conn, m.SearchPath())
+	return
+}
+
+// InspectSchema returns the structure of the schema's tables: their columns, indexes
+// and constraints, without the version table
+func (m *Migrator) InspectSchema(conn driver.Conn) (driver.SchemaInfo, error) {
+	sd, revert, err := m.schemaDriver(conn)
+	if err != nil {
+		return driver.SchemaInfo{}, err
+	}
+	defer revert()
+	return sd.InspectSchema(conn, m.Schema)
+}
+
+// DiffSchema returns a draft up and down migration that change the schema's structure to
+// target's, e.g. of a dev database where the change was prototyped. Review it before
+// applying: renamed tables and columns are dropped and added, and no data is migrated.
+// Both are empty if the structures are the same.
+func (m *Migrator) DiffSchema(conn driver.Conn, target driver.SchemaInfo) (up, down string, err error) {
+	current, err := m.InspectSchema(conn)
+	if err != nil {
+		return
+	}
+	sd := m.Driver.(driver.SchemaDriver)
+	return joinStatements(sd.DiffSchema(current, target)), joinStatements(sd.DiffSchema(target, current)), nil
+}
+
+func joinStatements(stmts []string) string {
+	if len(stmts) == 0 {
+		return ""
+	}
+	return strings.Join(stmts, ";\n\n") + ";\n"
+}
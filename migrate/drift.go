@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"encoding/json"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// DriftReport compares the migration files stored in the database with the files of Source.
+// It marshals to json with the versions as strings.
+type DriftReport struct {
+	// Added are the versions with files that aren't applied, but are older than the
+	// database version, so Up won't apply them. Pending versions aren't drift.
+	Added []file.Version
+	// Removed are the applied versions that have no files anymore
+	Removed []file.Version
+	// Modified are the applied versions whose files differ from the stored ones
+	Modified []ModifiedVersion
+}
+
+// ModifiedVersion is an applied version whose files differ from the stored ones
+type ModifiedVersion struct {
+	Version file.Version
+	// Up is true if the upfile differs
+	Up bool
+	// Down is true if the downfile differs, or was added or removed
+	Down bool
+}
+
+// Drifted returns true if any version was added, removed or modified
+func (r *DriftReport) Drifted() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Modified) > 0
+}
+
+// MarshalJSON writes the versions as strings
+func (r *DriftReport) MarshalJSON() ([]byte, error) {
+	type modified struct {
+		Version string `json:"version"`
+		Up      bool   `json:"up"`
+		Down    bool   `json:"down"`
+	}
+	mods := make([]modified, len(r.Modified))
+	for i, mv := range r.Modified {
+		mods[i] = modified{mv.Version.String(), mv.Up, mv.Down}
+	}
+	return json.Marshal(struct {
+		Added    []string   `json:"added"`
+		Removed  []string   `json:"removed"`
+		Modified []modified `json:"modified"`
+	}{versionStrings(r.Added), versionStrings(r.Removed), mods})
+}
+
+// Drift compares every stored up and down file with the migration files without applying
+// anything. Stored upfiles are compared by checksum when the driver stores one.
+// Unlike Verify, which fails at the first differing upfile, all differences are reported.
+func (m *Migrator) Drift(conn driver.Conn) (*DriftReport, error) {
+	prevFiles, files, err := m.initReadOnly(conn, false)
+	if err != nil {
+		return nil, err
+	}
+	return drift(prevFiles, files)
+}
+
+// drift compares the stored files with the migration files
+func drift(prevFiles, files file.MigrationFiles) (r *DriftReport, err error) {
+	r = &DriftReport{}
+	for _, prev := range prevFiles {
+		mf, ok := files.Find(prev.Version)
+		if !ok {
+			r.Removed = append(r.Removed, prev.Version)
+			continue
+		}
+		mv := ModifiedVersion{Version: prev.Version}
+		if mv.Up, err = mf.SameUpFile(prev); err != nil {
+			return nil, err
+		}
+		if mv.Down, err = mf.SameDownFile(prev); err != nil {
+			return nil, err
+		}
+		if mv.Up, mv.Down = !mv.Up, !mv.Down; mv.Up || mv.Down {
+			r.Modified = append(r.Modified, mv)
+		}
+	}
+	version := prevFiles.LastVersion()
+	for _, mf := range files {
+		if _, ok := prevFiles.Find(mf.Version); !ok && mf.Compare(version) < 0 {
+			r.Added = append(r.Added, mf.Version)
+		}
+	}
+	return r, nil
+}
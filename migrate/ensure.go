@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+
+	"github.com/acls/migrate/driver"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// EnsureMigrated migrates the database to the migration files with MigrateBetween, so it's
+// up to date when an application's main calls it before serving. Other instances wait for
+// the lock until it's done and then have nothing to apply. It returns ctx's error if ctx is
+// done before, or else the first error.
+//
+//	if err := m.EnsureMigrated(ctx, conn); err != nil {
+//		log.Fatal(err)
+//	}
+func (m *Migrator) EnsureMigrated(ctx context.Context, conn driver.Conn) error {
+	pipe := pipep.New()
+	go m.MigrateBetweenCtx(ctx, pipe, conn)
+	errs := pipep.ReadErrors(pipe)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	for _, err := range errs {
+		if !errors.Is(err, ErrNoChange) {
+			return err
+		}
+	}
+	return nil
+}
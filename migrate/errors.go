@@ -0,0 +1,66 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/acls/migrate/file"
+)
+
+// Errors sent to the pipe, and returned by the Sync functions, wrap these so they
+// can be checked with errors.Is.
+var (
+	// ErrNoChange is sent when there are no migrations to apply and ReportNoChange is set
+	ErrNoChange = errors.New("No change")
+	// ErrLocked is sent when another migrator holds the schema's lock past LockTimeout
+	ErrLocked = errors.New("Migrations are locked")
+	// ErrMigrationTimeout is sent when a migration, or a statement with Statements, runs past MigrationTimeout
+	ErrMigrationTimeout = errors.New("Migration timed out")
+	// ErrInterrupted is passed to MigrationFailed when a migration is interrupted
+	ErrInterrupted = errors.New("Migration interrupted")
+	// ErrMissingDownFile is sent when a version without a down file is rolled back
+	ErrMissingDownFile = errors.New("Missing down file")
+	// ErrVersionNotFound is returned when a version isn't in the migration files
+	ErrVersionNotFound = errors.New("Version not found")
+	// ErrNotMigrated is returned by Healthy when the database isn't at the migration files' version
+	ErrNotMigrated = errors.New("Database isn't migrated")
+	// ErrAheadOfFiles is returned when the database version is after the last migration file
+	ErrAheadOfFiles = errors.New("Database version is ahead of migration files")
+	// ErrUnmetRequirement is returned when a migration would be applied before a version it requires,
+	// or a required version would be rolled back. See file.RequiresDirective.
+	ErrUnmetRequirement = errors.New("Unmet requirement")
+	// ErrUnsupportedDriver is returned when the driver doesn't implement an optional interface
+	ErrUnsupportedDriver = errors.New("Unsupported driver")
+	// ErrNoSnapshot is returned by RollbackToSnapshot when no run recorded a snapshot
+	ErrNoSnapshot = errors.New("No snapshot in the audit log")
+	// ErrPartialWave is returned when a transaction of a parallel wave fails to commit after
+	// others were committed. The error lists the applied versions.
+	ErrPartialWave = errors.New("Parallel wave partially applied")
+
+	// ErrVersionGap is returned when a version is missing between the first and last migration files
+	ErrVersionGap = file.ErrVersionGap
+	// ErrBaseFilesDiffer is returned when an applied upfile differs from the file on disk.
+	// Set Force to skip this validation.
+	ErrBaseFilesDiffer = file.ErrBaseFilesDiffer
+	// ErrInvalidFiles is returned when the migration files can't be read, e.g. duplicate versions
+	ErrInvalidFiles = file.ErrInvalidFiles
+	// ErrOutOfOrder is returned when a file with a version lower than the database's version
+	// isn't applied. Set AllowOutOfOrder to apply it.
+	ErrOutOfOrder = file.ErrOutOfOrder
+	// ErrVersionConflict is returned when an applied version was applied from another file
+	// than the file with its version, e.g. after parallel branches used the same version
+	ErrVersionConflict = file.ErrVersionConflict
+)
+
+func versionNotFound(version file.Version) error {
+	return fmt.Errorf("%w: %v", ErrVersionNotFound, version)
+}
+
+func partialWave(applied []string, err error) error {
+	return fmt.Errorf("%w, applied %s: %v", ErrPartialWave, strings.Join(applied, ", "), err)
+}
+
+func unsupportedDriver(iface string) error {
+	return fmt.Errorf("%w, must be a %s", ErrUnsupportedDriver, iface)
+}
@@ -0,0 +1,126 @@
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// Event is passed to the Migrator's EventHandler. It's one of MigrationStarted,
+// MigrationApplied, MigrationFailed, TableDumped, FilesUpdated or SnapshotTaken.
+type Event interface {
+	isEvent()
+}
+
+// EventHandler receives the events of a migration, dump or restore. It's called
+// from the migrating goroutine, so it should return quickly.
+type EventHandler func(Event)
+
+// MigrationStarted is sent before a file is applied
+type MigrationStarted struct {
+	File *file.File
+}
+
+// MigrationApplied is sent after a file is applied. The file's transaction may
+// not be committed yet, e.g. when the transaction is per major version.
+type MigrationApplied struct {
+	File     *file.File
+	Duration time.Duration
+}
+
+// MigrationFailed is sent when a file fails or the migration is interrupted
+type MigrationFailed struct {
+	File *file.File
+	Err  error
+}
+
+// TableDumped is sent after a table's data is dumped
+type TableDumped struct {
+	Table string
+}
+
+// FilesUpdated is sent after each batch of stored file contents is updated, when the
+// contents of versions recorded without them are written
+type FilesUpdated struct {
+	Updated, Total int
+}
+
+func (MigrationStarted) isEvent() {}
+func (MigrationApplied) isEvent() {}
+func (MigrationFailed) isEvent()  {}
+func (TableDumped) isEvent()      {}
+func (FilesUpdated) isEvent()     {}
+
+func (m *Migrator) emit(e Event) {
+	if m.Logger != nil {
+		m.logEvent(e)
+	}
+	if m.EventHandler != nil {
+		m.EventHandler(e)
+	}
+}
+
+// migrateFile applies the migration with fn, which is the driver's Migrate or MigrateContent,
+// redirects its pipe and emits the migration's events.
+// ok is false if an error was sent to the pipe or the migration was interrupted.
+func (m *Migrator) migrateFile(pipe chan interface{}, db driver.Databaser, mf *file.Migration, fn func(driver.Databaser, *file.Migration, chan interface{})) (ok bool) {
+	f := mf.File()
+	if f == nil {
+		pipe <- fmt.Errorf("%w for version %v", ErrMissingDownFile, mf.Version)
+		return false
+	}
+	m.emit(MigrationStarted{File: f})
+	start := m.Now()
+	_, span := m.startSpan("migrate.file",
+		Attribute{AttrFile, f.FileName},
+		Attribute{AttrVersion, mf.Version.String()},
+		Attribute{AttrDirection, directionName(mf.Up())})
+
+	var failed error
+	pipe1 := pipep.New()
+	go fn(db, mf, pipe1)
+	ok = m.waitAndRedirect(tap(pipe1, func(item interface{}) {
+		if err, isErr := item.(error); isErr && failed == nil {
+			failed = err
+		}
+	}), pipe)
+
+	switch {
+	case ok:
+		m.emit(MigrationApplied{File: f, Duration: m.Since(start)})
+		endSpan(span, nil)
+	case failed != nil:
+		m.emit(MigrationFailed{File: f, Err: failed})
+		endSpan(span, failed)
+	default:
+		m.emit(MigrationFailed{File: f, Err: ErrInterrupted})
+		endSpan(span, ErrInterrupted)
+	}
+	return ok
+}
+
+// tap calls fn with each item of pipe before passing it on to the returned pipe
+func tap(pipe chan interface{}, fn func(interface{})) chan interface{} {
+	out := pipep.New()
+	go func() {
+		defer close(out)
+		for item := range pipe {
+			fn(item)
+			out <- item
+		}
+	}()
+	return out
+}
+
+// recordApplied records when an up migration was applied and how long it took,
+// if the driver is a driver.AppliedDriver
+func (m *Migrator) recordApplied(db driver.Execer, mg *file.Migration, appliedAt time.Time, duration time.Duration) error {
+	ad, ok := m.Driver.(driver.AppliedDriver)
+	if !ok || !mg.Up() {
+		return nil
+	}
+	return ad.RecordApplied(db, mg.Version, appliedAt, duration)
+}
@@ -0,0 +1,205 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// ExpandContract renames a column or changes its type without a table rewrite under an
+// exclusive lock. It's planned as three migrations, each deployed while the application
+// keeps running:
+//
+//  1. expand: add the new column and a trigger that keeps it in sync with the old one
+//  2. backfill: copy the old column of the existing rows to the new one
+//  3. contract: drop the trigger and the old column, once the application uses the new column
+//
+// The backfill requires the expand and the contract requires the backfill, see
+// file.RequiresDirective, and the contract fails if a row wasn't backfilled.
+type ExpandContract struct {
+	Table  string
+	Column string
+	// NewColumn is the column's new name. If it's empty the column keeps its name: the new
+	// column is added as <column>_new and renamed by the contract.
+	NewColumn string
+	// Type is the new column's type, and OldType the old one's, which defaults to Type
+	Type    string
+	OldType string
+	// Using is the expression of the old column's values converted to the new column,
+	// e.g. "amount * 100", which defaults to the old column
+	Using string
+	// Reverse is the expression of the new column's values converted to the old column,
+	// which defaults to the new column. The trigger keeps the old column in sync with it
+	// for the application that's still deployed, and the contract's down migration uses it.
+	Reverse string
+	// NotNull sets NOT NULL on the new column in the contract
+	NotNull bool
+	// Key is the column the backfill is batched by, "id" by default
+	Key string
+}
+
+// ExpandContractPlan are the up and down files of the expand, backfill and contract migrations
+type ExpandContractPlan struct {
+	Expand, Backfill, Contract [2]string
+}
+
+// newColumn is the column added by the expand
+func (ec ExpandContract) newColumn() string {
+	if ec.NewColumn == "" || ec.NewColumn == ec.Column {
+		return ec.Column + "_new"
+	}
+	return ec.NewColumn
+}
+
+// trigger is the name of the sync trigger and its function
+func (ec ExpandContract) trigger() string {
+	return strings.Replace(ec.Table, ".", "_", -1) + "_" + ec.Column + "_expand"
+}
+
+func (ec ExpandContract) validate() error {
+	switch {
+	case ec.Table == "":
+		return errors.New("Expand/contract needs a table")
+	case ec.Column == "":
+		return errors.New("Expand/contract needs a column")
+	case ec.Type == "":
+		return errors.New("Expand/contract needs the new column's type")
+	case (ec.NewColumn == "" || ec.NewColumn == ec.Column) && ec.Type == ec.OldType:
+		return errors.New("Expand/contract needs a new column name or type")
+	}
+	return nil
+}
+
+// Plan returns the migrations of the expand/contract
+func (ec ExpandContract) Plan() (plan ExpandContractPlan, err error) {
+	if err = ec.validate(); err != nil {
+		return
+	}
+	table, col, newCol, trigger := ec.Table, ec.Column, ec.newColumn(), ec.trigger()
+	oldType, using, reverse := ec.OldType, ec.Using, ec.Reverse
+	if oldType == "" {
+		oldType = ec.Type
+	}
+	if using == "" {
+		using = col
+	}
+	if reverse == "" {
+		reverse = newCol
+	}
+	// a type change without a rename adds a temporary column
+	temp := newCol != ec.NewColumn
+
+	// the trigger evaluates the expressions on the row, so they can use any of its columns.
+	// Writes of the old column are converted to the new one, and the other way around.
+	convert := func(expr string) string { return "(SELECT " + expr + " FROM (SELECT NEW.*) AS r)" }
+	syncFunc := fmt.Sprintf(`CREATE FUNCTION %[1]s() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' AND NEW.%[2]s IS NULL OR TG_OP = 'UPDATE' AND NEW.%[3]s IS DISTINCT FROM OLD.%[3]s THEN
+		NEW.%[2]s := %[4]s;
+	ELSIF NEW.%[2]s IS DISTINCT FROM %[4]s THEN
+		NEW.%[3]s := %[5]s;
+	END IF;
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER %[1]s BEFORE INSERT OR UPDATE ON %[6]s
+	FOR EACH ROW EXECUTE PROCEDURE %[1]s();
+`, trigger, newCol, col, convert(using), convert(reverse), table)
+	dropSync := fmt.Sprintf("DROP TRIGGER IF EXISTS %[1]s ON %[2]s;\nDROP FUNCTION IF EXISTS %[1]s();\n", trigger, table)
+	lockTimeout := "SET LOCAL lock_timeout = '5s';\n\n"
+
+	plan.Expand[0] = lockTimeout +
+		fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;\n\n", table, newCol, ec.Type) + syncFunc
+	plan.Expand[1] = lockTimeout + dropSync +
+		fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s;\n", table, newCol)
+
+	plan.Backfill[0] = fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s;\n", table, newCol, using, ec.todo())
+
+	backfilled := fmt.Sprintf(`DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM %s WHERE %s) THEN
+		RAISE EXCEPTION '%s.%s isn''t backfilled';
+	END IF;
+END
+$$;
+`, table, ec.todo(), table, newCol)
+	plan.Contract[0] = lockTimeout + backfilled + "\n" + dropSync
+	if ec.NotNull {
+		plan.Contract[0] += fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET NOT NULL;\n", table, newCol)
+	}
+	plan.Contract[0] += fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;\n", table, col)
+	plan.Contract[1] = lockTimeout
+	if temp {
+		plan.Contract[0] += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;\n", table, newCol, col)
+		plan.Contract[1] += fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s;\n", table, col, newCol)
+	}
+	if ec.NotNull {
+		plan.Contract[1] += fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP NOT NULL;\n", table, newCol)
+	}
+	// the old column is filled before the trigger is created, so the new one isn't converted back
+	plan.Contract[1] += fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;\nUPDATE %s SET %s = %s;\n\n",
+		table, col, oldType, table, col, reverse) + syncFunc
+	return
+}
+
+// todo is the condition of the rows that aren't backfilled
+func (ec ExpandContract) todo() string {
+	return ec.newColumn() + " IS NULL AND " + ec.Column + " IS NOT NULL"
+}
+
+// Backfill returns the backfill of the existing rows in batches of batchSize rows, for tables
+// that are too big for the backfill migration's transaction. Run it with RunBackfill once the
+// expand is applied, then the backfill migration only updates the rows it missed.
+func (ec ExpandContract) Backfill(batchSize int) Backfill {
+	key := ec.Key
+	if key == "" {
+		key = "id"
+	}
+	using := ec.Using
+	if using == "" {
+		using = ec.Column
+	}
+	set := ec.newColumn() + " = " + using
+	return Backfill{
+		Name: ec.trigger(),
+		Total: func(db driver.Databaser) (n int64, err error) {
+			err = db.QueryRow("SELECT count(*) FROM " + ec.Table + " WHERE " + ec.todo()).Scan(&n)
+			return
+		},
+		Batch:   KeysetBatch(ec.Table, key, ec.todo(), set, batchSize),
+		Explain: keysetQuery(ec.Table, key, ec.todo(), set, batchSize, ""),
+	}
+}
+
+// CreateExpandContract creates the expand, backfill and contract migrations of the plan
+// as consecutive versions named <name>_expand, <name>_backfill and <name>_contract
+func (m *Migrator) CreateExpandContract(incMajor bool, name string, ec ExpandContract) ([]*file.MigrationFile, error) {
+	plan, err := ec.Plan()
+	if err != nil {
+		return nil, err
+	}
+	expand, err := m.Create(incMajor, name+"_expand", plan.Expand[0], plan.Expand[1])
+	if err != nil {
+		return nil, err
+	}
+	backfill, err := m.Create(false, name+"_backfill",
+		requires(expand.Version)+plan.Backfill[0], plan.Backfill[1])
+	if err != nil {
+		return nil, err
+	}
+	contract, err := m.Create(false, name+"_contract",
+		requires(backfill.Version)+plan.Contract[0], plan.Contract[1])
+	if err != nil {
+		return nil, err
+	}
+	return []*file.MigrationFile{expand, backfill, contract}, nil
+}
+
+// requires returns the file.RequiresDirective line of the version
+func requires(v file.Version) string {
+	return file.RequiresDirective + " " + v.String() + "\n"
+}
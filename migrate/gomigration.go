@@ -0,0 +1,145 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// GoMigrationFunc applies a Go migration in the migration's transaction. It must not
+// commit or roll back tx.
+type GoMigrationFunc func(ctx context.Context, tx driver.Tx) error
+
+// goMigrationContent is stored as the content of a Go migration's files
+const goMigrationContent = "-- Go migration\n"
+
+var (
+	goMigrationsMu sync.RWMutex
+	goMigrations   = map[string]goMigration{}
+)
+
+type goMigration struct {
+	version  file.Version
+	up, down GoMigrationFunc
+}
+
+// RegisterGoMigration registers a version that's implemented in Go, e.g. a backfill that needs
+// application logic. It's ordered with the migration files by its version, which must not
+// have files. A nil down can't be rolled back. Migrators need a driver.ParallelDriver
+// to apply Go migrations. It panics if the version is registered twice.
+func RegisterGoMigration(version file.Version, up, down GoMigrationFunc) {
+	goMigrationsMu.Lock()
+	defer goMigrationsMu.Unlock()
+	if _, ok := goMigrations[version.String()]; ok {
+		panic(fmt.Sprintf("migrate: RegisterGoMigration called twice for version %v", version))
+	}
+	goMigrations[version.String()] = goMigration{version: version, up: up, down: down}
+}
+
+// migrationFile returns the Go migration as a migration file
+func (g goMigration) migrationFile() file.MigrationFile {
+	newFile := func(d direction.Direction, suffix string) *file.File {
+		return &file.File{
+			FileName:  g.version.MinorString() + "_go." + suffix + ".go",
+			Version:   g.version,
+			Name:      "go",
+			Content:   []byte(goMigrationContent),
+			Direction: d,
+		}
+	}
+	mf := file.MigrationFile{Version: g.version, UpFile: newFile(direction.Up, "up")}
+	if g.down != nil {
+		mf.DownFile = newFile(direction.Down, "down")
+	}
+	return mf
+}
+
+// MigrationFiles reads the migration files from Source, or Path, and adds the registered Go migrations.
+// With Render and ChecksumRendered, the files' content is rendered when it's read.
+func (m *Migrator) MigrationFiles() (file.MigrationFiles, error) {
+	openers, err := m.sourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	files, err := file.GetMigrationFiles(openers, m.Driver.FilenameExtension(), m.ParseOptions)
+	if err != nil {
+		return nil, err
+	}
+	if m.Render && m.ChecksumRendered {
+		m.renderOnRead(files)
+	}
+	goMigrationsMu.RLock()
+	defer goMigrationsMu.RUnlock()
+	for _, g := range goMigrations {
+		if _, ok := files.Find(g.version); ok {
+			return nil, fmt.Errorf("%w: version %v is a Go migration and has migration files", ErrInvalidFiles, g.version)
+		}
+		files = append(files, g.migrationFile())
+	}
+	sort.Sort(files)
+	return files, nil
+}
+
+// goMigrationFunc returns the Go func of the migration, if it's a Go migration
+func goMigrationFunc(mg *file.Migration) (fn GoMigrationFunc, isGo bool, err error) {
+	f := mg.File()
+	if f == nil {
+		return nil, false, nil
+	}
+	if err := f.ReadContent(); err != nil || string(f.Content) != goMigrationContent {
+		return nil, false, nil
+	}
+	goMigrationsMu.RLock()
+	g, ok := goMigrations[mg.Version.String()]
+	goMigrationsMu.RUnlock()
+	if !ok {
+		return nil, true, fmt.Errorf("Go migration %v isn't registered", mg.Version)
+	}
+	if mg.Up() {
+		return g.up, true, nil
+	}
+	if g.down == nil {
+		return nil, true, fmt.Errorf("%w for Go migration %v", ErrMissingDownFile, mg.Version)
+	}
+	return g.down, true, nil
+}
+
+// migrateGo records the migration's version, if record is set, and then runs its Go func
+func (m *Migrator) migrateGo(fn GoMigrationFunc, fnErr error, record bool, db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	defer close(pipe)
+	f := mg.File()
+	pipe <- f
+	if fnErr != nil {
+		pipe <- fnErr
+		return
+	}
+	rd, ok := m.Driver.(driver.ParallelDriver)
+	if !ok {
+		pipe <- unsupportedDriver("ParallelDriver")
+		return
+	}
+	tdb, ctx, cancel := m.withTimeout(db)
+	defer cancel()
+	tx, ok := tdb.(driver.Tx)
+	if !ok {
+		pipe <- fmt.Errorf("Go migration %v needs a transaction", mg.Version)
+		return
+	}
+	if record {
+		if err := m.recordVersion(rd, tx, mg); err != nil {
+			pipe <- err
+			return
+		}
+	}
+	if err := fn(ctx, tx); err != nil {
+		if timedOut(ctx) {
+			err = m.timeoutError(f, err)
+		}
+		pipe <- fmt.Errorf("%s: %w", f.FileName, err)
+	}
+}
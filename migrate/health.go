@@ -0,0 +1,47 @@
+package migrate
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/acls/migrate/driver"
+)
+
+// Healthy returns nil if the database is at the last version of the migration files, none of
+// the applied files drifted and no migration holds the lock, e.g. for a readiness probe, so
+// an application doesn't serve against an outdated schema. Migrations are transactional,
+// so a failed migration doesn't leave a dirty state behind. It returns an error wrapping
+// ErrNotMigrated or ErrLocked otherwise.
+func (m *Migrator) Healthy(conn driver.Conn) error {
+	conn, release, err := m.acquire(conn)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s, err := m.Status(conn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.Locked:
+		return fmt.Errorf("%w: schema %s", ErrLocked, m.Schema)
+	case s.Version.Compare(s.Latest) != 0:
+		return fmt.Errorf("%w: version %v, migration files %v", ErrNotMigrated, s.Version, s.Latest)
+	case len(s.Drifted) > 0:
+		return fmt.Errorf("%w: drifted versions %v", ErrNotMigrated, s.Drifted)
+	}
+	return nil
+}
+
+// HealthHandler serves Healthy as a readiness probe. It responds with 503 Service Unavailable
+// and the error if it isn't healthy. Pass a nil conn to open one with NewConn per request,
+// since a connection can't be shared by concurrent requests.
+func (m *Migrator) HealthHandler(conn driver.Conn) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Healthy(conn); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// ImportHistory records the migration files up to upTo as applied, without running them,
+// e.g. when taking over a database migrated by another tool. If upTo is nil, it's the last
+// version applied according to the other tool's version table. Returns the recorded migrations.
+func (m *Migrator) ImportHistory(conn driver.Conn, table string, upTo file.Version) (imported file.Migrations, err error) {
+	if upTo == nil {
+		hd, ok := m.Driver.(driver.HistoryDriver)
+		if !ok {
+			return nil, unsupportedDriver("HistoryDriver")
+		}
+		revert, err := hd.SearchPath(conn, m.SearchPath())
+		if err != nil {
+			return nil, err
+		}
+		tool, version, err := hd.LastForeignVersion(conn, table)
+		revert()
+		if err != nil {
+			return nil, err
+		}
+		if upTo, err = file.ParseVersion(version); err != nil {
+			return nil, fmt.Errorf("Unable to use %s version '%s', specify the version to import up to: %v", tool, version, err)
+		}
+	}
+
+	// ParallelDriver can record versions without applying them
+	rd, ok := m.Driver.(driver.ParallelDriver)
+	if !ok {
+		return nil, unsupportedDriver("ParallelDriver")
+	}
+
+	unlock, err := m.lock(conn)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	prevFiles, files, err := m.init(conn, true)
+	if err != nil {
+		return
+	}
+	if _, ok := files.Find(upTo); !ok {
+		return nil, versionNotFound(upTo)
+	}
+	for _, mg := range files.ToLastFrom(prevFiles.LastVersion()) {
+		if mg.Compare(upTo) > 0 {
+			break
+		}
+		imported = append(imported, mg)
+	}
+	if len(imported) == 0 {
+		return
+	}
+
+	if err = m.recordVersions(conn, rd, imported); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// recordVersions records the migrations in the version table in one transaction, without running them
+func (m *Migrator) recordVersions(conn driver.Conn, rd driver.ParallelDriver, migrations file.Migrations) error {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return err
+	}
+	defer revert()
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	for i := range migrations {
+		if err := m.recordVersion(rd, tx, &migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := m.recordApplied(tx, &migrations[i], m.Now(), 0); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
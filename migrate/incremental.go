@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"errors"
+	"io"
+	"path"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// IncrementalDump configures Dump to only write the tables that changed since a base dump.
+// The manifest records the base's location for the other tables, so restores read them from
+// the base, see file.NewIncrementalReader.
+type IncrementalDump struct {
+	// Base is the manifest of the base dump, which is at BaseLocation
+	Base         *file.Manifest
+	BaseLocation string
+	// ByMigrations only writes the tables touched by the migrations after the base's schema
+	// version, see file.TouchedTables, instead of comparing the hashes of the tables' rows.
+	// It's for databases whose data only changes through migrations. Otherwise the driver
+	// must be a driver.TableHashDriver, and the tables without a hash in the base are written.
+	// The hashes are read before the tables are dumped.
+	ByMigrations bool
+}
+
+// incrementalWriter skips the tables that didn't change since the base dump
+type incrementalWriter struct {
+	file.DumpWriter
+	mw      *file.ManifestWriter
+	inc     IncrementalDump
+	base    map[string]file.ManifestEntry // the base's tables by path
+	touched map[string]bool               // the tables touched by migrations, if ByMigrations
+	hashes  map[string]string             // the hashes of the tables, otherwise
+}
+
+func newIncrementalWriter(mw *file.ManifestWriter, inc IncrementalDump) *incrementalWriter {
+	return &incrementalWriter{DumpWriter: mw, mw: mw, inc: inc}
+}
+
+// init reads the base's tables, and the tables touched by the files after the base's version
+// or the hashes of the tables. It's called before the files' contents are released.
+func (w *incrementalWriter) init(m *Migrator, conn driver.Databaser, files file.MigrationFiles) error {
+	if w.inc.Base == nil {
+		return errors.New("Missing the manifest of the base dump")
+	}
+	w.base = map[string]file.ManifestEntry{}
+	for _, e := range w.inc.Base.Files {
+		if path.Dir(e.Path)+"/" == file.TablesDir {
+			w.base[e.Path] = e
+		}
+	}
+
+	if !w.inc.ByMigrations {
+		td, ok := m.Driver.(driver.TableHashDriver)
+		if !ok {
+			return unsupportedDriver("TableHashDriver")
+		}
+		hashes, err := td.TableHashes(conn, m.Schema)
+		if err != nil {
+			return err
+		}
+		w.hashes = hashes
+		return nil
+	}
+	if w.inc.Base.SchemaVersion == "" {
+		return errors.New("The base dump has no schema version")
+	}
+	since, err := file.ParseVersion(w.inc.Base.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	w.touched = map[string]bool{}
+	for _, f := range files {
+		if f.Compare(since) <= 0 || f.UpFile == nil {
+			continue
+		}
+		if err := f.UpFile.ReadContent(); err != nil {
+			return err
+		}
+		for _, table := range file.TouchedTables(f.UpFile.Content) {
+			w.touched[table] = true
+		}
+	}
+	return nil
+}
+
+// Writer returns file.ErrSkipFile for the tables that didn't change, and records their
+// entries from the base instead
+func (w *incrementalWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	if dir != file.TablesDir {
+		return w.DumpWriter.Writer(dir, name)
+	}
+	p := path.Join(dir, name)
+	base, inBase := w.base[p]
+	changed := true
+	if w.touched != nil {
+		changed = w.touched[name]
+	} else {
+		hash := w.hashes[name]
+		w.mw.SetContentHash(p, hash)
+		changed = hash == "" || base.ContentHash != hash
+	}
+	if inBase && !changed {
+		w.mw.Reuse(base, w.inc.BaseLocation)
+		return nil, file.ErrSkipFile
+	}
+	return w.DumpWriter.Writer(dir, name)
+}
@@ -0,0 +1,53 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// DefaultBigTableRows is the rows of a big table when LintOptions.BigTableRows isn't set
+const DefaultBigTableRows = 100000
+
+// Lint checks the up files of the migration files for dangerous operations, see file.LintRules.
+// Pass a nil conn to lint without a database, e.g. in code review. With a conn and a
+// driver.StatsDriver, volatile defaults are only flagged on big tables.
+func (m *Migrator) Lint(conn driver.Conn, opts file.LintOptions) (findings []file.LintFinding, err error) {
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil && opts.TableRows == nil {
+		if _, ok := m.Driver.(driver.StatsDriver); ok {
+			stats, err := m.ExportStats(conn)
+			if err != nil {
+				return nil, err
+			}
+			opts.TableRows = tableRows(stats)
+			if opts.BigTableRows == 0 {
+				opts.BigTableRows = DefaultBigTableRows
+			}
+		}
+	}
+	for _, mf := range files {
+		if mf.UpFile == nil {
+			continue
+		}
+		if err := mf.UpFile.ReadContent(); err != nil {
+			return nil, err
+		}
+		findings = append(findings, file.Lint(mf.UpFile.FileName, mf.UpFile.Content, opts)...)
+	}
+	return findings, nil
+}
+
+// tableRows looks up the estimated rows of the stats' tables
+func tableRows(stats driver.Stats) func(table string) (float64, bool) {
+	rows := make(map[string]float64, len(stats.Tables))
+	for _, t := range stats.Tables {
+		rows[t.Name] = t.Rows
+	}
+	return func(table string) (float64, bool) {
+		r, ok := rows[table]
+		return r, ok
+	}
+}
@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// lockRetryInterval is how often a locked migration tries to take the lock again
+var lockRetryInterval = time.Second
+
+// lock takes the schema's migration lock, waiting up to LockTimeout for another migrator
+// to release it. The lock is skipped with NoLock or if the driver isn't a driver.LockDriver.
+// The migrations of a Migrator made with New wait for each other first, even with NoLock.
+func (m *Migrator) lock(conn driver.Conn) (unlock func(), err error) {
+	release := func() {}
+	if m.sem != nil {
+		if err := m.waitMigrations(); err != nil {
+			return nil, err
+		}
+		release = func() { <-m.sem }
+	}
+	ld, ok := m.Driver.(driver.LockDriver)
+	if m.NoLock || !ok {
+		return release, nil
+	}
+	start := m.Now()
+	for {
+		ok, err := ld.TryLock(conn, m.Schema)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if ok {
+			return func() {
+				// unlock even if the context is done, e.g. after a shutdown signal
+				ld.Unlock(withContext(context.Background(), conn), m.Schema)
+				release()
+			}, nil
+		}
+		if err := m.waitLock(start); err != nil {
+			release()
+			return nil, err
+		}
+	}
+}
+
+// waitLock waits before trying to take the lock again. It returns ErrLocked after
+// LockTimeout, or the context's error when it's done.
+func (m *Migrator) waitLock(start time.Time) error {
+	if m.LockTimeout > 0 && m.Since(start) >= m.LockTimeout {
+		return fmt.Errorf("%w: schema %s after %v", ErrLocked, m.Schema, m.LockTimeout)
+	}
+	if m.ctx != nil && m.ctx.Err() != nil {
+		return m.ctx.Err()
+	}
+	m.clock().Sleep(lockRetryInterval)
+	return nil
+}
+
+// waitMigrations waits for the other migrations of the Migrator, up to LockTimeout
+func (m *Migrator) waitMigrations() error {
+	var timeout <-chan time.Time
+	if m.LockTimeout > 0 {
+		t := time.NewTimer(m.LockTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case m.sem <- struct{}{}:
+		return nil
+	case <-timeout:
+		return fmt.Errorf("%w: schema %s after %v", ErrLocked, m.Schema, m.LockTimeout)
+	case <-m.context().Done():
+		return m.context().Err()
+	}
+}
+
+// acquire returns conn, or a connection opened with NewConn if conn is nil.
+// release closes the opened connection.
+func (m *Migrator) acquire(conn driver.Conn) (_ driver.Conn, release func(), err error) {
+	if conn != nil {
+		return conn, func() {}, nil
+	}
+	if m.NewConn == nil {
+		return nil, nil, errors.New("Connection is nil and NewConn isn't set")
+	}
+	if conn, err = m.Connect(); err != nil {
+		return nil, nil, err
+	}
+	return conn, func() { conn.Close() }, nil
+}
+
+// acquireLock acquires a connection, see acquire, and takes the lock with it
+func (m *Migrator) acquireLock(conn driver.Conn) (_ driver.Conn, unlock func(), err error) {
+	conn, release, err := m.acquire(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	unlockConn, err := m.lock(conn)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return conn, func() {
+		unlockConn()
+		release()
+	}, nil
+}
+
+// locked acquires a connection and takes the lock with it, see acquireLock, runs fn and
+// closes the pipe with fn's error. The pipe is closed after the lock is released, so the
+// caller can use conn again as soon as it's closed.
+func (m *Migrator) locked(pipe chan interface{}, conn driver.Conn, fn func(conn driver.Conn) error) {
+	conn, unlock, err := m.acquireLock(conn)
+	if err == nil {
+		err = fn(conn)
+		unlock()
+	}
+	go pipep.Close(pipe, err)
+}
+
+// isLocked returns true if another migrator holds the schema's migration lock
+func (m *Migrator) isLocked(conn driver.Conn) (bool, error) {
+	ld, ok := m.Driver.(driver.LockDriver)
+	if !ok {
+		return false, nil
+	}
+	ok, err := ld.TryLock(conn, m.Schema)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return true, nil
+	}
+	return false, ld.Unlock(conn, m.Schema)
+}
+
+// ForceUnlock releases the schema's migration lock held by any migrator,
+// e.g. one that hung. Only use it when no migration is running.
+func (m *Migrator) ForceUnlock(conn driver.Conn) error {
+	ld, ok := m.Driver.(driver.LockDriver)
+	if !ok {
+		return unsupportedDriver("LockDriver")
+	}
+	return ld.ForceUnlock(conn, m.Schema)
+}
@@ -0,0 +1,44 @@
+package migrate
+
+import (
+	"log/slog"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+	"github.com/acls/migrate/secret"
+)
+
+// logEvent logs the event to the Logger: started migrations at debug level, applied
+// migrations, dumped tables and updated files at info level and failed migrations at error level
+func (m *Migrator) logEvent(e Event) {
+	ctx := m.context()
+	switch e := e.(type) {
+	case MigrationStarted:
+		m.Logger.LogAttrs(ctx, slog.LevelDebug, "Migration started", fileAttrs(e.File)...)
+	case MigrationApplied:
+		attrs := append(fileAttrs(e.File), slog.Duration("duration", e.Duration))
+		m.Logger.LogAttrs(ctx, slog.LevelInfo, "Migration applied", attrs...)
+	case MigrationFailed:
+		attrs := append(fileAttrs(e.File), slog.String("error", secret.Redact(e.Err.Error())))
+		m.Logger.LogAttrs(ctx, slog.LevelError, "Migration failed", attrs...)
+	case TableDumped:
+		m.Logger.LogAttrs(ctx, slog.LevelInfo, "Table dumped", slog.String("schema", m.Schema), slog.String("table", e.Table))
+	case FilesUpdated:
+		m.Logger.LogAttrs(ctx, slog.LevelInfo, "Files updated", slog.Int("updated", e.Updated), slog.Int("total", e.Total))
+	case SnapshotTaken:
+		m.Logger.LogAttrs(ctx, slog.LevelInfo, "Snapshot taken", slog.String("schema", m.Schema), slog.String("location", e.Location))
+	}
+}
+
+// fileAttrs are the log attributes of a migration file
+func fileAttrs(f *file.File) []slog.Attr {
+	attrs := []slog.Attr{slog.String("file", f.FileName)}
+	if f.Version != nil {
+		attrs = append(attrs, slog.String("version", f.Version.String()))
+	}
+	dir := "up"
+	if f.Direction == direction.Down {
+		dir = "down"
+	}
+	return append(attrs, slog.String("direction", dir))
+}
@@ -0,0 +1,107 @@
+package migrate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// MarkApplied records the versions as applied, storing their file contents, without
+// running them, e.g. after they were applied by hand during an incident.
+// The versions must be the next pending versions, since versions are applied in order.
+// Returns the recorded migrations.
+func (m *Migrator) MarkApplied(conn driver.Conn, versions ...file.Version) (marked file.Migrations, err error) {
+	rd, ok := m.Driver.(driver.ParallelDriver)
+	if !ok {
+		return nil, unsupportedDriver("ParallelDriver")
+	}
+	unlock, err := m.lock(conn)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	prevFiles, files, err := m.init(conn, true)
+	if err != nil {
+		return
+	}
+	versions = sortedVersions(versions, false)
+	pending := files.ToLastFrom(prevFiles.LastVersion())
+	for i, v := range versions {
+		if _, ok := files.Find(v); !ok {
+			return nil, versionNotFound(v)
+		}
+		if i >= len(pending) || pending[i].Compare(v) != 0 {
+			return nil, fmt.Errorf("Can't mark %v as applied, the next pending version is %v", v, nextVersion(pending, i))
+		}
+		marked = append(marked, pending[i])
+	}
+	if err = m.recordVersions(conn, rd, marked); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// MarkReverted deletes the versions from the version table without running their down files,
+// e.g. after they were rolled back by hand. The versions must be the last applied versions.
+// Returns the deleted migrations.
+func (m *Migrator) MarkReverted(conn driver.Conn, versions ...file.Version) (marked file.Migrations, err error) {
+	rd, ok := m.Driver.(driver.ParallelDriver)
+	if !ok {
+		return nil, unsupportedDriver("ParallelDriver")
+	}
+	unlock, err := m.lock(conn)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	prevFiles, _, err := m.init(conn, false)
+	if err != nil {
+		return
+	}
+	versions = sortedVersions(versions, true)
+	sort.Sort(prevFiles)
+	for i, v := range versions {
+		if _, ok := prevFiles.Find(v); !ok {
+			return nil, fmt.Errorf("%w: %v isn't applied", ErrVersionNotFound, v)
+		}
+		j := len(prevFiles) - 1 - i
+		if prevFiles[j].Compare(v) != 0 {
+			return nil, fmt.Errorf("Can't mark %v as reverted, the last applied version is %v", v, prevFiles[j].Version)
+		}
+		marked = append(marked, prevFiles[j].Migration(direction.Down))
+	}
+	if err = m.recordVersions(conn, rd, marked); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// sortedVersions returns the unique versions sorted, descending if reverse is set
+func sortedVersions(versions []file.Version, reverse bool) []file.Version {
+	versions = append([]file.Version(nil), versions...)
+	sort.Slice(versions, func(i, j int) bool {
+		if reverse {
+			return versions[i].Compare(versions[j]) > 0
+		}
+		return versions[i].Compare(versions[j]) < 0
+	})
+	unique := versions[:0]
+	for i, v := range versions {
+		if i == 0 || v.Compare(versions[i-1]) != 0 {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+func nextVersion(pending file.Migrations, i int) interface{} {
+	if i < len(pending) {
+		return pending[i].Version
+	}
+	return "none"
+}
@@ -3,14 +3,19 @@
 package migrate
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/acls/migrate/driver"
 	"github.com/acls/migrate/file"
@@ -18,23 +23,134 @@ import (
 	pipep "github.com/acls/migrate/pipe"
 )
 
-// Migrator struct
+// Migrator struct. A Migrator made with New can be shared by goroutines: its migrations
+// wait for each other, even on the same connection. Don't change its fields while it's in
+// use. A connection is only used by one goroutine at a time, so concurrent callers should
+// pass their own connection, or nil to get one from NewConn.
 type Migrator struct {
 	Driver driver.Driver
 	// Path for schema migrations.
 	Path string
+	// Source of the migration files, e.g. an FSSource for embedded files. Defaults to
+	// the Path directory. Create and templates still use Path.
+	Source Source
 	// // Path for storing executed migrations that are used for validation and for downgrading when the versions don't exist in MigrationsPath
 	// PrevPath string
 	// True if a transaction should be used for each file instead of per each major version
 	TxPerFile bool
-	// True if the migration should be interruptable
+	// True if the migration should be interruptable by the ShutdownSignals. It stops after
+	// the current file, rolls back the open transaction and releases the lock.
 	Interrupts bool
 	// Don't validate base upfiles
 	Force bool
+	// ParseOptions are used to read the migration files. Files that can't be parsed are
+	// skipped silently unless Warn or Strict is set.
+	ParseOptions file.ParseOptions
 	// Schema to use
 	Schema string
 	// ExtraSchemas to put in search path
 	ExtraSchemas []string
+	// PrevConn, if set, is used to read the migration files from the version table
+	// of another database instead of from Path. E.g. promoting staging to production.
+	PrevConn driver.Conn
+	// Parallel applies consecutive major versions that don't depend on each other
+	// concurrently. Requires V2, a driver.ParallelDriver and NewConn. See file.DependsFile.
+	// If a commit of a wave fails after others succeeded, the error is an ErrPartialWave.
+	Parallel bool
+	// NewConn opens the extra connections used by Parallel, and the connection of the
+	// migrations and Version and Pending when they're passed a nil conn. It's closed when
+	// they're done, so it can return a connection of a pool.
+	NewConn func() (driver.Conn, error)
+	// WaitTimeout is how long to retry NewConn, with exponential backoff, until the database
+	// is reachable. 0 tries once. See WaitForDatabase.
+	WaitTimeout time.Duration
+	// Clock defaults to SystemClock
+	Clock Clock
+	// NoLock doesn't take the schema's migration lock. By default, migrations wait
+	// for the lock if the driver is a driver.LockDriver.
+	NoLock bool
+	// LockTimeout is how long to wait for another migrator's lock before ErrLocked.
+	// 0 waits until the lock is released.
+	LockTimeout time.Duration
+	// ReportNoChange sends ErrNoChange to the pipe when there are no migrations to apply
+	ReportNoChange bool
+	// EventHandler, if set, receives typed events alongside the items sent to the pipe
+	EventHandler EventHandler
+	// Logger, if set, logs the events as structured records alongside the items sent to the pipe
+	Logger *slog.Logger
+	// Tracer, if set, starts spans for the migrations, dumps and restores. They're children
+	// of the span in the context of the *Ctx functions.
+	Tracer Tracer
+	// Render renders migration files as text/templates with RenderData before applying them.
+	// The stored files, and the checksums they're validated with, are the templates unless
+	// ChecksumRendered is set. Requires a driver.ParallelDriver.
+	Render bool
+	// ChecksumRendered stores and validates the rendered files instead of the templates,
+	// so changing Vars is drift
+	ChecksumRendered bool
+	// Vars are passed to rendered files as .Vars
+	Vars map[string]string
+	// TxOptions are the isolation level and access mode of the migration transactions.
+	// Requires a driver.TxOptionsDriver if set.
+	TxOptions driver.TxOptions
+	// ContinueOnError rolls back only the file that fails, to a savepoint, and commits the
+	// files before it in its transaction, instead of rolling back the whole transaction.
+	// The migration still stops at the failed file, since versions are applied in order.
+	// Has no effect with TxPerFile. Requires a driver.SavepointDriver if set.
+	ContinueOnError bool
+	// SkipContent records versions with their checksums but without the contents of their
+	// files, e.g. when the files are kept elsewhere. Down migrations read the down files
+	// from Source or Path instead. Requires a driver.ContentDriver if set.
+	SkipContent bool
+	// AllowOutOfOrder applies the files with a version lower than the database's version that
+	// aren't applied, e.g. when merged late from another branch, before the newer files on
+	// Up. They fail with file.ErrOutOfOrder otherwise.
+	AllowOutOfOrder bool
+	// Audit records each run of MigrateFiles, and the functions that call it, in the driver's
+	// audit log, even when its transaction is rolled back or it fails before applying any file,
+	// e.g. to take the lock. Requires a driver.AuditDriver if set.
+	Audit bool
+	// Snapshots, if set, dumps the schema to a new snapshot before each run of MigrateFiles
+	// that applies migrations. The snapshot's location is recorded with the run in the audit
+	// log, see RollbackToSnapshot. Requires a driver.DumpDriver and a driver.CopyConn.
+	Snapshots Snapshots
+	// ToolVersion is recorded in the manifests of dumps, e.g. the cli's version
+	ToolVersion string
+	// Incremental, if set, makes Dump only write the tables that changed since a base dump
+	Incremental *IncrementalDump
+	// DumpContents selects the schema files, the table data or both for Dump and Restore
+	DumpContents DumpContents
+	// DumpJobs dumps or restores up to DumpJobs tables at once, each over its own connection.
+	// The ones after the conn of Dump or Restore are opened with NewConn, which must return
+	// driver.CopyConns. Requires a driver.ParallelDumpDriver for dumps and a
+	// driver.ParallelRestoreDriver for restores if it's more than 1. Restores from streams
+	// restore one table at a time.
+	DumpJobs int
+	// DumpSnapshot dumps all the tables as of the same moment, by sharing the snapshot of
+	// Dump's conn with the other connections. Requires a driver.ParallelDumpDriver.
+	DumpSnapshot bool
+	// Command is recorded as the command of the runs in the audit log, e.g. the cli's
+	// command. Defaults to the direction of the run.
+	Command string
+	// Notifiers are notified with a summary after each run of MigrateFiles, whether it
+	// succeeded or failed
+	Notifiers []Notifier
+	// Retry retries the transactions of migrations that fail with transient errors
+	Retry RetryPolicy
+	// MigrationTimeout cancels a migration that runs longer, or each statement with Statements,
+	// and sends ErrMigrationTimeout. Its transaction is rolled back. Requires a transaction
+	// that's a driver.ContextDatabaser. 0 doesn't time out.
+	MigrationTimeout time.Duration
+	// Statements applies each file one statement at a time, if the driver is a
+	// driver.StatementDriver, and sends a *StatementError for the statement that fails
+	Statements bool
+
+	// ctx is set by the *Ctx functions
+	ctx context.Context
+	// sem serializes the migrations of a Migrator made with New
+	sem chan struct{}
+	// run is the run that's recorded, set on the copy that runs it
+	run *runRecord
 }
 
 func (m *Migrator) SearchPath() string {
@@ -42,31 +158,59 @@ func (m *Migrator) SearchPath() string {
 }
 
 func (m *Migrator) init(conn driver.Conn, validate bool) (prevFiles, files file.MigrationFiles, err error) {
+	return m.initFiles(conn, validate, false)
+}
+
+// initReadOnly is init for the commands that only read, e.g. Status and Plan. A current
+// version table isn't ensured, so they don't run its DDL, and a missing one has no versions.
+// The table is still ensured if it's outdated, or if the driver isn't a driver.VersionTableDriver.
+func (m *Migrator) initReadOnly(conn driver.Conn, validate bool) (prevFiles, files file.MigrationFiles, err error) {
+	return m.initFiles(conn, validate, true)
+}
+
+func (m *Migrator) initFiles(conn driver.Conn, validate, readOnly bool) (prevFiles, files file.MigrationFiles, err error) {
 	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
 	if err != nil {
 		return
 	}
 	defer revert()
 
-	if err = m.Driver.EnsureVersionTable(conn, m.Schema); err != nil {
+	if err = m.checkContent(); err != nil {
 		return
 	}
+	exists, current := true, false
+	if vd, ok := m.Driver.(driver.VersionTableDriver); ok && readOnly {
+		if exists, current, err = vd.VersionTableState(conn); err != nil {
+			return
+		}
+	}
+	if exists && !current {
+		if err = m.Driver.EnsureVersionTable(conn, m.Schema); err != nil {
+			return
+		}
+	}
 
-	prevFiles, err = m.Driver.GetMigrationFiles(conn)
-	if err != nil {
-		return
+	if exists {
+		if prevFiles, err = m.Driver.GetMigrationFiles(conn); err != nil {
+			return
+		}
 	}
 
-	files, err = file.ReadMigrationFiles(m.Path, m.Driver.FilenameExtension())
+	files, err = m.readMigrationFiles()
 	if err != nil {
 		return
 	}
-	version, err := m.Driver.Version(conn)
-	if err != nil {
-		return
+	if m.SkipContent {
+		sourceDownFiles(prevFiles, files)
 	}
-	if prevFiles.LastVersion().Compare(version) != 0 {
-		panic(fmt.Errorf("Last file version %v is less than database version %v", prevFiles.LastVersion(), version))
+	if exists {
+		version, err := m.Driver.Version(conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		if prevFiles.LastVersion().Compare(version) != 0 {
+			panic(fmt.Errorf("Last file version %v is less than database version %v", prevFiles.LastVersion(), version))
+		}
 	}
 
 	if validate && !m.Force {
@@ -75,21 +219,198 @@ func (m *Migrator) init(conn driver.Conn, validate bool) (prevFiles, files file.
 		if l > len(files) {
 			l = len(files)
 		}
-		if err = files.ValidateBaseFiles(prevFiles[:l]); err != nil {
+		if err = m.validateBaseFiles(files, prevFiles[:l]); err != nil {
 			return
 		}
 	}
 	return
 }
 
-// Up applies all available migrations
-func (m *Migrator) Up(pipe chan interface{}, conn driver.Conn) {
+// readMigrationFiles reads the migration files from PrevConn, if set, otherwise from Source
+func (m *Migrator) readMigrationFiles() (file.MigrationFiles, error) {
+	if m.PrevConn == nil {
+		return m.MigrationFiles()
+	}
+	revert, err := m.Driver.SearchPath(m.PrevConn, m.SearchPath())
+	if err != nil {
+		return nil, err
+	}
+	defer revert()
+	return m.Driver.GetMigrationFiles(m.PrevConn)
+}
+
+// Verify validates that the base upfiles match the files stored in the database
+func (m *Migrator) Verify(conn driver.Conn) error {
+	prevFiles, files, err := m.init(conn, false)
+	if err != nil {
+		return err
+	}
+	l := len(prevFiles)
+	if l > len(files) {
+		l = len(files)
+	}
+	return m.validateBaseFiles(files, prevFiles[:l])
+}
+
+// IsApplied returns true if the version is stored in the database
+func (m *Migrator) IsApplied(conn driver.Conn, version file.Version) (bool, error) {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return false, err
+	}
+	defer revert()
+
+	prevFiles, err := m.Driver.GetMigrationFiles(conn)
+	if err != nil {
+		return false, err
+	}
+	_, ok := prevFiles.Find(version)
+	return ok, nil
+}
+
+// ResolveVersion parses s as a version or, if that fails, finds the version of
+// the migration file named s. E.g. "add_users_index"
+func (m *Migrator) ResolveVersion(s string) (file.Version, error) {
+	if version, err := file.ParseVersion(s); err == nil {
+		return version, nil
+	}
+	files, err := m.readMigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	found := files.FindByName(s)
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%w: no version or migration named '%s'", ErrVersionNotFound, s)
+	case 1:
+		return found[0].Version, nil
+	}
+	versions := make([]string, len(found))
+	for i, f := range found {
+		versions[i] = f.Version.String()
+	}
+	return nil, fmt.Errorf("Migration name '%s' is ambiguous, matches versions: %s", s, strings.Join(versions, ", "))
+}
+
+// StoredMigration is a migration as stored in the version table
+type StoredMigration struct {
+	Version file.Version
+	// UpFile and DownFile contain the stored file contents
+	UpFile   *file.File
+	DownFile *file.File
+	// AppliedAt and Duration are zero if unknown
+	AppliedAt time.Time
+	Duration  time.Duration
+}
+
+// StoredMigrations returns the migrations stored in the database between
+// fromVersion and toVersion, inclusive. A nil version is unbounded.
+func (m *Migrator) StoredMigrations(conn driver.Conn, fromVersion, toVersion file.Version) (migrations []StoredMigration, err error) {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return
+	}
+	defer revert()
+
+	prevFiles, err := m.Driver.GetMigrationFiles(conn)
+	if err != nil {
+		return
+	}
+	sort.Sort(prevFiles)
+	for _, mf := range prevFiles {
+		if fromVersion != nil && mf.Compare(fromVersion) < 0 {
+			continue
+		}
+		if toVersion != nil && mf.Compare(toVersion) > 0 {
+			break
+		}
+		if err = mf.UpFile.ReadContent(); err != nil {
+			return
+		}
+		if err = mf.DownFile.ReadContent(); err != nil {
+			return
+		}
+		migrations = append(migrations, StoredMigration{
+			Version:   mf.Version,
+			UpFile:    mf.UpFile,
+			DownFile:  mf.DownFile,
+			AppliedAt: mf.AppliedAt,
+			Duration:  mf.Duration,
+		})
+	}
+	return
+}
+
+// StoredFile returns the up or down file for the version as stored in the database.
+// Falls back to the file in Path when the database doesn't have the content.
+// stored is false when the file was read from Path.
+func (m *Migrator) StoredFile(conn driver.Conn, version file.Version, d direction.Direction) (f *file.File, stored bool, err error) {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return
+	}
+	defer revert()
+
+	prevFiles, err := m.Driver.GetMigrationFiles(conn)
+	if err != nil {
+		return
+	}
+	if mf, ok := prevFiles.Find(version); ok {
+		mg := mf.Migration(d)
+		f = mg.File()
+		if err = f.ReadContent(); err != nil {
+			return
+		}
+		// versions migrated before contents were stored have empty contents
+		if len(f.Content) > 0 {
+			return f, true, nil
+		}
+	}
+
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return
+	}
+	mf, ok := files.Find(version)
+	if !ok {
+		return nil, false, versionNotFound(version)
+	}
+	mg := mf.Migration(d)
+	f = mg.File()
+	err = f.ReadContent()
+	return
+}
+
+// Pending returns the database version and the up migrations that haven't been applied.
+// Returns an error if the migration files don't match the files stored in the database.
+func (m *Migrator) Pending(conn driver.Conn) (version file.Version, pending file.Migrations, err error) {
+	conn, release, err := m.acquire(conn)
+	if err != nil {
+		return
+	}
+	defer release()
 	prevFiles, files, err := m.init(conn, true)
 	if err != nil {
-		go pipep.Close(pipe, err)
 		return
 	}
-	m.up(pipe, conn, prevFiles, files, prevFiles.LastVersion())
+	version = prevFiles.LastVersion()
+	if last := files.LastVersion(); version.Compare(last) > 0 {
+		err = fmt.Errorf("%w: %v is after %v", ErrAheadOfFiles, version, last)
+		return
+	}
+	pending = m.planUp(prevFiles, files)
+	return
+}
+
+// Up applies all available migrations
+func (m *Migrator) Up(pipe chan interface{}, conn driver.Conn) {
+	m.lockedRun(pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) error {
+		prevFiles, files, err := m.init(conn, true)
+		if err != nil {
+			return err
+		}
+		return m.applyFiles(pipe, conn, prevFiles, files, m.planUp(prevFiles, files))
+	})
 }
 func (m *Migrator) up(pipe chan interface{}, conn driver.Conn, prevFiles, files file.MigrationFiles, version file.Version) {
 	applyMigrations := files.ToLastFrom(version)
@@ -105,14 +426,14 @@ func (m *Migrator) UpSync(conn driver.Conn) []error {
 
 // Down rolls back all migrations
 func (m *Migrator) Down(pipe chan interface{}, conn driver.Conn) {
-	prevFiles, files, err := m.init(conn, true)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	applyMigrations := files.ToFirstFrom(prevFiles.LastVersion())
-	m.MigrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+	m.lockedRun(pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) error {
+		prevFiles, files, err := m.init(conn, true)
+		if err != nil {
+			return err
+		}
+		applyMigrations := files.ToFirstFrom(prevFiles.LastVersion())
+		return m.applyFiles(pipe, conn, prevFiles, files, applyMigrations)
+	})
 }
 
 // DownSync is synchronous version of Down
@@ -126,7 +447,7 @@ func (m *Migrator) DownSync(conn driver.Conn) []error {
 func (m *Migrator) Redo(pipe chan interface{}, conn driver.Conn) {
 	pipe1 := pipep.New()
 	go m.Migrate(pipe1, conn, -1)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+	if ok := m.waitAndRedirect(pipe1, pipe); !ok {
 		go pipep.Close(pipe, nil)
 		return
 	} else {
@@ -145,7 +466,7 @@ func (m *Migrator) RedoSync(conn driver.Conn) []error {
 func (m *Migrator) Reset(pipe chan interface{}, conn driver.Conn) {
 	pipe1 := pipep.New()
 	go m.Down(pipe1, conn)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+	if ok := m.waitAndRedirect(pipe1, pipe); !ok {
 		go pipep.Close(pipe, nil)
 		return
 	} else {
@@ -162,40 +483,18 @@ func (m *Migrator) ResetSync(conn driver.Conn) []error {
 
 // MigrateBetween migrates to the destination version
 func (m *Migrator) MigrateBetween(pipe chan interface{}, conn driver.Conn) (curVersion, dstVersion file.Version) {
-	prevFiles, files, err := m.init(conn, !m.Force)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	var applyMigrations file.Migrations
-	if len(prevFiles) == 0 {
-		// no previous files so just migrate up or down depending on versions
-		sort.Sort(files) // make sure LastVersion is correct
-		curVersion = prevFiles.LastVersion()
-		dstVersion = files.LastVersion()
-		if curVersion.Compare(dstVersion) <= 0 { // migrate up
-			applyMigrations = files.ToLastFrom(curVersion)
-		} else { // migrate down
-			applyMigrations = files.DownTo(dstVersion)
+	m.lockedRun(pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) error {
+		prevFiles, files, err := m.init(conn, !m.Force)
+		if err != nil {
+			return err
 		}
-	} else {
-		// migrate between previous files and current files
-		curVersion, dstVersion, applyMigrations, err = files.Between(prevFiles, m.Force)
+		var applyMigrations file.Migrations
+		curVersion, dstVersion, applyMigrations, err = m.planMigrateBetween(prevFiles, files)
 		if err != nil {
-			go pipep.Close(pipe, err)
-			return
+			return err
 		}
-		// // TODO: delete? this should be possible with file contents stored in and fetched from db
-		// sort.Sort(prevFiles) // ensure correct sort
-		// version := prevFiles.LastVersion()
-		// if curVersion.Compare(version) != 0 {
-		// 	go pipep.Close(pipe, fmt.Errorf("Database version(%v) doesn't match current migration files version(%v)", curVersion, version))
-		// 	return
-		// }
-	}
-
-	m.MigrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+		return m.applyFiles(pipe, conn, prevFiles, files, applyMigrations)
+	})
 	return
 }
 
@@ -211,20 +510,18 @@ func (m *Migrator) MigrateBetweenSync(conn driver.Conn) (curVersion, dstVersion
 
 // MigrateTo migrates to the destination version
 func (m *Migrator) MigrateTo(pipe chan interface{}, conn driver.Conn, dstVersion file.Version) (version file.Version) {
-	prevFiles, files, err := m.init(conn, true)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	version = prevFiles.LastVersion()
-	applyMigrations, err := files.FromTo(version, dstVersion)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	m.MigrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+	m.lockedRun(pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) error {
+		prevFiles, files, err := m.init(conn, true)
+		if err != nil {
+			return err
+		}
+		version = prevFiles.LastVersion()
+		applyMigrations, err := files.FromTo(version, dstVersion)
+		if err != nil {
+			return err
+		}
+		return m.applyFiles(pipe, conn, prevFiles, files, applyMigrations)
+	})
 	return
 }
 
@@ -240,19 +537,17 @@ func (m *Migrator) MigrateToSync(conn driver.Conn, dstVersion file.Version) (ver
 
 // Migrate applies relative +n/-n migrations
 func (m *Migrator) Migrate(pipe chan interface{}, conn driver.Conn, relativeN int) {
-	prevFiles, files, err := m.init(conn, true)
-	if err != nil {
-		go pipep.Close(pipe, err)
-		return
-	}
-
-	applyMigrations := files.From(prevFiles.LastVersion(), relativeN)
-
-	if relativeN == 0 {
-		applyMigrations = nil
-	}
-
-	m.MigrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+	m.lockedRun(pipe, conn, func(m *Migrator, pipe chan interface{}, conn driver.Conn) error {
+		prevFiles, files, err := m.init(conn, true)
+		if err != nil {
+			return err
+		}
+		applyMigrations := files.From(prevFiles.LastVersion(), relativeN)
+		if relativeN == 0 {
+			applyMigrations = nil
+		}
+		return m.applyFiles(pipe, conn, prevFiles, files, applyMigrations)
+	})
 }
 
 // MigrateSync is synchronous version of Migrate
@@ -265,7 +560,10 @@ func (m *Migrator) MigrateSync(conn driver.Conn, relativeN int) []error {
 // Create creates new migration files on disk
 func (m *Migrator) Create(incMajor bool, name string, contents ...string) (*file.MigrationFile, error) {
 	migrationsPath := m.Path
-	files, err := file.ReadMigrationFiles(migrationsPath, m.Driver.FilenameExtension())
+	if migrationsPath == "" {
+		return nil, errors.New("Create needs a Path")
+	}
+	files, err := m.MigrationFiles()
 	if err != nil {
 		return nil, err
 	}
@@ -317,10 +615,27 @@ func (m *Migrator) Create(incMajor bool, name string, contents ...string) (*file
 
 // MigrateFiles applies migrations in given files
 func (m *Migrator) MigrateFiles(pipe chan interface{}, conn driver.Conn, prevFiles, files file.MigrationFiles, applyMigrations file.Migrations) {
-	err := m.migrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+	if !m.recording() || m.run != nil {
+		go pipep.Close(pipe, m.applyFiles(pipe, conn, prevFiles, files, applyMigrations))
+		return
+	}
+	err := m.recorded(pipe, conn, func(m *Migrator, pipe chan interface{}) error {
+		return m.applyFiles(pipe, conn, prevFiles, files, applyMigrations)
+	})
 	go pipep.Close(pipe, err)
 }
 
+// applyFiles is MigrateFiles without closing the pipe
+func (m *Migrator) applyFiles(pipe chan interface{}, conn driver.Conn, prevFiles, files file.MigrationFiles, applyMigrations file.Migrations) error {
+	m, span := m.startSpan("migrate", migrationAttrs(m.Schema, applyMigrations)...)
+	err := m.startRun(conn, prevFiles, applyMigrations)
+	if err == nil {
+		err = m.migrateFiles(pipe, conn, prevFiles, files, applyMigrations)
+	}
+	endSpan(span, err)
+	return err
+}
+
 func (m *Migrator) migrateFiles(pipe chan interface{}, conn driver.Conn, prevFiles, files file.MigrationFiles, applyMigrations file.Migrations) error {
 	var (
 		d           = m.Driver
@@ -343,12 +658,19 @@ func (m *Migrator) migrateFiles(pipe chan interface{}, conn driver.Conn, prevFil
 	}
 
 	updateFiles := func(stopAt file.Version) (err error) {
-		tx, err = conn.Begin()
+		tx, err = m.begin(conn)
 		if err != nil {
 			return err
 		}
 
 		sort.Sort(files) // ensure sorted ascending
+		if bd, ok := d.(driver.BatchUpdateDriver); ok {
+			if err := m.updateFilesBatch(pipe, bd, tx, files, stopAt); err != nil {
+				tx.Rollback()
+				return err
+			}
+			return commit()
+		}
 		for _, mf := range files {
 			if mf.Compare(stopAt) >= 0 {
 				break
@@ -361,8 +683,8 @@ func (m *Migrator) migrateFiles(pipe chan interface{}, conn driver.Conn, prevFil
 			// update file contents
 			f := mf.Migration(direction.Up)
 			pipe1 := pipep.New()
-			go d.UpdateFiles(tx, &f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+			go m.updateFiles(tx, &f, pipe1)
+			if ok := m.waitAndRedirect(pipe1, pipe); !ok {
 				return tx.Rollback()
 			}
 		}
@@ -384,13 +706,27 @@ func (m *Migrator) migrateFiles(pipe chan interface{}, conn driver.Conn, prevFil
 				return err
 			}
 			if len(first.Content) == 0 {
-				return updateFiles(files.LastVersion().Inc(true))
+				if err := updateFiles(files.LastVersion().Inc(true)); err != nil {
+					return err
+				}
 			}
 		}
+		applied, err := m.migrateRepeatables(pipe, conn)
+		if err != nil {
+			return err
+		}
 		// no migrations to apply
+		if m.ReportNoChange && applied == 0 {
+			return ErrNoChange
+		}
 		return nil
 	}
 
+	applyMigrations, err = orderRequires(prevFiles, files, applyMigrations)
+	if err != nil {
+		return err
+	}
+
 	// In case the file content on disk has changed, such as
 	// fixing a down file, on up migrations ensure previous
 	// migration content matches content on disk.
@@ -401,33 +737,101 @@ func (m *Migrator) migrateFiles(pipe chan interface{}, conn driver.Conn, prevFil
 		}
 	}
 
+	callbacks, err := m.Callbacks()
+	if err != nil {
+		return err
+	}
+	if err := m.runCallback(pipe, conn, callbacks.BeforeAll); err != nil {
+		return err
+	}
+	// finish applies the repeatable migrations and runs the after all callback
+	finish := func() error {
+		// repeatable migrations follow the latest definitions, so skip them when migrating down
+		if applyMigrations[len(applyMigrations)-1].Up() {
+			if _, err := m.migrateRepeatables(pipe, conn); err != nil {
+				return err
+			}
+		}
+		return m.runCallback(pipe, conn, callbacks.AfterAll)
+	}
+
+	// the after each callback runs in the transaction of each file, so it can't run in parallel
+	if pd, ok := m.parallelDriver(applyMigrations); ok && callbacks.AfterEach == nil {
+		if ok, err := m.migrateParallel(pipe, conn, pd, applyMigrations); !ok {
+			return err
+		}
+		return finish()
+	}
+
+	var txMigrations file.Migrations // applied in the open transaction
+	retries := 0
+	// apply applies the migrations in the open transaction, and commits it if commitTx is set.
+	// After a transient error, the transaction is rolled back, begun again and all of its
+	// migrations are applied again, up to Retry.Retries times.
+	apply := func(migrations file.Migrations, commitTx bool) (ok bool, err error) {
+		for {
+			retryable := retries < m.Retry.Retries
+			ok, transient := m.migrateTx(pipe, tx, migrations, retryable, callbacks.AfterEach)
+			if ok && commitTx {
+				if err = commit(); err != nil {
+					if !retryable || !m.isTransient(err) {
+						return false, err
+					}
+					ok, transient = false, err
+				}
+			}
+			if ok {
+				return true, nil
+			}
+			if tx != nil {
+				if transient != nil || !m.keepApplied(pipe, tx) {
+					tx.Rollback()
+				}
+				tx = nil
+			}
+			if transient == nil {
+				return false, nil
+			}
+			wait := m.Retry.backoff(retries)
+			retries++
+			pipe <- Retrying{Wait: wait, Err: transient}
+			m.clock().Sleep(wait)
+			if tx, err = m.begin(conn); err != nil {
+				return false, err
+			}
+			migrations = txMigrations
+		}
+	}
+
 	txPerFile := m.TxPerFile
 	for _, f := range applyMigrations {
-		// fmt.Println("f", f)
 		// commit if per file or major version changed
 		if tx != nil && (txPerFile || prevVersion.Major() != f.Major()) {
-			if err := commit(); err != nil {
+			if ok, err := apply(nil, true); !ok {
 				return err
 			}
+			txMigrations = nil
 		}
 		// begin new transaction if no active transaction
 		if tx == nil {
-			tx, err = conn.Begin()
+			tx, err = m.begin(conn)
 			if err != nil {
 				return err
 			}
 		}
 
-		pipe1 := pipep.New()
-		go d.Migrate(tx, &f, pipe1)
-		if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
-			return tx.Rollback()
+		txMigrations = append(txMigrations, f)
+		if ok, err := apply(file.Migrations{f}, false); !ok {
+			return err
 		}
 
 		prevVersion = f.Version
 	}
 	// commit last transaction
-	return commit()
+	if ok, err := apply(nil, true); !ok {
+		return err
+	}
+	return finish()
 }
 
 // NewPipe is a convenience function for pipe.New().
@@ -436,18 +840,63 @@ func NewPipe() chan interface{} {
 	return pipep.New()
 }
 
-// interrupts returns a signal channel if interrupts checking is
-// enabled. nil otherwise.
-func (m *Migrator) handleInterrupts() chan os.Signal {
+// ShutdownSignals interrupt a migration with Interrupts, or cancel the context of SignalContext.
+// SIGTERM is sent e.g. when a Kubernetes pod is terminated.
+var ShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// handleInterrupts returns a signal channel if interrupts checking is
+// enabled. nil otherwise. stop stops sending to it.
+func (m *Migrator) handleInterrupts() (_ chan os.Signal, stop func()) {
+	var c chan os.Signal
 	if m.Interrupts {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
+		c = make(chan os.Signal, 1)
+		signal.Notify(c, ShutdownSignals...)
+	}
+	c, stopContext := m.contextInterrupts(c)
+	return c, func() {
+		if m.Interrupts {
+			signal.Stop(c)
+		}
+		stopContext()
+	}
+}
+
+// interruptHandler returns the handleInterrupts func of the drivers, whose channels are
+// stopped by stop once the driver is done
+func (m *Migrator) interruptHandler() (handle func() chan os.Signal, stop func()) {
+	var mu sync.Mutex
+	var stops []func()
+	handle = func() chan os.Signal {
+		c, stop := m.handleInterrupts()
+		mu.Lock()
+		stops = append(stops, stop)
+		mu.Unlock()
 		return c
 	}
-	return nil
+	stop = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, stop := range stops {
+			stop()
+		}
+		stops = nil
+	}
+	return
+}
+
+// waitAndRedirect is pipep.WaitAndRedirect with the migrator's interrupts
+func (m *Migrator) waitAndRedirect(pipe, redirectPipe chan interface{}) (ok bool) {
+	interrupts, stop := m.handleInterrupts()
+	defer stop()
+	return pipep.WaitAndRedirect(pipe, redirectPipe, interrupts)
 }
 
 func (m *Migrator) Version(conn driver.Conn) (version file.Version, err error) {
+	conn, release, err := m.acquire(conn)
+	if err != nil {
+		return
+	}
+	defer release()
 	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
 	if err != nil {
 		return
@@ -457,7 +906,24 @@ func (m *Migrator) Version(conn driver.Conn) (version file.Version, err error) {
 }
 
 // SchemaDir is the dir used to store schema migrations in dump files
-const SchemaDir = "schema/"
+const SchemaDir = file.SchemaDir
+
+// DumpContents are the parts of a database that are dumped and restored
+type DumpContents int
+
+// DumpContents
+const (
+	// DumpAll dumps and restores the schema files and the table data
+	DumpAll DumpContents = iota
+	// DumpSchemaOnly dumps the schema files, and restores them by migrating up
+	DumpSchemaOnly
+	// DumpDataOnly dumps the table data, and restores it into a schema that's already
+	// migrated to the dump's schema version
+	DumpDataOnly
+)
+
+func (c DumpContents) schema() bool { return c != DumpDataOnly }
+func (c DumpContents) data() bool   { return c != DumpSchemaOnly }
 
 // DumpSync is synchronous version of Dump
 func (m *Migrator) DumpSync(conn driver.CopyConn, dw file.DumpWriter) []error {
@@ -466,11 +932,31 @@ func (m *Migrator) DumpSync(conn driver.CopyConn, dw file.DumpWriter) []error {
 	return pipep.ReadErrors(pipe)
 }
 func (m *Migrator) Dump(pipe chan interface{}, conn driver.CopyConn, dw file.DumpWriter) {
+	m, span := m.startSpan("migrate.dump", Attribute{AttrSchema, m.Schema})
+	pd, dumpOpts, parallelErr := m.parallelDump()
+	if pd != nil && dumpOpts.Jobs > 1 && !file.WritesConcurrently(dw) {
+		// the tables are written at the same time
+		dw = file.NewSpoolWriter(dw)
+	}
+	mw := file.NewManifestWriter(dw)
+	dw = mw
+	var iw *incrementalWriter
+	if m.Incremental != nil {
+		iw = newIncrementalWriter(mw, *m.Incremental)
+		dw = iw
+	}
+	if m.Tracer != nil {
+		dw = tracedDumpWriter{DumpWriter: dw, m: m}
+	}
 	var err error
 	defer func() {
+		endSpan(span, err)
 		go pipep.Close(pipe, err)
 	}()
 
+	if err = parallelErr; err != nil {
+		return
+	}
 	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
 	if err != nil {
 		return
@@ -479,7 +965,7 @@ func (m *Migrator) Dump(pipe chan interface{}, conn driver.CopyConn, dw file.Dum
 
 	dd, ok := m.Driver.(driver.DumpDriver)
 	if !ok {
-		err = errors.New("Driver must be a DumpDriver")
+		err = unsupportedDriver("DumpDriver")
 		return
 	}
 
@@ -488,25 +974,75 @@ func (m *Migrator) Dump(pipe chan interface{}, conn driver.CopyConn, dw file.Dum
 	if err != nil {
 		return
 	}
+	if iw != nil {
+		if err = iw.init(m, conn, prevFiles); err != nil {
+			return
+		}
+	}
+
+	// write layout version
+	if err = file.WriteDumpVersion(dw); err != nil {
+		return
+	}
 
 	// write schema files
 	getWriter := func(dir, name string) (io.WriteCloser, error) {
 		// insert 'schema' dir into path
 		return dw.Writer(path.Join(SchemaDir, dir), name)
 	}
-	for _, f := range prevFiles {
-		err = f.WriteFileContents(getWriter, true)
-		if err != nil {
-			return
+	if m.DumpContents.schema() {
+		for _, f := range prevFiles {
+			if err = f.WriteFileContents(getWriter, true); err != nil {
+				return
+			}
 		}
 	}
 
-	// write table data
-	pipe1 := pipep.New()
-	go dd.Dump(conn, dw, m.Schema, pipe1, m.handleInterrupts)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+	if m.DumpContents.data() && !m.dumpData(pipe, conn, dd, pd, dumpOpts, dw) {
 		return
 	}
+
+	// the manifest is last, once the checksums of the files are known
+	manifest := file.Manifest{CreatedAt: m.Now(), ToolVersion: m.ToolVersion}
+	if len(prevFiles) > 0 {
+		manifest.SchemaVersion = prevFiles.LastVersion().String()
+	}
+	err = mw.WriteManifest(manifest)
+}
+
+// dumpData dumps the tables. It returns false if it was interrupted.
+func (m *Migrator) dumpData(pipe chan interface{}, conn driver.CopyConn, dd driver.DumpDriver, pd driver.ParallelDumpDriver, opts driver.DumpOptions, dw file.DumpWriter) bool {
+	pipe1 := pipep.New()
+	handle, stop := m.interruptHandler()
+	defer stop()
+	if pd != nil {
+		go pd.DumpParallel(conn, dw, m.Schema, opts, pipe1, handle)
+		// the driver sends a table's name once it's dumped
+		tables := tap(pipe1, func(item interface{}) {
+			if name, ok := item.(string); ok {
+				m.emit(TableDumped{Table: name})
+			}
+		})
+		return m.waitAndRedirect(tables, pipe)
+	}
+	go dd.Dump(conn, dw, m.Schema, pipe1, handle)
+	// the driver sends a table's name before dumping it
+	var table string
+	tables := tap(pipe1, func(item interface{}) {
+		if name, ok := item.(string); ok {
+			if table != "" {
+				m.emit(TableDumped{Table: table})
+			}
+			table = name
+		}
+	})
+	if ok := m.waitAndRedirect(tables, pipe); !ok {
+		return false
+	}
+	if table != "" {
+		m.emit(TableDumped{Table: table})
+	}
+	return true
 }
 
 // RestoreSync is synchronous version of Restore
@@ -516,16 +1052,56 @@ func (m *Migrator) RestoreSync(conn driver.CopyConn, dr file.DumpReader) []error
 	return pipep.ReadErrors(pipe)
 }
 func (m *Migrator) Restore(pipe chan interface{}, conn driver.CopyConn, dr file.DumpReader) {
+	m, span := m.startSpan("migrate.restore", Attribute{AttrSchema, m.Schema})
 	var err error
 	defer func() {
+		endSpan(span, err)
 		go pipep.Close(pipe, err)
 	}()
 
 	dd, ok := m.Driver.(driver.DumpDriver)
 	if !ok {
-		err = errors.New("Driver must be a DumpDriver")
+		err = unsupportedDriver("DumpDriver")
 		return
 	}
+	rd, restoreOpts, err := m.parallelRestore(dr)
+	if err != nil {
+		return
+	}
+	if rd != nil && !file.ReadsConcurrently(dr) {
+		// the tables are read at the same time
+		dr = file.NewSpoolReader(dr)
+	}
+
+	var unlock func()
+	if unlock, err = m.lock(conn); err != nil {
+		return
+	}
+	defer unlock()
+
+	// read the dump using the layout it was written with
+	layout, err := file.DetectDumpLayout(dr)
+	if err != nil {
+		return
+	}
+	// verify the files against the manifest as they're read, if the dump has one
+	manifest, err := file.ReadManifest(dr)
+	if err != nil {
+		return
+	}
+	if manifest != nil {
+		if dr, err = manifest.Reader(dr); err != nil {
+			return
+		}
+	}
+	dr = layout.Reader(dr)
+	if m.Tracer != nil {
+		if sr, ok := dr.(file.StreamReader); ok {
+			dr = tracedStreamReader{tracedDumpReader: tracedDumpReader{DumpReader: dr, m: m}, sr: sr}
+		} else {
+			dr = tracedDumpReader{DumpReader: dr, m: m}
+		}
+	}
 
 	schema := m.Schema
 	if schema == "" {
@@ -538,7 +1114,8 @@ func (m *Migrator) Restore(pipe chan interface{}, conn driver.CopyConn, dr file.
 	}
 	defer revert()
 
-	if m.Force {
+	// data only restores keep the migrated schema
+	if m.Force && m.DumpContents.schema() {
 		if err = dd.DeleteSchema(conn, schema); err != nil {
 			return
 		}
@@ -547,14 +1124,18 @@ func (m *Migrator) Restore(pipe chan interface{}, conn driver.CopyConn, dr file.
 		return
 	}
 
-	{ // migrate up using schema read from DumpReader
+	if !m.DumpContents.schema() {
+		if err = m.checkDumpVersion(conn, manifest); err != nil {
+			return
+		}
+	} else { // migrate up using schema read from DumpReader
 		var openers file.Openers
 		openers, err = dr.Files(SchemaDir)
 		if err != nil {
 			return
 		}
 		var files file.MigrationFiles
-		files, err = file.GetMigrationFiles(openers, m.Driver.FilenameExtension())
+		files, err = file.GetMigrationFiles(openers, m.Driver.FilenameExtension(), m.ParseOptions)
 		if err != nil {
 			return
 		}
@@ -564,10 +1145,13 @@ func (m *Migrator) Restore(pipe chan interface{}, conn driver.CopyConn, dr file.
 		}
 		pipe1 := pipep.New()
 		go m.up(pipe1, conn, nil, files, file.NewVersion2(0, 0))
-		if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+		if ok := m.waitAndRedirect(pipe1, pipe); !ok {
 			return
 		}
 	}
+	if !m.DumpContents.data() {
+		return
+	}
 
 	if err = dd.TruncateTables(conn, schema); err != nil {
 		return
@@ -575,9 +1159,57 @@ func (m *Migrator) Restore(pipe chan interface{}, conn driver.CopyConn, dr file.
 
 	{ // restore data
 		pipe1 := pipep.New()
-		go dd.Restore(conn, dr, schema, pipe1, m.handleInterrupts)
-		if ok := pipep.WaitAndRedirect(pipe1, pipe, m.handleInterrupts()); !ok {
+		handle, stop := m.interruptHandler()
+		defer stop()
+		if rd != nil {
+			go rd.RestoreParallel(conn, dr, schema, restoreOpts, pipe1, handle)
+		} else {
+			go dd.Restore(conn, dr, schema, pipe1, handle)
+		}
+		if ok := m.waitAndRedirect(pipe1, pipe); !ok {
 			return
 		}
 	}
+
+	// COPY doesn't advance sequences
+	if sd, ok := m.Driver.(driver.SequenceDriver); ok {
+		var sequences []string
+		if sequences, err = sd.FixSequences(conn, schema); err != nil {
+			return
+		}
+		for _, seq := range sequences {
+			pipe <- seq
+		}
+	}
+}
+
+// checkDumpVersion returns an error if the schema isn't at the version of the dump's manifest.
+// Dumps without a manifest aren't checked.
+func (m *Migrator) checkDumpVersion(conn driver.Conn, manifest *file.Manifest) error {
+	if manifest == nil || manifest.SchemaVersion == "" {
+		return nil
+	}
+	version, err := m.Driver.Version(conn)
+	if err != nil {
+		return err
+	}
+	if version.String() != manifest.SchemaVersion {
+		return fmt.Errorf("The schema is at version %v, but the dump's data is of version %s", version, manifest.SchemaVersion)
+	}
+	return nil
+}
+
+// FixSequences sets the sequences of the tables in Schema to the max value of their column.
+// Restore does this automatically.
+func (m *Migrator) FixSequences(conn driver.Conn) (sequences []string, err error) {
+	sd, ok := m.Driver.(driver.SequenceDriver)
+	if !ok {
+		return nil, unsupportedDriver("SequenceDriver")
+	}
+	revert, err := sd.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return
+	}
+	defer revert()
+	return sd.FixSequences(conn, m.Schema)
 }
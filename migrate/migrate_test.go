@@ -1,15 +1,33 @@
 package migrate
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 	// Ensure imports for each driver we wish to test
 
 	"github.com/acls/migrate/driver"
 	mpgx "github.com/acls/migrate/driver/pgx"
 	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+	pipep "github.com/acls/migrate/pipe"
 	"github.com/acls/migrate/testutil"
 	"github.com/jackc/pgx"
 )
@@ -20,7 +38,7 @@ func init() {
 
 var schema = "migrate_migrate"
 
-func NewMigratorAndConn(t *testing.T, tmpdir string) (*Migrator, driver.Conn, func()) {
+func NewMigratorAndConn(t testing.TB, tmpdir string) (*Migrator, driver.Conn, func()) {
 	m := &Migrator{
 		Driver: mpgx.New(""),
 		Path:   tmpdir,
@@ -182,6 +200,32 @@ func TestUp(t *testing.T) {
 	}
 }
 
+// TestUpReleasesLock uses the conn as soon as the pipe is closed, run it with -race
+func TestUpReleasesLock(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-UpReleasesLock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	for i := 0; i < 5; i++ {
+		if errs := m.UpSync(conn); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		if _, err := m.Version(conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if locked, err := m.isLocked(conn); err != nil || locked {
+		t.Fatalf("Expected the lock to be released, got %v, %v", locked, err)
+	}
+}
+
 func TestRedo(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Redo")
 	if err != nil {
@@ -398,6 +442,13 @@ func TestDumpRestore(t *testing.T) {
 	// add a rows to dumped tables
 	appendText(path.Join(dumpDir, file.TablesDir, "primary_table"), "3\n")
 	appendText(path.Join(dumpDir, file.TablesDir, "foreign_table"), "3	3\n4	3\n")
+	// the edited tables don't match the manifest anymore
+	if _, err := os.Stat(path.Join(dumpDir, file.DumpManifestFile)); err != nil {
+		t.Fatal("Expected a manifest:", err)
+	}
+	if err := os.Remove(path.Join(dumpDir, file.DumpManifestFile)); err != nil {
+		t.Fatal(err)
+	}
 
 	// Restore to a different schema
 	m.Schema += "2"
@@ -419,6 +470,13 @@ func TestDumpRestore(t *testing.T) {
 		t.Fatalf("Expected version %v, got %v", expect, version)
 	}
 	assertRowCounts(true, 3, 4)
+	// sequences were set to the max restored ids
+	if err = conn.Exec("INSERT INTO " + m.Schema + ".foreign_table (p_id) VALUES(1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err = conn.Exec("DELETE FROM " + m.Schema + ".foreign_table WHERE id = 5"); err != nil {
+		t.Fatal(err)
+	}
 
 	// Restore to the same schema should fail
 	m.Schema = schema
@@ -442,4 +500,2450 @@ func TestDumpRestore(t *testing.T) {
 		t.Fatalf("Expected version %v, got %v", expect, version)
 	}
 	assertRowCounts(true, 3, 4)
+
+	// Dump only the schema
+	schemaDir, err := ioutil.TempDir("/tmp", "migrate-DumpRestore_schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(schemaDir)
+	m.DumpContents = DumpSchemaOnly
+	errs = m.DumpSync(conn.(driver.CopyConn), &file.DirWriter{BaseDir: schemaDir})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if _, err := os.Stat(path.Join(schemaDir, file.TablesDir)); !os.IsNotExist(err) {
+		t.Fatal("Expected no tables in a schema only dump:", err)
+	}
+
+	// Restore only the data into the migrated schema, which isn't dropped with Force
+	m.DumpContents = DumpDataOnly
+	errs = m.RestoreSync(conn.(driver.CopyConn), &file.DirReader{BaseDir: dumpDir})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	assertRowCounts(true, 3, 4)
+
+	// Dump the tables over two connections in one snapshot
+	parallelDir, err := ioutil.TempDir("/tmp", "migrate-DumpRestore_parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(parallelDir)
+	m.DumpContents = DumpAll
+	m.DumpJobs, m.DumpSnapshot = 2, true
+	m.NewConn = func() (driver.Conn, error) {
+		c, err := testutil.PgxConn(m.Schema)
+		if err != nil {
+			return nil, err
+		}
+		return mpgx.Conn(c), nil
+	}
+	errs = m.DumpSync(conn.(driver.CopyConn), &file.DirWriter{BaseDir: parallelDir})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	manifest, err := file.ReadManifest(&file.DirReader{BaseDir: parallelDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest == nil || strings.Join(manifest.Tables, ",") != "foreign_table,primary_table" {
+		t.Fatalf("Expected both tables in the manifest, got %+v", manifest)
+	}
+
+	// restore the tables in parallel, primary_table before foreign_table
+	errs = m.RestoreSync(conn.(driver.CopyConn), &file.DirReader{BaseDir: parallelDir})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+}
+
+func TestExportScript(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-ExportScript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	var buf bytes.Buffer
+	if err := m.ExportScript(&buf, file.NewVersion2(0, 0), file.NewVersion2(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	// one transaction per major version
+	if n := strings.Count(script, "BEGIN;"); n != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", n)
+	}
+	if !strings.Contains(script, "CREATE TABLE t4") {
+		t.Fatal("Expected script to contain the last up file")
+	}
+
+	buf.Reset()
+	if err := m.ExportScript(&buf, file.NewVersion2(1, 1), file.NewVersion2(0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	script = buf.String()
+	if !strings.Contains(script, "DROP TABLE t3;") || strings.Contains(script, "DROP TABLE t2;") {
+		t.Fatalf("Unexpected down script:\n%s", script)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	errs := m.UpSync(conn)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := m.Verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// change an applied upfile
+	upFile := path.Join(tmpdir, file.NewVersion2(0, 0).MajorString(), "0001_migration1.up.sql")
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE t1 (id BIGINT PRIMARY KEY);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Verify(conn); err == nil {
+		t.Fatal("Expected base files to differ")
+	}
+}
+
+func TestStoredMigrations(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-StoredMigrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	errs := m.UpSync(conn)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	migrations, err := m.StoredMigrations(conn, file.NewVersion2(0, 2), file.NewVersion2(0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("Expected 2 migrations, got %d", len(migrations))
+	}
+	if string(migrations[0].DownFile.Content) != "DROP TABLE t2;" {
+		t.Fatalf("Unexpected down file content: %s", migrations[0].DownFile.Content)
+	}
+
+	migrations, err = m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 4 {
+		t.Fatalf("Expected 4 migrations, got %d", len(migrations))
+	}
+}
+
+func TestPending(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	_, pending, err := m.Pending(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 4 {
+		t.Fatalf("Expected 4 pending migrations, got %d", len(pending))
+	}
+
+	errs := m.UpSync(conn)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	version, pending, err := m.Pending(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("Expected no pending migrations, got %d", len(pending))
+	}
+	if expect := file.NewVersion2(1, 1); expect.Compare(version) != 0 {
+		t.Fatalf("Expected version %v, got %v", expect, version)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	plan, err := m.Plan(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 4 {
+		t.Fatalf("Expected 4 planned migrations, got %d", len(plan))
+	}
+	if version, _ := m.Version(conn); isVersion(version) {
+		t.Fatalf("Expected Plan not to apply migrations, got version %v", version)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	plan, err = m.PlanTo(conn, plan[0].Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 3 || plan[0].Up() {
+		t.Fatalf("Expected 3 down migrations, got %d", len(plan))
+	}
+	plan, err = m.PlanMigrateBetween(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 0 {
+		t.Fatalf("Expected no migrations between the same files, got %d", len(plan))
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	if errs := m.MigrateSync(conn, 2); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	upFile := path.Join(tmpdir, file.NewVersion2(0, 0).MajorString(), "0001_migration1.up.sql")
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE t1 (id BIGINT PRIMARY KEY);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Status(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Pending) != 2 || len(s.Drifted) != 1 || s.Locked || s.UpToDate() {
+		t.Fatalf("Expected 2 pending, 1 drifted and unlocked, got %+v", s)
+	}
+	if expect := file.NewVersion2(1, 1); expect.Compare(s.Latest) != 0 {
+		t.Fatalf("Expected latest version %v, got %v", expect, s.Latest)
+	}
+	if _, err := json.Marshal(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// versionTableDriver reports the state of a version table without versions
+type versionTableDriver struct {
+	driver.Driver
+	exists, current bool
+	ensured         bool
+}
+
+func (d *versionTableDriver) SearchPath(conn driver.Conn, searchPath string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+func (d *versionTableDriver) EnsureVersionTable(db driver.Beginner, schema string) error {
+	d.ensured = true
+	return nil
+}
+func (d *versionTableDriver) VersionTableState(db driver.Queryer) (bool, bool, error) {
+	return d.exists, d.current, nil
+}
+func (d *versionTableDriver) FilenameExtension() string { return "sql" }
+func (d *versionTableDriver) GetMigrationFiles(db driver.Databaser) (file.MigrationFiles, error) {
+	return nil, nil
+}
+func (d *versionTableDriver) Version(db driver.RowQueryer) (file.Version, error) {
+	return file.NewVersion2(0, 0), nil
+}
+
+func TestStatusReadOnly(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-StatusReadOnly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := &versionTableDriver{}
+	m := &Migrator{Driver: d, Path: tmpdir}
+	createMigrations(t, m)
+	conn := struct{ driver.Conn }{}
+
+	for _, tt := range []struct {
+		exists, current, ensured bool
+	}{
+		{false, false, false},
+		{true, true, false},
+		{true, false, true},
+	} {
+		d.exists, d.current, d.ensured = tt.exists, tt.current, false
+		s, err := m.Status(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Pending) != 4 {
+			t.Fatalf("Expected 4 pending, got %+v", s)
+		}
+		if d.ensured != tt.ensured {
+			t.Fatalf("Expected ensured %v for exists %v and current %v, got %v", tt.ensured, tt.exists, tt.current, d.ensured)
+		}
+	}
+}
+
+func TestMarkApplied(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-MarkApplied")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	v1, v2 := file.NewVersion2(0, 1), file.NewVersion2(0, 2)
+	if _, err := m.MarkApplied(conn, v2); err == nil {
+		t.Fatal("Expected an error marking a version out of order")
+	}
+	marked, err := m.MarkApplied(conn, v2, v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marked) != 2 {
+		t.Fatalf("Expected 2 marked migrations, got %d", len(marked))
+	}
+	if version, _ := m.Version(conn); version.Compare(v2) != 0 {
+		t.Fatalf("Expected version %v, got %v", v2, version)
+	}
+	// the tables weren't created
+	if err := conn.Exec("SELECT * FROM " + m.Schema + ".t1"); err == nil {
+		t.Fatal("Expected t1 not to exist")
+	}
+	if err := m.Verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.MarkReverted(conn, v1); err == nil {
+		t.Fatal("Expected an error reverting a version that isn't the last")
+	}
+	if _, err := m.MarkReverted(conn, v1, v2); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := m.Version(conn); isVersion(version) {
+		t.Fatalf("Expected no version, got %v", version)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Statements")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	m.Statements = true
+	if _, err := m.Create(false, "statements", `CREATE TABLE t1 (id INTEGER PRIMARY KEY);
+		CREATE TABLE t2 (id INTEGER PRIMARY KEY);
+		CREATE TABLE t1 (id INTEGER PRIMARY KEY);`, "DROP TABLE t2; DROP TABLE t1;"); err != nil {
+		t.Fatal(err)
+	}
+
+	errs := m.UpSync(conn)
+	var stmtErr *StatementError
+	if len(errs) != 1 || !errors.As(errs[0], &stmtErr) {
+		t.Fatalf("Expected a StatementError, got %v", errs)
+	}
+	if stmtErr.Index != 2 || stmtErr.Total != 3 {
+		t.Fatalf("Expected statement 3 of 3 to fail, got %d of %d", stmtErr.Index+1, stmtErr.Total)
+	}
+	if version, _ := m.Version(conn); isVersion(version) {
+		t.Fatalf("Expected the migration to be rolled back, got version %v", version)
+	}
+}
+
+func TestMigrationTimeout(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-MigrationTimeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	m.MigrationTimeout = 100 * time.Millisecond
+	if _, err := m.Create(false, "sleep", "CREATE TABLE t1 (id INTEGER PRIMARY KEY); SELECT pg_sleep(10);", "DROP TABLE t1;"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, statements := range []bool{false, true} {
+		m.Statements = statements
+		errs := m.UpSync(conn)
+		if len(errs) == 0 || !errors.Is(errs[0], ErrMigrationTimeout) {
+			t.Fatalf("Expected ErrMigrationTimeout with Statements %v, got %v", statements, errs)
+		}
+		if version, _ := m.Version(conn); isVersion(version) {
+			t.Fatalf("Expected the migration to be rolled back, got version %v", version)
+		}
+	}
+}
+
+func TestGoMigration(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-GoMigration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	version := file.NewVersion2(1, 2)
+	RegisterGoMigration(version, func(ctx context.Context, tx driver.Tx) error {
+		return tx.Exec("CREATE TABLE go_table (id INTEGER PRIMARY KEY)")
+	}, func(ctx context.Context, tx driver.Tx) error {
+		return tx.Exec("DROP TABLE go_table")
+	})
+	defer delete(goMigrations, version.String())
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if v, _ := m.Version(conn); v.Compare(version) != 0 {
+		t.Fatalf("Expected version %v, got %v", version, v)
+	}
+	if err := conn.Exec("SELECT * FROM " + m.Schema + ".go_table"); err != nil {
+		t.Fatal(err)
+	}
+	// files created after a Go migration are ordered after it
+	mf, err := m.Create(false, "after_go", "SELECT 1;", "SELECT 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mf.Compare(version) <= 0 {
+		t.Fatalf("Expected a version after %v, got %v", version, mf.Version)
+	}
+	if errs := m.DownSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := conn.Exec("SELECT * FROM " + m.Schema + ".go_table"); err == nil {
+		t.Fatal("Expected go_table to be dropped")
+	}
+}
+
+func TestRender(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	m.Render = true
+	m.Vars = map[string]string{"table": "rendered"}
+	up := "CREATE TABLE {{ .Schema }}.{{ .Vars.table }} (id INTEGER PRIMARY KEY);"
+	if _, err := m.Create(false, "render", up, "DROP TABLE {{ .Schema }}.{{ .Vars.table }};"); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := conn.Exec("SELECT * FROM " + m.Schema + ".rendered"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || string(stored[0].UpFile.Content) != up {
+		t.Fatalf("Expected the template to be stored, got %v", stored)
+	}
+
+	// the rendered file is stored and validated with ChecksumRendered
+	m.ChecksumRendered = true
+	if err := m.Verify(conn); !errors.Is(err, ErrBaseFilesDiffer) {
+		t.Fatalf("Expected ErrBaseFilesDiffer, got %v", err)
+	}
+}
+
+func TestFSSource(t *testing.T) {
+	major := file.NewVersion2(0, 0).MajorString()
+	fsys := fstest.MapFS{
+		"migrations/" + major + "/001_users.up.sql":   {Data: []byte("CREATE TABLE users (id INT);")},
+		"migrations/" + major + "/001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"migrations/" + major + "/002_posts.up.sql":   {Data: []byte("CREATE TABLE posts (id INT);")},
+		"migrations/" + major + "/002_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+		"other/ignored.up.sql":                        {Data: []byte("SELECT 1;")},
+	}
+	if _, err := New(mpgx.New(""), WithSource(nil)); err == nil {
+		t.Fatal("Expected error for nil Source")
+	}
+	m, err := New(mpgx.New(""), WithSource(FSSource{FS: fsys, Dir: "migrations"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := m.MigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1].Version.String() != file.NewVersion2(0, 2).String() {
+		t.Fatalf("Expected 2 migrations, got %v", files)
+	}
+	if err := files[1].UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(files[1].UpFile.Content) != "CREATE TABLE posts (id INT);" {
+		t.Fatalf("Unexpected content %q", files[1].UpFile.Content)
+	}
+	if _, err := m.Create(false, "comments", "", ""); err == nil {
+		t.Fatal("Expected Create to need a Path")
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	major := file.NewVersion2(0, 0).MajorString()
+	files := map[string]string{
+		"/app/manifest.txt":                     "# migrations\n" + major + "/001_users.up.sql\n\n" + major + "/001_users.down.sql\n",
+		"/app/" + major + "/001_users.up.sql":   "CREATE TABLE users (id INT);",
+		"/app/" + major + "/001_users.down.sql": "DROP TABLE users;",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	source, err := ParseSource(srv.URL + "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.(*HTTPSource).Header = http.Header{"Authorization": {"Bearer token"}}
+	m, err := New(mpgx.New(""), WithSource(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := m.MigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || migrations[0].DownFile == nil {
+		t.Fatalf("Expected 1 migration, got %v", migrations)
+	}
+	if err := migrations[0].UpFile.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(migrations[0].UpFile.Content) != "CREATE TABLE users (id INT);" {
+		t.Fatalf("Unexpected content %q", migrations[0].UpFile.Content)
+	}
+
+	files["/app/manifest.txt"] = "../secret.sql\n"
+	if _, err := m.MigrationFiles(); err == nil {
+		t.Fatal("Expected error for a name outside of the base url")
+	}
+	for rawurl, expected := range map[string]Source{
+		"./schema":               nil,
+		"s3://bucket/app/db":     &S3Source{Bucket: "bucket", Prefix: "/app/db"},
+		"gs://bucket/migrations": &GCSSource{Bucket: "bucket", Prefix: "/migrations"},
+	} {
+		if source, err := ParseSource(rawurl); err != nil || !reflect.DeepEqual(source, expected) {
+			t.Errorf("Expected %v for %s, got %v, %v", expected, rawurl, source, err)
+		}
+	}
+	if _, err := ParseSource("ftp://host/migrations"); err == nil {
+		t.Error("Expected error for ftp url")
+	}
+}
+
+func TestScript(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	var buf bytes.Buffer
+	if err := m.Script(conn, nil, &buf); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	// the first transaction updates the stored files before the first version
+	if strings.Count(script, "\nBEGIN;\n") != 3 || strings.Count(script, "\nCOMMIT;\n") != 3 {
+		t.Fatalf("Expected a transaction per major version, got\n%s", script)
+	}
+	if strings.Count(script, "INSERT INTO "+m.Driver.TableName()) != 4 {
+		t.Fatalf("Expected 4 version table inserts, got\n%s", script)
+	}
+	if version, _ := m.Version(conn); isVersion(version) {
+		t.Fatalf("Expected Script not to apply migrations, got version %v", version)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	buf.Reset()
+	if err := m.Script(conn, file.NewVersion2(0, 1), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(buf.String(), "DELETE FROM "+m.Driver.TableName()) != 3 {
+		t.Fatalf("Expected 3 version table deletes, got\n%s", buf.String())
+	}
+}
+
+func TestScriptStatement(t *testing.T) {
+	for _, tc := range []struct {
+		query    string
+		args     []interface{}
+		expected string
+	}{
+		{"SELECT 1", nil, "SELECT 1;"},
+		{"SELECT 1;\n", nil, "SELECT 1;"},
+		{"SELECT 1 -- one", nil, "SELECT 1 -- one\n;"},
+		{"INSERT INTO t VALUES ($1,$2,$10)", []interface{}{uint64(1), "it's", nil}, "INSERT INTO t VALUES (1,'it''s',$10);"},
+	} {
+		if got := scriptStatement(tc.query, tc.args); got != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestDrift(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	files, err := m.MigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Sort(files)
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	r, err := m.Drift(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Drifted() {
+		t.Fatalf("Expected no drift, got %+v", r)
+	}
+
+	// modify a downfile and remove the last version
+	first := files[0].DownFile
+	if err := ioutil.WriteFile(first.Path(tmpdir), []byte("DROP TABLE IF EXISTS t1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	last := files[len(files)-1]
+	os.Remove(last.UpFile.Path(tmpdir))
+	os.Remove(last.DownFile.Path(tmpdir))
+
+	if r, err = m.Drift(conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Removed) != 1 || r.Removed[0].Compare(last.Version) != 0 {
+		t.Fatalf("Expected %v to be removed, got %v", last.Version, r.Removed)
+	}
+	if len(r.Modified) != 1 || r.Modified[0].Up || !r.Modified[0].Down {
+		t.Fatalf("Expected the downfile of %v to be modified, got %+v", files[0].Version, r.Modified)
+	}
+	if len(r.Added) != 0 {
+		t.Fatalf("Expected no added versions, got %v", r.Added)
+	}
+
+	repaired, err := m.Repair(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repaired) != 1 || repaired[0].Compare(files[0].Version) != 0 {
+		t.Fatalf("Expected %v to be repaired, got %v", files[0].Version, repaired)
+	}
+	if r, err = m.Drift(conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Modified) != 0 || len(r.Removed) != 1 {
+		t.Fatalf("Expected only the removed version to drift after Repair, got %+v", r)
+	}
+}
+
+func TestCheckRequires(t *testing.T) {
+	mf := func(minor uint64, up string) file.MigrationFile {
+		return file.MigrationFile{
+			Version:  file.NewVersion2(0, minor),
+			UpFile:   &file.File{Version: file.NewVersion2(0, minor), Content: []byte(up), Direction: direction.Up},
+			DownFile: &file.File{Version: file.NewVersion2(0, minor), Content: []byte("SELECT 1;"), Direction: direction.Down},
+		}
+	}
+	applied := file.MigrationFiles{mf(1, "SELECT 1;"), mf(2, "-- requires: 000/0001\nSELECT 1;")}
+	pending := mf(3, "-- requires: 000/0002\nSELECT 1;")
+	later := mf(4, "-- requires: 000/0005\nSELECT 1;")
+
+	if _, err := orderRequires(applied, nil, file.Migrations{pending.Migration(direction.Up)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := orderRequires(applied, nil, file.Migrations{later.Migration(direction.Up)}); !errors.Is(err, ErrUnmetRequirement) {
+		t.Fatalf("Expected ErrUnmetRequirement for a missing requirement, got %v", err)
+	}
+
+	// a pending requirement is applied first
+	first := mf(5, "-- requires: 000/0006\nSELECT 1;")
+	second := mf(6, "-- requires: 000/0001\nSELECT 1;")
+	ordered, err := orderRequires(applied, nil, file.Migrations{first.Migration(direction.Up), second.Migration(direction.Up)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ordered) != 2 || ordered[0].Version.Compare(second.Version) != 0 || ordered[1].Version.Compare(first.Version) != 0 {
+		t.Fatalf("Expected %v before %v, got %v", second.Version, first.Version, ordered)
+	}
+	cycle := mf(6, "-- requires: 000/0005\nSELECT 1;")
+	if _, err := orderRequires(applied, nil, file.Migrations{first.Migration(direction.Up), cycle.Migration(direction.Up)}); !errors.Is(err, ErrUnmetRequirement) {
+		t.Fatalf("Expected ErrUnmetRequirement for a cycle, got %v", err)
+	}
+
+	// the requirements of the applied versions are read from the files
+	down := file.Migrations{applied[0].Migration(direction.Down)}
+	if _, err := orderRequires(applied, applied, down); !errors.Is(err, ErrUnmetRequirement) {
+		t.Fatalf("Expected ErrUnmetRequirement rolling back a required version, got %v", err)
+	}
+	down = file.Migrations{applied[1].Migration(direction.Down), applied[0].Migration(direction.Down)}
+	if _, err := orderRequires(applied, applied, down); err != nil {
+		t.Fatal(err)
+	}
+	// the stored files aren't read
+	stored := file.MigrationFiles{applied[0], applied[1]}
+	stored[1].UpFile = &file.File{Version: applied[1].Version, Direction: direction.Up, Open: func() (io.ReadCloser, error) {
+		return nil, errors.New("read a stored file")
+	}}
+	if _, err := orderRequires(stored, nil, file.Migrations{pending.Migration(direction.Up)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSquash(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Squash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	upTo := file.NewVersion2(0, 3)
+	baseline, err := m.Squash(conn, upTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if baseline.UpFile.Name != file.BaselineName {
+		t.Fatalf("Expected a baseline, got %s", baseline.UpFile.FileName)
+	}
+	files, err := m.MigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !files[0].Baseline() || files[0].Compare(upTo) != 0 {
+		t.Fatalf("Expected the baseline and 1.1, got %v", files)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, SquashedDir, "000_0003", "000")); err != nil {
+		t.Fatalf("Expected the squashed files to be archived: %v", err)
+	}
+	if err := m.Verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// an empty schema starts at the baseline
+	if errs := m.ResetSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if version, _ := m.Version(conn); version.Compare(files[1].Version) != 0 {
+		t.Fatalf("Expected version %v, got %v", files[1].Version, version)
+	}
+
+	if _, err := m.Squash(conn, file.NewVersion2(1, 2)); err == nil {
+		t.Fatal("Expected error squashing a version that isn't applied")
+	}
+}
+
+func TestRepeatable(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Repeatable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	views := path.Join(tmpdir, "R_views.sql")
+	if err := ioutil.WriteFile(views, []byte("CREATE OR REPLACE VIEW v1 AS SELECT id FROM t1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := conn.Exec("SELECT id FROM " + m.Schema + ".v1"); err != nil {
+		t.Fatalf("Expected the view to be created after the migrations: %v", err)
+	}
+
+	// unchanged repeatable migrations aren't applied again
+	m.ReportNoChange = true
+	if errs := m.UpSync(conn); len(errs) != 1 || !errors.Is(errs[0], ErrNoChange) {
+		t.Fatalf("Expected ErrNoChange, got %v", errs)
+	}
+	if err := ioutil.WriteFile(views, []byte("CREATE OR REPLACE VIEW v1 AS SELECT id, 1 AS one FROM t1;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatalf("Expected the changed repeatable migration to be applied, got %v", errs)
+	}
+	if err := conn.Exec("SELECT one FROM " + m.Schema + ".v1"); err != nil {
+		t.Fatalf("Expected the view to be replaced: %v", err)
+	}
+}
+
+func TestCallbacks(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Callbacks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	callbacks := map[string]string{
+		"_before_all.sql": "CREATE TABLE IF NOT EXISTS callback_log (name TEXT NOT NULL);",
+		"_after_each.sql": "INSERT INTO callback_log VALUES ('each');",
+		"_after_all.sql":  "INSERT INTO callback_log VALUES ('all');",
+	}
+	for name, content := range callbacks {
+		if err := ioutil.WriteFile(path.Join(tmpdir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := m.MigrationFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	var each, all int
+	if err := conn.QueryRow("SELECT count(*) FILTER (WHERE name = 'each'), count(*) FILTER (WHERE name = 'all') FROM "+m.Schema+".callback_log").Scan(&each, &all); err != nil {
+		t.Fatal(err)
+	}
+	if each != len(files) || all != 1 {
+		t.Fatalf("Expected %d after each and 1 after all callbacks, got %d and %d", len(files), each, all)
+	}
+}
+
+func TestTxOptions(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-TxOptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	if _, err := m.Create(false, "isolation", "CREATE TABLE isolation AS SELECT current_setting('transaction_isolation') AS level;", "DROP TABLE isolation;"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.TxOptions = driver.TxOptions{IsolationLevel: "CHAOS"}
+	if errs := m.UpSync(conn); len(errs) == 0 {
+		t.Fatal("Expected an invalid isolation level error")
+	}
+	m.TxOptions = driver.TxOptions{IsolationLevel: driver.Serializable, ReadWrite: true}
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	var level string
+	if err := conn.QueryRow("SELECT level FROM " + m.Schema + ".isolation").Scan(&level); err != nil {
+		t.Fatal(err)
+	}
+	if level != "serializable" {
+		t.Fatalf("Expected a serializable migration, got %s", level)
+	}
+}
+
+func TestContinueOnError(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-ContinueOnError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	for _, name := range []string{"first", "second"} {
+		if _, err := m.Create(false, name, "CREATE TABLE "+name+" (id INT);", "DROP TABLE "+name+";"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.Create(false, "broken", "CREATE TABLE;", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole transaction is rolled back by default
+	if errs := m.UpSync(conn); len(errs) == 0 {
+		t.Fatal("Expected the broken migration to fail")
+	}
+	if applied, err := m.IsApplied(conn, file.NewVersion2(0, 1)); err != nil || applied {
+		t.Fatalf("Expected nothing applied, got %v, %v", applied, err)
+	}
+
+	m.ContinueOnError = true
+	if errs := m.UpSync(conn); len(errs) == 0 {
+		t.Fatal("Expected the broken migration to fail")
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := file.NewVersion2(0, 2); version.Compare(expect) != 0 {
+		t.Fatalf("Expected the files before the broken one to be kept at %v, got %v", expect, version)
+	}
+	if err := conn.Exec("SELECT id FROM " + m.Schema + ".second"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSkipContent(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-SkipContent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	if _, err := m.Create(false, "users", "CREATE TABLE users (id INT);", "DROP TABLE users;"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SkipContent = true
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	migrations, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || len(migrations[0].UpFile.Content) != 0 || len(migrations[0].DownFile.Content) != 0 {
+		t.Fatalf("Expected a version without contents, got %+v", migrations)
+	}
+	// the checksum is still validated
+	if err := m.Verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	// the down file is read from Path
+	if errs := m.DownSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if err := conn.Exec("SELECT id FROM " + m.Schema + ".users"); err == nil {
+		t.Fatal("Expected the users table to be dropped")
+	}
+}
+
+func TestUpdateFilesBatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-UpdateFilesBatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	for _, name := range []string{"first", "second"} {
+		if _, err := m.Create(false, name, "CREATE TABLE "+name+" (id INT);", "DROP TABLE "+name+";"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	// forget the contents, like versions recorded before they were stored
+	if err := conn.Exec("UPDATE " + m.Schema + "." + m.Driver.TableName() + " SET up_file_gz = '', down_file_gz = ''"); err != nil {
+		t.Fatal(err)
+	}
+
+	var updated []FilesUpdated
+	m.EventHandler = func(e Event) {
+		if e, ok := e.(FilesUpdated); ok {
+			updated = append(updated, e)
+		}
+	}
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if len(updated) != 1 || updated[0].Updated != 2 || updated[0].Total != 2 {
+		t.Fatalf("Expected one batch of 2 files, got %+v", updated)
+	}
+	migrations, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mg := range migrations {
+		if len(mg.UpFile.Content) == 0 || len(mg.DownFile.Content) == 0 {
+			t.Fatalf("Expected the contents of version %v to be updated", mg.Version)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	if _, err := m.Create(false, "users", "CREATE TABLE users (id INT);", "DROP TABLE users;"); err != nil {
+		t.Fatal(err)
+	}
+	m.Audit = true
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := m.Create(false, "broken", "CREATE TABLE;", ""); err != nil {
+		t.Fatal(err)
+	}
+	// the failed run is recorded although its transaction is rolled back
+	if errs := m.UpSync(conn); len(errs) == 0 {
+		t.Fatal("Expected the broken migration to fail")
+	}
+
+	runs, err := m.Runs(conn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %+v", runs)
+	}
+	if r := runs[0]; r.Outcome != driver.RunFailed || r.Error == "" || r.FromVersion != r.ToVersion || r.Command != "up" {
+		t.Fatalf("Unexpected failed run: %+v", r)
+	}
+	if r := runs[1]; r.Outcome != driver.RunSucceeded || r.FromVersion == r.ToVersion {
+		t.Fatalf("Unexpected successful run: %+v", r)
+	}
+	s, err := m.Status(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastRun == nil || s.LastRun.ID != runs[0].ID {
+		t.Fatalf("Expected the failed run as the last run, got %+v", s.LastRun)
+	}
+}
+
+// dirSnapshots writes the snapshots to dirs named after the runs
+type dirSnapshots string
+
+func (d dirSnapshots) Create(runID string) (file.DumpWriter, string, error) {
+	location := path.Join(string(d), runID)
+	return &file.DirWriter{BaseDir: location}, location, os.MkdirAll(location, 0700)
+}
+
+func (d dirSnapshots) Open(location string) (file.DumpReader, error) {
+	return &file.DirReader{BaseDir: location}, nil
+}
+
+func TestRollbackToSnapshot(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-RollbackToSnapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	snapshots, err := ioutil.TempDir("/tmp", "migrate-Snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(snapshots)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	m.Audit = true
+	m.Snapshots = dirSnapshots(snapshots)
+	if errs := m.RollbackToSnapshotSync(conn.(driver.CopyConn), ""); len(errs) != 1 || errs[0] != ErrNoSnapshot {
+		t.Fatalf("Expected ErrNoSnapshot, got %v", errs)
+	}
+
+	if _, err := m.Create(false, "users", "CREATE TABLE users (id INT); INSERT INTO users VALUES (1);", "DROP TABLE users;"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := m.Create(false, "more_users", "INSERT INTO users VALUES (2);", "DELETE FROM users WHERE id = 2;"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.UpSync(conn); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	runs, err := m.Runs(conn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Snapshot == "" {
+		t.Fatalf("Expected the runs to have snapshots, got %+v", runs)
+	}
+
+	// the latest snapshot was taken before the second version
+	if errs := m.RollbackToSnapshotSync(conn.(driver.CopyConn), ""); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.String() != "000/0001" {
+		t.Fatalf("Expected version 000/0001, got %v", version)
+	}
+	var count int
+	if err := conn.QueryRow("SELECT count(*) FROM users").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 user, got %d", count)
+	}
+
+	// the audit log is kept
+	after, err := m.Runs(conn, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 3 || after[0].Command != "rollback-to-snapshot" || after[0].Snapshot != runs[0].Snapshot || after[1].ID != runs[0].ID {
+		t.Fatalf("Unexpected runs after the rollback: %+v", after)
+	}
+}
+
+func TestCreateFromTemplate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-CreateFromTemplate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer cleanup()
+	conn.Close()
+
+	mf, err := m.CreateFromTemplate(false, "users", "create-table", map[string]string{"table": "users"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(mf.UpFile.Content), "CREATE TABLE users") {
+		t.Fatalf("Unexpected up file content: %s", mf.UpFile.Content)
+	}
+
+	// missing vars
+	if _, err := m.CreateFromTemplate(false, "email", "add-column", map[string]string{"table": "users"}); err == nil {
+		t.Fatal("Expected missing key error")
+	}
+
+	// user templates override built-in templates
+	dir := path.Join(tmpdir, TemplatesDir)
+	os.MkdirAll(dir, 0700)
+	ioutil.WriteFile(path.Join(dir, "create-table.up.sql"), []byte("CREATE TABLE {{.table}} ();"), 0644)
+	ioutil.WriteFile(path.Join(dir, "create-table.down.sql"), []byte("DROP TABLE {{.table}};"), 0644)
+	mf, err = m.CreateFromTemplate(false, "posts", "create-table", map[string]string{"table": "posts"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mf.UpFile.Content) != "CREATE TABLE posts ();" {
+		t.Fatalf("Unexpected up file content: %s", mf.UpFile.Content)
+	}
+}
+
+func TestCreateExpandContract(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-CreateExpandContract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	m := &Migrator{Driver: mpgx.New(""), Path: tmpdir}
+
+	if _, err := m.CreateExpandContract(false, "price", ExpandContract{Table: "items", Column: "price"}); err == nil {
+		t.Fatal("Expected missing type error")
+	}
+
+	files, err := m.CreateExpandContract(false, "price", ExpandContract{
+		Table: "items", Column: "price", Type: "bigint", OldType: "numeric",
+		Using: "price * 100", Reverse: "price_new / 100.0", NotNull: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 migrations, got %d", len(files))
+	}
+	for i, name := range []string{"price_expand", "price_backfill", "price_contract"} {
+		if files[i].UpFile.Name != name {
+			t.Fatalf("Expected migration %s, got %s", name, files[i].UpFile.Name)
+		}
+	}
+	expand, backfill, contract := files[0], files[1], files[2]
+	if !strings.Contains(string(expand.UpFile.Content), "ALTER TABLE items ADD COLUMN price_new bigint;") ||
+		!strings.Contains(string(expand.UpFile.Content), "CREATE TRIGGER items_price_expand") {
+		t.Fatalf("Unexpected expand up file: %s", expand.UpFile.Content)
+	}
+
+	// each step requires the previous one
+	for i, mf := range []*file.MigrationFile{backfill, contract} {
+		required, err := file.ParseRequires(mf.UpFile.Content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(required) != 1 || required[0].Compare(files[i].Version) != 0 {
+			t.Fatalf("Expected %s to require %v, got %v", mf.UpFile.Name, files[i].Version, required)
+		}
+	}
+
+	// the type change renames the new column, after checking the backfill
+	up := string(contract.UpFile.Content)
+	check := strings.Index(up, "isn''t backfilled")
+	drop := strings.Index(up, "ALTER TABLE items DROP COLUMN price;")
+	rename := strings.Index(up, "ALTER TABLE items RENAME COLUMN price_new TO price;")
+	if check < 0 || drop < check || rename < drop {
+		t.Fatalf("Unexpected contract up file: %s", up)
+	}
+	if !strings.Contains(string(contract.DownFile.Content), "ALTER TABLE items ADD COLUMN price numeric;\nUPDATE items SET price = price_new / 100.0;") {
+		t.Fatalf("Unexpected contract down file: %s", contract.DownFile.Content)
+	}
+	// SET, ALTER TABLE, CREATE FUNCTION and CREATE TRIGGER
+	if stmts := file.SplitStatements(expand.UpFile.Content); len(stmts) != 4 {
+		t.Fatalf("Unexpected expand statements: %v", stmts)
+	}
+}
+
+func planFiles(contents ...string) file.MigrationFiles {
+	var files file.MigrationFiles
+	v := file.NewVersion2(0, 0)
+	for _, c := range contents {
+		v = v.Inc(false)
+		files = append(files, file.MigrationFile{
+			Version:  v,
+			UpFile:   &file.File{Version: v, Content: []byte(c), Direction: direction.Up},
+			DownFile: &file.File{Version: v, Content: []byte("undo " + c), Direction: direction.Down},
+		})
+	}
+	return files
+}
+
+func TestPlanBetween(t *testing.T) {
+	stored := planFiles("a", "b", "c")
+	disk := planFiles("a", "b2", "c2", "d2")
+
+	// down the divergent stored files, then up to the last disk version
+	migrations, err := PlanBetween(stored, disk, nil, PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"undo c", "undo b", "b2", "c2", "d2"}
+	if len(migrations) != len(expect) {
+		t.Fatalf("Expected %d migrations, got %d", len(expect), len(migrations))
+	}
+	for i := range migrations {
+		if got := string(migrations[i].File().Content); got != expect[i] {
+			t.Fatalf("Expected migration %d to be %q, got %q", i, expect[i], got)
+		}
+	}
+
+	// force treats the same versions as applied
+	migrations, err = PlanBetween(stored, disk, nil, PlanOptions{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || string(migrations[0].File().Content) != "d2" {
+		t.Fatalf("Expected only d2, got %d migrations", len(migrations))
+	}
+
+	// target below the common files only migrates down
+	migrations, err = PlanBetween(stored, disk, file.NewVersion2(0, 0), PlanOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 || migrations[0].Up() {
+		t.Fatalf("Expected 3 down migrations, got %d", len(migrations))
+	}
+
+	if _, err = PlanBetween(stored, disk, file.NewVersion2(0, 9), PlanOptions{}); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("Expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestPlanMigrateBetweenOutOfOrder(t *testing.T) {
+	content := []byte("CREATE TABLE users ();")
+	mf := func(major, minor uint64, name string) file.MigrationFile {
+		return file.MigrationFile{Version: file.NewVersion2(major, minor), UpFile: &file.File{FileName: name, Content: content}}
+	}
+	prev := file.MigrationFiles{mf(0, 1, "0001_users.up.sql"), mf(1, 1, "0001_posts.up.sql")}
+	files := file.MigrationFiles{mf(0, 1, "0001_users.up.sql"), mf(0, 2, "0002_merged_late.up.sql"),
+		mf(1, 1, "0001_posts.up.sql"), mf(1, 2, "0002_comments.up.sql")}
+
+	m := &Migrator{}
+	if _, _, _, err := m.planMigrateBetween(prev, files); !errors.Is(err, ErrOutOfOrder) {
+		t.Fatalf("Expected ErrOutOfOrder, got %v", err)
+	}
+	m.AllowOutOfOrder = true
+	_, _, migrations, err := m.planMigrateBetween(prev, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Compare(file.NewVersion2(0, 2)) != 0 || migrations[1].Compare(file.NewVersion2(1, 2)) != 0 {
+		t.Fatalf("Expected the late file, then 001/0002, got %v", migrations)
+	}
+}
+
+func TestMigrateBetweenOutOfOrder(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-MigrateBetweenOutOfOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	// 000/0002 is merged after 000/0003 was applied
+	majorDir := path.Join(tmpdir, file.NewVersion2(0, 0).MajorString())
+	lateDir, err := ioutil.TempDir("/tmp", "migrate-late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(lateDir)
+	for _, name := range []string{"0002_migration2.up.sql", "0002_migration2.down.sql"} {
+		if err := os.Rename(path.Join(majorDir, name), path.Join(lateDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	for _, name := range []string{"0002_migration2.up.sql", "0002_migration2.down.sql"} {
+		if err := os.Rename(path.Join(lateDir, name), path.Join(majorDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, errs := m.MigrateBetweenSync(conn); len(errs) != 1 || !errors.Is(errs[0], ErrOutOfOrder) {
+		t.Fatalf("Expected ErrOutOfOrder, got %v", errs)
+	}
+	m.AllowOutOfOrder = true
+	if _, _, errs := m.MigrateBetweenSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if ok, err := m.IsApplied(conn, file.NewVersion2(0, 2)); err != nil || !ok {
+		t.Fatalf("Expected the late file to be applied, got %v, %v", ok, err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Backfill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	if err := m.Driver.(driver.DumpDriver).DeleteSchema(conn, m.Schema); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create(false, "items", `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, done BOOLEAN NOT NULL DEFAULT FALSE);
+		INSERT INTO items (id) SELECT generate_series(1, 10);
+	`, "DROP TABLE items;"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	batches := 0
+	b := Backfill{
+		Name: "items_done",
+		Total: func(db driver.Databaser) (total int64, err error) {
+			err = db.QueryRow("SELECT COUNT(*) FROM items").Scan(&total)
+			return
+		},
+		Batch: func(db driver.Databaser, lastKey string) (string, int64, error) {
+			batches++
+			if lastKey == "" {
+				lastKey = "0"
+			}
+			var key string
+			var rows int64
+			err := db.QueryRow(`WITH batch AS (
+				UPDATE items SET done = TRUE WHERE id IN (SELECT id FROM items WHERE id > $1::int ORDER BY id LIMIT 4)
+				RETURNING id
+			) SELECT COALESCE(MAX(id)::text, ''), COUNT(*) FROM batch`, lastKey).Scan(&key, &rows)
+			return key, rows, err
+		},
+	}
+	if errs := m.RunBackfillSync(conn, b); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// 3 batches of rows and 1 empty batch
+	if batches != 4 {
+		t.Fatalf("Expected 4 batches, got %d", batches)
+	}
+
+	backfills, err := m.Backfills(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backfills) != 1 || !backfills[0].Done || backfills[0].Rows != 10 || backfills[0].Total != 10 {
+		t.Fatalf("Unexpected backfills: %v", backfills)
+	}
+
+	// a done backfill isn't run again
+	if errs := m.RunBackfillSync(conn, b); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if batches != 4 {
+		t.Fatalf("Expected no more batches, got %d", batches)
+	}
+}
+
+func TestKeysetBatch(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-KeysetBatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	if err := m.Driver.(driver.DumpDriver).DeleteSchema(conn, m.Schema); err != nil {
+		t.Fatal(err)
+	}
+	clock := NewFrozenClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	m.Clock = clock
+	if _, err := m.Create(false, "items", `
+		CREATE TABLE items (id INTEGER PRIMARY KEY, n INTEGER NOT NULL);
+		INSERT INTO items SELECT i, i FROM generate_series(1, 10) AS i;
+	`, "DROP TABLE items;"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	throttled := 0
+	b := Backfill{
+		Name:  "items_n",
+		Batch: KeysetBatch("items", "id", "n % 2 = 0", "n = n * 10", 2),
+		Sleep: time.Second,
+		Throttle: func() error {
+			throttled++
+			return nil
+		},
+	}
+	if errs := m.RunBackfillSync(conn, b); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	// 3 batches of the 5 even rows and 1 empty batch, with a sleep after each full batch
+	if throttled != 4 {
+		t.Fatalf("Expected 4 batches, got %d", throttled)
+	}
+	if slept := m.Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)); slept != 3*time.Second {
+		t.Fatalf("Expected to sleep 3s, slept %v", slept)
+	}
+	var sum int
+	if err := conn.QueryRow("SELECT sum(n) FROM items").Scan(&sum); err != nil {
+		t.Fatal(err)
+	}
+	// the odd rows 1+3+5+7+9 and the even rows times 10
+	if sum != 25+300 {
+		t.Fatalf("Expected sum 325, got %d", sum)
+	}
+}
+
+func TestFrozenClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFrozenClock(start)
+	m := &Migrator{Clock: clock}
+
+	if !m.Now().Equal(start) {
+		t.Fatalf("Expected %v, got %v", start, m.Now())
+	}
+	clock.Sleep(time.Minute)
+	if d := m.Since(start); d != time.Minute {
+		t.Fatalf("Expected 1m, got %v", d)
+	}
+
+	// defaults to the system clock
+	m.Clock = nil
+	if m.Since(time.Now()) > time.Second {
+		t.Fatal("Expected the system clock")
+	}
+}
+
+func TestUpCtx(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-UpCtx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	// a canceled context stops before anything is applied
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pipe := pipep.New()
+	go m.UpCtx(ctx, pipe, conn)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) == 0 || errs[len(errs)-1] != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", errs)
+	}
+
+	pipe = pipep.New()
+	go m.UpCtx(context.Background(), pipe, conn)
+	if errs := pipep.ReadErrors(pipe); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := file.NewVersion2(1, 1); expect.Compare(version) != 0 {
+		t.Fatalf("Expected version %v, got %v", expect, version)
+	}
+}
+
+func TestEnsureMigrated(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-EnsureMigrated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	m.ReportNoChange = true
+
+	for i := 0; i < 2; i++ {
+		if err := m.EnsureMigrated(context.Background(), conn); err != nil {
+			t.Fatal(err)
+		}
+		version, err := m.Version(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := file.NewVersion2(1, 1); expect.Compare(version) != 0 {
+			t.Fatalf("Expected version %v, got %v", expect, version)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.EnsureMigrated(ctx, conn); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestHealthy(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	if err := m.Healthy(conn); !errors.Is(err, ErrNotMigrated) {
+		t.Fatalf("Expected ErrNotMigrated, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	m.HealthHandler(conn).ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := m.Healthy(conn); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	m.HealthHandler(conn).ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+}
+
+func TestSignalContext(t *testing.T) {
+	ctx, stop := SignalContext(context.Background())
+	defer stop()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected SIGTERM to cancel the context")
+	}
+}
+
+func TestContextInterrupts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &Migrator{ctx: ctx}
+
+	// stopped handlers don't wait for the context
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		_, stop := m.contextInterrupts(nil)
+		stop()
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("Expected the goroutines to exit, got %d instead of %d", n, before)
+	}
+
+	c, stop := m.contextInterrupts(nil)
+	defer stop()
+	cancel()
+	select {
+	case <-c:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected an interrupt when the context is done")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	if _, err := m.Create(false, "broken", "CREATE TABLE;", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var started, applied, failed int
+	m.EventHandler = func(e Event) {
+		switch e := e.(type) {
+		case MigrationStarted:
+			started++
+		case MigrationApplied:
+			applied++
+		case MigrationFailed:
+			if e.Err == nil || !strings.Contains(e.File.Name, "broken") {
+				t.Errorf("Unexpected failure %v for %s", e.Err, e.File.Name)
+			}
+			failed++
+		}
+	}
+	if errs := m.UpSync(conn); len(errs) == 0 {
+		t.Fatal("Expected broken migration to fail")
+	}
+	if started != 5 || applied != 4 || failed != 1 {
+		t.Fatalf("Expected 5 started, 4 applied and 1 failed, got %d, %d and %d", started, applied, failed)
+	}
+}
+
+// recordingTracer records the names and attributes of the ended spans
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent context.Context
+	attrs  map[string]interface{}
+	err    error
+}
+
+type spanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	s := &recordedSpan{t: t, name: name, parent: ctx, attrs: map[string]interface{}{}}
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttributes(attrs ...Attribute) {
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+func (s *recordedSpan) RecordError(err error) { s.err = err }
+func (s *recordedSpan) End() {
+	s.t.mu.Lock()
+	s.t.spans = append(s.t.spans, s)
+	s.t.mu.Unlock()
+}
+
+func TestTracer(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-Tracer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+	tracer := &recordingTracer{}
+	m.Tracer = tracer
+
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	last := tracer.spans[len(tracer.spans)-1]
+	if last.name != "migrate" || last.attrs[AttrDirection] != "up" || last.attrs[AttrFiles] != len(tracer.spans)-1 {
+		t.Fatalf("Expected the migrate span to end last, got %s %v", last.name, last.attrs)
+	}
+	for _, s := range tracer.spans[:len(tracer.spans)-1] {
+		if s.name != "migrate.file" || s.parent.Value(spanKey{}) != last || s.attrs[AttrFile] == "" {
+			t.Fatalf("Expected child file spans, got %s %v", s.name, s.attrs)
+		}
+	}
+}
+
+func TestTracedDumpTables(t *testing.T) {
+	dir := t.TempDir()
+	tracer := &recordingTracer{}
+	m := &Migrator{Tracer: tracer}
+
+	w, err := tracedDumpWriter{DumpWriter: &file.DirWriter{BaseDir: dir}, m: m}.Writer(file.TablesDir, "t1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "1\tone\n2\ttwo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	openers, err := tracedDumpReader{DumpReader: &file.DirReader{BaseDir: dir}, m: m}.Files(file.TablesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := openers[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(tracer.spans))
+	}
+	for i, name := range []string{"migrate.dump_table", "migrate.restore_table"} {
+		s := tracer.spans[i]
+		if s.name != name || s.attrs[AttrTable] != "t1" || s.attrs[AttrRows] != int64(2) {
+			t.Fatalf("Expected %s span of t1 with 2 rows, got %s %v", name, s.name, s.attrs)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Migrator{Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	f := &file.File{FileName: "0001_users.down.sql", Version: file.NewVersion2(0, 1), Direction: direction.Down}
+	m.emit(MigrationStarted{File: f})
+	m.emit(MigrationFailed{File: f, Err: errors.New("boom")})
+
+	var records []map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var r map[string]interface{}
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %v", records)
+	}
+	failed := records[1]
+	if failed["level"] != "ERROR" || failed["file"] != f.FileName || failed["version"] != f.Version.String() ||
+		failed["direction"] != "down" || failed["error"] != "boom" {
+		t.Fatalf("Unexpected record %v", failed)
+	}
+}
+
+func TestNew(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-New")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := mpgx.New("")
+	m, err := New(d, WithPath(tmpdir), WithSchema("app_1"), WithTxPerFile(), WithForce())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Path != tmpdir || m.Schema != "app_1" || !m.TxPerFile || !m.Force {
+		t.Fatalf("Unexpected migrator %+v", m)
+	}
+
+	for _, tc := range []struct {
+		d    driver.Driver
+		opts []Option
+	}{
+		{nil, []Option{WithPath(tmpdir)}},
+		{d, nil},
+		{d, []Option{WithPath(path.Join(tmpdir, "missing"))}},
+		{d, []Option{WithPath(tmpdir), WithSchema("app; DROP TABLE x")}},
+		{d, []Option{WithPath(tmpdir), WithSchema("1app")}},
+	} {
+		if _, err := New(tc.d, tc.opts...); err == nil {
+			t.Errorf("Expected error for %v", tc)
+		}
+	}
+}
+
+func TestStatsSnapshot(t *testing.T) {
+	stats := driver.Stats{Tables: []driver.TableStats{{
+		Name:  "users",
+		Rows:  1e6,
+		Pages: 12000,
+		Columns: []driver.ColumnStats{
+			{Name: "email", NullFrac: 0.01, AvgWidth: 24, NDistinct: -1},
+		},
+	}}}
+	var buf bytes.Buffer
+	if err := WriteStats(&buf, stats); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadStats(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Tables) != 1 || len(read.Tables[0].Columns) != 1 || read.Tables[0].Columns[0] != stats.Tables[0].Columns[0] || read.Tables[0].Rows != 1e6 {
+		t.Fatalf("Expected %+v, got %+v", stats, read)
+	}
+}
+
+// lockedDriver is a driver.LockDriver whose lock is held by another migrator
+// until the given number of tries
+type lockedDriver struct {
+	driver.Driver
+	tries, freeAfter int
+	unlocked         bool
+}
+
+func (d *lockedDriver) TryLock(conn driver.Conn, schema string) (bool, error) {
+	d.tries++
+	return d.tries > d.freeAfter, nil
+}
+func (d *lockedDriver) Unlock(conn driver.Conn, schema string) error {
+	d.unlocked = true
+	return nil
+}
+func (d *lockedDriver) ForceUnlock(db driver.Databaser, schema string) error {
+	d.freeAfter = 0
+	return nil
+}
+
+func TestLock(t *testing.T) {
+	d := &lockedDriver{freeAfter: 3}
+	m := &Migrator{Driver: d, Clock: NewFrozenClock(time.Now())}
+
+	// waits for the lock
+	unlock, err := m.lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if d.tries != 4 || !d.unlocked {
+		t.Fatalf("Expected 4 tries and unlock, got %d and %v", d.tries, d.unlocked)
+	}
+
+	// times out
+	d.tries, d.freeAfter = 0, 100
+	m.LockTimeout = 5 * time.Second
+	if _, err := m.lock(nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+
+	if err := m.ForceUnlock(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.lock(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// skipped with NoLock
+	d.tries, d.freeAfter = 0, 100
+	m.NoLock = true
+	if _, err := m.lock(nil); err != nil || d.tries != 0 {
+		t.Fatalf("Expected no lock, got %v after %d tries", err, d.tries)
+	}
+}
+
+func TestLockedClosesPipeAfterUnlock(t *testing.T) {
+	d := &lockedDriver{}
+	m := &Migrator{Driver: d}
+	failed := errors.New("failed")
+	pipe := pipep.New()
+	conn := struct{ driver.Conn }{}
+	go m.locked(pipe, conn, func(conn driver.Conn) error { return failed })
+	if errs := pipep.ReadErrors(pipe); len(errs) != 1 || errs[0] != failed {
+		t.Fatalf("Expected the error of fn, got %v", errs)
+	}
+	if !d.unlocked {
+		t.Fatal("Expected the lock to be released before the pipe is closed")
+	}
+}
+
+// auditLockedDriver records the runs of a locked schema
+type auditLockedDriver struct {
+	lockedDriver
+	runs []driver.Run
+}
+
+func (d *auditLockedDriver) SearchPath(conn driver.Conn, searchPath string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+func (d *auditLockedDriver) Version(db driver.RowQueryer) (file.Version, error) {
+	return file.NewVersion2(0, 1), nil
+}
+func (d *auditLockedDriver) RecordRun(db driver.Execer, run driver.Run) error {
+	d.runs = append(d.runs, run)
+	return nil
+}
+func (d *auditLockedDriver) Runs(db driver.Databaser, limit int) ([]driver.Run, error) {
+	return d.runs, nil
+}
+
+func TestAuditLockTimeout(t *testing.T) {
+	d := &auditLockedDriver{lockedDriver: lockedDriver{freeAfter: 100}}
+	m := &Migrator{Driver: d, Audit: true, LockTimeout: 5 * time.Second, Clock: NewFrozenClock(time.Now())}
+	if errs := m.UpSync(struct{ driver.Conn }{}); len(errs) != 1 || !errors.Is(errs[0], ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", errs)
+	}
+	if len(d.runs) != 1 {
+		t.Fatalf("Expected the run to be recorded, got %v", d.runs)
+	}
+	if run := d.runs[0]; run.Outcome != driver.RunFailed || run.FromVersion != "000/0001" || run.ToVersion != "000/0001" || !strings.Contains(run.Error, "locked") {
+		t.Fatalf("Expected a failed run at 000/0001, got %+v", run)
+	}
+}
+
+func TestNotifyLockTimeout(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "caller")
+	var summaries []RunSummary
+	m := &Migrator{Driver: &auditLockedDriver{lockedDriver: lockedDriver{freeAfter: 100}}, LockTimeout: 5 * time.Second, Clock: NewFrozenClock(time.Now())}
+	m.Notifiers = []Notifier{NotifierFunc(func(nctx context.Context, summary RunSummary) error {
+		if nctx.Value(key{}) != "caller" {
+			t.Error("Expected the caller's context")
+		}
+		summaries = append(summaries, summary)
+		return errors.New("unreachable")
+	})}
+
+	pipe := pipep.New()
+	go m.UpCtx(ctx, pipe, struct{ driver.Conn }{})
+	var locked, failed bool
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			locked = locked || errors.Is(item, ErrLocked)
+		case NotificationFailed:
+			failed = item.Err.Error() == "unreachable"
+		}
+	}
+	if !locked || !failed {
+		t.Fatalf("Expected ErrLocked and a NotificationFailed, got %v and %v", locked, failed)
+	}
+	if len(summaries) != 1 || summaries[0].Succeeded() || summaries[0].FromVersion != "000/0001" {
+		t.Fatalf("Expected a failed run summary, got %+v", summaries)
+	}
+}
+
+func TestLockShared(t *testing.T) {
+	m, err := New(&lockedDriver{}, WithPath(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// migrations of a shared Migrator wait for each other, even without the schema lock
+	m.NoLock = true
+	unlock, err := m.lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.LockTimeout = 10 * time.Millisecond
+	if _, err := m.lock(nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+	unlock()
+	unlock, err = m.lock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if _, _, err := m.acquire(nil); err == nil {
+		t.Fatal("Expected an error for a nil connection without NewConn")
+	}
+}
+
+func TestWaitForDatabase(t *testing.T) {
+	m := &Migrator{Clock: NewFrozenClock(time.Now()), WaitTimeout: time.Minute}
+	refused := errors.New("connection refused")
+	tries := 0
+	err := m.WaitForDatabase(func() error {
+		if tries++; tries < 4 {
+			return refused
+		}
+		return nil
+	})
+	if err != nil || tries != 4 {
+		t.Fatalf("Expected to connect after 4 tries, got %v after %d", err, tries)
+	}
+
+	m.WaitTimeout = time.Second
+	tries = 0
+	if err := m.WaitForDatabase(func() error { tries++; return refused }); !errors.Is(err, refused) || tries < 2 {
+		t.Fatalf("Expected the connection error after retrying, got %v after %d tries", err, tries)
+	}
+
+	m.WaitTimeout = 0
+	tries = 0
+	if err := m.WaitForDatabase(func() error { tries++; return refused }); err != refused || tries != 1 {
+		t.Fatalf("Expected one try without WaitTimeout, got %v after %d tries", err, tries)
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	summary := RunSummary{
+		Run: driver.Run{
+			Command:     "up",
+			FromVersion: "0/1",
+			ToVersion:   "0/3",
+			StartedAt:   start,
+			FinishedAt:  start.Add(1500 * time.Millisecond),
+			Outcome:     driver.RunSucceeded,
+		},
+		Schema:  "public",
+		Applied: []string{"0/2", "0/3"},
+	}
+	n := &WebhookNotifier{URL: srv.URL}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatal(err)
+	}
+	if got["outcome"] != driver.RunSucceeded || got["to_version"] != "0/3" || got["duration_ms"] != float64(1500) || len(got["applied"].([]interface{})) != 2 {
+		t.Fatalf("Unexpected summary: %v", got)
+	}
+
+	n.URL = srv.URL + "/missing"
+	srv.Config.Handler = http.NotFoundHandler()
+	if err := n.Notify(context.Background(), summary); err == nil {
+		t.Fatal("Expected an error for a 404")
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Retries: 5, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second}
+	for retry, wait := range expected {
+		if got := p.backoff(retry); got != wait {
+			t.Fatalf("Expected retry %d to wait %v, got %v", retry, wait, got)
+		}
+	}
+}
+
+var errSerialization = errors.New("could not serialize access due to concurrent update")
+
+// retryDriver fails the given number of migrations with a transient error
+type retryDriver struct {
+	driver.Driver
+	failures int
+	applied  []string
+}
+
+func (d *retryDriver) SearchPath(conn driver.Conn, searchPath string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+func (d *retryDriver) FilenameExtension() string { return "sql" }
+func (d *retryDriver) Migrate(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	defer close(pipe)
+	if d.failures > 0 {
+		d.failures--
+		pipe <- errSerialization
+		return
+	}
+	d.applied = append(d.applied, mg.Version.String())
+}
+func (d *retryDriver) IsTransient(err error) bool { return err == errSerialization }
+
+// retryConn counts the commits and rollbacks of its transactions
+type retryConn struct {
+	driver.Conn
+	commits, rollbacks int
+}
+
+func (c *retryConn) Begin() (driver.Tx, error) { return &retryTx{c: c}, nil }
+
+type retryTx struct {
+	driver.Tx
+	c *retryConn
+}
+
+func (tx *retryTx) Commit() error   { tx.c.commits++; return nil }
+func (tx *retryTx) Rollback() error { tx.c.rollbacks++; return nil }
+
+func TestRetry(t *testing.T) {
+	var files file.MigrationFiles
+	for i := uint64(1); i <= 2; i++ {
+		v := file.NewVersion2(0, i)
+		files = append(files, file.MigrationFile{
+			Version:  v,
+			UpFile:   &file.File{Version: v, Direction: direction.Up, Content: []byte("SELECT 1;")},
+			DownFile: &file.File{Version: v, Direction: direction.Down, Content: []byte("SELECT 1;")},
+		})
+	}
+	migrations := file.Migrations{files[0].Migration(direction.Up), files[1].Migration(direction.Up)}
+
+	ms := time.Millisecond
+	for _, tt := range []struct {
+		failures, retries  int
+		applied            []string
+		waits              []time.Duration
+		commits, rollbacks int
+	}{
+		// the first file fails twice, and the transaction is applied on the third try
+		{2, 3, []string{"000/0001", "000/0002"}, []time.Duration{10 * ms, 20 * ms}, 2, 2},
+		// the retries run out
+		{3, 2, nil, []time.Duration{10 * ms, 20 * ms}, 1, 3},
+	} {
+		d := &retryDriver{failures: tt.failures}
+		m := &Migrator{Driver: d, Path: t.TempDir(), Clock: NewFrozenClock(time.Now())}
+		m.Retry = RetryPolicy{Retries: tt.retries, Backoff: 10 * ms}
+		conn := &retryConn{}
+
+		pipe := pipep.New()
+		var waits []time.Duration
+		var errs []error
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for item := range pipe {
+				switch item := item.(type) {
+				case Retrying:
+					waits = append(waits, item.Wait)
+				case error:
+					errs = append(errs, item)
+				}
+			}
+		}()
+		err := m.migrateFiles(pipe, conn, nil, files, migrations)
+		close(pipe)
+		<-done
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if tt.applied == nil {
+			if len(errs) != 1 || errs[0] != errSerialization {
+				t.Fatalf("%d failures: Expected the transient error after %d retries, got %v", tt.failures, tt.retries, errs)
+			}
+		} else if len(errs) > 0 {
+			t.Fatalf("%d failures: Expected the retries to succeed, got %v", tt.failures, errs)
+		}
+		if !reflect.DeepEqual(waits, tt.waits) {
+			t.Fatalf("%d failures: Expected the retries to wait %v, got %v", tt.failures, tt.waits, waits)
+		}
+		if !reflect.DeepEqual(d.applied, tt.applied) {
+			t.Fatalf("%d failures: Expected %v to be applied, got %v", tt.failures, tt.applied, d.applied)
+		}
+		if conn.commits != tt.commits || conn.rollbacks != tt.rollbacks {
+			t.Fatalf("%d failures: Expected %d commits and %d rollbacks, got %d and %d", tt.failures, tt.commits, tt.rollbacks, conn.commits, conn.rollbacks)
+		}
+	}
+}
+
+// waveDriver applies the parallel migrations without a database
+type waveDriver struct {
+	driver.Driver
+}
+
+func (d *waveDriver) SearchPath(conn driver.Conn, searchPath string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+func (d *waveDriver) MigrateContent(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	close(pipe)
+}
+func (d *waveDriver) RecordVersion(db driver.Databaser, mg *file.Migration) error { return nil }
+
+// waveConn begins transactions that fail to commit if failCommit is set
+type waveConn struct {
+	driver.Conn
+	failCommit bool
+}
+
+func (c *waveConn) Begin() (driver.Tx, error) { return &waveTx{c: c}, nil }
+func (c *waveConn) Close() error              { return nil }
+
+type waveTx struct {
+	driver.Tx
+	c *waveConn
+}
+
+func (tx *waveTx) Commit() error {
+	if tx.c.failCommit {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+func (tx *waveTx) Rollback() error { return nil }
+
+func TestPartialWave(t *testing.T) {
+	var wave []file.Migrations
+	for major := uint64(0); major < 2; major++ {
+		v := file.NewVersion2(major, 1)
+		mf := file.MigrationFile{
+			Version: v,
+			UpFile:  &file.File{Version: v, Direction: direction.Up, Content: []byte("SELECT 1;")},
+		}
+		wave = append(wave, file.Migrations{mf.Migration(direction.Up)})
+	}
+	d := &waveDriver{}
+	m := &Migrator{Driver: d, Clock: NewFrozenClock(time.Now())}
+	// the second major version's transaction fails to commit after the first's is committed
+	m.NewConn = func() (driver.Conn, error) { return &waveConn{failCommit: true}, nil }
+
+	pipe := pipep.New()
+	go func() {
+		for range pipe {
+		}
+	}()
+	ok, err := m.migrateWave(pipe, &waveConn{}, d, wave)
+	close(pipe)
+	if ok || !errors.Is(err, ErrPartialWave) {
+		t.Fatalf("Expected a partially applied wave, got %v", err)
+	}
+	if applied, failed := wave[0][0].Version.String(), wave[1][0].Version.String(); !strings.Contains(err.Error(), applied) || strings.Contains(err.Error(), failed) {
+		t.Fatalf("Expected only %s to be listed as applied, got %v", applied, err)
+	}
+}
+
+func benchmarkBench(b *testing.B, opts BenchOptions) {
+	m, conn, cleanup := NewMigratorAndConn(b, "")
+	defer conn.Close()
+	defer cleanup()
+
+	var total BenchResult
+	for i := 0; i < b.N; i++ {
+		r, err := m.Bench(conn.(driver.CopyConn), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total.Migrations += r.Migrations
+		total.MigrateTime += r.MigrateTime
+		total.DumpBytes += r.DumpBytes
+		total.DumpTime += r.DumpTime
+		total.RestoreRows += r.RestoreRows
+		total.RestoreTime += r.RestoreTime
+	}
+	b.ReportMetric(total.MigrationsPerSecond(), "migrations/s")
+	b.ReportMetric(total.DumpMBPerSecond(), "dump-MB/s")
+	b.ReportMetric(total.RestoreRowsPerSecond(), "restore-rows/s")
+}
+
+func BenchmarkMigrations(b *testing.B) {
+	benchmarkBench(b, BenchOptions{Migrations: 100})
+}
+
+func BenchmarkDumpRestore(b *testing.B) {
+	benchmarkBench(b, BenchOptions{Migrations: 1, Rows: 100000})
+}
+
+func TestForeignVersionTable(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-ForeignVersionTable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	// a Flyway history table with the default version table name
+	if err := conn.Exec(`CREATE TABLE schema_migrations (
+		installed_rank INT NOT NULL PRIMARY KEY, version VARCHAR(50), script VARCHAR(1000) NOT NULL, success BOOLEAN NOT NULL);
+		INSERT INTO schema_migrations VALUES (1, '0/2', 'V1__init.sql', TRUE), (2, '0/3', 'V2__next.sql', FALSE)`); err != nil {
+		t.Fatal(err)
+	}
+	errs := m.UpSync(conn)
+	var foreign *driver.ForeignTableError
+	if len(errs) != 1 || !errors.As(errs[0], &foreign) || foreign.Tool != "Flyway" {
+		t.Fatalf("Expected Flyway ForeignTableError, got %v", errs)
+	}
+
+	// import the history into another version table
+	m.Driver = mpgx.New("acls_migrations")
+	imported, err := m.ImportHistory(conn, "schema_migrations", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 {
+		t.Fatalf("Expected 2 imported migrations, got %v", imported)
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := file.NewVersion2(0, 2); expect.Compare(version) != 0 {
+		t.Fatalf("Expected version %v, got %v", expect, version)
+	}
+}
+
+func TestAppliedAt(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-AppliedAt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	m, conn, cleanup := NewMigratorAndConn(t, tmpdir)
+	defer conn.Close()
+	defer cleanup()
+	createMigrations(t, m)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.Clock = NewFrozenClock(now)
+	if errs := m.UpSync(conn); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	stored, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stored {
+		if !s.AppliedAt.Equal(now) || s.Duration != 0 {
+			t.Fatalf("Expected %v applied at %v in 0s, got %v in %v", s.Version, now, s.AppliedAt, s.Duration)
+		}
+	}
 }
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/acls/migrate/driver"
+)
+
+// RunSummary is passed to the Notifiers after a run. It marshals to json with the run's
+// fields, the schema, the applied versions and the duration in milliseconds.
+type RunSummary struct {
+	driver.Run
+	Schema string `json:"schema"`
+	// Applied are the versions of the files applied by the run. On failure they include
+	// the files whose transaction was rolled back, ToVersion is the version after the run.
+	Applied []string `json:"applied"`
+}
+
+// Duration returns how long the run took
+func (s RunSummary) Duration() time.Duration {
+	return s.FinishedAt.Sub(s.StartedAt)
+}
+
+// MarshalJSON adds the duration in milliseconds
+func (s RunSummary) MarshalJSON() ([]byte, error) {
+	type summary RunSummary
+	return json.Marshal(struct {
+		summary
+		DurationMs int64 `json:"duration_ms"`
+	}{summary(s), int64(s.Duration() / time.Millisecond)})
+}
+
+// Succeeded returns true if the run's outcome is driver.RunSucceeded
+func (s RunSummary) Succeeded() bool {
+	return s.Outcome == driver.RunSucceeded
+}
+
+// Notifier is notified after each run, see Migrator.Notifiers
+type Notifier interface {
+	Notify(ctx context.Context, summary RunSummary) error
+}
+
+// NotifierFunc is a func that's a Notifier
+type NotifierFunc func(ctx context.Context, summary RunSummary) error
+
+// Notify calls fn
+func (fn NotifierFunc) Notify(ctx context.Context, summary RunSummary) error {
+	return fn(ctx, summary)
+}
+
+// WebhookNotifier POSTs the summary as json to URL
+type WebhookNotifier struct {
+	URL string
+	// Header is added to the request, e.g. an Authorization header
+	Header http.Header
+	// Client defaults to a client with a 30 second timeout
+	Client *http.Client
+}
+
+// Notify POSTs the summary and fails if the response isn't a 2xx
+func (w *WebhookNotifier) Notify(ctx context.Context, summary RunSummary) error {
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("POST %s returned %s", w.URL, res.Status)
+	}
+	return nil
+}
+
+// CommandNotifier runs Command with sh -c, with the summary as json on stdin and
+// MIGRATE_OUTCOME, MIGRATE_FROM_VERSION and MIGRATE_TO_VERSION in its environment
+type CommandNotifier struct {
+	Command string
+}
+
+// Notify runs the command and fails if it exits with an error
+func (c *CommandNotifier) Notify(ctx context.Context, summary RunSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"MIGRATE_OUTCOME="+summary.Outcome,
+		"MIGRATE_FROM_VERSION="+summary.FromVersion,
+		"MIGRATE_TO_VERSION="+summary.ToVersion)
+	return cmd.Run()
+}
+
+// NotificationFailed is sent to the pipe when a Notifier fails
+type NotificationFailed struct {
+	Err error
+}
+
+func (n NotificationFailed) String() string {
+	return "Notification failed: " + n.Err.Error()
+}
+
+// notify notifies each of the Notifiers with the Migrator's context. Their errors are
+// sent to the pipe as NotificationFailed, so they don't fail the migration.
+func (m *Migrator) notify(pipe chan interface{}, summary RunSummary) {
+	ctx := m.context()
+	for _, n := range m.Notifiers {
+		if err := n.Notify(ctx, summary); err != nil {
+			pipe <- NotificationFailed{Err: err}
+		}
+	}
+}
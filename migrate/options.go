@@ -0,0 +1,128 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// Option configures a Migrator created with New
+type Option func(*Migrator) error
+
+// New creates a Migrator for the driver and validates its configuration up front,
+// instead of failing on the first migration.
+//
+//	m, err := migrate.New(d, migrate.WithPath("./migrations"), migrate.WithSchema("app"))
+func New(d driver.Driver, opts ...Option) (*Migrator, error) {
+	if d == nil {
+		return nil, errors.New("Driver is nil")
+	}
+	m := &Migrator{
+		Driver: d,
+		Schema: "public",
+		sem:    make(chan struct{}, 1),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if m.Path == "" && m.Source == nil {
+		return nil, errors.New("Path or Source is required")
+	}
+	return m, nil
+}
+
+// WithPath sets the directory of the migration files, which must exist
+func WithPath(path string) Option {
+	return func(m *Migrator) error {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("Invalid path: %v", err)
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("Invalid path: %s is not a directory", path)
+		}
+		m.Path = path
+		return nil
+	}
+}
+
+// WithSchema sets the schema, which must be a plain identifier since it's used unquoted in sql
+func WithSchema(schema string) Option {
+	return func(m *Migrator) error {
+		if !safeIdentifier.MatchString(schema) {
+			return fmt.Errorf("Invalid schema '%s': must be letters, digits and underscores, not starting with a digit", schema)
+		}
+		m.Schema = schema
+		return nil
+	}
+}
+
+// WithSource reads the migration files from the source instead of Path
+func WithSource(source Source) Option {
+	return func(m *Migrator) error {
+		if source == nil {
+			return errors.New("Source is nil")
+		}
+		m.Source = source
+		return nil
+	}
+}
+
+// WithNewConn opens connections with newConn, e.g. from a pool, see Migrator.NewConn
+func WithNewConn(newConn func() (driver.Conn, error)) Option {
+	return func(m *Migrator) error {
+		if newConn == nil {
+			return errors.New("NewConn is nil")
+		}
+		m.NewConn = newConn
+		return nil
+	}
+}
+
+// WithTxOptions sets the isolation level and access mode of the migration transactions
+func WithTxOptions(opts driver.TxOptions) Option {
+	return func(m *Migrator) error {
+		m.TxOptions = opts
+		return nil
+	}
+}
+
+// WithParseOptions sets how files that can't be parsed as migration files are reported
+func WithParseOptions(opts file.ParseOptions) Option {
+	return func(m *Migrator) error {
+		m.ParseOptions = opts
+		return nil
+	}
+}
+
+// WithTxPerFile uses a transaction for each file instead of each major version
+func WithTxPerFile() Option {
+	return func(m *Migrator) error {
+		m.TxPerFile = true
+		return nil
+	}
+}
+
+// WithForce doesn't validate the base up files
+func WithForce() Option {
+	return func(m *Migrator) error {
+		m.Force = true
+		return nil
+	}
+}
+
+// WithStatements applies files one statement at a time, see Migrator.Statements
+func WithStatements() Option {
+	return func(m *Migrator) error {
+		m.Statements = true
+		return nil
+	}
+}
+
+var safeIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// validateBaseFiles validates the files against the stored files, skipping the out-of-order
+// files with AllowOutOfOrder
+func (m *Migrator) validateBaseFiles(files, prevFiles file.MigrationFiles) error {
+	if m.AllowOutOfOrder {
+		return files.ValidateBaseFilesOutOfOrder(prevFiles)
+	}
+	return files.ValidateBaseFiles(prevFiles)
+}
+
+// planUp returns the migrations Up applies: the out-of-order files with AllowOutOfOrder,
+// then the files after the database's version
+func (m *Migrator) planUp(prevFiles, files file.MigrationFiles) file.Migrations {
+	version := prevFiles.LastVersion()
+	var migrations file.Migrations
+	if m.AllowOutOfOrder {
+		for _, mf := range files.OutOfOrder(prevFiles) {
+			migrations = append(migrations, mf.Migration(direction.Up))
+		}
+	}
+	return append(migrations, files.ToLastFrom(version)...)
+}
@@ -0,0 +1,206 @@
+package migrate
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// parallelDriver returns the driver if the migrations can be applied in parallel
+func (m *Migrator) parallelDriver(applyMigrations file.Migrations) (driver.ParallelDriver, bool) {
+	// the savepoints of ContinueOnError are in the sequential transactions
+	if !m.Parallel || !file.V2 || m.TxPerFile || m.ContinueOnError || m.NewConn == nil {
+		return nil, false
+	}
+	pd, ok := m.Driver.(driver.ParallelDriver)
+	if !ok {
+		return nil, false
+	}
+	for _, f := range applyMigrations {
+		if !f.Up() {
+			return nil, false
+		}
+	}
+	return pd, true
+}
+
+// migrateParallel applies each wave of independent major versions concurrently.
+// See file.DependsFile.
+// ok is false if an error was sent to the pipe or the migration was interrupted.
+func (m *Migrator) migrateParallel(pipe chan interface{}, conn driver.Conn, pd driver.ParallelDriver, applyMigrations file.Migrations) (ok bool, err error) {
+	openers, err := m.sourceFiles()
+	if err != nil {
+		return false, err
+	}
+	deps, err := file.GetMajorDependencies(openers)
+	if err != nil {
+		return false, err
+	}
+	if err = deps.AddRequires(applyMigrations); err != nil {
+		return false, err
+	}
+	for _, wave := range deps.Waves(applyMigrations) {
+		if ok, err = m.migrateWave(pipe, conn, pd, wave); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// migrateWave applies the contents of each major version in its own transaction
+// on its own connection. Once all succeed, the versions are recorded in all of the
+// transactions before they're committed in order, so the version table stays linear.
+// If a commit fails after others succeeded, the error is an ErrPartialWave listing
+// the committed versions.
+// ok is false if an error was sent to the pipe or the migration was interrupted.
+func (m *Migrator) migrateWave(pipe chan interface{}, conn driver.Conn, pd driver.ParallelDriver, wave []file.Migrations) (ok bool, err error) {
+	txs := make([]driver.Tx, len(wave))
+	var conns []driver.Conn
+	defer func() {
+		// rollback uncommitted transactions and close extra connections
+		for _, tx := range txs {
+			if tx != nil {
+				tx.Rollback()
+			}
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	// begin a transaction per major version
+	for i := range wave {
+		c := conn
+		if i > 0 {
+			if c, err = m.NewConn(); err != nil {
+				return
+			}
+			conns = append(conns, c)
+			if _, err = m.Driver.SearchPath(c, m.SearchPath()); err != nil {
+				return
+			}
+		}
+		if txs[i], err = m.begin(c); err != nil {
+			return
+		}
+	}
+
+	// apply contents concurrently
+	oks := make([]bool, len(wave))
+	starts := make([][]time.Time, len(wave))
+	durations := make([][]time.Duration, len(wave))
+	for i := range wave {
+		starts[i] = make([]time.Time, len(wave[i]))
+		durations[i] = make([]time.Duration, len(wave[i]))
+	}
+	apply := m.applyFunc(false)
+	var wg sync.WaitGroup
+	for i := range wave {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			migrations := wave[i]
+			for j := range migrations {
+				starts[i][j] = m.Now()
+				if ok := m.migrateFile(pipe, txs[i], &migrations[j], apply); !ok {
+					return
+				}
+				durations[i][j] = m.Since(starts[i][j])
+			}
+			oks[i] = true
+		}(i)
+	}
+	wg.Wait()
+	for _, ok := range oks {
+		if !ok {
+			return false, nil
+		}
+	}
+
+	// record versions, then commit in order
+	for i, migrations := range wave {
+		for j := range migrations {
+			if err = m.recordVersion(pd, txs[i], &migrations[j]); err != nil {
+				return
+			}
+			if err = m.recordApplied(txs[i], &migrations[j], starts[i][j], durations[i][j]); err != nil {
+				return
+			}
+		}
+	}
+	var applied []string
+	for i, migrations := range wave {
+		err = txs[i].Commit()
+		txs[i] = nil
+		if err != nil {
+			if len(applied) > 0 {
+				err = partialWave(applied, err)
+			}
+			return
+		}
+		for _, mg := range migrations {
+			applied = append(applied, mg.Version.String())
+		}
+	}
+	return true, nil
+}
+
+// parallelDump returns the driver and options of a parallel dump, or a nil driver if the
+// tables are dumped over one connection one at a time
+func (m *Migrator) parallelDump() (driver.ParallelDumpDriver, driver.DumpOptions, error) {
+	opts := driver.DumpOptions{Jobs: m.DumpJobs, Snapshot: m.DumpSnapshot}
+	if opts.Jobs < 1 {
+		opts.Jobs = 1
+	}
+	if opts.Jobs == 1 && !opts.Snapshot {
+		return nil, opts, nil
+	}
+	pd, ok := m.Driver.(driver.ParallelDumpDriver)
+	if !ok {
+		return nil, opts, unsupportedDriver("ParallelDumpDriver")
+	}
+	if opts.Jobs > 1 && m.NewConn == nil {
+		return nil, opts, errors.New("Parallel dumps need NewConn")
+	}
+	opts.NewConn = m.newCopyConn
+	return pd, opts, nil
+}
+
+// newCopyConn opens a connection with NewConn for a parallel dump or restore
+func (m *Migrator) newCopyConn() (driver.CopyConn, error) {
+	conn, err := m.NewConn()
+	if err != nil {
+		return nil, err
+	}
+	cc, ok := conn.(driver.CopyConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("NewConn must return a driver.CopyConn for parallel dumps and restores")
+	}
+	if _, err = m.Driver.SearchPath(cc, m.SearchPath()); err != nil {
+		cc.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+// parallelRestore returns the driver and options of a parallel restore, or a nil driver if
+// the tables are restored over one connection one at a time, e.g. from a stream
+func (m *Migrator) parallelRestore(dr file.DumpReader) (driver.ParallelRestoreDriver, driver.DumpOptions, error) {
+	opts := driver.DumpOptions{Jobs: m.DumpJobs}
+	if _, stream := dr.(file.StreamReader); opts.Jobs <= 1 || stream {
+		return nil, opts, nil
+	}
+	rd, ok := m.Driver.(driver.ParallelRestoreDriver)
+	if !ok {
+		return nil, opts, unsupportedDriver("ParallelRestoreDriver")
+	}
+	if m.NewConn == nil {
+		return nil, opts, errors.New("Parallel restores need NewConn")
+	}
+	opts.NewConn = m.newCopyConn
+	return rd, opts, nil
+}
@@ -0,0 +1,158 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// PlanOptions changes how PlanBetween computes a plan
+type PlanOptions struct {
+	// Force treats stored and disk files with the same version as the same migration
+	// even if their upfile contents differ
+	Force bool
+}
+
+// PlanBetween returns the minimal migrations needed to go from the stored migration
+// files (the ones applied to a database) to the target version of the disk migration files.
+// Stored migrations that differ from the disk files are migrated down using the stored
+// down files before the disk files are migrated up.
+// A nil target is the last disk version.
+// PlanBetween doesn't access a database, so it can be used to check upgrade paths offline.
+func PlanBetween(stored, disk file.MigrationFiles, target file.Version, opts PlanOptions) (file.Migrations, error) {
+	// copy so the passed in slices aren't reordered
+	stored = append(file.MigrationFiles(nil), stored...)
+	disk = append(file.MigrationFiles(nil), disk...)
+	sort.Sort(stored)
+	sort.Sort(disk)
+
+	if missing := disk.MissingVersion(); missing != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVersionGap, missing)
+	}
+	if target == nil {
+		target = disk.LastVersion()
+	}
+
+	// find the migrations that are the same on both sides
+	common := 0
+	for ; common < len(stored) && common < len(disk); common++ {
+		s, d := stored[common], disk[common]
+		if s.Compare(d.Version) != 0 {
+			break
+		}
+		if opts.Force {
+			continue
+		}
+		if err := s.UpFile.ReadContent(); err != nil {
+			return nil, fmt.Errorf("Failed to read stored upfile content: %v", err)
+		}
+		if err := d.UpFile.ReadContent(); err != nil {
+			return nil, fmt.Errorf("Failed to read upfile content: %v", err)
+		}
+		if !bytes.Equal(s.UpFile.Content, d.UpFile.Content) {
+			break
+		}
+	}
+	base := stored[:common].LastVersion()
+
+	var migrations file.Migrations
+	if target.Compare(base) < 0 {
+		// the target was applied, so only migrate down
+		if isVersion(target) {
+			if _, ok := stored.Find(target); !ok {
+				return nil, versionNotFound(target)
+			}
+		}
+		for i := len(stored) - 1; i >= 0 && stored[i].Compare(target) > 0; i-- {
+			migrations = append(migrations, stored[i].Migration(direction.Down))
+		}
+		return migrations, nil
+	}
+
+	if target.Compare(base) != 0 {
+		if _, ok := disk[common:].Find(target); !ok {
+			return nil, versionNotFound(target)
+		}
+	}
+	// migrate down the stored migrations that differ
+	for i := len(stored) - 1; i >= common; i-- {
+		migrations = append(migrations, stored[i].Migration(direction.Down))
+	}
+	// then up to the target
+	for _, mf := range disk[common:] {
+		if mf.Compare(target) > 0 {
+			break
+		}
+		migrations = append(migrations, mf.Migration(direction.Up))
+	}
+	return migrations, nil
+}
+
+// isVersion returns false for the empty version, before any migrations
+func isVersion(v file.Version) bool {
+	return v.Major() != 0 || v.Minor() != 0
+}
+
+// Plan returns the migrations Up would apply, in order, without applying them
+func (m *Migrator) Plan(conn driver.Conn) (file.Migrations, error) {
+	prevFiles, files, err := m.initReadOnly(conn, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.planUp(prevFiles, files), nil
+}
+
+// PlanTo returns the migrations MigrateTo would apply, in order, without applying them
+func (m *Migrator) PlanTo(conn driver.Conn, dstVersion file.Version) (file.Migrations, error) {
+	prevFiles, files, err := m.initReadOnly(conn, true)
+	if err != nil {
+		return nil, err
+	}
+	return files.FromTo(prevFiles.LastVersion(), dstVersion)
+}
+
+// PlanMigrateBetween returns the migrations MigrateBetween would apply, in order, without applying them
+func (m *Migrator) PlanMigrateBetween(conn driver.Conn) (file.Migrations, error) {
+	prevFiles, files, err := m.initReadOnly(conn, !m.Force)
+	if err != nil {
+		return nil, err
+	}
+	_, _, migrations, err := m.planMigrateBetween(prevFiles, files)
+	return migrations, err
+}
+
+// planMigrateBetween resolves the migrations from the stored files to the files on disk.
+// With AllowOutOfOrder, the late files are applied before the files after the stored version.
+func (m *Migrator) planMigrateBetween(prevFiles, files file.MigrationFiles) (curVersion, dstVersion file.Version, migrations file.Migrations, err error) {
+	if len(prevFiles) == 0 {
+		// no previous files so just migrate up or down depending on versions
+		sort.Sort(files) // make sure LastVersion is correct
+		curVersion = prevFiles.LastVersion()
+		dstVersion = files.LastVersion()
+		if curVersion.Compare(dstVersion) <= 0 { // migrate up
+			migrations = files.ToLastFrom(curVersion)
+		} else { // migrate down
+			migrations = files.DownTo(dstVersion)
+		}
+		return
+	}
+	if !m.AllowOutOfOrder {
+		// migrate between previous files and current files
+		return files.Between(prevFiles, m.Force)
+	}
+	// validate the files and apply the late ones like Up does
+	if curVersion, dstVersion, migrations, err = files.Between(prevFiles, true); err != nil || curVersion.Compare(dstVersion) > 0 {
+		return
+	}
+	if !m.Force {
+		if err = m.validateBaseFiles(files, prevFiles); err != nil {
+			return
+		}
+	}
+	migrations = m.planUp(prevFiles, files)
+	return
+}
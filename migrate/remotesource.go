@@ -0,0 +1,204 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ParseSource returns the Source for a s3://bucket/prefix, gs://bucket/prefix or
+// http(s)://host/path url, or nil if it's a local path
+func ParseSource(rawurl string) (Source, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return nil, nil
+	}
+	switch u.Scheme {
+	case "s3":
+		return &S3Source{Bucket: u.Host, Prefix: u.Path}, nil
+	case "gs":
+		return &GCSSource{Bucket: u.Host, Prefix: u.Path}, nil
+	case "http", "https":
+		return &HTTPSource{BaseURL: rawurl}, nil
+	}
+	return nil, fmt.Errorf("Unsupported source scheme %s", u.Scheme)
+}
+
+// HTTPSource reads the migration files below a base url. The names of the files are
+// listed one per line in a manifest, which can be generated in the migrations dir with
+//
+//	find . -type f ! -name manifest.txt | sed 's|^\./||' > manifest.txt
+type HTTPSource struct {
+	BaseURL string
+	// Manifest is the manifest's name below BaseURL. Defaults to manifest.txt
+	Manifest string
+	// Header is added to each request, e.g. an Authorization header
+	Header http.Header
+	// Client defaults to a client with a 30 second timeout
+	Client *http.Client
+}
+
+// ListMigrations reads the manifest. Empty lines and lines starting with # are skipped.
+func (s *HTTPSource) ListMigrations() ([]string, error) {
+	manifest := s.Manifest
+	if manifest == "" {
+		manifest = "manifest.txt"
+	}
+	body, err := s.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		if err := checkSourceName(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, scanner.Err()
+}
+
+// Open gets the file below BaseURL
+func (s *HTTPSource) Open(name string) (io.ReadCloser, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(s.BaseURL, "/")+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", req.URL, res.Status)
+	}
+	return res.Body, nil
+}
+
+// S3Source reads the migration files below a prefix of a S3 bucket with the aws cli,
+// so its credentials chain is used
+type S3Source struct {
+	Bucket string
+	Prefix string
+	// Command defaults to aws
+	Command string
+}
+
+// ListMigrations lists the objects below Prefix
+func (s *S3Source) ListMigrations() ([]string, error) {
+	prefix := sourcePrefix(s.Prefix)
+	out, err := runSourceCommand(s.Command, "aws", "s3api", "list-objects-v2",
+		"--bucket", s.Bucket, "--prefix", prefix, "--query", "Contents[].Key", "--output", "json")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal(out, &keys); err != nil {
+		return nil, err
+	}
+	return trimPrefixes(keys, prefix), nil
+}
+
+// Open downloads the object
+func (s *S3Source) Open(name string) (io.ReadCloser, error) {
+	out, err := runSourceCommand(s.Command, "aws", "s3", "cp",
+		"s3://"+s.Bucket+"/"+sourcePrefix(s.Prefix)+name, "-")
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(out)), nil
+}
+
+// GCSSource reads the migration files below a prefix of a Google Cloud Storage bucket
+// with the gcloud cli, so its credentials are used
+type GCSSource struct {
+	Bucket string
+	Prefix string
+	// Command defaults to gcloud
+	Command string
+}
+
+// ListMigrations lists the objects below Prefix
+func (s *GCSSource) ListMigrations() ([]string, error) {
+	base := "gs://" + s.Bucket + "/" + sourcePrefix(s.Prefix)
+	out, err := runSourceCommand(s.Command, "gcloud", "storage", "ls", base+"**")
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasSuffix(line, "/") {
+			urls = append(urls, line)
+		}
+	}
+	return trimPrefixes(urls, base), nil
+}
+
+// Open downloads the object
+func (s *GCSSource) Open(name string) (io.ReadCloser, error) {
+	out, err := runSourceCommand(s.Command, "gcloud", "storage", "cat",
+		"gs://"+s.Bucket+"/"+sourcePrefix(s.Prefix)+name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(out)), nil
+}
+
+// sourcePrefix returns the prefix without a leading slash and with a trailing one, if not empty
+func sourcePrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func trimPrefixes(keys []string, prefix string) []string {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if name := strings.TrimPrefix(key, prefix); name != "" && !strings.HasSuffix(name, "/") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkSourceName returns an error if the name isn't relative to the source's root
+func checkSourceName(name string) error {
+	if strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") ||
+		strings.Contains(name, "/../") || strings.HasSuffix(name, "/..") {
+		return fmt.Errorf("Invalid file name %s in manifest", name)
+	}
+	return nil
+}
+
+func runSourceCommand(command, defaultCommand string, args ...string) ([]byte, error) {
+	if command == "" {
+		command = defaultCommand
+	}
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
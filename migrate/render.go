@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// RenderData is passed to migration files rendered with Render, e.g.
+//
+//	CREATE TABLE {{ .Schema }}.users (id BIGINT PRIMARY KEY);
+//	GRANT SELECT ON {{ .Schema }}.users TO {{ .Env "READER_ROLE" }};
+type RenderData struct {
+	// Schema is the Migrator's Schema
+	Schema string
+	// Vars are the Migrator's Vars, e.g. {{ .Vars.owner }}
+	Vars map[string]string
+	// Version is the version of the file
+	Version file.Version
+}
+
+// Env returns the environment variable
+func (RenderData) Env(key string) string {
+	return os.Getenv(key)
+}
+
+// render renders the content as a template with the file's RenderData
+func (m *Migrator) render(f *file.File, content []byte) ([]byte, error) {
+	t, err := template.New(f.FileName).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, RenderData{Schema: m.Schema, Vars: m.Vars, Version: f.Version}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderOnRead makes the files' content rendered when it's read, so it's stored and validated
+// rendered. Used with Render and ChecksumRendered.
+func (m *Migrator) renderOnRead(files file.MigrationFiles) {
+	for _, mf := range files {
+		for _, f := range []*file.File{mf.UpFile, mf.DownFile} {
+			if f == nil || f.Open == nil {
+				continue
+			}
+			f, open := f, f.Open
+			f.Open = func() (io.ReadCloser, error) {
+				r, err := open()
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				content, err := ioutil.ReadAll(r)
+				if err != nil {
+					return nil, err
+				}
+				if content, err = m.render(f, content); err != nil {
+					return nil, err
+				}
+				return ioutil.NopCloser(bytes.NewReader(content)), nil
+			}
+		}
+	}
+}
+
+// renderOnApply returns a func that records the migration's version with its template
+// content and applies its rendered content with apply. Used with Render, but not
+// ChecksumRendered, so the stored files are the templates.
+func (m *Migrator) renderOnApply(record bool, apply func(driver.Databaser, *file.Migration, chan interface{})) func(driver.Databaser, *file.Migration, chan interface{}) {
+	return func(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+		f := mg.File()
+		if f == nil {
+			apply(db, mg, pipe)
+			return
+		}
+		fail := func(err error) {
+			defer close(pipe)
+			pipe <- f
+			pipe <- err
+		}
+		if err := f.ReadContent(); err != nil {
+			fail(err)
+			return
+		}
+		content, err := m.render(f, f.Content)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if record {
+			rd, ok := m.Driver.(driver.ParallelDriver)
+			if !ok {
+				fail(unsupportedDriver("ParallelDriver"))
+				return
+			}
+			if err := m.recordVersion(rd, db, mg); err != nil {
+				fail(err)
+				return
+			}
+		}
+		rendered := *f
+		rendered.Content = content
+		mf := file.MigrationFile{Version: mg.Version, DownFile: &rendered}
+		var d direction.Direction = direction.Down
+		if mg.Up() {
+			mf = file.MigrationFile{Version: mg.Version, UpFile: &rendered}
+			d = direction.Up
+		}
+		renderedMg := mf.Migration(d)
+		apply(db, &renderedMg, pipe)
+	}
+}
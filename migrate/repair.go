@@ -0,0 +1,54 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// Repair rewrites the stored up and down files, and checksums, of the applied versions whose
+// files were modified, e.g. to fix whitespace or comments, so validation passes without Force.
+// The files aren't applied again, so only repair edits that don't change the schema.
+// Applied versions without files are left as is. Returns the repaired versions.
+func (m *Migrator) Repair(conn driver.Conn) (repaired []file.Version, err error) {
+	unlock, err := m.lock(conn)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	prevFiles, files, err := m.init(conn, false)
+	if err != nil {
+		return
+	}
+	r, err := drift(prevFiles, files)
+	if err != nil || len(r.Modified) == 0 {
+		return
+	}
+
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return
+	}
+	defer revert()
+	tx, err := conn.Begin()
+	if err != nil {
+		return
+	}
+	for _, mv := range r.Modified {
+		mf, _ := files.Find(mv.Version)
+		mg := mf.Migration(direction.Up)
+		pipe := pipep.New()
+		go m.updateFiles(tx, &mg, pipe)
+		if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+			tx.Rollback()
+			return nil, errs[0]
+		}
+		repaired = append(repaired, mv.Version)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -0,0 +1,64 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// RepeatableFiles reads the repeatable migration files from Source, or Path.
+// See file.RepeatablePrefix.
+func (m *Migrator) RepeatableFiles() (file.Files, error) {
+	openers, err := m.sourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	return file.GetRepeatableFiles(openers, m.Driver.FilenameExtension())
+}
+
+// migrateRepeatables applies the repeatable migrations whose checksum changed in one
+// transaction and returns how many were applied. The search path must be set.
+func (m *Migrator) migrateRepeatables(pipe chan interface{}, conn driver.Conn) (applied int, err error) {
+	files, err := m.RepeatableFiles()
+	if err != nil || len(files) == 0 {
+		return 0, err
+	}
+	rd, ok := m.Driver.(driver.RepeatableDriver)
+	if !ok {
+		return 0, unsupportedDriver("RepeatableDriver")
+	}
+	if err = rd.EnsureRepeatableTable(conn); err != nil {
+		return
+	}
+	checksums, err := rd.RepeatableChecksums(conn)
+	if err != nil {
+		return
+	}
+
+	var tx driver.Tx
+	for _, f := range files {
+		if err = f.ReadContent(); err != nil {
+			break
+		}
+		if checksums[f.Name] == file.Checksum(f.Content) {
+			continue
+		}
+		if tx == nil {
+			if tx, err = m.begin(conn); err != nil {
+				return 0, err
+			}
+		}
+		pipe <- f
+		if err = rd.ApplyRepeatable(tx, f); err != nil {
+			break
+		}
+		applied++
+	}
+	if tx == nil {
+		return 0, err
+	}
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return applied, tx.Commit()
+}
@@ -0,0 +1,121 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/acls/migrate/file"
+)
+
+// orderRequires orders the consecutive up migrations so that each one is applied after the
+// versions it requires, keeping the version order otherwise. See file.RequiresDirective.
+// The requirements are checked against the applied versions, without reading their stored
+// files: only rolling a version back reads the requirements of the applied versions, from
+// their files in files. It returns an ErrUnmetRequirement if a required version is neither
+// applied nor pending, the requirements form a cycle, or a version would be rolled back
+// while an applied version requires it.
+func orderRequires(prevFiles, files file.MigrationFiles, migrations file.Migrations) (file.Migrations, error) {
+	applied := map[string]bool{}
+	for _, mf := range prevFiles {
+		applied[mf.Version.String()] = true
+	}
+
+	ordered := make(file.Migrations, 0, len(migrations))
+	for i := 0; i < len(migrations); {
+		if !migrations[i].Up() {
+			mg := migrations[i]
+			delete(applied, mg.Version.String())
+			if err := checkRollback(applied, files, mg); err != nil {
+				return nil, err
+			}
+			ordered = append(ordered, mg)
+			i++
+			continue
+		}
+		j := i
+		for j < len(migrations) && migrations[j].Up() {
+			j++
+		}
+		ups, err := orderUps(applied, migrations[i:j])
+		if err != nil {
+			return nil, err
+		}
+		ordered = append(ordered, ups...)
+		i = j
+	}
+	return ordered, nil
+}
+
+// orderUps orders the up migrations after their requirements and adds them to applied
+func orderUps(applied map[string]bool, migrations file.Migrations) (file.Migrations, error) {
+	pending := map[string]bool{}
+	requires := make([][]file.Version, len(migrations))
+	for i := range migrations {
+		mg := &migrations[i]
+		pending[mg.Version.String()] = true
+		var err error
+		if requires[i], err = mg.File().Requires(); err != nil {
+			return nil, err
+		}
+	}
+	for i, mg := range migrations {
+		for _, v := range requires[i] {
+			if !applied[v.String()] && !pending[v.String()] {
+				return nil, fmt.Errorf("%w: %v requires %v, which isn't applied or pending", ErrUnmetRequirement, mg.Version, v)
+			}
+		}
+	}
+
+	// take the first migration whose requirements are applied until all of them are
+	ordered := make(file.Migrations, 0, len(migrations))
+	done := make([]bool, len(migrations))
+	for len(ordered) < len(migrations) {
+		next := -1
+		for i := range migrations {
+			if done[i] {
+				continue
+			}
+			ready := true
+			for _, v := range requires[i] {
+				if !applied[v.String()] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			for i := range migrations {
+				if !done[i] {
+					return nil, fmt.Errorf("%w: the requirements of %v form a cycle", ErrUnmetRequirement, migrations[i].Version)
+				}
+			}
+		}
+		done[next] = true
+		applied[migrations[next].Version.String()] = true
+		ordered = append(ordered, migrations[next])
+	}
+	return ordered, nil
+}
+
+// checkRollback returns an ErrUnmetRequirement if an applied version that's in files
+// requires the version rolled back
+func checkRollback(applied map[string]bool, files file.MigrationFiles, mg file.Migration) error {
+	for _, mf := range files {
+		if !applied[mf.Version.String()] {
+			continue
+		}
+		requires, err := mf.UpFile.Requires()
+		if err != nil {
+			return err
+		}
+		for _, v := range requires {
+			if v.Compare(mg.Version) == 0 {
+				return fmt.Errorf("%w: %v can't be rolled back while %v requires it", ErrUnmetRequirement, mg.Version, mf.Version)
+			}
+		}
+	}
+	return nil
+}
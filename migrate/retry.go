@@ -0,0 +1,155 @@
+package migrate
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// RetryPolicy retries the transactions of migrations that fail with a transient error,
+// e.g. a serialization failure or deadlock, if the driver is a driver.RetryDriver.
+// Only the failed transaction is rolled back and applied again, which is the failed
+// file with TxPerFile. Parallel migrations aren't retried.
+type RetryPolicy struct {
+	// Retries is the number of retries per migration run. 0 doesn't retry.
+	Retries int
+	// Backoff is the wait before the first retry, which doubles for each next retry
+	Backoff time.Duration
+	// MaxBackoff caps the wait, if set
+	MaxBackoff time.Duration
+}
+
+// Retrying is sent to the pipe before a transaction is retried after Wait
+type Retrying struct {
+	Wait time.Duration
+	Err  error
+}
+
+func (r Retrying) String() string {
+	return fmt.Sprintf("Retrying after %v: %v", r.Wait, r.Err)
+}
+
+// backoff returns the wait before the retry, counted from 0
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	wait := p.Backoff
+	for i := 0; i < retry && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// isTransient returns true if the driver is a driver.RetryDriver and err is transient
+func (m *Migrator) isTransient(err error) bool {
+	rd, ok := m.Driver.(driver.RetryDriver)
+	return ok && rd.IsTransient(err)
+}
+
+// migrateTx applies the migrations in the transaction, records when they were applied and
+// executes the after each callback, if it's set, after each of them. With ContinueOnError,
+// each migration starts with a savepoint, see keepApplied, which requires a driver.SavepointDriver.
+// If retryable is set, a transient error isn't sent to the pipe, but returned instead.
+func (m *Migrator) migrateTx(pipe chan interface{}, tx driver.Databaser, migrations file.Migrations, retryable bool, afterEach *file.File) (ok bool, transient error) {
+	for i := range migrations {
+		mg := &migrations[i]
+		if m.savepoints() {
+			sd, ok := m.Driver.(driver.SavepointDriver)
+			if !ok {
+				pipe <- unsupportedDriver("SavepointDriver")
+				return false, nil
+			}
+			if err := sd.Savepoint(tx, fileSavepoint); err != nil {
+				pipe <- err
+				return false, nil
+			}
+		}
+		start := m.Now()
+		if ok, transient = m.migrateFileRetryable(pipe, tx, mg, retryable); !ok {
+			return
+		}
+		err := m.recordApplied(tx, mg, start, m.Since(start))
+		if err == nil {
+			err = m.execCallback(tx, afterEach)
+		}
+		if err != nil {
+			if retryable && m.isTransient(err) {
+				return false, err
+			}
+			pipe <- err
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// migrateFileRetryable is migrateFile, but if retryable is set and the migration only
+// fails with transient errors, the first one is returned instead of sent to the pipe
+func (m *Migrator) migrateFileRetryable(pipe chan interface{}, db driver.Databaser, mg *file.Migration, retryable bool) (ok bool, transient error) {
+	if !retryable {
+		return m.migrateFile(pipe, db, mg, m.applyFunc(true)), nil
+	}
+	var errs []error
+	pipe1 := pipep.New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for item := range pipe1 {
+			if err, isErr := item.(error); isErr {
+				errs = append(errs, err)
+				continue
+			}
+			pipe <- item
+		}
+	}()
+	ok = m.migrateFile(pipe1, db, mg, m.applyFunc(true))
+	close(pipe1)
+	<-done
+
+	allTransient := len(errs) > 0
+	for _, err := range errs {
+		allTransient = allTransient && m.isTransient(err)
+	}
+	if !ok && allTransient {
+		return false, errs[0]
+	}
+	for _, err := range errs {
+		pipe <- err
+	}
+	return ok, nil
+}
+
+// fileSavepoint is the savepoint before each file with ContinueOnError
+const fileSavepoint = "migrate_file"
+
+// savepoints returns true if each file of a transaction starts with a savepoint
+func (m *Migrator) savepoints() bool {
+	return m.ContinueOnError && !m.TxPerFile
+}
+
+// keepApplied rolls back the failed file to its savepoint and commits the files before it
+// in the transaction, if ContinueOnError is set. It returns false if the transaction still
+// needs to be rolled back.
+func (m *Migrator) keepApplied(pipe chan interface{}, tx driver.Tx) bool {
+	if !m.savepoints() {
+		return false
+	}
+	// migrateTx sets the savepoint only if the driver is a driver.SavepointDriver
+	sd, ok := m.Driver.(driver.SavepointDriver)
+	if !ok {
+		return false
+	}
+	if err := sd.RollbackToSavepoint(tx, fileSavepoint); err != nil {
+		pipe <- err
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		pipe <- err
+		return false
+	}
+	return true
+}
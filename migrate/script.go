@@ -0,0 +1,248 @@
+package migrate
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// ExportScript writes the migrations needed to go from fromVersion to toVersion
+// to w as a single sql script. The migrations are read from m.Path. Go migrations can't be exported.
+// Transactions match MigrateFiles: one per major version, or one per file if TxPerFile is set.
+func (m *Migrator) ExportScript(w io.Writer, fromVersion, toVersion file.Version) error {
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return err
+	}
+	migrations, err := files.FromTo(fromVersion, toVersion)
+	if err != nil {
+		return err
+	}
+
+	if _, err = fmt.Fprintf(w, "-- Migrate from version %v to %v\n", fromVersion, toVersion); err != nil {
+		return err
+	}
+
+	var (
+		inTx        bool
+		prevVersion file.Version
+	)
+	commit := func() error {
+		inTx = false
+		_, err := io.WriteString(w, "\nCOMMIT;\n")
+		return err
+	}
+	for i := range migrations {
+		if _, isGo, _ := goMigrationFunc(&migrations[i]); isGo {
+			return fmt.Errorf("Version %v is a Go migration and can't be exported to a script", migrations[i].Version)
+		}
+	}
+	for _, mg := range migrations {
+		// commit if per file or major version changed
+		if inTx && (m.TxPerFile || prevVersion.Major() != mg.Major()) {
+			if err = commit(); err != nil {
+				return err
+			}
+		}
+		// begin new transaction if no active transaction
+		if !inTx {
+			if _, err = io.WriteString(w, "\nBEGIN;\n"); err != nil {
+				return err
+			}
+			inTx = true
+		}
+
+		f := mg.File()
+		if err = f.ReadContent(); err != nil {
+			return err
+		}
+		name := f.FileName
+		if file.V2 {
+			name = f.MajorString() + "/" + name
+		}
+		content := f.Content
+		if m.Render && !m.ChecksumRendered {
+			if content, err = m.render(f, content); err != nil {
+				return err
+			}
+		}
+		if _, err = fmt.Fprintf(w, "\n-- %s\n%s\n", name, content); err != nil {
+			return err
+		}
+
+		prevVersion = mg.Version
+	}
+	// commit last transaction
+	if inTx {
+		return commit()
+	}
+	return nil
+}
+
+// Script is a dry run of MigrateTo, or of Up if dstVersion is nil. It runs the same code as
+// a migration, but the statements, including the version table's and the transaction
+// boundaries, are written to w instead of being executed. Only the reads, e.g. of the
+// stored files, use conn. Go migrations can't be scripted.
+func (m *Migrator) Script(conn driver.Conn, dstVersion file.Version, w io.Writer) error {
+	prevFiles, files, err := m.initReadOnly(conn, true)
+	if err != nil {
+		return err
+	}
+	curVersion := prevFiles.LastVersion()
+	var migrations file.Migrations
+	if dstVersion == nil {
+		migrations = files.ToLastFrom(curVersion)
+	} else if migrations, err = files.FromTo(curVersion, dstVersion); err != nil {
+		return err
+	}
+	for i := range migrations {
+		if _, isGo, _ := goMigrationFunc(&migrations[i]); isGo {
+			return fmt.Errorf("Version %v is a Go migration and can't be scripted", migrations[i].Version)
+		}
+	}
+
+	sc := &scriptConn{Conn: conn, w: w, version: curVersion}
+	sm := *m
+	sm.Parallel = false
+	sm.Interrupts = false
+	sm.ReportNoChange = false
+	// the version table isn't changed, so track the version for the driver
+	sm.EventHandler = func(e Event) {
+		if a, ok := e.(MigrationApplied); ok {
+			sc.version = versionAfter(prevFiles, a.File)
+		}
+	}
+
+	if _, err = fmt.Fprintf(w, "-- Migrate from version %v to %v\n", curVersion, scriptTarget(prevFiles, migrations, curVersion)); err != nil {
+		return err
+	}
+	pipe := pipep.New()
+	go func() {
+		pipep.Close(pipe, sm.migrateFiles(pipe, sc, prevFiles, files, migrations))
+	}()
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		return errs[0]
+	}
+	return sc.err
+}
+
+// scriptTarget returns the version after the migrations
+func scriptTarget(stored file.MigrationFiles, migrations file.Migrations, curVersion file.Version) file.Version {
+	if len(migrations) == 0 {
+		return curVersion
+	}
+	last := migrations[len(migrations)-1]
+	if last.Up() {
+		return last.Version
+	}
+	return versionAfter(stored, last.File())
+}
+
+// versionAfter returns the version after the file is applied. After a down file,
+// it's the version before the file's in the stored files.
+func versionAfter(stored file.MigrationFiles, f *file.File) file.Version {
+	if f.Direction == direction.Up {
+		return f.Version
+	}
+	version := file.NewVersion2(0, 0)
+	for _, mf := range stored {
+		if mf.Compare(f.Version) >= 0 {
+			break
+		}
+		version = mf.Version
+	}
+	return version
+}
+
+// scriptConn writes the statements of a dry run to w. Reads use the embedded conn.
+type scriptConn struct {
+	driver.Conn
+	w       io.Writer
+	version file.Version
+	// err is the first write error
+	err error
+}
+
+var _ driver.ScriptDatabaser = &scriptConn{}
+
+func (c *scriptConn) Exec(query string, args ...interface{}) error {
+	if c.err == nil {
+		_, c.err = fmt.Fprintf(c.w, "\n%s\n", scriptStatement(query, args))
+	}
+	return c.err
+}
+
+func (c *scriptConn) Begin() (driver.Tx, error) {
+	return scriptTx{c}, c.Exec("BEGIN")
+}
+
+// Close doesn't close the embedded conn, which belongs to the caller of Script
+func (c *scriptConn) Close() error {
+	return nil
+}
+
+func (c *scriptConn) ScriptVersion() file.Version {
+	return c.version
+}
+
+type scriptTx struct {
+	*scriptConn
+}
+
+func (tx scriptTx) Commit() error {
+	return tx.Exec("COMMIT")
+}
+
+func (tx scriptTx) Rollback() error {
+	return tx.Exec("ROLLBACK")
+}
+
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+// scriptStatement replaces the $n placeholders of the query with the args as literals
+// and terminates it with a semicolon
+func scriptStatement(query string, args []interface{}) string {
+	if len(args) > 0 {
+		query = placeholder.ReplaceAllStringFunc(query, func(p string) string {
+			n, _ := strconv.Atoi(p[1:])
+			if n < 1 || n > len(args) {
+				return p
+			}
+			return sqlLiteral(args[n-1])
+		})
+	}
+	query = strings.TrimRight(query, " \t\r\n")
+	if strings.HasSuffix(query, ";") {
+		return query
+	}
+	// don't terminate inside a trailing line comment
+	if strings.Contains(query[strings.LastIndex(query, "\n")+1:], "--") {
+		return query + "\n;"
+	}
+	return query + ";"
+}
+
+func sqlLiteral(arg interface{}) string {
+	switch v := arg.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.Replace(v, "'", "''", -1) + "'"
+	case []byte:
+		return "'\\x" + hex.EncodeToString(v) + "'::bytea"
+	case time.Time:
+		return "'" + v.Format(time.RFC3339Nano) + "'::timestamptz"
+	case fmt.Stringer:
+		return sqlLiteral(v.String())
+	}
+	return fmt.Sprint(arg)
+}
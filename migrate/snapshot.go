@@ -0,0 +1,152 @@
+package migrate
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// Snapshots stores the dumps taken before migrations, see Migrator.Snapshots
+type Snapshots interface {
+	// Create returns the writer of a new snapshot for the run and the snapshot's location
+	Create(runID string) (dw file.DumpWriter, location string, err error)
+	// Open returns the reader of the snapshot at the location
+	Open(location string) (file.DumpReader, error)
+}
+
+// SnapshotTaken is sent after the schema is dumped to a snapshot, before the run's migrations
+type SnapshotTaken struct {
+	Location string
+}
+
+func (SnapshotTaken) isEvent() {}
+
+// snapshot dumps the schema to a new snapshot for the run and returns its location
+func (m *Migrator) snapshot(conn driver.Conn, runID string) (location string, err error) {
+	cc, ok := conn.(driver.CopyConn)
+	if !ok {
+		return "", errors.New("Snapshots need a driver.CopyConn")
+	}
+	dw, location, err := m.Snapshots.Create(runID)
+	if err != nil {
+		return "", err
+	}
+	// snapshots are full dumps, so a rollback doesn't depend on another dump
+	sm := *m
+	sm.Incremental, sm.DumpContents = nil, DumpAll
+	errs := sm.DumpSync(cc, dw)
+	if err := dw.Close(); err != nil && len(errs) == 0 {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return "", errs[0]
+	}
+	m.emit(SnapshotTaken{Location: location})
+	return location, nil
+}
+
+// RollbackToSnapshot restores the schema from the snapshot at location, or from the latest
+// snapshot in the audit log if location is empty. It undoes a run that failed midway, e.g.
+// the major versions, or files with TxPerFile, that were committed before the one that failed.
+// The schema is dropped and restored like Restore with Force. The runs of its audit log are
+// kept, and the rollback is recorded as a run. Requires Snapshots and a driver.AuditDriver.
+func (m *Migrator) RollbackToSnapshot(pipe chan interface{}, conn driver.CopyConn, location string) {
+	var err error
+	defer func() {
+		go pipep.Close(pipe, err)
+	}()
+
+	if m.Snapshots == nil {
+		err = errors.New("RollbackToSnapshot needs Snapshots")
+		return
+	}
+	ad, ok := m.Driver.(driver.AuditDriver)
+	if !ok {
+		err = unsupportedDriver("AuditDriver")
+		return
+	}
+	runs, err := m.Runs(conn, 0)
+	if err != nil {
+		return
+	}
+	if location == "" {
+		for _, r := range runs {
+			if r.Snapshot != "" {
+				location = r.Snapshot
+				break
+			}
+		}
+		if location == "" {
+			err = ErrNoSnapshot
+			return
+		}
+	}
+	dr, err := m.Snapshots.Open(location)
+	if err != nil {
+		return
+	}
+	if closer, ok := dr.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	run := driver.Run{
+		Command:   "rollback-to-snapshot",
+		User:      currentUser(),
+		StartedAt: m.Now(),
+		Snapshot:  location,
+	}
+	run.Host, _ = os.Hostname()
+	if run.ID, err = newRunID(); err != nil {
+		return
+	}
+	if version, verr := m.Version(conn); verr == nil {
+		run.FromVersion = version.String()
+	}
+
+	// the restore's migrations aren't a run of their own
+	cm := *m
+	cm.Force, cm.Audit, cm.Snapshots, cm.DumpContents = true, false, nil, DumpAll
+	var failed error
+	pipe1 := pipep.New()
+	go cm.Restore(pipe1, conn, dr)
+	for item := range pipe1 {
+		if e, ok := item.(error); ok && failed == nil {
+			failed = e
+		}
+		pipe <- item
+	}
+
+	// the audit log was dropped with the schema
+	if err = m.recordRuns(ad, conn, runs); err != nil {
+		return
+	}
+	rm := *m
+	rm.run = &runRecord{Run: run}
+	err = rm.recordRun(ad, conn, failed)
+}
+
+// RollbackToSnapshotSync is synchronous version of RollbackToSnapshot
+func (m *Migrator) RollbackToSnapshotSync(conn driver.CopyConn, location string) []error {
+	pipe := pipep.New()
+	go m.RollbackToSnapshot(pipe, conn, location)
+	return pipep.ReadErrors(pipe)
+}
+
+// recordRuns writes the runs to the audit log again, the oldest first
+func (m *Migrator) recordRuns(ad driver.AuditDriver, conn driver.Conn, runs []driver.Run) error {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return err
+	}
+	defer revert()
+	for i := len(runs) - 1; i >= 0; i-- {
+		if err := ad.RecordRun(conn, runs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package migrate
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/acls/migrate/file"
+)
+
+// Source provides the migration files, e.g. a directory or an embed.FS
+type Source interface {
+	// ListMigrations returns the names of the files relative to the source's root,
+	// separated by slashes, e.g. 0/0001_users.up.sql with V2
+	ListMigrations() ([]string, error)
+	// Open opens the file with the name returned by ListMigrations
+	Open(name string) (io.ReadCloser, error)
+}
+
+// DirSource is a Source for a directory
+type DirSource struct {
+	Dir string
+}
+
+// ListMigrations returns the files in the directory and its subdirectories
+func (s DirSource) ListMigrations() ([]string, error) {
+	openers, err := (&file.DirReader{BaseDir: s.Dir}).Files("")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(openers))
+	for i, o := range openers {
+		names[i] = filepath.ToSlash(o.Name)
+	}
+	return names, nil
+}
+
+// Open opens the file in the directory
+func (s DirSource) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, filepath.FromSlash(name)))
+}
+
+// FSSource is a Source for a fs.FS, e.g. migrations embedded in the binary:
+//
+//	//go:embed migrations
+//	var migrations embed.FS
+//
+//	m, err := migrate.New(d, migrate.WithSource(migrate.FSSource{FS: migrations, Dir: "migrations"}))
+type FSSource struct {
+	FS fs.FS
+	// Dir is the directory of the migrations in FS. Defaults to the root.
+	Dir string
+}
+
+func (s FSSource) dir() string {
+	if s.Dir == "" {
+		return "."
+	}
+	return s.Dir
+}
+
+// ListMigrations returns the files in Dir and its subdirectories
+func (s FSSource) ListMigrations() (names []string, err error) {
+	dir := s.dir()
+	err = fs.WalkDir(s.FS, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if dir != "." {
+			name = name[len(dir)+1:]
+		}
+		names = append(names, name)
+		return nil
+	})
+	return
+}
+
+// Open opens the file in Dir
+func (s FSSource) Open(name string) (io.ReadCloser, error) {
+	return s.FS.Open(path.Join(s.dir(), name))
+}
+
+// source returns Source, or a DirSource for Path if it isn't set
+func (m *Migrator) source() Source {
+	if m.Source != nil {
+		return m.Source
+	}
+	return DirSource{Dir: m.Path}
+}
+
+// sourceFiles returns openers for the files of the migrator's source
+func (m *Migrator) sourceFiles() (file.Openers, error) {
+	src := m.source()
+	names, err := src.ListMigrations()
+	if err != nil {
+		return nil, err
+	}
+	openers := make(file.Openers, len(names))
+	for i, name := range names {
+		name := name
+		openers[i] = file.Opener{
+			Name: name,
+			Open: func() (io.ReadCloser, error) { return src.Open(name) },
+		}
+	}
+	return openers, nil
+}
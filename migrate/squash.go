@@ -0,0 +1,222 @@
+package migrate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// SquashedDir is the dir in Path that Squash moves the replaced migration files to
+const SquashedDir = ".squashed"
+
+// Squash replaces the applied migration files up to and including upTo with a baseline at
+// version upTo, see file.BaselineName. Its upfile runs their upfiles in order and its downfile
+// their downfiles in reverse order. The replaced files are moved to Path/.squashed/<upTo>,
+// and the versions before upTo are deleted from the version table, so an empty database
+// starts at the baseline.
+// Once the files are squashed, Squash only rewrites the version table of the other databases,
+// after checking that their stored files up to upTo match the baseline.
+// The driver must be a driver.SquashDriver.
+func (m *Migrator) Squash(conn driver.Conn, upTo file.Version) (baseline *file.MigrationFile, err error) {
+	sd, ok := m.Driver.(driver.SquashDriver)
+	if !ok {
+		return nil, unsupportedDriver("SquashDriver")
+	}
+	if m.Path == "" {
+		return nil, errors.New("Squash needs a Path")
+	}
+	unlock, err := m.lock(conn)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	prevFiles, files, err := m.init(conn, false)
+	if err != nil {
+		return
+	}
+	n := 0
+	for n < len(prevFiles) && prevFiles[n].Compare(upTo) <= 0 {
+		n++
+	}
+	if n == 0 || prevFiles[n-1].Compare(upTo) != 0 {
+		return nil, fmt.Errorf("Can't squash version %v, it isn't applied", upTo)
+	}
+	if n == 1 {
+		// already the first version
+		mf := prevFiles[0]
+		return &mf, nil
+	}
+	stored := prevFiles[:n]
+	up, down, err := squashContent(stored)
+	if err != nil {
+		return
+	}
+
+	var archived map[string]string
+	if first, ok := files.Find(upTo); ok && first.Baseline() {
+		// the files were squashed for another database
+		if err = first.UpFile.ReadContent(); err != nil {
+			return
+		}
+		if !m.Force && !bytes.Equal(first.UpFile.Content, up) {
+			return nil, fmt.Errorf("%w: the baseline %v doesn't match the stored files", ErrBaseFilesDiffer, upTo)
+		}
+		baseline = &first
+	} else {
+		if !m.Force {
+			if err = files.ValidateBaseFiles(stored); err != nil {
+				return
+			}
+		}
+		if down, err = squashDownContent(files, upTo); err != nil {
+			return
+		}
+		baseline = m.baselineFile(upTo, up, down)
+		if archived, err = m.archiveFiles(files, upTo); err != nil {
+			return
+		}
+		if err = baseline.WriteFiles(m.Path); err != nil {
+			restoreFiles(archived)
+			return
+		}
+	}
+
+	undo := func() {
+		if archived != nil {
+			os.Remove(baseline.UpFile.Path(m.Path))
+			if baseline.DownFile != nil {
+				os.Remove(baseline.DownFile.Path(m.Path))
+			}
+			restoreFiles(archived)
+		}
+	}
+	if err = m.squashVersions(conn, sd, baseline); err != nil {
+		undo()
+		return nil, err
+	}
+	return baseline, nil
+}
+
+// squashVersions replaces the recorded versions up to the baseline in a transaction
+func (m *Migrator) squashVersions(conn driver.Conn, sd driver.SquashDriver, baseline *file.MigrationFile) error {
+	revert, err := m.Driver.SearchPath(conn, m.SearchPath())
+	if err != nil {
+		return err
+	}
+	defer revert()
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	mg := baseline.Migration(direction.Up)
+	if err := sd.Squash(tx, &mg, m.contentOptions()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// squashContent joins the upfiles in order and the downfiles in reverse order.
+// Each file starts with a comment of its version.
+func squashContent(files file.MigrationFiles) (up, down []byte, err error) {
+	var ups, downs bytes.Buffer
+	for i, mf := range files {
+		if err = mf.UpFile.ReadContent(); err != nil {
+			return
+		}
+		if string(mf.UpFile.Content) == goMigrationContent {
+			return nil, nil, fmt.Errorf("Version %v is a Go migration and can't be squashed", mf.Version)
+		}
+		fmt.Fprintf(&ups, "-- %v\n%s\n\n", mf.Version, bytes.TrimSpace(mf.UpFile.Content))
+
+		mf = files[len(files)-1-i]
+		if mf.DownFile == nil {
+			continue
+		}
+		if err = mf.DownFile.ReadContent(); err != nil {
+			return
+		}
+		fmt.Fprintf(&downs, "-- %v\n%s\n\n", mf.Version, bytes.TrimSpace(mf.DownFile.Content))
+	}
+	return ups.Bytes(), downs.Bytes(), nil
+}
+
+// squashDownContent joins the downfiles up to upTo in reverse order
+func squashDownContent(files file.MigrationFiles, upTo file.Version) ([]byte, error) {
+	n := 0
+	for n < len(files) && files[n].Compare(upTo) <= 0 {
+		n++
+	}
+	_, down, err := squashContent(files[:n])
+	return down, err
+}
+
+func (m *Migrator) baselineFile(version file.Version, up, down []byte) *file.MigrationFile {
+	filename := func(d string) string {
+		return fmt.Sprintf("%s_%s.%s.%s", version.MinorString(), file.BaselineName, d, m.Driver.FilenameExtension())
+	}
+	return &file.MigrationFile{
+		Version: version,
+		UpFile: &file.File{
+			Version:   version,
+			FileName:  filename("up"),
+			Name:      file.BaselineName,
+			Content:   up,
+			Direction: direction.Up,
+		},
+		DownFile: &file.File{
+			Version:   version,
+			FileName:  filename("down"),
+			Name:      file.BaselineName,
+			Content:   down,
+			Direction: direction.Down,
+		},
+	}
+}
+
+// archiveFiles moves the files up to upTo to SquashedDir and returns the new path of each moved file
+func (m *Migrator) archiveFiles(files file.MigrationFiles, upTo file.Version) (map[string]string, error) {
+	dir := path.Join(m.Path, SquashedDir, upTo.MajorString()+"_"+upTo.MinorString())
+	archived := make(map[string]string)
+	for _, mf := range files {
+		if mf.Compare(upTo) > 0 {
+			break
+		}
+		for _, f := range []*file.File{mf.UpFile, mf.DownFile} {
+			if f == nil {
+				continue
+			}
+			src := f.Path(m.Path)
+			rel, err := filepath.Rel(m.Path, src)
+			if err != nil {
+				restoreFiles(archived)
+				return nil, err
+			}
+			dst := filepath.Join(dir, rel)
+			if err = os.MkdirAll(filepath.Dir(dst), 0755); err == nil {
+				err = os.Rename(src, dst)
+			}
+			if err != nil {
+				restoreFiles(archived)
+				return nil, err
+			}
+			archived[src] = dst
+		}
+	}
+	return archived, nil
+}
+
+// restoreFiles moves the archived files back
+func restoreFiles(archived map[string]string) {
+	for src, dst := range archived {
+		os.Rename(dst, src)
+	}
+}
@@ -0,0 +1,92 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// StatementError is sent when a statement fails and Statements is set
+type StatementError struct {
+	File *file.File
+	// Index is the failed statement's index, which is also the number of statements that succeeded
+	Index int
+	// Total is the number of statements in the file
+	Total     int
+	Statement file.Statement
+	Err       error
+}
+
+func (e *StatementError) Error() string {
+	line, _ := file.LineColumnFromOffset(e.File.Content, e.Statement.Offset)
+	return fmt.Sprintf("Statement %d of %d in %s (line %d) failed after %d succeeded: %v",
+		e.Index+1, e.Total, e.File.FileName, line, e.Index, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// applyFunc returns the func that applies a migration: its Go func for Go migrations, otherwise
+// the driver's Migrate, or MigrateContent if the version is recorded separately. With Statements,
+// and a driver.StatementDriver, the file is applied one statement at a time instead.
+func (m *Migrator) applyFunc(record bool) func(driver.Databaser, *file.Migration, chan interface{}) {
+	apply := m.sqlApplyFunc(record)
+	if m.Render && !m.ChecksumRendered {
+		apply = m.renderOnApply(record, m.sqlApplyFunc(false))
+	}
+	return func(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+		if fn, isGo, err := goMigrationFunc(mg); isGo {
+			m.migrateGo(fn, err, record, db, mg, pipe)
+			return
+		}
+		apply(db, mg, pipe)
+	}
+}
+
+// sqlApplyFunc returns the func that applies a migration file, see applyFunc
+func (m *Migrator) sqlApplyFunc(record bool) func(driver.Databaser, *file.Migration, chan interface{}) {
+	sd, ok := m.Driver.(driver.StatementDriver)
+	if m.Statements && ok {
+		return func(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+			m.migrateStatements(sd, record, db, mg, pipe)
+		}
+	}
+	if !record {
+		return m.applyWithTimeout(m.Driver.(driver.ParallelDriver).MigrateContent)
+	}
+	return m.applyWithTimeout(m.driverMigrate)
+}
+
+// migrateStatements records the migration's version, if record is set, and then
+// executes its statements one by one, each with MigrationTimeout
+func (m *Migrator) migrateStatements(sd driver.StatementDriver, record bool, db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+	defer close(pipe)
+	f := mg.File()
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+	pipe <- f
+
+	if record {
+		if err := m.recordVersion(sd, db, mg); err != nil {
+			pipe <- err
+			return
+		}
+	}
+	statements := file.SplitStatements(f.Content)
+	for i, stmt := range statements {
+		tdb, ctx, cancel := m.withTimeout(db)
+		err := sd.ExecStatement(tdb, f, stmt)
+		if err != nil && timedOut(ctx) {
+			err = m.timeoutError(f, err)
+		}
+		cancel()
+		if err != nil {
+			pipe <- &StatementError{File: f, Index: i, Total: len(statements), Statement: stmt, Err: err}
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/acls/migrate/driver"
+)
+
+// ReadStats decodes a stats snapshot written by WriteStats
+func ReadStats(r io.Reader) (stats driver.Stats, err error) {
+	err = json.NewDecoder(r).Decode(&stats)
+	return
+}
+
+// WriteStats encodes the stats snapshot as json
+func WriteStats(w io.Writer, stats driver.Stats) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stats)
+}
+
+func (m *Migrator) statsDriver(conn driver.Conn) (sd driver.StatsDriver, revert func() error, err error) {
+	sd, ok := m.Driver.(driver.StatsDriver)
+	if !ok {
+		return nil, nil, unsupportedDriver("StatsDriver")
+	}
+	revert, err = sd.SearchPath(conn, m.SearchPath())
+	return
+}
+
+// ExportStats returns the planner statistics of the schema's tables, e.g. from production.
+// They hold no column values.
+func (m *Migrator) ExportStats(conn driver.Conn) (driver.Stats, error) {
+	sd, revert, err := m.statsDriver(conn)
+	if err != nil {
+		return driver.Stats{}, err
+	}
+	defer revert()
+	return sd.ExportStats(conn, m.Schema)
+}
+
+// ImportStats overwrites the planner statistics of the schema's tables, so the plans
+// of a scratch database reflect the data distribution of where the stats were exported.
+func (m *Migrator) ImportStats(conn driver.Conn, stats driver.Stats) error {
+	sd, revert, err := m.statsDriver(conn)
+	if err != nil {
+		return err
+	}
+	defer revert()
+	return sd.ImportStats(conn, m.Schema, stats)
+}
+
+// Explain returns the query plan of the query
+func (m *Migrator) Explain(conn driver.Conn, query string) (string, error) {
+	sd, revert, err := m.statsDriver(conn)
+	if err != nil {
+		return "", err
+	}
+	defer revert()
+	return sd.Explain(conn, query)
+}
+
+// ExplainBackfill returns the query plan of the backfill's Explain query
+func (m *Migrator) ExplainBackfill(conn driver.Conn, b Backfill) (string, error) {
+	if b.Explain == "" {
+		return "", errors.New("Backfill " + b.Name + " has no Explain query")
+	}
+	return m.Explain(conn, b.Explain)
+}
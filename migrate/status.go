@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"encoding/json"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+)
+
+// Status is the migration state of a schema, e.g. for an admin endpoint.
+// It marshals to json with the versions as strings.
+type Status struct {
+	// Version is the database version
+	Version file.Version
+	// Latest is the last version of the migration files
+	Latest file.Version
+	// Pending are the versions that Up would apply
+	Pending []file.Version
+	// Drifted are the applied versions whose stored upfile differs from the file on disk,
+	// or that aren't on disk anymore
+	Drifted []file.Version
+	// Locked is true if another migrator holds the schema's lock.
+	// Always false if the driver isn't a driver.LockDriver.
+	Locked bool
+	// LastRun is the latest run recorded in the audit log. Nil if Audit isn't set.
+	LastRun *driver.Run
+}
+
+// UpToDate returns true if there are no pending or drifted versions
+func (s *Status) UpToDate() bool {
+	return len(s.Pending) == 0 && len(s.Drifted) == 0
+}
+
+// MarshalJSON writes the versions as strings
+func (s *Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version string      `json:"version"`
+		Latest  string      `json:"latest"`
+		Pending []string    `json:"pending"`
+		Drifted []string    `json:"drifted"`
+		Locked  bool        `json:"locked"`
+		LastRun *driver.Run `json:"last_run,omitempty"`
+	}{s.Version.String(), s.Latest.String(), versionStrings(s.Pending), versionStrings(s.Drifted), s.Locked, s.LastRun})
+}
+
+func versionStrings(versions []file.Version) []string {
+	strs := make([]string, len(versions))
+	for i, v := range versions {
+		strs[i] = v.String()
+	}
+	return strs
+}
+
+// Status returns the migration state of the schema. Unlike Pending, drifted files
+// are reported instead of returned as an error.
+func (m *Migrator) Status(conn driver.Conn) (*Status, error) {
+	prevFiles, files, err := m.initReadOnly(conn, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &Status{
+		Version: prevFiles.LastVersion(),
+		Latest:  files.LastVersion(),
+	}
+	if s.Version.Compare(s.Latest) <= 0 {
+		for _, mg := range files.ToLastFrom(s.Version) {
+			s.Pending = append(s.Pending, mg.Version)
+		}
+	}
+	for _, prev := range prevFiles {
+		mf, ok := files.Find(prev.Version)
+		if ok {
+			if ok, err = mf.SameUpFile(prev); err != nil {
+				return nil, err
+			}
+		}
+		if !ok {
+			s.Drifted = append(s.Drifted, prev.Version)
+		}
+	}
+	if s.Locked, err = m.isLocked(conn); err != nil {
+		return nil, err
+	}
+	if m.Audit {
+		runs, err := m.Runs(conn, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			s.LastRun = &runs[0]
+		}
+	}
+	return s, nil
+}
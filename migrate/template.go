@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"text/template"
+
+	"github.com/acls/migrate/file"
+)
+
+// TemplatesDir is the dir in Path that holds user provided templates.
+// A template is a pair of files named <template>.up.<ext> and <template>.down.<ext>.
+const TemplatesDir = ".templates"
+
+// builtinTemplates are the up and down templates available to CreateFromTemplate
+var builtinTemplates = map[string][2]string{
+	"create-table": {
+		"CREATE TABLE {{.table}} (\n\tid BIGSERIAL PRIMARY KEY\n);\n",
+		"DROP TABLE {{.table}};\n",
+	},
+	"add-column": {
+		"ALTER TABLE {{.table}} ADD COLUMN {{.column}} {{.type}};\n",
+		"ALTER TABLE {{.table}} DROP COLUMN {{.column}};\n",
+	},
+	"create-index": {
+		"CREATE INDEX IF NOT EXISTS {{.table}}_{{.column}}_idx ON {{.table}} ({{.column}});\n",
+		"DROP INDEX IF EXISTS {{.table}}_{{.column}}_idx;\n",
+	},
+}
+
+// CreateFromTemplate creates new migration files on disk with the contents rendered
+// from a template in TemplatesDir or from a built-in template.
+func (m *Migrator) CreateFromTemplate(incMajor bool, name, tmpl string, vars map[string]string) (*file.MigrationFile, error) {
+	up, down, err := m.readTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	upContent, err := renderTemplate(tmpl+".up", up, vars)
+	if err != nil {
+		return nil, err
+	}
+	downContent, err := renderTemplate(tmpl+".down", down, vars)
+	if err != nil {
+		return nil, err
+	}
+	return m.Create(incMajor, name, upContent, downContent)
+}
+
+// readTemplate reads the up and down templates, preferring those in TemplatesDir
+func (m *Migrator) readTemplate(tmpl string) (up, down string, err error) {
+	dir := path.Join(m.Path, TemplatesDir)
+	ext := m.Driver.FilenameExtension()
+	upBytes, err := ioutil.ReadFile(path.Join(dir, tmpl+".up."+ext))
+	if os.IsNotExist(err) {
+		t, ok := builtinTemplates[tmpl]
+		if !ok {
+			return "", "", fmt.Errorf("Template '%s' not found in %s or built-in templates", tmpl, dir)
+		}
+		return t[0], t[1], nil
+	}
+	if err != nil {
+		return
+	}
+	downBytes, err := ioutil.ReadFile(path.Join(dir, tmpl+".down."+ext))
+	if err != nil {
+		return
+	}
+	return string(upBytes), string(downBytes), nil
+}
+
+func renderTemplate(name, text string, vars map[string]string) (string, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,55 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// withTimeout returns a context that's canceled after MigrationTimeout, if it's set, and
+// a copy of db that uses it, if db is a driver.ContextDatabaser
+func (m *Migrator) withTimeout(db driver.Databaser) (driver.Databaser, context.Context, context.CancelFunc) {
+	if m.MigrationTimeout <= 0 {
+		return db, m.context(), func() {}
+	}
+	ctx, cancel := context.WithTimeout(m.context(), m.MigrationTimeout)
+	if cdb, ok := db.(driver.ContextDatabaser); ok {
+		db = cdb.WithContext(ctx)
+	}
+	return db, ctx, cancel
+}
+
+// timedOut returns true if the context of withTimeout timed out
+func timedOut(ctx context.Context) bool {
+	return ctx.Err() == context.DeadlineExceeded
+}
+
+// timeoutError returns the ErrMigrationTimeout for the file
+func (m *Migrator) timeoutError(f *file.File, err error) error {
+	return fmt.Errorf("%w: %s after %v: %v", ErrMigrationTimeout, f.FileName, m.MigrationTimeout, err)
+}
+
+// applyWithTimeout applies the migration with fn, which is canceled after MigrationTimeout.
+// Errors from a canceled migration are replaced with ErrMigrationTimeout.
+// With Statements, each statement times out instead, see migrateStatements.
+func (m *Migrator) applyWithTimeout(fn func(driver.Databaser, *file.Migration, chan interface{})) func(driver.Databaser, *file.Migration, chan interface{}) {
+	if m.MigrationTimeout <= 0 || m.Statements {
+		return fn
+	}
+	return func(db driver.Databaser, mg *file.Migration, pipe chan interface{}) {
+		defer close(pipe)
+		tdb, ctx, cancel := m.withTimeout(db)
+		defer cancel()
+		pipe1 := pipep.New()
+		go fn(tdb, mg, pipe1)
+		for item := range pipe1 {
+			if err, ok := item.(error); ok && timedOut(ctx) {
+				item = m.timeoutError(mg.File(), err)
+			}
+			pipe <- item
+		}
+	}
+}
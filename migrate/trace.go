@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/secret"
+)
+
+// Tracer starts the spans of the Migrator: one per migration command, dump and restore, with
+// a child span per migration file and per dumped or restored table. It has the shape of
+// OpenTelemetry's trace.Tracer, so an adapter only converts the attributes, see the README.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Attribute is a key and a string, int or int64 value of a span
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// The attributes of the spans
+const (
+	AttrSchema    = "migrate.schema"
+	AttrVersion   = "migrate.version"
+	AttrDirection = "migrate.direction"
+	AttrFile      = "migrate.file"
+	AttrFiles     = "migrate.files"
+	AttrTable     = "migrate.table"
+	AttrRows      = "migrate.rows"
+)
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// startSpan starts a span with the Tracer, if it's set, and returns a copy of the migrator
+// whose context has the span, so the spans started with the copy are its children
+func (m *Migrator) startSpan(name string, attrs ...Attribute) (*Migrator, Span) {
+	if m.Tracer == nil {
+		return m, noopSpan{}
+	}
+	ctx, span := m.Tracer.Start(m.context(), name, attrs...)
+	cm := *m
+	cm.ctx = ctx
+	return &cm, span
+}
+
+// endSpan records the error, if any, and ends the span
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(secret.RedactError(err))
+	}
+	span.End()
+}
+
+// migrationAttrs are the attributes of the span of a migration command
+func migrationAttrs(schema string, migrations file.Migrations) []Attribute {
+	attrs := []Attribute{{AttrSchema, schema}, {AttrFiles, len(migrations)}}
+	if len(migrations) > 0 {
+		first, last := migrations[0], migrations[len(migrations)-1]
+		attrs = append(attrs,
+			Attribute{AttrDirection, directionName(first.Up())},
+			Attribute{AttrVersion, last.Version.String()})
+	}
+	return attrs
+}
+
+func directionName(up bool) string {
+	if up {
+		return "up"
+	}
+	return "down"
+}
+
+// tracedDumpWriter starts a span for each table that's written and ends it when the
+// table's writer is closed, with the number of rows written
+type tracedDumpWriter struct {
+	file.DumpWriter
+	m *Migrator
+}
+
+func (dw tracedDumpWriter) Writer(dir, name string) (io.WriteCloser, error) {
+	w, err := dw.DumpWriter.Writer(dir, name)
+	if err != nil || dir != file.TablesDir {
+		return w, err
+	}
+	_, span := dw.m.Tracer.Start(dw.m.context(), "migrate.dump_table", Attribute{AttrTable, name})
+	return &tracedTable{WriteCloser: w, span: span}, nil
+}
+
+// tracedDumpReader starts a span for each table that's read and ends it when the table's
+// reader is closed, with the number of rows read
+type tracedDumpReader struct {
+	file.DumpReader
+	m *Migrator
+}
+
+func (dr tracedDumpReader) Files(dir string) (file.Openers, error) {
+	openers, err := dr.DumpReader.Files(dir)
+	if err != nil || dir != file.TablesDir {
+		return openers, err
+	}
+	traced := make(file.Openers, len(openers))
+	for i, o := range openers {
+		traced[i] = dr.trace(o)
+	}
+	return traced, nil
+}
+
+func (dr tracedDumpReader) trace(o file.Opener) file.Opener {
+	return file.Opener{Name: o.Name, Open: func() (io.ReadCloser, error) {
+		r, err := o.Open()
+		if err != nil {
+			return nil, err
+		}
+		_, span := dr.m.Tracer.Start(dr.m.context(), "migrate.restore_table", Attribute{AttrTable, o.Name})
+		return &tracedTable{Reader: r, Closer: r, span: span}, nil
+	}}
+}
+
+// tracedStreamReader is the tracedDumpReader of a file.StreamReader
+type tracedStreamReader struct {
+	tracedDumpReader
+	sr file.StreamReader
+}
+
+func (dr tracedStreamReader) NextFile(dir string) (file.Opener, error) {
+	o, err := dr.sr.NextFile(dir)
+	if err != nil || dir != file.TablesDir {
+		return o, err
+	}
+	return dr.trace(o), nil
+}
+
+// tracedTable counts the rows of a table in the COPY text format, which has a line per row
+type tracedTable struct {
+	io.WriteCloser
+	io.Reader
+	io.Closer
+	span Span
+	rows int64
+}
+
+func (t *tracedTable) Write(p []byte) (int, error) {
+	t.rows += int64(bytes.Count(p, []byte{'\n'}))
+	return t.WriteCloser.Write(p)
+}
+
+func (t *tracedTable) Read(p []byte) (int, error) {
+	n, err := t.Reader.Read(p)
+	t.rows += int64(bytes.Count(p[:n], []byte{'\n'}))
+	return n, err
+}
+
+func (t *tracedTable) Close() (err error) {
+	if t.WriteCloser != nil {
+		err = t.WriteCloser.Close()
+	} else {
+		err = t.Closer.Close()
+	}
+	t.span.SetAttributes(Attribute{AttrRows, t.rows})
+	endSpan(t.span, err)
+	return
+}
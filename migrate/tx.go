@@ -0,0 +1,24 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/driver"
+)
+
+// begin begins a migration transaction with TxOptions. The driver must be a
+// driver.TxOptionsDriver if they're set.
+func (m *Migrator) begin(conn driver.Beginner) (driver.Tx, error) {
+	tx, err := conn.Begin()
+	if err != nil || m.TxOptions == (driver.TxOptions{}) {
+		return tx, err
+	}
+	td, ok := m.Driver.(driver.TxOptionsDriver)
+	if !ok {
+		tx.Rollback()
+		return nil, unsupportedDriver("TxOptionsDriver")
+	}
+	if err := td.SetTxOptions(tx, m.TxOptions); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,28 @@
+package migrate
+
+import (
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// updateFilesBatch updates the stored contents of the files before stopAt with the driver's
+// batches, emitting FilesUpdated after each batch
+func (m *Migrator) updateFilesBatch(pipe chan interface{}, bd driver.BatchUpdateDriver, tx driver.Tx, files file.MigrationFiles, stopAt file.Version) error {
+	var migrations []*file.Migration
+	for _, mf := range files {
+		if mf.Compare(stopAt) >= 0 {
+			break
+		}
+		// make copy of file for console output
+		f := *mf.UpFile
+		f.Direction = 0 // change console output
+		pipe <- &f
+
+		mg := mf.Migration(direction.Up)
+		migrations = append(migrations, &mg)
+	}
+	return bd.UpdateFilesBatch(tx, migrations, m.contentOptions(), func(updated int) {
+		m.emit(FilesUpdated{Updated: updated, Total: len(migrations)})
+	})
+}
@@ -0,0 +1,48 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/secret"
+)
+
+// waitBackoff is the exponential backoff between the tries of WaitForDatabase
+var waitBackoff = RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}
+
+// WaitForDatabase calls connect until it succeeds, waiting with exponential backoff between
+// the tries, up to WaitTimeout. Databases that start alongside the migrations, e.g. in
+// docker-compose or Kubernetes, may not accept connections yet. It tries once with a
+// WaitTimeout of 0.
+func (m *Migrator) WaitForDatabase(connect func() error) error {
+	start := m.Now()
+	for try := 0; ; try++ {
+		err := connect()
+		if err == nil || m.WaitTimeout <= 0 {
+			return err
+		}
+		wait := waitBackoff.backoff(try)
+		if m.Since(start)+wait > m.WaitTimeout {
+			return fmt.Errorf("Database not reachable after %v: %w", m.WaitTimeout, err)
+		}
+		if m.ctx != nil && m.ctx.Err() != nil {
+			return m.ctx.Err()
+		}
+		m.clock().Sleep(wait)
+	}
+}
+
+// Connect opens a connection with NewConn, waiting for the database up to WaitTimeout
+func (m *Migrator) Connect() (conn driver.Conn, err error) {
+	if m.NewConn == nil {
+		return nil, errors.New("NewConn isn't set")
+	}
+	err = m.WaitForDatabase(func() (err error) {
+		conn, err = m.NewConn()
+		return
+	})
+	// connection errors may echo the url
+	return conn, secret.RedactError(err)
+}
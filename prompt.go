@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stdin is shared so buffered input isn't lost between reads
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a line from stdin without the trailing newline
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readURL reads the connection url from stdin
+func readURL() (string, error) {
+	url, err := readLine()
+	if err != nil {
+		return "", newMessage(msgReadURL, err)
+	}
+	if url == "" {
+		return "", newMessage(msgEmptyURL)
+	}
+	return url, nil
+}
+
+// promptPassword asks for the password without echoing it, when stdin is a terminal,
+// and adds it to the connection url
+func promptPassword(url string) (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	// best effort, the password is echoed if stty isn't available
+	if echo("-echo") == nil {
+		defer func() {
+			_ = echo("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	password, err := readLine()
+	if err != nil {
+		return "", newMessage(msgReadPassword, err)
+	}
+	return withPassword(url, password)
+}
+
+func echo(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// withPassword sets the password in a url or a key=value connection string
+func withPassword(url, password string) (string, error) {
+	if !strings.Contains(url, "://") {
+		password = strings.Replace(password, `\`, `\\`, -1)
+		password = strings.Replace(password, `'`, `\'`, -1)
+		return url + " password='" + password + "'", nil
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return "", err
+	}
+	username := ""
+	if u.User != nil {
+		username = u.User.Username()
+	}
+	u.User = neturl.UserPassword(username, password)
+	return u.String(), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// dumpStdio is the '-dump' of a dump that's streamed to stdout or from stdin
+const dumpStdio = "-"
+
+// dumpStdout is the stdout of the dump's stream. main replaces os.Stdout with stderr when
+// the dump is streamed to it, so the output doesn't go into the stream.
+var dumpStdout = os.Stdout
+
+// remoteDump is a dump in object storage, or the stream of stdout and stdin
+type remoteDump struct {
+	dw     file.DumpWriter
+	dr     file.DumpReader
+	stream bool
+}
+
+// parseRemoteDump returns the dump of a s3://bucket/prefix, gs://bucket/prefix or
+// azblob://account/container/prefix url, or the stream of '-', or nil if the dump path is
+// local. The options of the storage are the url's query, e.g. ?region=eu-west-1.
+func parseRemoteDump(dumpPath, dumpFormat string) (*remoteDump, error) {
+	if dumpPath == dumpStdio {
+		gz := dumpFormat == dumpFormatTarGz
+		return &remoteDump{
+			dw:     file.NewTarStreamWriter(dumpStdout, gz),
+			dr:     &stdinReader{gz: gz},
+			stream: true,
+		}, nil
+	}
+	u, err := url.Parse(dumpPath)
+	if err != nil || u.Host == "" {
+		return nil, nil
+	}
+	q := u.Query()
+	switch u.Scheme {
+	case "s3":
+		cfg := file.S3Config{
+			Bucket:   u.Host,
+			Prefix:   u.Path,
+			Profile:  q.Get("profile"),
+			Region:   q.Get("region"),
+			Endpoint: q.Get("endpoint"),
+		}
+		w := &file.S3Writer{S3Config: cfg}
+		if size := q.Get("expected_size"); size != "" {
+			if w.ExpectedSize, err = strconv.ParseInt(size, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		return &remoteDump{dw: w, dr: &file.S3Reader{S3Config: cfg}}, nil
+	case "gs":
+		cfg := file.GCSConfig{
+			Bucket:                    u.Host,
+			Prefix:                    u.Path,
+			ImpersonateServiceAccount: q.Get("impersonate_service_account"),
+		}
+		w := &file.GCSWriter{GCSConfig: cfg}
+		if size := q.Get("part_size"); size != "" {
+			if w.PartSize, err = strconv.ParseInt(size, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+		return &remoteDump{dw: w, dr: &file.GCSReader{GCSConfig: cfg}}, nil
+	case "azblob":
+		// azblob://account/container/prefix
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		endpoint := "https://" + u.Host + ".blob.core.windows.net"
+		if e := q.Get("endpoint"); e != "" {
+			endpoint = strings.TrimRight(e, "/")
+		}
+		cfg := file.AzureConfig{
+			ContainerURL: endpoint + "/" + parts[0],
+			SASToken:     os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+		}
+		if len(parts) == 2 {
+			cfg.Prefix = parts[1]
+		}
+		w := &file.AzureWriter{AzureConfig: cfg}
+		if size := q.Get("block_size"); size != "" {
+			if w.BlockSize, err = strconv.Atoi(size); err != nil {
+				return nil, err
+			}
+		}
+		return &remoteDump{dw: w, dr: &file.AzureReader{AzureConfig: cfg}}, nil
+	}
+	return nil, nil
+}
+
+// empty returns true if the dump has no files. A stream is empty for dumps but not for
+// restores, since stdin can't be checked without reading it.
+func (d *remoteDump) empty(command string) (bool, error) {
+	if d.stream {
+		return command != "restore", nil
+	}
+	openers, err := d.dr.Files("")
+	return len(openers) == 0, err
+}
+
+// stdinReader reads the dump's stream from stdin once it's read, so it's not read by dumps
+type stdinReader struct {
+	gz   bool
+	once sync.Once
+	sr   file.StreamReader
+	err  error
+}
+
+func (r *stdinReader) open() error {
+	r.once.Do(func() {
+		r.sr, r.err = file.NewTarStreamReader(os.Stdin, r.gz)
+	})
+	return r.err
+}
+
+func (r *stdinReader) Files(dir string) (file.Openers, error) {
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r.sr.Files(dir)
+}
+
+func (r *stdinReader) NextFile(dir string) (file.Opener, error) {
+	if err := r.open(); err != nil {
+		return file.Opener{}, err
+	}
+	return r.sr.NextFile(dir)
+}
+
+// dumpFormatOf returns the format of the dump's extension, or dir
+func dumpFormatOf(location string) string {
+	for _, f := range []string{dumpFormatZip, dumpFormatTar, dumpFormatTarGz} {
+		if strings.HasSuffix(location, "."+f) {
+			return f
+		}
+	}
+	return dumpFormatDir
+}
+
+// openDumpLocation opens the dump at a remote url, or at a local path in the format of its
+// extension, e.g. the base of an incremental dump
+func openDumpLocation(location string) (file.DumpReader, error) {
+	remote, err := parseRemoteDump(location, dumpFormatDir)
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil {
+		if remote.stream {
+			return nil, newMessage(msgStreamBase)
+		}
+		return remote.dr, nil
+	}
+	if _, err := os.Stat(location); err != nil {
+		return nil, err
+	}
+	return openDumpReader(location, dumpFormatOf(location))
+}
+
+// incrementalDump returns the options of a dump relative to the base dump at location
+func incrementalDump(location string, byMigrations bool) (*migrate.IncrementalDump, error) {
+	dr, err := openDumpLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := dr.(io.Closer); ok {
+		defer closer.Close()
+	}
+	manifest, err := file.ReadManifest(dr)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, newMessage(msgNoBaseManifest, location)
+	}
+	return &migrate.IncrementalDump{Base: manifest, BaseLocation: location, ByMigrations: byMigrations}, nil
+}
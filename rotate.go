@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+
+	mpgx "github.com/acls/migrate/driver/pgx"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// runRotate runs the SchemaMigrator workflows that rotate the <schema>_tmp,
+// <schema> and <schema>_bak schemas.
+//
+//	restore-rotate  restore '-dump' into _tmp, migrate it, then rotate tmp -> live -> bak
+//	revert-schema   migrate _bak, then rotate bak -> live -> tmp
+//	drop-rotate     recreate and migrate _tmp, then rotate tmp -> live -> bak
+func runRotate(m *migrate.Migrator, url, dumpPath, dumpFormat, command string) error {
+	timerStart := m.Now()
+
+	sm, err := mpgx.NewSchemaMigrator(url, *m)
+	if err != nil {
+		return err
+	}
+	defer sm.Close()
+
+	if err := rotate(sm, dumpPath, dumpFormat, command); err != nil {
+		return err
+	}
+
+	if verbosity > quietLevel {
+		printMessage(msgRotated, command, m.Schema, m.Since(timerStart).Seconds())
+	}
+	return nil
+}
+
+// schemaRotator is the part of mpgx.SchemaMigrator that runRotate uses
+type schemaRotator interface {
+	Restore(dr file.DumpReader) error
+	Revert() error
+	Drop() error
+}
+
+// rotate runs the command's workflow with sm
+func rotate(sm schemaRotator, dumpPath, dumpFormat, command string) error {
+	switch command {
+	case "restore-rotate":
+		dumpPath, empty, err := checkDumpPath(dumpPath, dumpFormat)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return newMessage(msgDumpEmpty)
+		}
+		dr, err := openDumpReader(dumpPath, dumpFormat)
+		if err != nil {
+			return err
+		}
+		if dr, err = file.NewIncrementalReader(dr, openDumpLocation); err != nil {
+			return err
+		}
+		if closer, ok := dr.(io.Closer); ok {
+			defer closer.Close()
+		}
+		return sm.Restore(dr)
+	case "revert-schema":
+		return sm.Revert()
+	case "drop-rotate":
+		return sm.Drop()
+	}
+	return nil
+}
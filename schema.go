@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+)
+
+// runExportSchema writes the structure of the schema's tables to outFile as json
+func runExportSchema(m *migrate.Migrator, conn driver.Conn, outFile string) (err error) {
+	info, err := m.InspectSchema(conn)
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	if outFile != "" {
+		if w, err = os.Create(outFile); err != nil {
+			return err
+		}
+		defer func() {
+			if e := w.Close(); err == nil {
+				err = e
+			}
+		}()
+	}
+	return migrate.WriteSchema(w, info)
+}
+
+// runDiffSchema diffs the schema with target, a database url or a file written by
+// export-schema. It creates the migration files if name is set, and prints them otherwise.
+func runDiffSchema(m *migrate.Migrator, conn driver.Conn, target, name, outFile string, incMajor bool) (err error) {
+	if target == "" {
+		return newMessage(msgNoDiffTarget)
+	}
+	info, err := readTargetSchema(m, target)
+	if err != nil {
+		return err
+	}
+	up, down, err := m.DiffSchema(conn, info)
+	if err != nil {
+		return err
+	}
+	if up == "" {
+		printMessage(msgSchemasMatch)
+		return nil
+	}
+	if name != "" {
+		mf, err := m.Create(incMajor, name, diffHeader+up, diffHeader+down)
+		if err != nil {
+			return err
+		}
+		printCreated(m.Path, mf)
+		return nil
+	}
+	w := io.Writer(os.Stdout)
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if e := f.Close(); err == nil {
+				err = e
+			}
+		}()
+		w = f
+	}
+	_, err = fmt.Fprintf(w, "%s-- up\n%s\n-- down\n%s", diffHeader, up, down)
+	return err
+}
+
+const diffHeader = "-- Draft generated by diff-schema. Review it before applying: renames are drops\n" +
+	"-- and adds, and no data is migrated.\n"
+
+// readTargetSchema inspects the database at target, or reads the json file
+func readTargetSchema(m *migrate.Migrator, target string) (driver.SchemaInfo, error) {
+	if !strings.Contains(target, "://") {
+		f, err := os.Open(target)
+		if err != nil {
+			return driver.SchemaInfo{}, err
+		}
+		defer f.Close()
+		return migrate.ReadSchema(f)
+	}
+	conn, err := m.Driver.NewConn(target, m.Schema)
+	if err != nil {
+		return driver.SchemaInfo{}, err
+	}
+	defer conn.Close()
+	return m.InspectSchema(conn)
+}
@@ -0,0 +1,130 @@
+package secret
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManager gets secrets with the aws cli so its credentials chain is used.
+// The key is <secret-id>[#<json field>].
+type AWSSecretsManager struct {
+	// Command defaults to aws
+	Command string
+}
+
+// Get gets the secret string
+func (p AWSSecretsManager) Get(key string) (string, error) {
+	id, field := splitField(key)
+	command := p.Command
+	if command == "" {
+		command = "aws"
+	}
+	cmd := exec.Command(command, "secretsmanager", "get-secret-value",
+		"--secret-id", id, "--query", "SecretString", "--output", "text")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(out), "\r\n")
+	if field == "" {
+		return value, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", err
+	}
+	return fieldString(data, field)
+}
+
+// Vault reads secrets from the HashiCorp Vault http api.
+// The key is <path>#<field>, e.g. secret/data/app#password for the kv v2 engine.
+type Vault struct {
+	// Addr defaults to $VAULT_ADDR
+	Addr string
+	// Token defaults to $VAULT_TOKEN
+	Token string
+	// Client defaults to a client with a 10 second timeout
+	Client *http.Client
+}
+
+// Get reads the field of the secret
+func (p *Vault) Get(key string) (string, error) {
+	path, field := splitField(key)
+	if field == "" {
+		return "", errNoField
+	}
+	addr, token := p.Addr, p.Token
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR not set")
+	}
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// kv v2 nests the secret in data.data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	return fieldString(data, field)
+}
+
+// splitField splits key#field
+func splitField(key string) (string, string) {
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+var errNoField = errors.New("Specify the secret field with <key>#<field>")
+
+func fieldString(data map[string]interface{}, field string) (string, error) {
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("Secret has no field '%s'", field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
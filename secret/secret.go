@@ -0,0 +1,250 @@
+// Package secret resolves secret placeholders in connection urls.
+//
+// A placeholder is ${<provider>:<key>}. E.g.
+//
+//	postgres://app:${file:/run/secrets/db_password}@localhost/app
+//	postgres://app:${vault:secret/data/app#password}@localhost/app
+//
+// ${NAME} without a provider is an environment variable.
+package secret
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider returns the secret for a key
+type Provider interface {
+	Get(key string) (string, error)
+}
+
+// ProviderFunc is a func that is a Provider
+type ProviderFunc func(key string) (string, error)
+
+// Get calls f
+func (f ProviderFunc) Get(key string) (string, error) {
+	return f(key)
+}
+
+// Env reads the secret from an environment variable
+var Env = ProviderFunc(func(key string) (string, error) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("Environment variable '%s' not set", key)
+	}
+	return value, nil
+})
+
+// File reads the secret from a file, e.g. a docker or kubernetes secret
+var File = ProviderFunc(func(key string) (string, error) {
+	b, err := ioutil.ReadFile(key)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+})
+
+// DefaultProviders are the providers used by NewResolver
+func DefaultProviders() map[string]Provider {
+	return map[string]Provider{
+		"env":    Env,
+		"file":   File,
+		"aws-sm": AWSSecretsManager{},
+		"vault":  &Vault{},
+	}
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Resolver resolves placeholders using its providers and caches the secrets for TTL.
+// Call Invalidate when a secret may have been rotated, e.g. after an authentication error.
+type Resolver struct {
+	Providers map[string]Provider
+	// TTL is how long secrets are cached. Zero caches until Invalidate is called.
+	TTL time.Duration
+	// Now returns the current time
+	Now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]entry
+	// resolved are the secrets got from the providers, which Redact replaces
+	resolved map[string]bool
+}
+
+// NewResolver creates a Resolver with the default providers
+func NewResolver(ttl time.Duration) *Resolver {
+	return &Resolver{
+		Providers: DefaultProviders(),
+		TTL:       ttl,
+		Now:       time.Now,
+	}
+}
+
+// Resolve replaces the placeholders and environment variables in s. A $ that doesn't begin a
+// placeholder is kept, e.g. in a password like pa$$word. In a url, the values are escaped for
+// the part they're in, so a password can contain characters like @, / and %.
+func (r *Resolver) Resolve(s string) (string, error) {
+	// the parts are found with each placeholder replaced by a NUL
+	parts := urlParts(expand(s, func(string) string { return "\x00" }))
+	var err error
+	n := 0
+	resolved := expand(s, func(name string) string {
+		part := parts[n]
+		n++
+		i := strings.Index(name, ":")
+		if i <= 0 {
+			return part.escape(os.Getenv(name))
+		}
+		value, e := r.Get(name[:i], name[i+1:])
+		if e != nil && err == nil {
+			err = e
+		}
+		return part.escape(value)
+	})
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// urlPart is the part of a url that a placeholder is in
+type urlPart int
+
+const (
+	// rawPart isn't escaped, e.g. the host or a string that isn't a url
+	rawPart urlPart = iota
+	userinfoPart
+	pathPart
+	queryPart
+)
+
+func (p urlPart) escape(value string) string {
+	switch p {
+	case userinfoPart:
+		return strings.TrimPrefix(url.UserPassword("", value).String(), ":")
+	case pathPart:
+		return url.PathEscape(value)
+	case queryPart:
+		return url.QueryEscape(value)
+	}
+	return value
+}
+
+// urlParts returns the parts of the url s that its NULs are in
+func urlParts(s string) (parts []urlPart) {
+	authority := strings.Index(s, "://")
+	if authority < 0 {
+		for i := 0; i < strings.Count(s, "\x00"); i++ {
+			parts = append(parts, rawPart)
+		}
+		return
+	}
+	authority += len("://")
+	host := authority
+	end := len(s)
+	if i := strings.IndexAny(s[authority:], "/?#"); i >= 0 {
+		end = authority + i
+	}
+	if i := strings.LastIndex(s[authority:end], "@"); i >= 0 {
+		host = authority + i
+	}
+
+	part := rawPart
+	fragment := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case i == authority && host > authority:
+			part = userinfoPart
+		case i == host:
+			part = rawPart
+		case i >= end && s[i] == '#' && !fragment:
+			// the fragment is escaped like the path
+			part, fragment = pathPart, true
+		case i >= end && s[i] == '?' && !fragment && part != queryPart:
+			part = queryPart
+		case i == end:
+			part = pathPart
+		}
+		if s[i] == 0 {
+			parts = append(parts, part)
+		}
+	}
+	return
+}
+
+// expand replaces the ${name} placeholders in s with mapping's values. Unlike os.Expand, it
+// keeps $name and a $ without a name.
+func expand(s string, mapping func(name string) string) string {
+	var buf bytes.Buffer
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			break
+		}
+		buf.WriteString(s[:i])
+		buf.WriteString(mapping(s[i+2 : i+2+j]))
+		s = s[i+2+j+1:]
+	}
+	buf.WriteString(s)
+	return buf.String()
+}
+
+// Get returns the secret from the cache or the provider
+func (r *Resolver) Get(provider, key string) (string, error) {
+	p, ok := r.Providers[provider]
+	if !ok {
+		return "", fmt.Errorf("Unknown secret provider '%s'", provider)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cacheKey := provider + ":" + key
+	now := r.now()
+	if e, ok := r.cache[cacheKey]; ok && (e.expires.IsZero() || now.Before(e.expires)) {
+		return e.value, nil
+	}
+	value, err := p.Get(key)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get secret '%s': %v", cacheKey, err)
+	}
+	if r.cache == nil {
+		r.cache = make(map[string]entry)
+	}
+	if r.resolved == nil {
+		r.resolved = make(map[string]bool)
+	}
+	r.resolved[value] = true
+	e := entry{value: value}
+	if r.TTL > 0 {
+		e.expires = now.Add(r.TTL)
+	}
+	r.cache[cacheKey] = e
+	return value, nil
+}
+
+// Invalidate clears the cache so the next Resolve gets the secrets from the providers
+func (r *Resolver) Invalidate() {
+	r.mu.Lock()
+	r.cache = nil
+	r.mu.Unlock()
+}
+
+func (r *Resolver) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
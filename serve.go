@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	"github.com/acls/migrate/migrate/direction"
+)
+
+// runServe serves the admin api on listen until it fails. Each request opens its own
+// connection, so concurrent migrations wait on the schema's lock like separate runs.
+func runServe(m *migrate.Migrator, listen, token, dumpDir, dumpFormat string, dashboard bool) error {
+	if token == "" {
+		return newMessage(msgNoAPIToken)
+	}
+	printMessage(msgServing, listen)
+	return http.ListenAndServe(listen, adminHandler(m, token, dumpDir, dumpFormat, dashboard))
+}
+
+// adminHandler routes the admin api. All endpoints, except /ready, need the token as
+// an 'Authorization: Bearer <token>' header.
+//
+//	GET  /status          status of the schema
+//	GET  /plan            versions 'up' would apply
+//	GET  /pending         migrations 'up' would apply, with their sql
+//	GET  /history         applied versions
+//	GET  /drift           drift report, with the stored and current files of modified versions
+//	POST /up              apply the pending migrations
+//	POST /down-to?to=<v>  roll back to version v
+//	POST /dump            dump to '-dump'
+//	POST /restore         restore from '-dump'
+//	GET  /ready           readiness probe, see migrate.HealthHandler
+//	GET  /                the dashboard, if enabled
+func adminHandler(m *migrate.Migrator, token, dumpDir, dumpFormat string, dashboard bool) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(pattern, method string, fn func(conn driver.Conn, r *http.Request) (interface{}, error)) {
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			if !authorized(r, token) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.Method != method {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			conn, err := m.Connect()
+			if err != nil {
+				writeAPIError(w, http.StatusServiceUnavailable, err)
+				return
+			}
+			defer conn.Close()
+			res, err := fn(conn, r)
+			if err != nil {
+				writeAPIError(w, apiStatus(err), err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(res)
+		})
+	}
+
+	handle("/status", "GET", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		return m.Status(conn)
+	})
+	handle("/plan", "GET", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		migrations, err := m.Plan(conn)
+		if err != nil {
+			return nil, err
+		}
+		versions := make([]string, len(migrations))
+		for i, mg := range migrations {
+			versions[i] = mg.Version.String()
+		}
+		return versions, nil
+	})
+	handle("/pending", "GET", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		return pending(m, conn)
+	})
+	handle("/history", "GET", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		return history(m, conn)
+	})
+	handle("/drift", "GET", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		return drift(m, conn)
+	})
+	handle("/up", "POST", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		cm := *m
+		cm.Command = "up"
+		return migrationResult(&cm, conn, cm.UpSync(conn))
+	})
+	handle("/down-to", "POST", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+		to, err := m.ResolveVersion(r.URL.Query().Get("to"))
+		if err != nil {
+			return nil, newMessage(msgBadVersionParam, err)
+		}
+		version, err := m.Version(conn)
+		if err != nil {
+			return nil, err
+		}
+		if to.Compare(version) > 0 {
+			return nil, newMessage(msgNotDown, to, version)
+		}
+		cm := *m
+		cm.Command = "down-to"
+		_, errs := cm.MigrateToSync(conn, to)
+		return migrationResult(&cm, conn, errs)
+	})
+	for _, command := range []string{"dump", "restore"} {
+		command := command
+		handle("/"+command, "POST", func(conn driver.Conn, r *http.Request) (interface{}, error) {
+			cconn, ok := conn.(driver.CopyConn)
+			if !ok {
+				return nil, newMessage(msgBatchNoCopy)
+			}
+			ok, err := dumpRestore(m, cconn, dumpDir, dumpFormat, command)
+			if err == nil && !ok {
+				err = errPipe
+			}
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"path": dumpDir}, nil
+		})
+	}
+	mux.Handle("/ready", m.HealthHandler(nil))
+	if dashboard {
+		mux.HandleFunc("/", serveDashboard)
+	}
+	return mux
+}
+
+// authorized compares the request's bearer token in constant time
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{redact(err.Error())})
+}
+
+// apiStatus is 409 Conflict for a locked schema, 400 for bad params and 500 otherwise
+func apiStatus(err error) int {
+	switch e := err.(type) {
+	case *message:
+		if e.id == msgBadVersionParam || e.id == msgNotDown {
+			return http.StatusBadRequest
+		}
+	}
+	if errors.Is(err, migrate.ErrLocked) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// migrationResult is the version after a migration, or its first error
+func migrationResult(m *migrate.Migrator, conn driver.Conn, errs []error) (interface{}, error) {
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	version, err := m.Version(conn)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"version": version.String()}, nil
+}
+
+type historyEntry struct {
+	Version    string    `json:"version"`
+	Name       string    `json:"name"`
+	AppliedAt  time.Time `json:"applied_at,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// history lists the applied versions without their files' contents
+func history(m *migrate.Migrator, conn driver.Conn) ([]historyEntry, error) {
+	stored, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]historyEntry, len(stored))
+	for i, s := range stored {
+		entries[i] = historyEntry{
+			Version:    s.Version.String(),
+			Name:       s.UpFile.Name,
+			AppliedAt:  s.AppliedAt,
+			DurationMs: int64(s.Duration / time.Millisecond),
+		}
+	}
+	return entries, nil
+}
+
+type pendingEntry struct {
+	Version string `json:"version"`
+	Name    string `json:"name"`
+	SQL     string `json:"sql"`
+}
+
+// pending lists the migrations 'up' would apply with their files' contents
+func pending(m *migrate.Migrator, conn driver.Conn) ([]pendingEntry, error) {
+	migrations, err := m.Plan(conn)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]pendingEntry, len(migrations))
+	for i, mg := range migrations {
+		f := mg.File()
+		if err := f.ReadContent(); err != nil {
+			return nil, err
+		}
+		entries[i] = pendingEntry{
+			Version: mg.Version.String(),
+			Name:    f.FileName,
+			SQL:     string(f.Content),
+		}
+	}
+	return entries, nil
+}
+
+type driftFile struct {
+	Version   string `json:"version"`
+	Direction string `json:"direction"`
+	Stored    string `json:"stored"`
+	Current   string `json:"current"`
+}
+
+type driftResult struct {
+	*migrate.DriftReport
+	Files []driftFile `json:"files"`
+}
+
+// MarshalJSON adds the files to the report's json
+func (r driftResult) MarshalJSON() ([]byte, error) {
+	report, err := json.Marshal(r.DriftReport)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(report, &fields); err != nil {
+		return nil, err
+	}
+	fields["files"] = r.Files
+	return json.Marshal(fields)
+}
+
+// drift is the drift report with the stored and current contents of the modified files
+func drift(m *migrate.Migrator, conn driver.Conn) (*driftResult, error) {
+	report, err := m.Drift(conn)
+	if err != nil {
+		return nil, err
+	}
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return nil, err
+	}
+	res := &driftResult{DriftReport: report, Files: []driftFile{}}
+	for _, mv := range report.Modified {
+		mf, _ := files.Find(mv.Version)
+		for _, d := range []struct {
+			changed bool
+			name    string
+			dir     direction.Direction
+			current *file.File
+		}{{mv.Up, "up", direction.Up, mf.UpFile}, {mv.Down, "down", direction.Down, mf.DownFile}} {
+			if !d.changed {
+				continue
+			}
+			df := driftFile{Version: mv.Version.String(), Direction: d.name}
+			stored, ok, err := m.StoredFile(conn, mv.Version, d.dir)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				df.Stored = string(stored.Content)
+			}
+			if d.current != nil {
+				if err := d.current.ReadContent(); err != nil {
+					return nil, err
+				}
+				df.Current = string(d.current.Content)
+			}
+			res.Files = append(res.Files, df)
+		}
+	}
+	return res, nil
+}
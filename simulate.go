@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// runExportStats writes the stats snapshot of the database to outFile or stdout
+func runExportStats(m *migrate.Migrator, conn driver.Conn, outFile string) (err error) {
+	stats, err := m.ExportStats(conn)
+	if err != nil {
+		return err
+	}
+	w := os.Stdout
+	if outFile != "" {
+		if w, err = os.Create(outFile); err != nil {
+			return err
+		}
+		defer func() {
+			if e := w.Close(); err == nil {
+				err = e
+			}
+		}()
+	}
+	return migrate.WriteStats(w, stats)
+}
+
+// runSimulate migrates the scratch database up, imports the stats snapshot and
+// prints the plan of each query read from stdin. Queries are separated by semicolons.
+func runSimulate(m *migrate.Migrator, conn driver.Conn, statsFile string) error {
+	if statsFile == "" {
+		return newMessage(msgNoStatsFile)
+	}
+	f, err := os.Open(statsFile)
+	if err != nil {
+		return err
+	}
+	stats, err := migrate.ReadStats(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	pipe := pipep.New()
+	go m.Up(pipe, conn)
+	if ok := writePipe(pipe); !ok {
+		return newMessage(msgCommandFailed)
+	}
+	if err := m.ImportStats(conn, stats); err != nil {
+		return err
+	}
+
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	for _, query := range strings.Split(string(input), ";") {
+		query = strings.TrimSpace(query)
+		if query == "" {
+			continue
+		}
+		plan, err := m.Explain(conn, query)
+		if err != nil {
+			return newMessage(msgExplainFailed, query, err)
+		}
+		printMessage(msgExplainPlan, query, plan)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+)
+
+// snapshotStore writes each snapshot to <dir>/<run id> in the dump format
+type snapshotStore struct {
+	dir, format string
+}
+
+func (s snapshotStore) Create(runID string) (file.DumpWriter, string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, "", err
+	}
+	location := filepath.Join(s.dir, runID)
+	var dw file.DumpWriter
+	var err error
+	switch s.format {
+	case dumpFormatZip:
+		location += "." + s.format
+		dw, err = file.NewZipWriter(location, location+".tmp")
+		dw = tmpCleaner{DumpWriter: dw, tmp: location + ".tmp"}
+	case dumpFormatTar:
+		location += "." + s.format
+		dw, err = file.NewTarWriter(location)
+	case dumpFormatTarGz:
+		location += "." + s.format
+		dw, err = file.NewTarGzWriter(location, "")
+	default:
+		err = os.MkdirAll(location, 0755)
+		dw = &file.DirWriter{BaseDir: location}
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return dw, location, nil
+}
+
+// Open reads the snapshot in the format of its extension, so the snapshots taken
+// with another '-dump-format' can be read too
+func (s snapshotStore) Open(location string) (file.DumpReader, error) {
+	return openDumpLocation(location)
+}
+
+// tmpCleaner removes the archive's tmp file when it's closed
+type tmpCleaner struct {
+	file.DumpWriter
+	tmp string
+}
+
+func (c tmpCleaner) Close() error {
+	defer os.Remove(c.tmp)
+	return c.DumpWriter.Close()
+}
+
+// runRollbackToSnapshot restores the schema from the snapshot, or the latest snapshot of the audit log
+func runRollbackToSnapshot(m *migrate.Migrator, conn driver.Conn, location string) error {
+	if m.Snapshots == nil {
+		return newMessage(msgNoSnapshotDir)
+	}
+	cconn, ok := conn.(driver.CopyConn)
+	if !ok {
+		return newMessage(msgBatchNoCopy)
+	}
+	timerStart := m.Now()
+	pipe := pipep.New()
+	go m.RollbackToSnapshot(pipe, cconn, location)
+	if !writePipe(pipe) {
+		return errPipe
+	}
+	printComplete(m, conn, timerStart)
+	return nil
+}
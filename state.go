@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/file"
+	"github.com/acls/migrate/migrate"
+)
+
+// state is written to -state-out so infrastructure-as-code tools can detect changes.
+// Fields are only ever added so the format stays stable.
+type state struct {
+	Version         string           `json:"version"`
+	PreviousVersion string           `json:"previous_version"`
+	Changed         bool             `json:"changed"`
+	Success         bool             `json:"success"`
+	Migrations      []migrationState `json:"migrations"`
+}
+
+type migrationState struct {
+	Version      string `json:"version"`
+	UpChecksum   string `json:"up_checksum"`
+	DownChecksum string `json:"down_checksum"`
+}
+
+// writeState writes the state of the database to the file
+func writeState(m *migrate.Migrator, conn driver.Conn, prevVersion file.Version, success bool, name string) error {
+	version, err := m.Version(conn)
+	if err != nil {
+		return err
+	}
+	stored, err := m.StoredMigrations(conn, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	s := state{
+		Version:         version.String(),
+		PreviousVersion: prevVersion.String(),
+		Changed:         version.Compare(prevVersion) != 0,
+		Success:         success,
+		Migrations:      make([]migrationState, 0, len(stored)),
+	}
+	for _, sm := range stored {
+		s.Migrations = append(s.Migrations, migrationState{
+			Version:      sm.Version.String(),
+			UpChecksum:   file.Checksum(sm.UpFile.Content),
+			DownChecksum: file.Checksum(sm.DownFile.Content),
+		})
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, append(b, '\n'), 0644)
+}
+
+// versionInfo is printed by 'version' with -json
+type versionInfo struct {
+	Version string `json:"version"`
+	Latest  string `json:"latest"`
+	Pending int    `json:"pending"`
+	// ContentsMatch is true if the stored up files match the files on disk
+	ContentsMatch bool   `json:"contents_match"`
+	Mismatch      string `json:"mismatch,omitempty"`
+}
+
+// printVersionJSON prints the database version, the last file version and the number of pending migrations
+func printVersionJSON(m *migrate.Migrator, conn driver.Conn) error {
+	version, err := m.Version(conn)
+	if err != nil {
+		return err
+	}
+	files, err := m.MigrationFiles()
+	if err != nil {
+		return err
+	}
+
+	info := versionInfo{
+		Version:       version.String(),
+		Latest:        files.LastVersion().String(),
+		Pending:       len(files.ToLastFrom(version)),
+		ContentsMatch: true,
+	}
+	if err := m.Verify(conn); err != nil {
+		info.ContentsMatch = false
+		info.Mismatch = err.Error()
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
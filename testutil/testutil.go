@@ -15,7 +15,7 @@ func PgxURL(schema string) string {
 }
 
 // MustInitPgx init pgx connection. Use a unique schema per module
-func MustInitPgx(t *testing.T, schema string) *pgx.Conn {
+func MustInitPgx(t testing.TB, schema string) *pgx.Conn {
 	conn, err := PgxConn(schema)
 	if err != nil {
 		t.Fatal(err)
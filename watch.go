@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acls/migrate/driver"
+	"github.com/acls/migrate/migrate"
+	pipep "github.com/acls/migrate/pipe"
+	"github.com/fatih/color"
+)
+
+// snapshot maps file paths to their size and modification time
+type snapshot map[string]string
+
+// takeSnapshot walks dir and returns a snapshot of its files
+func takeSnapshot(dir string) (snapshot, error) {
+	s := make(snapshot)
+	err := filepath.Walk(dir, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			s[fpath] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return s, err
+}
+
+func (s snapshot) equal(other snapshot) bool {
+	if len(s) != len(other) {
+		return false
+	}
+	for k, v := range s {
+		if other[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// runWatch polls -path for changes and migrates between the files and the database
+// each time something changes. With recreate, the schema is dropped and migrated from
+// scratch when an applied file was edited. It runs until interrupted.
+func runWatch(m *migrate.Migrator, conn driver.Conn, interval time.Duration, recreate bool) error {
+	dd, ok := m.Driver.(driver.DumpDriver)
+	if recreate && !ok {
+		return newMessage(msgCantDeleteSchemas)
+	}
+	// interrupts quit the watch instead of aborting a migration
+	m.Interrupts = false
+
+	var prev snapshot
+	for {
+		cur, err := takeSnapshot(m.Path)
+		if err != nil {
+			color.New(color.FgRed).Println(err)
+		} else if !cur.equal(prev) {
+			if prev != nil {
+				printMessage(msgWatchChanges, m.Now().Format("15:04:05"), m.Path)
+			}
+			timerStart := m.Now()
+			if recreate {
+				// only drop the schema when an applied file was edited, not when it can't be read
+				if err := m.Verify(conn); errors.Is(err, migrate.ErrBaseFilesDiffer) {
+					printMessage(msgDevRecreate, m.Schema)
+					if err := dd.DeleteSchema(conn, m.Schema); err != nil {
+						color.New(color.FgRed).Println(err)
+					}
+				} else if err != nil {
+					return err
+				}
+			}
+			pipe := pipep.New()
+			go m.MigrateBetween(pipe, conn)
+			writePipe(pipe)
+			printComplete(m, conn, timerStart)
+			printMessage(msgWatching, m.Path)
+			prev = cur
+		}
+		time.Sleep(interval)
+	}
+}